@@ -0,0 +1,146 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/payments"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Activities that undo the steps of a billing workflow that failed part way. Each can be retried
+// and run more than once without undoing anything twice.
+
+// DeleteSubscriptionActivity removes a subscription from the subscription store
+func DeleteSubscriptionActivity(ctx context.Context, subscriptionID string) error {
+	fmt.Printf("[Compensation Activity] Deleting subscription %s\n", subscriptionID)
+
+	if err := subscriptionStore.DeleteSubscription(ctx, subscriptionID); err != nil {
+		return err
+	}
+
+	fmt.Printf("[Compensation Activity] Deleted subscription %s\n", subscriptionID)
+	return nil
+}
+
+// VoidInvoiceActivity voids an invoice: the credit balance it used is given back to the customer,
+// its ledger entry is reversed and it is marked void in the invoice store, so nothing is owed for
+// it any more. A paid invoice must have its payment reversed first.
+func VoidInvoiceActivity(ctx context.Context, invoice InvoiceDetails, customerID string) (InvoiceDetails, error) {
+	fmt.Printf("[Compensation Activity] Voiding invoice %s\n", invoice.ID)
+
+	// Give back the credit ApplyCustomerBalanceActivity used, if it used any
+	applied, found, err := creditStore.GetTransaction(ctx, "cbt_"+invoice.ID)
+	if err != nil {
+		return InvoiceDetails{}, err
+	}
+	if found && applied.Amount.Sign() < 0 {
+		_, _, err := creditStore.RecordTransaction(ctx, credits.BalanceTransaction{
+			ID:          "cbt_void_" + invoice.ID,
+			CustomerID:  customerID,
+			Amount:      applied.Amount.Neg(),
+			Description: fmt.Sprintf("Invoice %s voided", invoice.ID),
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			return InvoiceDetails{}, err
+		}
+		fmt.Printf("[Compensation Activity] Gave %s of credit back to customer %s\n", applied.Amount.Neg(), customerID)
+	}
+
+	// Reverse the invoice's ledger entry, if it was posted
+	issued, found, err := generalLedger.GetEntry(ctx, "invoice/"+invoice.ID)
+	if err != nil {
+		return InvoiceDetails{}, err
+	}
+	if found {
+		if err := postEntry(ctx, issued.Reversal("void/"+invoice.ID, "Void invoice "+invoice.ID)); err != nil {
+			return InvoiceDetails{}, err
+		}
+	}
+
+	stored, err := invoiceStore.UpdateInvoiceStatus(ctx, invoice.ID, invoices.StatusVoid)
+	switch {
+	case errors.Is(err, invoices.ErrInvalidTransition):
+		return InvoiceDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), invoices.InvalidTransitionErrorType, err)
+	case err == nil:
+		invoice = stored
+	case !errors.Is(err, ErrInvoiceNotFound):
+		return InvoiceDetails{}, err
+	}
+
+	invoice.Status = invoices.StatusVoid
+	fmt.Printf("[Compensation Activity] Voided invoice %s\n", invoice.ID)
+	return invoice, nil
+}
+
+// ReversePaymentActivity refunds all of a captured payment to its payment method and reverses
+// it in the ledger, reopening the invoice it paid. Payments that moved no money are left alone.
+func ReversePaymentActivity(ctx context.Context, payment PaymentDetails, customerID string, reason string) (RefundDetails, error) {
+	if payment.Status != payments.StatusSucceeded || payment.Amount.Sign() <= 0 {
+		return RefundDetails{}, nil
+	}
+	fmt.Printf("[Compensation Activity] Reversing payment %s of %s: %s\n", payment.ID, payment.Amount, reason)
+
+	refund, err := paymentGateway.Refund(ctx, payments.RefundRequest{
+		IdempotencyKey: "reverse_" + payment.IdempotencyKey,
+		ChargeID:       payment.ID,
+		Amount:         payment.Amount,
+		Reason:         reason,
+	})
+	switch {
+	case errors.Is(err, payments.ErrRefundTooLarge):
+		return RefundDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), RefundExceedsCapturedErrorType, err)
+	case errors.Is(err, payments.ErrChargeNotFound):
+		return RefundDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "ChargeNotFound", err)
+	case err != nil:
+		return RefundDetails{}, err
+	}
+
+	// Post the payment first in case the attempt that captured it did not get to, then undo it
+	captured := payments.Payment{
+		IdempotencyKey: payment.IdempotencyKey,
+		ID:             payment.ID,
+		InvoiceID:      payment.InvoiceID,
+		Amount:         payment.Amount,
+		Status:         payment.Status,
+		ProcessedAt:    payment.ProcessedAt,
+	}
+	if err := postPayment(ctx, captured, customerID); err != nil {
+		return RefundDetails{}, err
+	}
+	posted, found, err := generalLedger.GetEntry(ctx, "payment/"+payment.IdempotencyKey)
+	if err != nil {
+		return RefundDetails{}, err
+	}
+	if !found {
+		return RefundDetails{}, fmt.Errorf("payment %s was not posted to the ledger", payment.ID)
+	}
+	reversal := posted.Reversal("payment_reversal/"+payment.IdempotencyKey, "Reverse payment "+payment.ID)
+	reversal.PostedAt = refund.CreatedAt
+	if err := postEntry(ctx, reversal); err != nil {
+		return RefundDetails{}, err
+	}
+
+	// The invoice is owed again
+	_, err = invoiceStore.UpdateInvoiceStatus(ctx, payment.InvoiceID, invoices.StatusOpen)
+	if err != nil && !errors.Is(err, ErrInvoiceNotFound) {
+		return RefundDetails{}, err
+	}
+
+	details := RefundDetails{
+		ID:              refund.ID,
+		ChargeID:        refund.ChargeID,
+		Amount:          refund.Amount,
+		Reason:          reason,
+		Status:          "succeeded",
+		PaymentMethodID: payment.PaymentMethodID,
+		ProcessedAt:     refund.CreatedAt,
+	}
+	fmt.Printf("[Compensation Activity] Refunded %s of payment %s as %s\n", details.Amount, payment.ID, details.ID)
+	return details, nil
+}
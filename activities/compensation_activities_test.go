@@ -0,0 +1,99 @@
+package activities
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/tax"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestCompensationUndoesBilledInvoice(t *testing.T) {
+	journal := useMemoryJournal(t)
+	useMemoryLedger(t)
+	useFakeGateway(t)
+	previous := creditStore
+	store := credits.NewMemoryStore()
+	SetCreditStore(store)
+	t.Cleanup(func() { SetCreditStore(previous) })
+
+	invoice, subscription := testInvoice()
+	invoice.Tax = tax.Summary{Subtotal: invoice.Amount, Tax: money.Zero("USD"), Total: invoice.Amount}
+	_, _, err := store.RecordTransaction(context.Background(), credits.BalanceTransaction{
+		ID:         "cbt_cn_inv_0_1",
+		CustomerID: subscription.CustomerID,
+		Amount:     money.MustParse("20.00", "USD"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(ApplyCustomerBalanceActivity)
+	env.RegisterActivity(PostInvoiceActivity)
+	env.RegisterActivity(ReversePaymentActivity)
+	env.RegisterActivity(VoidInvoiceActivity)
+
+	// Bill the invoice: 20.00 from credit, 29.99 charged
+	result, err := env.ExecuteActivity(ApplyCustomerBalanceActivity, invoice, subscription.CustomerID)
+	if err != nil {
+		t.Fatalf("ApplyCustomerBalanceActivity failed: %v", err)
+	}
+	if err := result.Get(&invoice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.ExecuteActivity(PostInvoiceActivity, invoice, subscription); err != nil {
+		t.Fatalf("PostInvoiceActivity failed: %v", err)
+	}
+	payment := processPayment(t, invoice, subscription, 0)
+
+	// Undo it, twice, as a retried compensation would
+	for i := 0; i < 2; i++ {
+		result, err := env.ExecuteActivity(ReversePaymentActivity, payment, subscription.CustomerID, "setup failed")
+		if err != nil {
+			t.Fatalf("ReversePaymentActivity failed: %v", err)
+		}
+		var refund RefundDetails
+		if err := result.Get(&refund); err != nil {
+			t.Fatal(err)
+		}
+		if refund.Amount != payment.Amount {
+			t.Errorf("attempt %d: refunded %s, want %s", i+1, refund.Amount, payment.Amount)
+		}
+
+		result, err = env.ExecuteActivity(VoidInvoiceActivity, invoice, subscription.CustomerID)
+		if err != nil {
+			t.Fatalf("VoidInvoiceActivity failed: %v", err)
+		}
+		var voided InvoiceDetails
+		if err := result.Get(&voided); err != nil {
+			t.Fatal(err)
+		}
+		if voided.Status != invoices.StatusVoid {
+			t.Errorf("attempt %d: invoice status = %q, want %q", i+1, voided.Status, invoices.StatusVoid)
+		}
+	}
+
+	balance, err := store.Balance(context.Background(), subscription.CustomerID, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != money.MustParse("20.00", "USD") {
+		t.Errorf("credit balance after voiding = %s, want 20.00 USD", balance)
+	}
+
+	// Every account is back where it started
+	totals, err := journal.Totals(context.Background(), "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for account, total := range totals {
+		if balance := total.Balance(account); !balance.IsZero() {
+			t.Errorf("balance of %s after compensating = %s, want zero", account, balance)
+		}
+	}
+}
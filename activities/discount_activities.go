@@ -0,0 +1,111 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/money"
+)
+
+// CouponRedemption is a coupon redeemed on a subscription and how many invoices it has discounted
+type CouponRedemption struct {
+	Coupon        catalog.Coupon
+	RedeemedAt    time.Time
+	CyclesApplied int
+}
+
+// Active reports whether the redemption discounts the next invoice. The zero value is inactive.
+func (r CouponRedemption) Active() bool {
+	return r.Coupon.ID != "" && r.Coupon.AppliesTo(r.CyclesApplied)
+}
+
+// ApplyCoupon takes the coupon's discount off the charges as a negative line item, if the
+// redemption is active. It only does arithmetic, so workflows call it before the charges are taxed.
+func (c *Charges) ApplyCoupon(redemption CouponRedemption) error {
+	if !redemption.Active() {
+		return nil
+	}
+
+	discount, err := redemption.Coupon.Discount(c.Total)
+	if err != nil {
+		return err
+	}
+	if discount.IsZero() {
+		return nil
+	}
+	if c.Total, err = c.Total.Sub(discount); err != nil {
+		return err
+	}
+	c.Items = append(c.Items, InvoiceItem{
+		Description: fmt.Sprintf("Coupon %s (%s)", redemption.Coupon.ID, redemption.Coupon.Name),
+		Amount:      discount.Neg(),
+		Quantity:    1,
+	})
+	c.CouponID = redemption.Coupon.ID
+	return nil
+}
+
+// ApplyCustomerBalanceActivity pays as much of an invoice as it can from the customer's credit
+// balance and adds the credit used as a negative line item. The balance is debited by a transaction
+// keyed by the invoice ID, so a retry applies the credit that was already used rather than more.
+func ApplyCustomerBalanceActivity(ctx context.Context, invoice InvoiceDetails, customerID string) (InvoiceDetails, error) {
+	transactionID := "cbt_" + invoice.ID
+
+	// A previous attempt already used the balance
+	applied, found, err := creditStore.GetTransaction(ctx, transactionID)
+	if err != nil {
+		return InvoiceDetails{}, err
+	}
+	var used money.Money
+	if found {
+		used = applied.Amount.Neg()
+	} else {
+		if invoice.Amount.Sign() <= 0 {
+			return invoice, nil
+		}
+		balance, err := creditStore.Balance(ctx, customerID, invoice.Amount.Currency())
+		if err != nil {
+			return InvoiceDetails{}, err
+		}
+		if balance.Sign() <= 0 {
+			return invoice, nil
+		}
+
+		fmt.Printf("[Subscription Activity] Applying the credit balance of %s of customer %s to invoice %s\n",
+			balance, customerID, invoice.ID)
+		used = balance
+		if cmp, err := balance.Cmp(invoice.Amount); err != nil {
+			return InvoiceDetails{}, err
+		} else if cmp > 0 {
+			used = invoice.Amount
+		}
+		recorded, _, err := creditStore.RecordTransaction(ctx, credits.BalanceTransaction{
+			ID:          transactionID,
+			CustomerID:  customerID,
+			Amount:      used.Neg(),
+			Description: fmt.Sprintf("Applied to invoice %s", invoice.ID),
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			return InvoiceDetails{}, err
+		}
+		used = recorded.Amount.Neg()
+	}
+
+	if invoice.Amount, err = invoice.Amount.Sub(used); err != nil {
+		return InvoiceDetails{}, err
+	}
+	invoice.CreditApplied = used
+	invoice.Items = append(invoice.Items, InvoiceItem{
+		Description: "Applied customer credit",
+		Amount:      used.Neg(),
+		Quantity:    1,
+	})
+	fmt.Printf("[Subscription Activity] Applied %s of credit to invoice %s, %s left to pay\n",
+		used, invoice.ID, invoice.Amount)
+
+	return invoice, nil
+}
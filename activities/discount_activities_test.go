@@ -0,0 +1,107 @@
+package activities
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestChargesApplyCoupon(t *testing.T) {
+	tests := []struct {
+		name       string
+		redemption CouponRedemption
+		want       money.Money
+	}{
+		{
+			name: "percent off rounds half up",
+			redemption: CouponRedemption{Coupon: catalog.Coupon{
+				ID: "WELCOME20", PercentOff: money.MustDecimal("20"), Duration: catalog.CouponOnce,
+			}},
+			want: money.MustParse("39.99", "USD"),
+		},
+		{
+			name: "amount off is capped at the total",
+			redemption: CouponRedemption{Coupon: catalog.Coupon{
+				ID: "BIG", AmountOff: money.MustDecimal("100"), Currency: "USD", Duration: catalog.CouponForever,
+			}},
+			want: money.Zero("USD"),
+		},
+		{
+			name: "used up repeating coupon",
+			redemption: CouponRedemption{
+				Coupon: catalog.Coupon{
+					ID: "SAVE5X3", AmountOff: money.MustDecimal("5"), Currency: "USD",
+					Duration: catalog.CouponRepeating, DurationCycles: 3,
+				},
+				CyclesApplied: 3,
+			},
+			want: money.MustParse("49.99", "USD"),
+		},
+	}
+
+	for _, tt := range tests {
+		charges := Charges{
+			Items: []InvoiceItem{{Description: "Premium", Amount: money.MustParse("49.99", "USD"), Quantity: 1}},
+			Total: money.MustParse("49.99", "USD"),
+		}
+		if err := charges.ApplyCoupon(tt.redemption); err != nil {
+			t.Fatalf("%s: ApplyCoupon failed: %v", tt.name, err)
+		}
+		if charges.Total != tt.want {
+			t.Errorf("%s: total = %s, want %s", tt.name, charges.Total, tt.want)
+		}
+		discounted := charges.Total != money.MustParse("49.99", "USD")
+		if discounted != (charges.CouponID != "") || discounted != (len(charges.Items) == 2) {
+			t.Errorf("%s: coupon %q with items %v, want a discount line only when discounted", tt.name, charges.CouponID, charges.Items)
+		}
+	}
+}
+
+func TestApplyCustomerBalanceUsesCreditOnce(t *testing.T) {
+	previous := creditStore
+	store := credits.NewMemoryStore()
+	SetCreditStore(store)
+	t.Cleanup(func() { SetCreditStore(previous) })
+
+	invoice, subscription := testInvoice()
+	_, _, err := store.RecordTransaction(context.Background(), credits.BalanceTransaction{
+		ID:         "cbt_cn_inv_0_1",
+		CustomerID: subscription.CustomerID,
+		Amount:     money.MustParse("20.00", "USD"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(ApplyCustomerBalanceActivity)
+
+	// A retry must apply the credit used by the first attempt, not what is left of the balance
+	for i := 0; i < 2; i++ {
+		result, err := env.ExecuteActivity(ApplyCustomerBalanceActivity, invoice, subscription.CustomerID)
+		if err != nil {
+			t.Fatalf("ApplyCustomerBalanceActivity failed: %v", err)
+		}
+		var applied InvoiceDetails
+		if err := result.Get(&applied); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := invoice.Amount.Sub(money.MustParse("20.00", "USD"))
+		if applied.Amount != want {
+			t.Errorf("attempt %d: invoice amount after credit = %s, want %s", i+1, applied.Amount, want)
+		}
+	}
+
+	balance, err := store.Balance(context.Background(), subscription.CustomerID, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balance.IsZero() {
+		t.Errorf("balance after applying credit = %s, want zero", balance)
+	}
+}
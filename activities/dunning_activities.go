@@ -0,0 +1,84 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/notify"
+	"github.com/tanint/play-temporal/payments"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Reminder severities, from the first notice to the last warning before cancellation
+const (
+	ReminderNotice  = "notice"
+	ReminderWarning = "warning"
+	ReminderFinal   = "final"
+)
+
+// PaymentReminder describes a dunning email sent after a failed payment
+type PaymentReminder struct {
+	InvoiceID      string
+	SubscriptionID string
+	CustomerID     string
+	Amount         money.Money
+	Attempt        int
+	Severity       string
+	DeclineCode    string    // why the last charge was declined, when the gateway said
+	NextRetryAt    time.Time // zero when no further retry is scheduled
+}
+
+// SendPaymentReminderEmailActivity emails the customer that a payment failed. There is one reminder
+// per charge attempt, each sent once.
+func SendPaymentReminderEmailActivity(ctx context.Context, reminder PaymentReminder) error {
+	fmt.Printf("[Dunning Activity] Sending %s payment reminder for invoice %s to customer %s (attempt %d)\n",
+		reminder.Severity, reminder.InvoiceID, reminder.CustomerID, reminder.Attempt)
+
+	key := fmt.Sprintf("%s/%d", reminder.InvoiceID, reminder.Attempt)
+	err := sendNotification(ctx, notify.PaymentFailed, key, reminder.CustomerID, notify.Data{
+		CustomerID:     reminder.CustomerID,
+		SubscriptionID: reminder.SubscriptionID,
+		InvoiceID:      reminder.InvoiceID,
+		Amount:         reminder.Amount,
+		DeclineCode:    reminder.DeclineCode,
+		Severity:       reminder.Severity,
+		NextRetryAt:    reminder.NextRetryAt,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	if reminder.NextRetryAt.IsZero() {
+		fmt.Printf("[Dunning Activity] Reminder sent for invoice %s, no further retries scheduled\n", reminder.InvoiceID)
+	} else {
+		fmt.Printf("[Dunning Activity] Reminder sent for invoice %s, next retry at %s\n",
+			reminder.InvoiceID, reminder.NextRetryAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// UpdatePaymentMethodActivity checks that the gateway knows a new payment method and stores it
+// for a subscription
+func UpdatePaymentMethodActivity(ctx context.Context, subscriptionID string, paymentMethodID string) error {
+	fmt.Printf("[Dunning Activity] Updating payment method for subscription %s to %s\n",
+		subscriptionID, paymentMethodID)
+
+	method, err := paymentGateway.GetPaymentMethod(ctx, paymentMethodID)
+	if errors.Is(err, payments.ErrPaymentMethodNotFound) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "PaymentMethodNotFound", err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[Dunning Activity] Payment method %s is a %s card ending in %s\n", method.ID, method.Brand, method.Last4)
+
+	err = subscriptionStore.UpdatePaymentMethod(ctx, subscriptionID, paymentMethodID)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "SubscriptionNotFound", err)
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/temporal"
+)
+
+// InvoiceNotEditableErrorType is the application error type used when a finalized invoice is edited
+const InvoiceNotEditableErrorType = "InvoiceNotEditable"
+
+// invoiceNumbers allocates the numbers of finalized invoices. It defaults to an in-memory sequence
+// for the default merchant and is replaced by the worker at startup.
+var (
+	invoiceNumbers      invoices.Sequence = invoices.NewMemorySequence()
+	merchantID                            = "default"
+	invoiceNumberPrefix                   = "INV"
+)
+
+// SetInvoiceNumbering configures the sequence invoice numbers are allocated from, the merchant
+// whose sequence it is and the prefix its numbers are printed with
+func SetInvoiceNumbering(sequence invoices.Sequence, merchant, prefix string) {
+	invoiceNumbers = sequence
+	merchantID = merchant
+	invoiceNumberPrefix = prefix
+}
+
+// CreateDraftInvoiceActivity creates a draft invoice with the given ID for the charges of a billing
+// period. The draft has an ID but no number; its items can be changed until it is finalized. An
+// invoice already stored under the ID, by an earlier attempt, is returned as it was stored.
+func CreateDraftInvoiceActivity(ctx context.Context, subscription SubscriptionDetails, charges Charges, invoiceID string) (InvoiceDetails, error) {
+	fmt.Printf("[Invoice Activity] Creating draft invoice %s for subscription %s\n", invoiceID, subscription.ID)
+
+	if invoiceID == "" {
+		err := errors.New("invoice ID is required")
+		return InvoiceDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInvoiceID", err)
+	}
+	existing, err := invoiceStore.GetInvoice(ctx, invoiceID)
+	if err == nil {
+		fmt.Printf("[Invoice Activity] Draft invoice %s was already created\n", existing.ID)
+		return existing, nil
+	}
+	if !errors.Is(err, ErrInvoiceNotFound) {
+		return InvoiceDetails{}, err
+	}
+
+	invoice := InvoiceDetails{
+		ID:             invoiceID,
+		SubscriptionID: subscription.ID,
+		Amount:         charges.Total,
+		Currency:       charges.Total.Currency(),
+		Status:         invoices.StatusDraft,
+		Period:         charges.Period,
+		Items:          charges.Items,
+		CouponID:       charges.CouponID,
+		ExchangeRate:   charges.ExchangeRate,
+	}
+	if err := invoiceStore.SaveInvoice(ctx, invoice); err != nil {
+		return InvoiceDetails{}, err
+	}
+
+	fmt.Printf("[Invoice Activity] Created draft invoice %s for subscription %s with %d items totaling %s\n",
+		invoice.ID, subscription.ID, len(invoice.Items), invoice.Amount)
+	return invoice, nil
+}
+
+// UpdateDraftInvoiceActivity saves the edited items of a draft invoice, with its amount recomputed
+// from them. An invoice that has been finalized fails with an InvoiceNotEditable error.
+func UpdateDraftInvoiceActivity(ctx context.Context, invoice InvoiceDetails) (InvoiceDetails, error) {
+	fmt.Printf("[Invoice Activity] Updating draft invoice %s\n", invoice.ID)
+
+	amounts := make([]money.Money, len(invoice.Items))
+	for i, item := range invoice.Items {
+		amounts[i] = item.Amount
+	}
+	total, err := money.Sum(invoice.Currency, amounts...)
+	if err != nil {
+		return InvoiceDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInvoiceItem", err)
+	}
+	invoice.Amount = total
+
+	err = invoiceStore.SaveInvoice(ctx, invoice)
+	if errors.Is(err, ErrInvoiceNotEditable) || errors.Is(err, invoices.ErrInvalidTransition) {
+		return InvoiceDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), InvoiceNotEditableErrorType, err)
+	}
+	if err != nil {
+		return InvoiceDetails{}, err
+	}
+
+	fmt.Printf("[Invoice Activity] Draft invoice %s now has %d items totaling %s\n", invoice.ID, len(invoice.Items), invoice.Amount)
+	return invoice, nil
+}
+
+// FinalizeInvoiceActivity finalizes a priced draft invoice: it is given the merchant's next
+// invoice number, issued now, due in 7 days and opened for payment. The number is allocated once
+// per invoice, so a retry finalizes the invoice with the number it was already given, and an
+// invoice that was already finalized is returned as it was stored.
+func FinalizeInvoiceActivity(ctx context.Context, invoice InvoiceDetails) (InvoiceDetails, error) {
+	fmt.Printf("[Invoice Activity] Finalizing invoice %s\n", invoice.ID)
+
+	stored, err := invoiceStore.GetInvoice(ctx, invoice.ID)
+	if err != nil && !errors.Is(err, ErrInvoiceNotFound) {
+		return InvoiceDetails{}, err
+	}
+	if err == nil && !stored.Status.Editable() {
+		fmt.Printf("[Invoice Activity] Invoice %s was already finalized as %s\n", stored.ID, stored.Number)
+		return stored, nil
+	}
+
+	number, err := invoiceNumbers.Allocate(ctx, merchantID, invoice.ID)
+	if err != nil {
+		return InvoiceDetails{}, err
+	}
+	issuedAt := time.Now()
+	invoice.Number = invoices.FormatNumber(invoiceNumberPrefix, number)
+	invoice.Status = invoices.StatusOpen
+	invoice.IssuedAt = issuedAt
+	invoice.DueDate = issuedAt.Add(7 * 24 * time.Hour) // Due in 7 days
+	if err := invoiceStore.SaveInvoice(ctx, invoice); err != nil {
+		return InvoiceDetails{}, err
+	}
+
+	fmt.Printf("[Invoice Activity] Finalized invoice %s as %s for %s\n", invoice.ID, invoice.Number, invoice.Amount)
+	return invoice, nil
+}
+
+// UpdateInvoiceStatusActivity moves an invoice to a new status in the invoice store and returns
+// it. A transition the invoice lifecycle does not allow fails with a non-retryable
+// InvalidInvoiceTransition error.
+func UpdateInvoiceStatusActivity(ctx context.Context, invoiceID string, status invoices.Status) (InvoiceDetails, error) {
+	fmt.Printf("[Invoice Activity] Marking invoice %s %s\n", invoiceID, status)
+
+	invoice, err := invoiceStore.UpdateInvoiceStatus(ctx, invoiceID, status)
+	if errors.Is(err, ErrInvoiceNotFound) {
+		return InvoiceDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvoiceNotFound", err)
+	}
+	if errors.Is(err, invoices.ErrInvalidTransition) || errors.Is(err, invoices.ErrUnknownStatus) {
+		return InvoiceDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), invoices.InvalidTransitionErrorType, err)
+	}
+	if err != nil {
+		return InvoiceDetails{}, err
+	}
+
+	fmt.Printf("[Invoice Activity] Invoice %s is now %s\n", invoiceID, invoice.Status)
+	return invoice, nil
+}
+
+// ListOpenInvoicesActivity returns the invoices of a subscription that are finalized and still owed
+func ListOpenInvoicesActivity(ctx context.Context, subscriptionID string) ([]InvoiceDetails, error) {
+	fmt.Printf("[Invoice Activity] Listing open invoices of subscription %s\n", subscriptionID)
+
+	stored, err := invoiceStore.ListInvoices(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	var open []InvoiceDetails
+	for _, invoice := range stored {
+		if invoice.Status == invoices.StatusOpen {
+			open = append(open, invoice)
+		}
+	}
+	return open, nil
+}
+
+// FindUnbilledPeriodsActivity returns the billing periods, of those given, that no invoice of the
+// subscription charges for, in the order given. An invoice charges for the periods of its items,
+// or for its own period when none of its items has one. Void invoices charge for nothing, while
+// drafts do, as their billing may still be under way.
+func FindUnbilledPeriodsActivity(ctx context.Context, subscriptionID string, periods []BillingPeriod) ([]BillingPeriod, error) {
+	fmt.Printf("[Invoice Activity] Looking for unbilled periods of subscription %s among %d\n", subscriptionID, len(periods))
+
+	stored, err := invoiceStore.ListInvoices(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	var billed []BillingPeriod
+	for _, invoice := range stored {
+		if invoice.Status == invoices.StatusVoid {
+			continue
+		}
+		charged := false
+		for _, item := range invoice.Items {
+			if !item.Period.Start.IsZero() {
+				billed = append(billed, item.Period)
+				charged = true
+			}
+		}
+		if !charged {
+			billed = append(billed, invoice.Period)
+		}
+	}
+
+	var unbilled []BillingPeriod
+	for _, period := range periods {
+		if !containsPeriod(billed, period) {
+			unbilled = append(unbilled, period)
+		}
+	}
+
+	fmt.Printf("[Invoice Activity] Subscription %s has %d unbilled periods\n", subscriptionID, len(unbilled))
+	return unbilled, nil
+}
+
+// containsPeriod reports whether a period is among others, comparing instants rather than time zones
+func containsPeriod(periods []BillingPeriod, period BillingPeriod) bool {
+	for _, p := range periods {
+		if p.Start.Equal(period.Start) && p.End.Equal(period.End) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// useMemoryInvoiceStore swaps in an empty invoice store and number sequence for the duration of a test
+func useMemoryInvoiceStore(t *testing.T) *MemoryInvoiceStore {
+	t.Helper()
+	store := NewMemoryInvoiceStore()
+	previousStore, previousNumbers, previousMerchant, previousPrefix := invoiceStore, invoiceNumbers, merchantID, invoiceNumberPrefix
+	SetInvoiceStore(store)
+	SetInvoiceNumbering(invoices.NewMemorySequence(), "acme", "ACME")
+	t.Cleanup(func() {
+		SetInvoiceStore(previousStore)
+		SetInvoiceNumbering(previousNumbers, previousMerchant, previousPrefix)
+	})
+	return store
+}
+
+// executeInvoiceActivity runs an invoice activity in a test activity environment and decodes the invoice it returns
+func executeInvoiceActivity(activity interface{}, args ...interface{}) (InvoiceDetails, error) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activity)
+
+	result, err := env.ExecuteActivity(activity, args...)
+	if err != nil {
+		return InvoiceDetails{}, err
+	}
+	var invoice InvoiceDetails
+	err = result.Get(&invoice)
+	return invoice, err
+}
+
+func TestInvoiceLifecycle(t *testing.T) {
+	store := useMemoryInvoiceStore(t)
+	_, subscription := testInvoice()
+	charges := Charges{
+		Items: []InvoiceItem{{Description: "Premium", Quantity: 1, Amount: money.MustParse("49.99", "USD")}},
+		Total: money.MustParse("49.99", "USD"),
+	}
+
+	// Two drafts, the second finalized first, are numbered in the order they are finalized
+	first, err := executeInvoiceActivity(CreateDraftInvoiceActivity, subscription, charges, "inv_first")
+	if err != nil {
+		t.Fatalf("CreateDraftInvoiceActivity failed: %v", err)
+	}
+	second, err := executeInvoiceActivity(CreateDraftInvoiceActivity, subscription, charges, "inv_second")
+	if err != nil {
+		t.Fatalf("CreateDraftInvoiceActivity failed: %v", err)
+	}
+	if first.Status != invoices.StatusDraft || first.Number != "" {
+		t.Fatalf("draft = %s numbered %q, want an unnumbered draft", first.Status, first.Number)
+	}
+
+	// Drafts can be edited, and their amount follows their items
+	first.Items = append(first.Items, InvoiceItem{Description: "Setup", Quantity: 1, Amount: money.MustParse("25.00", "USD")})
+	first, err = executeInvoiceActivity(UpdateDraftInvoiceActivity, first)
+	if err != nil {
+		t.Fatalf("UpdateDraftInvoiceActivity failed: %v", err)
+	}
+	if want := money.MustParse("74.99", "USD"); first.Amount != want {
+		t.Errorf("edited draft amount = %s, want %s", first.Amount, want)
+	}
+
+	for _, tt := range []struct {
+		invoice *InvoiceDetails
+		number  string
+	}{
+		{&second, "ACME-000001"},
+		{&first, "ACME-000002"},
+	} {
+		// Finalized twice, as a retried activity would be
+		for attempt := 1; attempt <= 2; attempt++ {
+			finalized, err := executeInvoiceActivity(FinalizeInvoiceActivity, *tt.invoice)
+			if err != nil {
+				t.Fatalf("FinalizeInvoiceActivity failed: %v", err)
+			}
+			if finalized.Number != tt.number || finalized.Status != invoices.StatusOpen {
+				t.Errorf("attempt %d: %s finalized as %s %q, want open %q",
+					attempt, tt.invoice.ID, finalized.Status, finalized.Number, tt.number)
+			}
+			if finalized.IssuedAt.IsZero() || !finalized.DueDate.After(finalized.IssuedAt) {
+				t.Errorf("attempt %d: %s issued %s and due %s", attempt, tt.invoice.ID, finalized.IssuedAt, finalized.DueDate)
+			}
+			*tt.invoice = finalized
+		}
+	}
+
+	// Finalized invoices cannot be edited
+	edited := first
+	edited.Status = invoices.StatusDraft
+	edited.Items = edited.Items[:1]
+	_, err = executeInvoiceActivity(UpdateDraftInvoiceActivity, edited)
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != InvoiceNotEditableErrorType {
+		t.Errorf("editing a finalized invoice = %v, want an %s error", err, InvoiceNotEditableErrorType)
+	}
+
+	// Statuses move only along the lifecycle
+	steps := []struct {
+		status    invoices.Status
+		errorType string
+	}{
+		{invoices.StatusDraft, invoices.InvalidTransitionErrorType},
+		{invoices.StatusPaid, ""},
+		{invoices.StatusVoid, invoices.InvalidTransitionErrorType},
+		{invoices.StatusOpen, ""},
+		{invoices.StatusUncollectible, ""},
+		{invoices.StatusVoid, ""},
+		{invoices.StatusPaid, invoices.InvalidTransitionErrorType},
+	}
+	for _, step := range steps {
+		_, err := executeInvoiceActivity(UpdateInvoiceStatusActivity, first.ID, step.status)
+		if step.errorType == "" {
+			if err != nil {
+				t.Errorf("marking %s %s failed: %v", first.ID, step.status, err)
+			}
+			continue
+		}
+		if !errors.As(err, &appErr) || appErr.Type() != step.errorType || !appErr.NonRetryable() {
+			t.Errorf("marking %s %s = %v, want a non-retryable %s error", first.ID, step.status, err, step.errorType)
+		}
+	}
+
+	stored, err := store.ListInvoices(t.Context(), subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[0].ID != first.ID || stored[0].Status != invoices.StatusVoid || stored[1].Status != invoices.StatusOpen {
+		t.Errorf("stored invoices = %+v, want %s void and %s open, oldest first", stored, first.ID, second.ID)
+	}
+}
+
+func TestCreateDraftInvoiceIsIdempotent(t *testing.T) {
+	store := useMemoryInvoiceStore(t)
+	_, subscription := testInvoice()
+	charges := Charges{
+		Items: []InvoiceItem{{Description: "Premium", Quantity: 1, Amount: money.MustParse("49.99", "USD")}},
+		Total: money.MustParse("49.99", "USD"),
+	}
+
+	first, err := executeInvoiceActivity(CreateDraftInvoiceActivity, subscription, charges, "inv_retried")
+	if err != nil {
+		t.Fatalf("CreateDraftInvoiceActivity failed: %v", err)
+	}
+
+	// A retry after the draft was saved returns the stored draft, not a second one
+	charges.Total = money.MustParse("99.99", "USD")
+	retried, err := executeInvoiceActivity(CreateDraftInvoiceActivity, subscription, charges, "inv_retried")
+	if err != nil {
+		t.Fatalf("retried CreateDraftInvoiceActivity failed: %v", err)
+	}
+	if retried.ID != first.ID || retried.Amount != first.Amount {
+		t.Errorf("retry created %s for %s, want the stored %s for %s", retried.ID, retried.Amount, first.ID, first.Amount)
+	}
+	stored, err := store.ListInvoices(context.Background(), subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Errorf("store holds %d invoices, want 1", len(stored))
+	}
+
+	if _, err := executeInvoiceActivity(CreateDraftInvoiceActivity, subscription, charges, ""); err == nil {
+		t.Error("creating a draft invoice without an ID succeeded")
+	}
+}
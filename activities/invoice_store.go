@@ -0,0 +1,121 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/tanint/play-temporal/invoices"
+)
+
+// ErrInvoiceNotFound is returned when an invoice does not exist in the store
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+// ErrInvoiceNotEditable is returned when saving over an invoice that has been finalized
+var ErrInvoiceNotEditable = errors.New("invoice is no longer a draft")
+
+// InvoiceStore persists invoices and the status each is in
+type InvoiceStore interface {
+	// SaveInvoice inserts an invoice, or replaces one that is still a draft, such as with its
+	// edited items or finalized. Saving over a finalized invoice fails with ErrInvoiceNotEditable,
+	// and a status change the lifecycle does not allow with an *invoices.TransitionError.
+	SaveInvoice(ctx context.Context, invoice InvoiceDetails) error
+	// GetInvoice loads an invoice by ID
+	GetInvoice(ctx context.Context, invoiceID string) (InvoiceDetails, error)
+	// UpdateInvoiceStatus moves an existing invoice to a new status and returns it. A change the
+	// lifecycle does not allow fails with an *invoices.TransitionError and leaves it unchanged.
+	UpdateInvoiceStatus(ctx context.Context, invoiceID string, status invoices.Status) (InvoiceDetails, error)
+	// ListInvoices returns the invoices of a subscription, oldest first
+	ListInvoices(ctx context.Context, subscriptionID string) ([]InvoiceDetails, error)
+}
+
+// invoiceStore is the store used by the invoice activities.
+// It defaults to an in-memory store and is replaced by the worker at startup.
+var invoiceStore InvoiceStore = NewMemoryInvoiceStore()
+
+// SetInvoiceStore configures the store used by the invoice activities
+func SetInvoiceStore(store InvoiceStore) {
+	invoiceStore = store
+}
+
+// MemoryInvoiceStore is an in-memory InvoiceStore, useful for local runs and tests
+type MemoryInvoiceStore struct {
+	mu             sync.RWMutex
+	invoices       map[string]InvoiceDetails
+	bySubscription map[string][]string // invoice IDs keyed by subscription ID, oldest first
+}
+
+// NewMemoryInvoiceStore creates an empty in-memory invoice store
+func NewMemoryInvoiceStore() *MemoryInvoiceStore {
+	return &MemoryInvoiceStore{
+		invoices:       make(map[string]InvoiceDetails),
+		bySubscription: make(map[string][]string),
+	}
+}
+
+// SaveInvoice inserts an invoice or replaces a draft
+func (s *MemoryInvoiceStore) SaveInvoice(ctx context.Context, invoice InvoiceDetails) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.invoices[invoice.ID]
+	if !ok {
+		if !invoice.Status.Valid() {
+			return fmt.Errorf("%w: %q", invoices.ErrUnknownStatus, invoice.Status)
+		}
+		s.invoices[invoice.ID] = invoice
+		s.bySubscription[invoice.SubscriptionID] = append(s.bySubscription[invoice.SubscriptionID], invoice.ID)
+		return nil
+	}
+	if !existing.Status.Editable() {
+		return fmt.Errorf("%w: %s is %s", ErrInvoiceNotEditable, invoice.ID, existing.Status)
+	}
+	if err := invoices.Transition(existing.Status, invoice.Status); err != nil {
+		return err
+	}
+	s.invoices[invoice.ID] = invoice
+	return nil
+}
+
+// GetInvoice loads an invoice by ID
+func (s *MemoryInvoiceStore) GetInvoice(ctx context.Context, invoiceID string) (InvoiceDetails, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	invoice, ok := s.invoices[invoiceID]
+	if !ok {
+		return InvoiceDetails{}, fmt.Errorf("%w: %s", ErrInvoiceNotFound, invoiceID)
+	}
+	return invoice, nil
+}
+
+// UpdateInvoiceStatus moves an existing invoice to a new status
+func (s *MemoryInvoiceStore) UpdateInvoiceStatus(ctx context.Context, invoiceID string, status invoices.Status) (InvoiceDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invoice, ok := s.invoices[invoiceID]
+	if !ok {
+		return InvoiceDetails{}, fmt.Errorf("%w: %s", ErrInvoiceNotFound, invoiceID)
+	}
+	if err := invoices.Transition(invoice.Status, status); err != nil {
+		return InvoiceDetails{}, err
+	}
+	invoice.Status = status
+	s.invoices[invoiceID] = invoice
+	return invoice, nil
+}
+
+// ListInvoices returns the invoices of a subscription, oldest first
+func (s *MemoryInvoiceStore) ListInvoices(ctx context.Context, subscriptionID string) ([]InvoiceDetails, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.bySubscription[subscriptionID]
+	list := make([]InvoiceDetails, len(ids))
+	for i, id := range ids {
+		list[i] = s.invoices[id]
+	}
+	return list, nil
+}
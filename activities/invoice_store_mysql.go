@@ -0,0 +1,185 @@
+package activities
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/invoices"
+)
+
+// The invoice's items, tax and amounts are kept as a JSON document; the columns beside it are the
+// ones invoices are looked up and listed by
+const createInvoicesTable = `
+CREATE TABLE IF NOT EXISTS invoices (
+	id              VARCHAR(64)  NOT NULL PRIMARY KEY,
+	subscription_id VARCHAR(64)  NOT NULL,
+	number          VARCHAR(64)  NOT NULL,
+	status          VARCHAR(32)  NOT NULL,
+	period_start    DATETIME(6)  NULL,
+	period_end      DATETIME(6)  NULL,
+	document        JSON         NOT NULL,
+	seq             BIGINT       NOT NULL AUTO_INCREMENT UNIQUE,
+	updated_at      TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	INDEX idx_invoices_subscription (subscription_id, seq)
+)`
+
+// MySQLInvoiceStore is an InvoiceStore backed by a MySQL table
+type MySQLInvoiceStore struct {
+	db *sql.DB
+}
+
+// NewMySQLInvoiceStore creates a MySQL-backed invoice store and makes sure its table exists
+func NewMySQLInvoiceStore(ctx context.Context, db *sql.DB) (*MySQLInvoiceStore, error) {
+	if _, err := db.ExecContext(ctx, createInvoicesTable); err != nil {
+		return nil, fmt.Errorf("creating invoices table: %w", err)
+	}
+	return &MySQLInvoiceStore{db: db}, nil
+}
+
+// SaveInvoice inserts an invoice or replaces a draft
+func (s *MySQLInvoiceStore) SaveInvoice(ctx context.Context, invoice InvoiceDetails) error {
+	document, err := json.Marshal(invoice)
+	if err != nil {
+		return fmt.Errorf("saving invoice %s: %w", invoice.ID, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("saving invoice %s: %w", invoice.ID, err)
+	}
+	defer tx.Rollback()
+
+	// Lock the existing row, if any, so a concurrent status change cannot slip in between
+	var status invoices.Status
+	err = tx.QueryRowContext(ctx, `SELECT status FROM invoices WHERE id = ? FOR UPDATE`, invoice.ID).Scan(&status)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if !invoice.Status.Valid() {
+			return fmt.Errorf("%w: %q", invoices.ErrUnknownStatus, invoice.Status)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO invoices (id, subscription_id, number, status, period_start, period_end, document)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			invoice.ID,
+			invoice.SubscriptionID,
+			invoice.Number,
+			invoice.Status,
+			nullTime(invoice.Period.Start),
+			nullTime(invoice.Period.End),
+			document,
+		)
+	case err != nil:
+		return fmt.Errorf("saving invoice %s: %w", invoice.ID, err)
+	case !status.Editable():
+		return fmt.Errorf("%w: %s is %s", ErrInvoiceNotEditable, invoice.ID, status)
+	default:
+		if err := invoices.Transition(status, invoice.Status); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE invoices SET number = ?, status = ?, period_start = ?, period_end = ?, document = ? WHERE id = ?`,
+			invoice.Number,
+			invoice.Status,
+			nullTime(invoice.Period.Start),
+			nullTime(invoice.Period.End),
+			document,
+			invoice.ID,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("saving invoice %s: %w", invoice.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("saving invoice %s: %w", invoice.ID, err)
+	}
+	return nil
+}
+
+// GetInvoice loads an invoice by ID
+func (s *MySQLInvoiceStore) GetInvoice(ctx context.Context, invoiceID string) (InvoiceDetails, error) {
+	return s.get(ctx, s.db, invoiceID, "")
+}
+
+// UpdateInvoiceStatus moves an existing invoice to a new status
+func (s *MySQLInvoiceStore) UpdateInvoiceStatus(ctx context.Context, invoiceID string, status invoices.Status) (InvoiceDetails, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return InvoiceDetails{}, fmt.Errorf("updating status of invoice %s: %w", invoiceID, err)
+	}
+	defer tx.Rollback()
+
+	invoice, err := s.get(ctx, tx, invoiceID, " FOR UPDATE")
+	if err != nil {
+		return InvoiceDetails{}, err
+	}
+	if err := invoices.Transition(invoice.Status, status); err != nil {
+		return InvoiceDetails{}, err
+	}
+	invoice.Status = status
+	document, err := json.Marshal(invoice)
+	if err != nil {
+		return InvoiceDetails{}, fmt.Errorf("updating status of invoice %s: %w", invoiceID, err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE invoices SET status = ?, document = ? WHERE id = ?`,
+		status, document, invoiceID,
+	)
+	if err != nil {
+		return InvoiceDetails{}, fmt.Errorf("updating status of invoice %s: %w", invoiceID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return InvoiceDetails{}, fmt.Errorf("updating status of invoice %s: %w", invoiceID, err)
+	}
+	return invoice, nil
+}
+
+// ListInvoices returns the invoices of a subscription, oldest first
+func (s *MySQLInvoiceStore) ListInvoices(ctx context.Context, subscriptionID string) ([]InvoiceDetails, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT document FROM invoices WHERE subscription_id = ? ORDER BY seq`,
+		subscriptionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing invoices of subscription %s: %w", subscriptionID, err)
+	}
+	defer rows.Close()
+
+	var list []InvoiceDetails
+	for rows.Next() {
+		var document []byte
+		if err := rows.Scan(&document); err != nil {
+			return nil, fmt.Errorf("listing invoices of subscription %s: %w", subscriptionID, err)
+		}
+		var invoice InvoiceDetails
+		if err := json.Unmarshal(document, &invoice); err != nil {
+			return nil, fmt.Errorf("listing invoices of subscription %s: %w", subscriptionID, err)
+		}
+		list = append(list, invoice)
+	}
+	return list, rows.Err()
+}
+
+// queryRower is a *sql.DB or a *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// get loads an invoice's document, with a locking clause appended to the query when one is given
+func (s *MySQLInvoiceStore) get(ctx context.Context, q queryRower, invoiceID string, lock string) (InvoiceDetails, error) {
+	var document []byte
+	err := q.QueryRowContext(ctx, `SELECT document FROM invoices WHERE id = ?`+lock, invoiceID).Scan(&document)
+	if errors.Is(err, sql.ErrNoRows) {
+		return InvoiceDetails{}, fmt.Errorf("%w: %s", ErrInvoiceNotFound, invoiceID)
+	}
+	if err != nil {
+		return InvoiceDetails{}, fmt.Errorf("loading invoice %s: %w", invoiceID, err)
+	}
+	var invoice InvoiceDetails
+	if err := json.Unmarshal(document, &invoice); err != nil {
+		return InvoiceDetails{}, fmt.Errorf("loading invoice %s: %w", invoiceID, err)
+	}
+	return invoice, nil
+}
@@ -0,0 +1,235 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/ledger"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/payments"
+)
+
+// generalLedger is the double-entry journal every movement of money is posted to.
+// It defaults to an in-memory journal and is replaced by the worker at startup.
+var generalLedger ledger.Store = ledger.NewMemoryStore()
+
+// SetLedgerStore configures the journal money movements are posted to
+func SetLedgerStore(store ledger.Store) {
+	generalLedger = store
+}
+
+// postEntry posts an entry to the journal. Entries are keyed by what they record, such as an
+// invoice or a payment's idempotency key, so posting again from a retried activity changes nothing.
+func postEntry(ctx context.Context, entry ledger.Entry) error {
+	if entry.PostedAt.IsZero() {
+		entry.PostedAt = time.Now()
+	}
+	posted, created, err := generalLedger.Post(ctx, entry)
+	if err != nil {
+		return err
+	}
+	if !created {
+		fmt.Printf("[Ledger Activity] Entry %s was already posted\n", posted.ID)
+		return nil
+	}
+	for _, posting := range posted.Postings {
+		fmt.Printf("[Ledger Activity] %s: %-6s %-19s %s\n", posted.ID, posting.Side, posting.Account, posting.Amount)
+	}
+	return nil
+}
+
+// invoiceEntry records an invoice being issued: the amount left to pay is owed by the customer,
+// the credit applied comes out of their credit balance, and the total is revenue and tax
+func invoiceEntry(invoice InvoiceDetails, subscription SubscriptionDetails) (ledger.Entry, error) {
+	revenue, err := invoice.Tax.Total.Sub(invoice.Tax.Tax)
+	if err != nil {
+		return ledger.Entry{}, err
+	}
+	entry := ledger.NewEntry("invoice/"+invoice.ID, "Invoice "+invoice.ID,
+		ledger.Dr(ledger.AccountsReceivable, invoice.Amount),
+		ledger.Dr(ledger.CustomerCredit, invoice.CreditApplied),
+		ledger.Cr(ledger.Revenue, revenue),
+		ledger.Cr(ledger.TaxPayable, invoice.Tax.Tax),
+	)
+	entry.InvoiceID = invoice.ID
+	entry.SubscriptionID = subscription.ID
+	entry.CustomerID = subscription.CustomerID
+	entry.PostedAt = invoice.IssuedAt
+	return entry, nil
+}
+
+// PostInvoiceActivity posts an issued invoice to the ledger, once its tax and the credit applied
+// to it are known. An invoice of zero is not posted.
+func PostInvoiceActivity(ctx context.Context, invoice InvoiceDetails, subscription SubscriptionDetails) error {
+	entry, err := invoiceEntry(invoice, subscription)
+	if err != nil {
+		return err
+	}
+	if len(entry.Postings) == 0 {
+		return nil
+	}
+	return postEntry(ctx, entry)
+}
+
+// postPayment posts a captured payment: the cash collected settles what the customer owes.
+// Failed payments and invoices paid entirely from credit move no cash and are not posted.
+func postPayment(ctx context.Context, payment payments.Payment, customerID string) error {
+	if payment.Status != payments.StatusSucceeded || payment.Amount.Sign() <= 0 {
+		return nil
+	}
+	entry := ledger.NewEntry("payment/"+payment.IdempotencyKey, "Payment "+payment.ID,
+		ledger.Dr(ledger.Cash, payment.Amount),
+		ledger.Cr(ledger.AccountsReceivable, payment.Amount),
+	)
+	entry.InvoiceID = payment.InvoiceID
+	entry.SubscriptionID = payment.SubscriptionID
+	entry.CustomerID = customerID
+	entry.PostedAt = payment.ProcessedAt
+	return postEntry(ctx, entry)
+}
+
+// postCreditNote posts a credit note: the amount is given back out of revenue, as cash refunded
+// to the payment method or as credit added to the customer's balance
+func postCreditNote(ctx context.Context, note credits.CreditNote) error {
+	to := ledger.Cash
+	if note.Destination == credits.DestinationBalance {
+		to = ledger.CustomerCredit
+	}
+	entry := ledger.NewEntry("credit_note/"+note.ID, "Credit note "+note.ID,
+		ledger.Dr(ledger.Refunds, note.Amount),
+		ledger.Cr(to, note.Amount),
+	)
+	entry.InvoiceID = note.InvoiceID
+	entry.SubscriptionID = note.SubscriptionID
+	entry.CustomerID = note.CustomerID
+	entry.PostedAt = note.CreatedAt
+	return postEntry(ctx, entry)
+}
+
+// Reconciliation is the result of checking the ledger against invoices and the payments and
+// credit notes recorded for them
+type Reconciliation struct {
+	Invoices int
+	// Discrepancies describes every amount the ledger disagrees on, empty when it agrees
+	Discrepancies []string
+}
+
+// Balanced reports whether the ledger agreed on everything
+func (r Reconciliation) Balanced() bool {
+	return len(r.Discrepancies) == 0
+}
+
+// ReconcileLedgerActivity checks the ledger against invoices. For each invoice, the entry posted
+// for it must match its amount, credit applied, revenue and tax; the cash posted must match the
+// payments recorded in the payment ledger less the refunds in its credit notes; and what is left
+// receivable must match what is left unpaid. The ledger's debits and credits must also balance in
+// every invoice's currency. Discrepancies are reported rather than returned as errors.
+func ReconcileLedgerActivity(ctx context.Context, invoices []InvoiceDetails) (Reconciliation, error) {
+	fmt.Printf("[Ledger Activity] Reconciling the ledger against %d invoices\n", len(invoices))
+
+	result := Reconciliation{Invoices: len(invoices)}
+	differ := func(invoiceID, what string, ledgerAmount, expected money.Money) {
+		if cmp, err := ledgerAmount.Cmp(expected); err != nil || cmp != 0 {
+			result.Discrepancies = append(result.Discrepancies, fmt.Sprintf(
+				"invoice %s: ledger has %s of %s, expected %s", invoiceID, what, ledgerAmount, expected))
+		}
+	}
+
+	currencies := make(map[string]bool)
+	for _, invoice := range invoices {
+		currency := invoice.Amount.Currency()
+		currencies[currency] = true
+
+		// The invoice's own entry
+		issued, found, err := generalLedger.GetEntry(ctx, "invoice/"+invoice.ID)
+		if err != nil {
+			return Reconciliation{}, err
+		}
+		expected, err := invoiceEntry(invoice, SubscriptionDetails{})
+		if err != nil {
+			return Reconciliation{}, err
+		}
+		if !found && len(expected.Postings) > 0 {
+			result.Discrepancies = append(result.Discrepancies,
+				fmt.Sprintf("invoice %s: no ledger entry for %s", invoice.ID, invoice.Amount))
+			continue
+		}
+		got, want := ledger.Sum([]ledger.Entry{issued}, currency), ledger.Sum([]ledger.Entry{expected}, currency)
+		for _, account := range ledger.Accounts {
+			differ(invoice.ID, "invoiced "+string(account), got[account].Balance(account), want[account].Balance(account))
+		}
+
+		// Cash collected less cash refunded
+		recorded, err := paymentLedger.ListByInvoice(ctx, invoice.ID)
+		if err != nil {
+			return Reconciliation{}, err
+		}
+		paid := money.Zero(currency)
+		for _, payment := range recorded {
+			if payment.Status == payments.StatusSucceeded && payment.Amount.Sign() > 0 {
+				if paid, err = paid.Add(payment.Amount); err != nil {
+					return Reconciliation{}, err
+				}
+			}
+		}
+		notes, err := creditStore.ListCreditNotes(ctx, invoice.ID)
+		if err != nil {
+			return Reconciliation{}, err
+		}
+		cash, credited := paid, money.Zero(currency)
+		for _, note := range notes {
+			if credited, err = credited.Add(note.Amount); err != nil {
+				return Reconciliation{}, err
+			}
+			if note.Destination == credits.DestinationPaymentMethod {
+				if cash, err = cash.Sub(note.Amount); err != nil {
+					return Reconciliation{}, err
+				}
+			}
+		}
+		unpaid, err := invoice.Amount.Sub(paid)
+		if err != nil {
+			return Reconciliation{}, err
+		}
+
+		entries, err := generalLedger.ListByInvoice(ctx, invoice.ID)
+		if err != nil {
+			return Reconciliation{}, err
+		}
+		totals := ledger.Sum(entries, currency)
+		differ(invoice.ID, string(ledger.Cash), totals[ledger.Cash].Balance(ledger.Cash), cash)
+		differ(invoice.ID, string(ledger.Refunds), totals[ledger.Refunds].Balance(ledger.Refunds), credited)
+		differ(invoice.ID, string(ledger.AccountsReceivable), totals[ledger.AccountsReceivable].Balance(ledger.AccountsReceivable), unpaid)
+	}
+
+	// Every entry balances on its own, so the whole ledger must too
+	for currency := range currencies {
+		totals, err := generalLedger.Totals(ctx, currency)
+		if err != nil {
+			return Reconciliation{}, err
+		}
+		debited, credited := money.Zero(currency), money.Zero(currency)
+		for _, t := range totals {
+			if debited, err = debited.Add(t.Debits); err != nil {
+				return Reconciliation{}, err
+			}
+			if credited, err = credited.Add(t.Credits); err != nil {
+				return Reconciliation{}, err
+			}
+		}
+		if cmp, err := debited.Cmp(credited); err != nil || cmp != 0 {
+			result.Discrepancies = append(result.Discrepancies,
+				fmt.Sprintf("ledger does not balance in %s: debits %s, credits %s", currency, debited, credited))
+		}
+	}
+
+	if result.Balanced() {
+		fmt.Printf("[Ledger Activity] Ledger agrees with %d invoices\n", len(invoices))
+	}
+	for _, discrepancy := range result.Discrepancies {
+		fmt.Printf("[Ledger Activity] Discrepancy: %s\n", discrepancy)
+	}
+	return result, nil
+}
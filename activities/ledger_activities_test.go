@@ -0,0 +1,110 @@
+package activities
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/ledger"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/tax"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// useMemoryJournal swaps in an empty general ledger for the duration of a test
+func useMemoryJournal(t *testing.T) *ledger.MemoryStore {
+	t.Helper()
+	store := ledger.NewMemoryStore()
+	previous := generalLedger
+	SetLedgerStore(store)
+	t.Cleanup(func() { SetLedgerStore(previous) })
+	return store
+}
+
+func TestLedgerReconcilesBilledInvoice(t *testing.T) {
+	journal := useMemoryJournal(t)
+	useMemoryLedger(t)
+	useFakeGateway(t)
+	previous := creditStore
+	SetCreditStore(credits.NewMemoryStore())
+	t.Cleanup(func() { SetCreditStore(previous) })
+
+	// 50.00 plus 5.00 tax, 5.01 of it paid from credit
+	invoice, subscription := testInvoice()
+	invoice.Tax = tax.Summary{
+		Subtotal: money.MustParse("50.00", "USD"),
+		Tax:      money.MustParse("5.00", "USD"),
+		Total:    money.MustParse("55.00", "USD"),
+	}
+	invoice.CreditApplied = money.MustParse("5.01", "USD")
+	invoice.Amount = money.MustParse("49.99", "USD")
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(PostInvoiceActivity)
+	env.RegisterActivity(IssueCreditNoteActivity)
+	env.RegisterActivity(ReconcileLedgerActivity)
+
+	reconcile := func(invoices ...InvoiceDetails) Reconciliation {
+		t.Helper()
+		result, err := env.ExecuteActivity(ReconcileLedgerActivity, invoices)
+		if err != nil {
+			t.Fatalf("ReconcileLedgerActivity failed: %v", err)
+		}
+		var reconciliation Reconciliation
+		if err := result.Get(&reconciliation); err != nil {
+			t.Fatal(err)
+		}
+		return reconciliation
+	}
+
+	// Posting is idempotent, so a retried activity posts the invoice once
+	for i := 0; i < 2; i++ {
+		if _, err := env.ExecuteActivity(PostInvoiceActivity, invoice, subscription); err != nil {
+			t.Fatalf("PostInvoiceActivity failed: %v", err)
+		}
+	}
+	processPayment(t, invoice, subscription, 0)
+	processPayment(t, invoice, subscription, 0)
+	note := credits.CreditNote{
+		ID:          "cn_inv_1_1",
+		InvoiceID:   invoice.ID,
+		CustomerID:  subscription.CustomerID,
+		Amount:      money.MustParse("10.00", "USD"),
+		Destination: credits.DestinationPaymentMethod,
+	}
+	if _, err := env.ExecuteActivity(IssueCreditNoteActivity, note); err != nil {
+		t.Fatalf("IssueCreditNoteActivity failed: %v", err)
+	}
+
+	if reconciliation := reconcile(invoice); !reconciliation.Balanced() {
+		t.Errorf("reconciliation found discrepancies: %v", reconciliation.Discrepancies)
+	}
+
+	totals, err := journal.Totals(context.Background(), "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[ledger.Account]string{
+		ledger.Cash:               "39.99",
+		ledger.AccountsReceivable: "0.00",
+		ledger.CustomerCredit:     "-5.01",
+		ledger.Revenue:            "50.00",
+		ledger.TaxPayable:         "5.00",
+		ledger.Refunds:            "10.00",
+	}
+	for account, balance := range want {
+		if got := totals[account].Balance(account); got != money.MustParse(balance, "USD") {
+			t.Errorf("balance of %s = %s, want %s USD", account, got, balance)
+		}
+	}
+
+	// An invoice that was never posted, and one whose amount disagrees with its entry
+	unposted := invoice
+	unposted.ID = "inv_2"
+	changed := invoice
+	changed.Amount = money.MustParse("59.99", "USD")
+	if reconciliation := reconcile(unposted, changed); len(reconciliation.Discrepancies) < 2 {
+		t.Errorf("discrepancies = %v, want the unposted and the changed invoice", reconciliation.Discrepancies)
+	}
+}
@@ -0,0 +1,116 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/tanint/play-temporal/notify"
+	"go.temporal.io/sdk/temporal"
+)
+
+// notifier delivers billing emails. By default they are written to the outbox in the blob store;
+// the worker switches to SMTP when a server is configured.
+var notifier notify.Notifier = notify.NewOutbox(blobStore, "billing@example.com")
+
+// notificationLog remembers which emails have been sent, so that retries never send one twice
+var notificationLog notify.SentLog = notify.NewMemorySentLog()
+
+// customerEmailDomain is the domain of customers' email addresses, which are <customer ID>@<domain>
+var customerEmailDomain = "example.com"
+
+// SetNotifier configures how billing emails are delivered
+func SetNotifier(n notify.Notifier) {
+	notifier = n
+}
+
+// SetNotificationLog configures the log of sent emails
+func SetNotificationLog(log notify.SentLog) {
+	notificationLog = log
+}
+
+// SetCustomerEmailDomain configures the domain of customers' email addresses
+func SetCustomerEmailDomain(domain string) {
+	customerEmailDomain = domain
+}
+
+// sendNotification emails a customer the notification of the given kind. The key identifies the
+// notification among those of its kind, e.g. the invoice ID, and an email already sent for the same
+// kind and key is not sent again.
+func sendNotification(ctx context.Context, kind notify.Kind, key string, customerID string, data notify.Data, attachments []notify.Attachment) error {
+	id := string(kind) + "/" + key
+	message, err := notify.Compose(kind, id, customerID+"@"+customerEmailDomain, data)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "InvalidEmailTemplate", err)
+	}
+	message.Date = time.Now()
+	message.Attachments = attachments
+
+	sent, err := notify.Deliver(ctx, notifier, notificationLog, message)
+	if err != nil {
+		return err
+	}
+	if sent {
+		fmt.Printf("[Notification Activity] Sent %q to %s (%s)\n", message.Subject, message.To, id)
+	} else {
+		fmt.Printf("[Notification Activity] Email %s was already sent\n", id)
+	}
+	return nil
+}
+
+// planName returns the name of a plan for an email, or its ID if the catalog does not have it
+func planName(planID string) string {
+	if plan, err := lookupPlan(planID); err == nil {
+		return plan.Name
+	}
+	return planID
+}
+
+// SendPaymentReceiptEmailActivity emails the customer a receipt for an invoice's successful payment
+func SendPaymentReceiptEmailActivity(ctx context.Context, invoice InvoiceDetails, subscription SubscriptionDetails, payment PaymentDetails) error {
+	fmt.Printf("[Notification Activity] Sending payment receipt for invoice %s to customer %s\n",
+		invoice.ID, subscription.CustomerID)
+
+	return sendNotification(ctx, notify.PaymentSucceeded, invoice.ID, subscription.CustomerID, notify.Data{
+		CustomerID:     subscription.CustomerID,
+		SubscriptionID: subscription.ID,
+		PlanName:       planName(subscription.PlanID),
+		InvoiceID:      invoice.ID,
+		InvoiceNumber:  invoice.Number,
+		Amount:         payment.Amount,
+		PaymentID:      payment.ID,
+	}, nil)
+}
+
+// SendSubscriptionCanceledEmailActivity emails the customer that their subscription was canceled.
+// A subscription is only canceled once, so at most one such email is sent for it.
+func SendSubscriptionCanceledEmailActivity(ctx context.Context, subscription SubscriptionDetails, canceledAt time.Time, reason string) error {
+	fmt.Printf("[Notification Activity] Sending cancellation email for subscription %s to customer %s\n",
+		subscription.ID, subscription.CustomerID)
+
+	return sendNotification(ctx, notify.SubscriptionCanceled, subscription.ID, subscription.CustomerID, notify.Data{
+		CustomerID:     subscription.CustomerID,
+		SubscriptionID: subscription.ID,
+		PlanName:       planName(subscription.PlanID),
+		CanceledAt:     canceledAt,
+		Reason:         reason,
+	}, nil)
+}
+
+// invoiceAttachments loads an invoice's rendered documents from the blob store to attach to its email
+func invoiceAttachments(ctx context.Context, documents InvoiceDocuments) ([]notify.Attachment, error) {
+	var attachments []notify.Attachment
+	for _, object := range documents.Attachments() {
+		data, err := blobStore.Get(ctx, object.Key)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, notify.Attachment{
+			Filename:    path.Base(object.Key),
+			ContentType: object.ContentType,
+			Data:        data,
+		})
+	}
+	return attachments, nil
+}
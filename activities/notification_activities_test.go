@@ -0,0 +1,65 @@
+package activities
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tanint/play-temporal/blobs"
+	"github.com/tanint/play-temporal/notify"
+)
+
+// recordingNotifier keeps every message it is asked to send
+type recordingNotifier struct {
+	messages []notify.Message
+}
+
+func (n *recordingNotifier) Send(ctx context.Context, message notify.Message) error {
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+// useRecordingNotifier swaps in a recording notifier, an empty sent log and a blob store in a
+// temporary directory for the duration of a test
+func useRecordingNotifier(t *testing.T) *recordingNotifier {
+	t.Helper()
+	recorder := &recordingNotifier{}
+	previousNotifier, previousLog, previousStore := notifier, notificationLog, blobStore
+	SetNotifier(recorder)
+	SetNotificationLog(notify.NewMemorySentLog())
+	SetBlobStore(blobs.NewFileStore(t.TempDir()))
+	t.Cleanup(func() {
+		SetNotifier(previousNotifier)
+		SetNotificationLog(previousLog)
+		SetBlobStore(previousStore)
+	})
+	return recorder
+}
+
+func TestInvoiceEmailIsSentOnceWithDocuments(t *testing.T) {
+	recorder := useRecordingNotifier(t)
+	invoice, subscription := testInvoice()
+	payment := PaymentDetails{ID: "pay_1", InvoiceID: invoice.ID, Amount: invoice.Amount, Status: "succeeded"}
+
+	documents, err := RenderInvoiceActivity(context.Background(), invoice, subscription, payment)
+	if err != nil {
+		t.Fatalf("RenderInvoiceActivity failed: %v", err)
+	}
+
+	// A retry of the activity must not email the customer again
+	for i := 0; i < 2; i++ {
+		if err := SendInvoiceEmailActivity(context.Background(), invoice, subscription, payment, documents); err != nil {
+			t.Fatalf("SendInvoiceEmailActivity failed: %v", err)
+		}
+	}
+
+	if len(recorder.messages) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(recorder.messages))
+	}
+	message := recorder.messages[0]
+	if message.ID != "invoice_issued/inv_1" || message.To != "cust_1@example.com" {
+		t.Errorf("email %s to %s, want invoice_issued/inv_1 to cust_1@example.com", message.ID, message.To)
+	}
+	if len(message.Attachments) != 2 || message.Attachments[0].Filename != "inv_1.pdf" || message.Attachments[1].Filename != "inv_1.html" {
+		t.Errorf("attachments = %v, want inv_1.pdf and inv_1.html", message.Attachments)
+	}
+}
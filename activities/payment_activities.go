@@ -0,0 +1,96 @@
+package activities
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tanint/play-temporal/payments"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Application error types returned by ProcessPaymentActivity when a charge fails. Each carries
+// the failed PaymentDetails as error details.
+const (
+	// CardDeclinedErrorType is a card the gateway refused or does not know
+	CardDeclinedErrorType = "CardDeclined"
+	// InsufficientFundsErrorType is a card without enough funds, which may work in a few days
+	InsufficientFundsErrorType = "InsufficientFunds"
+	// GatewayTimeoutErrorType is a gateway that could not be reached or asked to try again later
+	GatewayTimeoutErrorType = "GatewayTimeout"
+	// FraudSuspectedErrorType is a card reported lost or stolen, or a charge flagged as fraudulent
+	FraudSuspectedErrorType = "FraudSuspected"
+)
+
+// PaymentNonRetryableErrorTypes are the payment failures that retrying the activity cannot fix.
+// Workflows list them in the NonRetryableErrorTypes of their billing activity options, so that
+// only gateway timeouts are retried right away and declines are left to dunning.
+var PaymentNonRetryableErrorTypes = []string{
+	CardDeclinedErrorType,
+	InsufficientFundsErrorType,
+	FraudSuspectedErrorType,
+}
+
+// declineErrorTypes maps gateway decline codes to error types. Unlisted codes are CardDeclined.
+var declineErrorTypes = map[string]string{
+	payments.DeclineInsufficientFunds: InsufficientFundsErrorType,
+	payments.DeclineProcessingError:   GatewayTimeoutErrorType,
+	payments.DeclineTryAgainLater:     GatewayTimeoutErrorType,
+	payments.DeclineLostCard:          FraudSuspectedErrorType,
+	payments.DeclineStolenCard:        FraudSuspectedErrorType,
+	payments.DeclineFraudulent:        FraudSuspectedErrorType,
+}
+
+// paymentLedger records every charge attempt by idempotency key so that a retried payment
+// activity returns the first attempt's result instead of charging again.
+// It defaults to an in-memory ledger and is replaced by the worker at startup.
+var paymentLedger payments.Ledger = payments.NewMemoryLedger()
+
+// paymentGateway moves the money. It defaults to the local fake gateway and is replaced by
+// the worker at startup when a real gateway is configured.
+var paymentGateway payments.Gateway = payments.NewFakeGateway()
+
+// SetPaymentLedger configures the ledger used by the payment activities
+func SetPaymentLedger(ledger payments.Ledger) {
+	paymentLedger = ledger
+}
+
+// SetPaymentGateway configures the gateway used by the payment activities
+func SetPaymentGateway(gateway payments.Gateway) {
+	paymentGateway = gateway
+}
+
+// paymentDetails converts a ledger entry into the payment returned to workflows
+func paymentDetails(payment payments.Payment) PaymentDetails {
+	return PaymentDetails{
+		ID:              payment.ID,
+		InvoiceID:       payment.InvoiceID,
+		IdempotencyKey:  payment.IdempotencyKey,
+		Amount:          payment.Amount,
+		Currency:        payment.Amount.Currency(),
+		Status:          payment.Status,
+		PaymentMethodID: payment.PaymentMethodID,
+		ProcessedAt:     payment.ProcessedAt,
+	}
+}
+
+// paymentError turns a gateway failure into the typed application error returned by a payment
+// activity. Failures that are not about the payment, such as a bad request, are returned as is.
+func paymentError(err error, failed PaymentDetails) error {
+	var decline *payments.DeclineError
+	errorType := ""
+	switch {
+	case errors.As(err, &decline):
+		failed.DeclineCode = decline.Code
+		errorType = declineErrorTypes[decline.Code]
+		if errorType == "" {
+			errorType = CardDeclinedErrorType
+		}
+	case errors.Is(err, payments.ErrPaymentMethodNotFound):
+		errorType = CardDeclinedErrorType
+	case errors.Is(err, payments.ErrGatewayUnavailable), errors.Is(err, context.DeadlineExceeded):
+		errorType = GatewayTimeoutErrorType
+	default:
+		return err
+	}
+	return temporal.NewApplicationErrorWithCause(err.Error(), errorType, err, failed)
+}
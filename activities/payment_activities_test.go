@@ -0,0 +1,266 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/payments"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// useMemoryLedger swaps in an empty payment ledger for the duration of a test
+func useMemoryLedger(t *testing.T) *payments.MemoryLedger {
+	t.Helper()
+	ledger := payments.NewMemoryLedger()
+	previous := paymentLedger
+	SetPaymentLedger(ledger)
+	t.Cleanup(func() { SetPaymentLedger(previous) })
+	return ledger
+}
+
+// useFakeGateway swaps in a fresh fake payment gateway for the duration of a test
+func useFakeGateway(t *testing.T) *payments.FakeGateway {
+	t.Helper()
+	gateway := payments.NewFakeGateway()
+	previous := paymentGateway
+	SetPaymentGateway(gateway)
+	t.Cleanup(func() { SetPaymentGateway(previous) })
+	return gateway
+}
+
+func testInvoice() (InvoiceDetails, SubscriptionDetails) {
+	subscription := SubscriptionDetails{ID: "sub_1", CustomerID: "cust_1", PaymentMethodID: "pm_card_visa"}
+	invoice := InvoiceDetails{
+		ID:             "inv_1",
+		SubscriptionID: subscription.ID,
+		Amount:         money.MustParse("49.99", "USD"),
+		Currency:       "USD",
+	}
+	return invoice, subscription
+}
+
+// executePayment runs ProcessPaymentActivity in a test activity environment
+func executePayment(invoice InvoiceDetails, subscription SubscriptionDetails, attempt int) (PaymentDetails, error) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(ProcessPaymentActivity)
+
+	result, err := env.ExecuteActivity(ProcessPaymentActivity, invoice, subscription, attempt)
+	if err != nil {
+		return PaymentDetails{}, err
+	}
+	var payment PaymentDetails
+	err = result.Get(&payment)
+	return payment, err
+}
+
+func processPayment(t *testing.T, invoice InvoiceDetails, subscription SubscriptionDetails, attempt int) PaymentDetails {
+	t.Helper()
+	payment, err := executePayment(invoice, subscription, attempt)
+	if err != nil {
+		t.Fatalf("ProcessPaymentActivity failed: %v", err)
+	}
+	return payment
+}
+
+// crashingLedger is a payment ledger whose next crashes Record calls fail, as if the worker had
+// crashed after charging the gateway and before recording the payment
+type crashingLedger struct {
+	payments.Ledger
+	crashes int
+}
+
+func (l *crashingLedger) Record(ctx context.Context, payment payments.Payment) (payments.Payment, bool, error) {
+	if l.crashes > 0 {
+		l.crashes--
+		return payments.Payment{}, false, errors.New("worker crashed")
+	}
+	return l.Ledger.Record(ctx, payment)
+}
+
+func TestProcessPaymentActivityRetryDoesNotChargeTwice(t *testing.T) {
+	ledger := useMemoryLedger(t)
+	gateway := useFakeGateway(t)
+	invoice, subscription := testInvoice()
+
+	first := processPayment(t, invoice, subscription, 0)
+	retried := processPayment(t, invoice, subscription, 0)
+
+	if retried.ID != first.ID || retried.Status != first.Status {
+		t.Errorf("retry returned payment %s (%s), want %s (%s)", retried.ID, retried.Status, first.ID, first.Status)
+	}
+	if first.IdempotencyKey != payments.IdempotencyKey(invoice.ID, 0) {
+		t.Errorf("idempotency key = %q, want %q", first.IdempotencyKey, payments.IdempotencyKey(invoice.ID, 0))
+	}
+
+	charges, err := ledger.ListByInvoice(context.Background(), invoice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charges) != 1 {
+		t.Errorf("invoice was charged %d times, want 1", len(charges))
+	}
+	if n := len(gateway.Charges()); n != 1 {
+		t.Errorf("gateway took %d charges, want 1", n)
+	}
+}
+
+func TestProcessPaymentActivityRetryAfterCrashDoesNotChargeTwice(t *testing.T) {
+	ledger := &crashingLedger{Ledger: useMemoryLedger(t), crashes: 1}
+	SetPaymentLedger(ledger)
+	gateway := useFakeGateway(t)
+	invoice, subscription := testInvoice()
+
+	// The first attempt charges the gateway and crashes before recording the payment
+	if _, err := executePayment(invoice, subscription, 0); err == nil {
+		t.Fatal("attempt that crashed before recording the payment succeeded")
+	}
+	if n := len(gateway.Charges()); n != 1 {
+		t.Fatalf("gateway took %d charges before the crash, want 1", n)
+	}
+
+	// The retry finds nothing recorded and charges again under the same idempotency key, which
+	// the gateway answers with the charge it already took
+	retried := processPayment(t, invoice, subscription, 0)
+	charged := gateway.Charges()
+	if len(charged) != 1 {
+		t.Fatalf("gateway took %d charges, want 1", len(charged))
+	}
+	if retried.ID != charged[0].ID {
+		t.Errorf("retry recorded payment %s, want the charge taken before the crash %s", retried.ID, charged[0].ID)
+	}
+	charges, err := ledger.ListByInvoice(context.Background(), invoice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charges) != 1 {
+		t.Errorf("invoice was charged %d times, want 1", len(charges))
+	}
+}
+
+func TestProcessPaymentActivityConcurrentRetriesChargeOnce(t *testing.T) {
+	ledger := useMemoryLedger(t)
+	gateway := useFakeGateway(t)
+	invoice, subscription := testInvoice()
+
+	const attempts = 5
+	results := make([]PaymentDetails, attempts)
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = executePayment(invoice, subscription, 0)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("ProcessPaymentActivity failed: %v", err)
+		}
+	}
+
+	for _, payment := range results[1:] {
+		if payment.ID != results[0].ID {
+			t.Errorf("concurrent retries returned payments %s and %s", results[0].ID, payment.ID)
+		}
+	}
+	charges, err := ledger.ListByInvoice(context.Background(), invoice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charges) != 1 {
+		t.Errorf("invoice was charged %d times, want 1", len(charges))
+	}
+	if n := len(gateway.Charges()); n != 1 {
+		t.Errorf("gateway took %d charges, want 1", n)
+	}
+}
+
+func TestProcessPaymentActivityNewAttemptChargesAgain(t *testing.T) {
+	ledger := useMemoryLedger(t)
+	invoice, subscription := testInvoice()
+
+	first := processPayment(t, invoice, subscription, 0)
+	retry := processPayment(t, invoice, subscription, 1)
+
+	if retry.IdempotencyKey == first.IdempotencyKey {
+		t.Errorf("dunning retry reused idempotency key %q", first.IdempotencyKey)
+	}
+	charges, err := ledger.ListByInvoice(context.Background(), invoice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charges) != 2 {
+		t.Errorf("invoice has %d charge attempts, want 2", len(charges))
+	}
+}
+
+func TestProcessPaymentActivityFailures(t *testing.T) {
+	tests := []struct {
+		paymentMethodID string
+		errorType       string
+	}{
+		{"pm_card_declined", CardDeclinedErrorType},
+		{"pm_card_expired", CardDeclinedErrorType},
+		{"card_unknown", CardDeclinedErrorType},
+		{"pm_card_insufficient_funds", InsufficientFundsErrorType},
+		{"pm_card_stolen", FraudSuspectedErrorType},
+		{"pm_card_busy", GatewayTimeoutErrorType},
+	}
+
+	for _, tt := range tests {
+		ledger := useMemoryLedger(t)
+		gateway := useFakeGateway(t)
+		gateway.SetCard("pm_card_busy", payments.CardBehavior{DeclineCode: payments.DeclineTryAgainLater})
+		invoice, subscription := testInvoice()
+		subscription.PaymentMethodID = tt.paymentMethodID
+
+		_, err := executePayment(invoice, subscription, 0)
+		var appErr *temporal.ApplicationError
+		if !errors.As(err, &appErr) {
+			t.Errorf("charging %s = %v, want an application error", tt.paymentMethodID, err)
+			continue
+		}
+		if appErr.Type() != tt.errorType {
+			t.Errorf("charging %s failed with %s, want %s", tt.paymentMethodID, appErr.Type(), tt.errorType)
+		}
+
+		var failed PaymentDetails
+		if err := appErr.Details(&failed); err != nil {
+			t.Errorf("failure of %s has no payment details: %v", tt.paymentMethodID, err)
+		} else if failed.Status != payments.StatusFailed || failed.IdempotencyKey == "" {
+			t.Errorf("failure of %s carries payment %+v, want a failed attempt", tt.paymentMethodID, failed)
+		}
+
+		// Failed charges do not move money, so nothing is recorded under the key
+		charges, err := ledger.ListByInvoice(context.Background(), invoice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(charges) != 0 {
+			t.Errorf("failure of %s recorded %d payments, want 0", tt.paymentMethodID, len(charges))
+		}
+	}
+}
+
+func TestPaymentNonRetryableErrorTypes(t *testing.T) {
+	nonRetryable := make(map[string]bool)
+	for _, errorType := range PaymentNonRetryableErrorTypes {
+		nonRetryable[errorType] = true
+	}
+	for _, errorType := range []string{CardDeclinedErrorType, InsufficientFundsErrorType, FraudSuspectedErrorType} {
+		if !nonRetryable[errorType] {
+			t.Errorf("%s should not be retried by the activity", errorType)
+		}
+	}
+	if nonRetryable[GatewayTimeoutErrorType] {
+		t.Errorf("%s should be retried by the activity", GatewayTimeoutErrorType)
+	}
+}
@@ -0,0 +1,106 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/exchange"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/temporal"
+)
+
+// planCatalog is the catalog used to price subscriptions.
+// It is loaded and validated by the worker at startup.
+var planCatalog *catalog.Catalog
+
+// SetPlanCatalog configures the plan catalog used by the subscription activities
+func SetPlanCatalog(c *catalog.Catalog) {
+	planCatalog = c
+}
+
+// lookupPlan finds a plan in the configured catalog.
+// Unknown plans fail with a non-retryable error since retrying cannot fix them.
+func lookupPlan(planID string) (catalog.Plan, error) {
+	if planCatalog == nil {
+		return catalog.Plan{}, errors.New("plan catalog is not configured")
+	}
+
+	plan, err := planCatalog.Plan(planID)
+	if err != nil {
+		return catalog.Plan{}, temporal.NewNonRetryableApplicationError(err.Error(), "UnknownPlan", err)
+	}
+	return plan, nil
+}
+
+// LoadPlanActivity returns a plan from the catalog so workflows can price plan changes
+func LoadPlanActivity(ctx context.Context, planID string) (catalog.Plan, error) {
+	fmt.Printf("[Subscription Activity] Loading plan %s\n", planID)
+	return lookupPlan(planID)
+}
+
+// LoadCouponActivity returns a coupon from the catalog so a subscription can redeem it.
+// Unknown coupons fail with a non-retryable CouponNotFound error.
+func LoadCouponActivity(ctx context.Context, couponID string) (catalog.Coupon, error) {
+	fmt.Printf("[Subscription Activity] Loading coupon %s\n", couponID)
+
+	if planCatalog == nil {
+		return catalog.Coupon{}, errors.New("plan catalog is not configured")
+	}
+	coupon, err := planCatalog.Coupon(couponID)
+	if err != nil {
+		return catalog.Coupon{}, temporal.NewNonRetryableApplicationError(err.Error(), "CouponNotFound", err)
+	}
+	return coupon, nil
+}
+
+// exchangeRates converts plan prices into currencies the catalog does not price them in.
+// It is loaded from the exchange rates file by the worker at startup.
+var exchangeRates exchange.Provider
+
+// SetExchangeRates configures the exchange rates used to price subscriptions in other currencies
+func SetExchangeRates(provider exchange.Provider) {
+	exchangeRates = provider
+}
+
+// exchangeRate looks up the current rate between two currencies.
+// Missing rates fail with a non-retryable error since retrying cannot fix them.
+func exchangeRate(ctx context.Context, from, to string) (exchange.Rate, error) {
+	if exchangeRates == nil {
+		return exchange.Rate{}, errors.New("exchange rates are not configured")
+	}
+
+	rate, err := exchangeRates.Rate(ctx, from, to)
+	if errors.Is(err, exchange.ErrRateNotFound) {
+		return exchange.Rate{}, temporal.NewNonRetryableApplicationError(err.Error(), "ExchangeRateNotFound", err)
+	}
+	return rate, err
+}
+
+// planPrice prices a quantity of a plan in a currency. A price the catalog defines in the currency
+// is used as is; otherwise the plan's own price is converted and the rate used is returned too.
+func planPrice(ctx context.Context, plan catalog.Plan, quantity int64, currency string) (money.Money, exchange.Rate, error) {
+	if price, ok := plan.PriceIn(currency); ok {
+		return price.AmountFor(quantity, currency), exchange.Rate{}, nil
+	}
+
+	rate, err := exchangeRate(ctx, plan.Currency, currency)
+	if err != nil {
+		return money.Money{}, exchange.Rate{}, err
+	}
+	return plan.Price.ConvertedAmountFor(quantity, currency, rate.Rate), rate, nil
+}
+
+// PricePlanActivity prices a quantity of a plan in a currency at the current exchange rates,
+// so workflows can prorate a change to a plan priced in another currency
+func PricePlanActivity(ctx context.Context, planID string, quantity int64, currency string) (money.Money, error) {
+	fmt.Printf("[Subscription Activity] Pricing %d of plan %s in %s\n", quantity, planID, currency)
+
+	plan, err := lookupPlan(planID)
+	if err != nil {
+		return money.Money{}, err
+	}
+	price, _, err := planPrice(ctx, plan, quantity, currency)
+	return price, err
+}
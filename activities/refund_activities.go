@@ -0,0 +1,183 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/payments"
+	"go.temporal.io/sdk/temporal"
+)
+
+// RefundExceedsCapturedErrorType is the application error type of a refund larger than what is
+// left of the amount captured for an invoice
+const RefundExceedsCapturedErrorType = "RefundExceedsCaptured"
+
+// creditStore keeps credit notes and customer credit balances.
+// It defaults to an in-memory store and is replaced by the worker at startup.
+var creditStore credits.Store = credits.NewMemoryStore()
+
+// SetCreditStore configures the store used by the refund and credit activities
+func SetCreditStore(store credits.Store) {
+	creditStore = store
+}
+
+// RefundDetails contains information about a refund
+type RefundDetails struct {
+	ID              string
+	SubscriptionID  string
+	ChargeID        string // gateway charge refunded, when the refund went through the gateway
+	Amount          money.Money
+	Reason          string
+	Status          string
+	PaymentMethodID string
+	ProcessedAt     time.Time
+}
+
+// RefundableInvoice describes how much of a paid invoice can still be refunded
+type RefundableInvoice struct {
+	InvoiceID      string
+	SubscriptionID string
+	CustomerID     string
+	Payment        PaymentDetails // the payment that settled the invoice
+	Captured       money.Money
+	Credited       money.Money // total of the credit notes already issued
+	CreditNotes    int
+}
+
+// Remaining returns the part of the captured amount that has not been credited yet
+func (r RefundableInvoice) Remaining() (money.Money, error) {
+	return r.Captured.Sub(r.Credited)
+}
+
+// LoadRefundableInvoiceActivity finds the payment that settled an invoice and the credit notes
+// already issued against it
+func LoadRefundableInvoiceActivity(ctx context.Context, invoiceID string) (RefundableInvoice, error) {
+	fmt.Printf("[Refund Activity] Loading payments and credit notes for invoice %s\n", invoiceID)
+
+	recorded, err := paymentLedger.ListByInvoice(ctx, invoiceID)
+	if err != nil {
+		return RefundableInvoice{}, err
+	}
+	var paid *payments.Payment
+	for i := range recorded {
+		if recorded[i].Status == payments.StatusSucceeded {
+			paid = &recorded[i]
+			break
+		}
+	}
+	if paid == nil {
+		err := fmt.Errorf("invoice %s has no successful payment", invoiceID)
+		return RefundableInvoice{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvoiceNotPaid", err)
+	}
+
+	subscription, err := subscriptionStore.GetSubscription(ctx, paid.SubscriptionID)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return RefundableInvoice{}, temporal.NewNonRetryableApplicationError(err.Error(), "SubscriptionNotFound", err)
+	}
+	if err != nil {
+		return RefundableInvoice{}, err
+	}
+
+	notes, err := creditStore.ListCreditNotes(ctx, invoiceID)
+	if err != nil {
+		return RefundableInvoice{}, err
+	}
+	credited := money.Zero(paid.Amount.Currency())
+	for _, note := range notes {
+		if credited, err = credited.Add(note.Amount); err != nil {
+			return RefundableInvoice{}, err
+		}
+	}
+
+	invoice := RefundableInvoice{
+		InvoiceID:      invoiceID,
+		SubscriptionID: paid.SubscriptionID,
+		CustomerID:     subscription.CustomerID,
+		Payment:        paymentDetails(*paid),
+		Captured:       paid.Amount,
+		Credited:       credited,
+		CreditNotes:    len(notes),
+	}
+	fmt.Printf("[Refund Activity] Invoice %s captured %s, %s already credited in %d credit notes\n",
+		invoiceID, invoice.Captured, invoice.Credited, invoice.CreditNotes)
+
+	return invoice, nil
+}
+
+// RefundPaymentActivity refunds part or all of a payment through the payment gateway. The refund
+// is idempotent on key, so a retry returns the refund already made.
+func RefundPaymentActivity(ctx context.Context, payment PaymentDetails, amount money.Money, reason string, key string) (RefundDetails, error) {
+	fmt.Printf("[Refund Activity] Refunding %s of payment %s: %s\n", amount, payment.ID, reason)
+
+	refund, err := paymentGateway.Refund(ctx, payments.RefundRequest{
+		IdempotencyKey: key,
+		ChargeID:       payment.ID,
+		Amount:         amount,
+		Reason:         reason,
+	})
+	switch {
+	case errors.Is(err, payments.ErrRefundTooLarge):
+		return RefundDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), RefundExceedsCapturedErrorType, err)
+	case errors.Is(err, payments.ErrChargeNotFound):
+		return RefundDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "ChargeNotFound", err)
+	case err != nil:
+		return RefundDetails{}, err
+	}
+
+	details := RefundDetails{
+		ID:              refund.ID,
+		ChargeID:        refund.ChargeID,
+		Amount:          refund.Amount,
+		Reason:          reason,
+		Status:          "succeeded",
+		PaymentMethodID: payment.PaymentMethodID,
+		ProcessedAt:     refund.CreatedAt,
+	}
+	fmt.Printf("[Refund Activity] Processed refund %s of %s for payment %s\n", details.ID, details.Amount, payment.ID)
+
+	return details, nil
+}
+
+// IssueCreditNoteActivity issues a credit note against an invoice and posts it to the ledger.
+// Issuing a note whose ID was already used returns the note issued first.
+func IssueCreditNoteActivity(ctx context.Context, note credits.CreditNote) (credits.CreditNote, error) {
+	fmt.Printf("[Refund Activity] Issuing credit note %s of %s for invoice %s\n", note.ID, note.Amount, note.InvoiceID)
+
+	issued, created, err := creditStore.IssueCreditNote(ctx, note)
+	if err != nil {
+		return credits.CreditNote{}, err
+	}
+	if !created {
+		fmt.Printf("[Refund Activity] Credit note %s was already issued\n", issued.ID)
+	}
+	if err := postCreditNote(ctx, issued); err != nil {
+		return credits.CreditNote{}, err
+	}
+	return issued, nil
+}
+
+// CreditCustomerBalanceActivity records a transaction on a customer's credit balance and returns
+// the new balance in the transaction's currency. A retried transaction is only counted once. The
+// ledger side of the credit is posted with its credit note.
+func CreditCustomerBalanceActivity(ctx context.Context, transaction credits.BalanceTransaction) (money.Money, error) {
+	fmt.Printf("[Refund Activity] Adding %s to the balance of customer %s: %s\n",
+		transaction.Amount, transaction.CustomerID, transaction.Description)
+
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now()
+	}
+	if _, _, err := creditStore.RecordTransaction(ctx, transaction); err != nil {
+		return money.Money{}, err
+	}
+	balance, err := creditStore.Balance(ctx, transaction.CustomerID, transaction.Amount.Currency())
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	fmt.Printf("[Refund Activity] Customer %s now has a credit balance of %s\n", transaction.CustomerID, balance)
+	return balance, nil
+}
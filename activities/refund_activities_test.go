@@ -0,0 +1,91 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// useMemorySubscriptionStore swaps in an empty subscription store for the duration of a test
+func useMemorySubscriptionStore(t *testing.T) *MemorySubscriptionStore {
+	t.Helper()
+	store := NewMemorySubscriptionStore()
+	previous := subscriptionStore
+	SetSubscriptionStore(store)
+	t.Cleanup(func() { SetSubscriptionStore(previous) })
+	return store
+}
+
+func TestRefundNeverExceedsCaptured(t *testing.T) {
+	useMemoryLedger(t)
+	useFakeGateway(t)
+	store := useMemorySubscriptionStore(t)
+	invoice, subscription := testInvoice()
+	if err := store.CreateSubscription(context.Background(), subscription); err != nil {
+		t.Fatal(err)
+	}
+	processPayment(t, invoice, subscription, 0)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(LoadRefundableInvoiceActivity)
+	env.RegisterActivity(RefundPaymentActivity)
+
+	result, err := env.ExecuteActivity(LoadRefundableInvoiceActivity, invoice.ID)
+	if err != nil {
+		t.Fatalf("LoadRefundableInvoiceActivity failed: %v", err)
+	}
+	var refundable RefundableInvoice
+	if err := result.Get(&refundable); err != nil {
+		t.Fatal(err)
+	}
+	if refundable.Captured != invoice.Amount || refundable.CustomerID != subscription.CustomerID {
+		t.Fatalf("refundable invoice = %+v, want %s captured from %s", refundable, invoice.Amount, subscription.CustomerID)
+	}
+
+	partial := money.MustParse("30.00", "USD")
+	if _, err := env.ExecuteActivity(RefundPaymentActivity, refundable.Payment, partial, "duplicate", "refund_cn_1"); err != nil {
+		t.Fatalf("partial refund failed: %v", err)
+	}
+
+	// The second refund is larger than the 19.99 left on the charge
+	_, err = env.ExecuteActivity(RefundPaymentActivity, refundable.Payment, partial, "duplicate", "refund_cn_2")
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != RefundExceedsCapturedErrorType || !appErr.NonRetryable() {
+		t.Errorf("refunding more than was captured = %v, want a non-retryable %s error", err, RefundExceedsCapturedErrorType)
+	}
+}
+
+func TestCreditCustomerBalanceCountsRetriesOnce(t *testing.T) {
+	previous := creditStore
+	SetCreditStore(credits.NewMemoryStore())
+	t.Cleanup(func() { SetCreditStore(previous) })
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(CreditCustomerBalanceActivity)
+
+	transaction := credits.BalanceTransaction{
+		ID:         "cbt_cn_inv_1_1",
+		CustomerID: "cust_1",
+		Amount:     money.MustParse("10.00", "USD"),
+	}
+	var balance money.Money
+	for i := 0; i < 2; i++ {
+		result, err := env.ExecuteActivity(CreditCustomerBalanceActivity, transaction)
+		if err != nil {
+			t.Fatalf("CreditCustomerBalanceActivity failed: %v", err)
+		}
+		if err := result.Get(&balance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if balance != money.MustParse("10.00", "USD") {
+		t.Errorf("balance after a retried credit = %s, want USD 10.00", balance)
+	}
+}
@@ -0,0 +1,95 @@
+package activities
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/tanint/play-temporal/blobs"
+	"github.com/tanint/play-temporal/render"
+)
+
+// blobStore keeps rendered invoices. The worker points it at the configured blob directory.
+var blobStore blobs.Store = blobs.NewFileStore("data/blobs")
+
+// SetBlobStore configures the store rendered invoices are written to
+func SetBlobStore(store blobs.Store) {
+	blobStore = store
+}
+
+// InvoiceDocuments are the rendered versions of an invoice in the blob store
+type InvoiceDocuments struct {
+	HTML blobs.Object
+	PDF  blobs.Object
+}
+
+// Attachments lists the documents to attach to the invoice email, none when rendering failed
+func (d InvoiceDocuments) Attachments() []blobs.Object {
+	var attachments []blobs.Object
+	for _, object := range []blobs.Object{d.PDF, d.HTML} {
+		if object.Key != "" {
+			attachments = append(attachments, object)
+		}
+	}
+	return attachments
+}
+
+// RenderInvoiceActivity renders an invoice as HTML and PDF, with its payment status, and writes
+// both to the blob store under invoices/<invoice ID>. Rendering the same invoice again overwrites
+// the documents with identical ones, so retries are safe.
+func RenderInvoiceActivity(ctx context.Context, invoice InvoiceDetails, subscription SubscriptionDetails, payment PaymentDetails) (InvoiceDocuments, error) {
+	fmt.Printf("[Render Activity] Rendering invoice %s\n", invoice.ID)
+
+	doc := invoiceDocument(invoice, subscription, payment)
+
+	var html, pdf bytes.Buffer
+	if err := render.HTML(&html, doc); err != nil {
+		return InvoiceDocuments{}, fmt.Errorf("rendering invoice %s as HTML: %w", invoice.ID, err)
+	}
+	if err := render.PDF(&pdf, doc); err != nil {
+		return InvoiceDocuments{}, fmt.Errorf("rendering invoice %s as PDF: %w", invoice.ID, err)
+	}
+
+	var documents InvoiceDocuments
+	var err error
+	key := "invoices/" + invoice.ID
+	if documents.HTML, err = blobStore.Put(ctx, key+".html", html.Bytes(), "text/html; charset=utf-8"); err != nil {
+		return InvoiceDocuments{}, err
+	}
+	if documents.PDF, err = blobStore.Put(ctx, key+".pdf", pdf.Bytes(), "application/pdf"); err != nil {
+		return InvoiceDocuments{}, err
+	}
+
+	fmt.Printf("[Render Activity] Rendered invoice %s to %s and %s\n", invoice.ID, documents.HTML.URL, documents.PDF.URL)
+	return documents, nil
+}
+
+// invoiceDocument builds the rendered view of an invoice
+func invoiceDocument(invoice InvoiceDetails, subscription SubscriptionDetails, payment PaymentDetails) render.Document {
+	doc := render.Document{
+		InvoiceID:      invoice.ID,
+		Number:         invoice.Number,
+		SubscriptionID: invoice.SubscriptionID,
+		CustomerID:     subscription.CustomerID,
+		IssuedAt:       invoice.IssuedAt,
+		DueDate:        invoice.DueDate,
+		CouponID:       invoice.CouponID,
+		Tax:            invoice.Tax,
+		ExchangeRate:   invoice.ExchangeRate,
+		AmountDue:      invoice.Amount,
+		PaymentStatus:  payment.Status,
+		PaymentID:      payment.ID,
+		DeclineCode:    payment.DeclineCode,
+	}
+	if plan, err := lookupPlan(subscription.PlanID); err == nil {
+		doc.PlanName = plan.Name
+	}
+	for _, item := range invoice.Items {
+		doc.Lines = append(doc.Lines, render.Line{
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Amount:      item.Amount,
+		})
+	}
+	return doc
+}
@@ -2,9 +2,19 @@ package activities
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math/rand"
 	"time"
+
+	"github.com/tanint/play-temporal/exchange"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/notify"
+	"github.com/tanint/play-temporal/payments"
+	"github.com/tanint/play-temporal/periods"
+	"github.com/tanint/play-temporal/tax"
+	"go.temporal.io/sdk/temporal"
 )
 
 // SubscriptionDetails contains information about a subscription
@@ -12,10 +22,13 @@ type SubscriptionDetails struct {
 	ID              string
 	CustomerID      string
 	PlanID          string
-	PricePerMonth   float64
+	Quantity        int64
+	PricePerMonth   money.Money
 	StartDate       time.Time
-	BillingDay      int
-	Status          string
+	TrialEnd        time.Time // zero when the subscription has no trial
+	BillingDay      int       // day of the month, in TimeZone, that billing periods start on
+	TimeZone        string    // IANA name of the customer's time zone, empty for UTC
+	Status          lifecycle.Status
 	PaymentMethodID string
 }
 
@@ -23,172 +36,456 @@ type SubscriptionDetails struct {
 type InvoiceDetails struct {
 	ID             string
 	SubscriptionID string
-	Amount         float64
-	Currency       string
-	Status         string
-	DueDate        time.Time
-	Items          []InvoiceItem
+	// Number is the invoice's sequential number, e.g. INV-000042, allocated when it is finalized
+	Number   string
+	Amount   money.Money
+	Currency string
+	Status   invoices.Status
+	// Period is the billing period the invoice charges for
+	Period   BillingPeriod
+	IssuedAt time.Time
+	DueDate  time.Time
+	Items    []InvoiceItem
+	// CouponID is the coupon that discounted the invoice, empty when none did
+	CouponID string
+	// Tax is the tax on the invoice's charges and its lines, one per rate
+	Tax tax.Summary
+	// CreditApplied is the part of the invoice paid from the customer's credit balance, zero when
+	// none was. Amount is what is left to pay.
+	CreditApplied money.Money
+	// ExchangeRate is the rate the plan's price was converted at, zero when the catalog prices
+	// the plan in the invoice's currency
+	ExchangeRate exchange.Rate
 }
 
 // InvoiceItem represents a line item in an invoice
 type InvoiceItem struct {
 	Description string
-	Amount      float64
-	Quantity    int
+	Amount      money.Money
+	Quantity    int64
+	// Period is the time the line charges for, zero for one-off charges
+	Period BillingPeriod
+}
+
+// BillingPeriod is the time range [Start, End) covered by a set of charges
+type BillingPeriod = periods.Period
+
+// Charges contains the priced line items for a billing period
+type Charges struct {
+	Period BillingPeriod
+	Items  []InvoiceItem
+	Total  money.Money
+	// CouponID is the coupon that discounted the charges, empty when none did
+	CouponID string
+	// ExchangeRate is the rate the plan's price was converted at, zero when none was needed
+	ExchangeRate exchange.Rate
 }
 
 // PaymentDetails contains information about a payment
 type PaymentDetails struct {
 	ID              string
 	InvoiceID       string
-	Amount          float64
+	IdempotencyKey  string
+	Amount          money.Money
 	Currency        string
 	Status          string
 	PaymentMethodID string
+	DeclineCode     string // set on failed payments when the gateway declined the charge
 	ProcessedAt     time.Time
 }
 
-// CreateSubscriptionActivity simulates creating a new subscription
-func CreateSubscriptionActivity(ctx context.Context, customerID string, planID string) (SubscriptionDetails, error) {
-	fmt.Printf("[Subscription Activity] Creating subscription for customer %s on plan %s\n", customerID, planID)
+// CreateSubscriptionRequest contains the customer input needed to create a subscription
+type CreateSubscriptionRequest struct {
+	// SubscriptionID is chosen by the workflow, so that a retried activity creates the same subscription
+	SubscriptionID  string
+	CustomerID      string
+	PlanID          string
+	Quantity        int64
+	PaymentMethodID string
+	// TrialDays starts the subscription with a free trial of this many days
+	TrialDays int
+	// Currency is the currency the customer is billed in. Empty means the plan's currency.
+	Currency string
+	// TimeZone is the IANA name of the customer's time zone. Empty means UTC.
+	TimeZone string
+}
+
+// CreateSubscriptionActivity creates a new subscription and persists it in the subscription store.
+// A subscription that already exists under the requested ID, created by an earlier attempt, is
+// returned as it was stored.
+func CreateSubscriptionActivity(ctx context.Context, request CreateSubscriptionRequest) (SubscriptionDetails, error) {
+	fmt.Printf("[Subscription Activity] Creating subscription %s for customer %s on plan %s\n", request.SubscriptionID, request.CustomerID, request.PlanID)
 
-	// Simulate processing time
-	time.Sleep(500 * time.Millisecond)
+	if request.SubscriptionID == "" {
+		err := errors.New("subscription ID is required")
+		return SubscriptionDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidSubscriptionID", err)
+	}
+	existing, err := subscriptionStore.GetSubscription(ctx, request.SubscriptionID)
+	if err == nil {
+		fmt.Printf("[Subscription Activity] Subscription %s was already created\n", existing.ID)
+		return existing, nil
+	}
+	if !errors.Is(err, ErrSubscriptionNotFound) {
+		return SubscriptionDetails{}, err
+	}
+
+	// Look up the plan so the subscription is priced from the catalog
+	plan, err := lookupPlan(request.PlanID)
+	if err != nil {
+		return SubscriptionDetails{}, err
+	}
 
-	// Generate a random subscription ID
-	subscriptionID := fmt.Sprintf("sub_%d", rand.Intn(1000000))
+	quantity := request.Quantity
+	if quantity <= 0 {
+		quantity = 1
+	}
+
+	// Price the subscription in the customer's currency
+	currency := request.Currency
+	if currency == "" {
+		currency = plan.Currency
+	}
+	if !money.IsCurrencyCode(currency) {
+		err := fmt.Errorf("invalid currency %q", currency)
+		return SubscriptionDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidCurrency", err)
+	}
+	price, _, err := planPrice(ctx, plan, quantity, currency)
+	if err != nil {
+		return SubscriptionDetails{}, err
+	}
+	if _, err := periods.LoadLocation(request.TimeZone); err != nil {
+		return SubscriptionDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidTimeZone", err)
+	}
 
 	// Create subscription details
+	now := time.Now()
 	subscription := SubscriptionDetails{
-		ID:              subscriptionID,
-		CustomerID:      customerID,
-		PlanID:          planID,
-		PricePerMonth:   rand.Float64() * 100, // Random price between 0 and 100
-		StartDate:       time.Now(),
-		BillingDay:      time.Now().Day(),
-		Status:          "active",
-		PaymentMethodID: fmt.Sprintf("pm_%d", rand.Intn(1000000)),
+		ID:              request.SubscriptionID,
+		CustomerID:      request.CustomerID,
+		PlanID:          plan.ID,
+		Quantity:        quantity,
+		PricePerMonth:   price,
+		StartDate:       now,
+		TimeZone:        request.TimeZone,
+		Status:          lifecycle.StatusActive,
+		PaymentMethodID: request.PaymentMethodID,
+	}
+
+	// A trial defers the first charge, and the billing day, to the end of the trial
+	anchor := now
+	if request.TrialDays > 0 {
+		subscription.Status = lifecycle.StatusTrialing
+		subscription.TrialEnd = now.AddDate(0, 0, request.TrialDays)
+		anchor = subscription.TrialEnd
+	}
+	if subscription.BillingDay, err = periods.AnchorDay(anchor, subscription.TimeZone); err != nil {
+		return SubscriptionDetails{}, err
+	}
+
+	// Persist the subscription so later billing runs can load it
+	if err := subscriptionStore.CreateSubscription(ctx, subscription); err != nil {
+		return SubscriptionDetails{}, err
 	}
 
-	fmt.Printf("[Subscription Activity] Created subscription %s with monthly price %.2f\n",
+	fmt.Printf("[Subscription Activity] Created subscription %s with price %s\n",
 		subscription.ID, subscription.PricePerMonth)
 
 	return subscription, nil
 }
 
-// CalculateChargesActivity simulates calculating charges for a billing period
-func CalculateChargesActivity(ctx context.Context, subscription SubscriptionDetails) (float64, error) {
-	fmt.Printf("[Subscription Activity] Calculating charges for subscription %s\n", subscription.ID)
+// LoadSubscriptionActivity loads the current state of a subscription from the subscription store
+func LoadSubscriptionActivity(ctx context.Context, subscriptionID string) (SubscriptionDetails, error) {
+	fmt.Printf("[Subscription Activity] Loading subscription %s\n", subscriptionID)
 
-	// Simulate processing time
-	time.Sleep(300 * time.Millisecond)
+	subscription, err := loadSubscription(ctx, subscriptionID)
+	if err != nil {
+		return SubscriptionDetails{}, err
+	}
+
+	fmt.Printf("[Subscription Activity] Loaded subscription %s on plan %s with status: %s\n",
+		subscription.ID, subscription.PlanID, subscription.Status)
 
-	// Base charge is the subscription price
-	baseCharge := subscription.PricePerMonth
+	return subscription, nil
+}
+
+// loadSubscription reads a subscription from the store.
+// A missing subscription fails with a non-retryable error since retrying will not make it appear.
+func loadSubscription(ctx context.Context, subscriptionID string) (SubscriptionDetails, error) {
+	subscription, err := subscriptionStore.GetSubscription(ctx, subscriptionID)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return SubscriptionDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "SubscriptionNotFound", err)
+	}
+	return subscription, err
+}
+
+// CalculateChargesActivity prices a billing period from the plan catalog and the period's recorded
+// usage, in the subscription's currency. Prices the catalog does not define in that currency are
+// converted at the current exchange rate, which is recorded on the charges.
+func CalculateChargesActivity(ctx context.Context, subscription SubscriptionDetails, period BillingPeriod) (Charges, error) {
+	fmt.Printf("[Subscription Activity] Calculating charges for subscription %s\n", subscription.ID)
+
+	// Price the subscription from its current catalog entry
+	plan, err := lookupPlan(subscription.PlanID)
+	if err != nil {
+		return Charges{}, err
+	}
+	currency := subscription.PricePerMonth.Currency()
 
-	// Simulate some usage-based charges (random)
-	usageCharge := rand.Float64() * 20 // Random usage between 0 and 20
+	// Base charge is the plan price for the subscribed quantity
+	charges := Charges{Period: period}
+	baseCharge, rate, err := planPrice(ctx, plan, subscription.Quantity, currency)
+	if err != nil {
+		return Charges{}, err
+	}
+	charges.ExchangeRate = rate
+	charges.Items = append(charges.Items, InvoiceItem{
+		Description: fmt.Sprintf("Subscription to %s", plan.ID),
+		Amount:      baseCharge,
+		Quantity:    subscription.Quantity,
+		Period:      period,
+	})
+
+	// Usage charges come from the period's aggregate of each metered price
+	usageCharge := money.Zero(currency)
+	for _, metered := range plan.Metered {
+		quantity, err := usageStore.Aggregate(ctx, subscription.ID, metered.Meter, metered.Aggregation, period.Start, period.End)
+		if err != nil {
+			return Charges{}, err
+		}
+		if quantity == 0 {
+			continue
+		}
+
+		description := metered.Description
+		if description == "" {
+			description = metered.Meter
+		}
+		// Metered prices are only defined in the plan's currency
+		amount := metered.Price.AmountFor(quantity, plan.Currency)
+		if currency != plan.Currency {
+			if charges.ExchangeRate.IsZero() {
+				if charges.ExchangeRate, err = exchangeRate(ctx, plan.Currency, currency); err != nil {
+					return Charges{}, err
+				}
+			}
+			amount = metered.Price.ConvertedAmountFor(quantity, currency, charges.ExchangeRate.Rate)
+		}
+		if usageCharge, err = usageCharge.Add(amount); err != nil {
+			return Charges{}, err
+		}
+		charges.Items = append(charges.Items, InvoiceItem{
+			Description: description,
+			Amount:      amount,
+			Quantity:    quantity,
+			Period:      period,
+		})
+	}
 
 	// Calculate total
-	totalCharge := baseCharge + usageCharge
+	if charges.Total, err = baseCharge.Add(usageCharge); err != nil {
+		return Charges{}, err
+	}
 
-	fmt.Printf("[Subscription Activity] Calculated charges for subscription %s: base=%.2f, usage=%.2f, total=%.2f\n",
-		subscription.ID, baseCharge, usageCharge, totalCharge)
+	fmt.Printf("[Subscription Activity] Calculated charges for subscription %s: base=%s, usage=%s, total=%s\n",
+		subscription.ID, baseCharge, usageCharge, charges.Total)
+	if rate := charges.ExchangeRate; !rate.IsZero() {
+		fmt.Printf("[Subscription Activity] Converted from %s at %s %s per %s (%s, as of %s)\n",
+			rate.From, rate.Rate, rate.To, rate.From, rate.Source, rate.AsOf.Format(time.RFC3339))
+	}
 
-	return totalCharge, nil
+	return charges, nil
 }
 
-// GenerateInvoiceActivity simulates generating an invoice
-func GenerateInvoiceActivity(ctx context.Context, subscription SubscriptionDetails, amount float64) (InvoiceDetails, error) {
-	fmt.Printf("[Subscription Activity] Generating invoice for subscription %s\n", subscription.ID)
+// GenerateInvoiceActivity simulates pricing a draft invoice: its items become the charges, after
+// any coupon, and the tax on them. Tax charged on top of the price is added as one line item per
+// rate; tax included in the price is only listed in the invoice's tax summary. The invoice stays a
+// draft until FinalizeInvoiceActivity numbers and issues it.
+func GenerateInvoiceActivity(ctx context.Context, draft InvoiceDetails, charges Charges, taxes tax.Summary) (InvoiceDetails, error) {
+	fmt.Printf("[Subscription Activity] Generating invoice %s for subscription %s\n", draft.ID, draft.SubscriptionID)
 
 	// Simulate processing time
 	time.Sleep(400 * time.Millisecond)
 
-	// Generate a random invoice ID
-	invoiceID := fmt.Sprintf("inv_%d", rand.Intn(1000000))
-
-	// Create invoice details
-	invoice := InvoiceDetails{
-		ID:             invoiceID,
-		SubscriptionID: subscription.ID,
-		Amount:         amount,
-		Currency:       "USD",
-		Status:         "pending",
-		DueDate:        time.Now().Add(7 * 24 * time.Hour), // Due in 7 days
-		Items: []InvoiceItem{
-			{
-				Description: fmt.Sprintf("Subscription to %s", subscription.PlanID),
-				Amount:      subscription.PricePerMonth,
-				Quantity:    1,
-			},
-			{
-				Description: "Usage charges",
-				Amount:      amount - subscription.PricePerMonth,
+	invoice := draft
+	invoice.Amount = taxes.Total
+	invoice.Currency = charges.Total.Currency()
+	invoice.Items = charges.Items
+	invoice.CouponID = charges.CouponID
+	invoice.Tax = taxes
+	invoice.ExchangeRate = charges.ExchangeRate
+	if !taxes.Inclusive {
+		for _, line := range taxes.Lines {
+			invoice.Items = append(invoice.Items, InvoiceItem{
+				Description: fmt.Sprintf("%s (%s%%)", line.Name, line.Percent),
+				Amount:      line.Amount,
 				Quantity:    1,
-			},
-		},
+			})
+		}
 	}
 
-	fmt.Printf("[Subscription Activity] Generated invoice %s for subscription %s with amount %.2f USD\n",
-		invoice.ID, subscription.ID, invoice.Amount)
+	fmt.Printf("[Subscription Activity] Generated invoice %s for subscription %s with amount %s (tax %s)\n",
+		invoice.ID, invoice.SubscriptionID, invoice.Amount, taxes.Tax)
 
 	return invoice, nil
 }
 
-// ProcessPaymentActivity simulates processing a payment for an invoice
-func ProcessPaymentActivity(ctx context.Context, invoice InvoiceDetails, subscription SubscriptionDetails) (PaymentDetails, error) {
-	fmt.Printf("[Subscription Activity] Processing payment for invoice %s\n", invoice.ID)
-
-	// Simulate processing time
-	time.Sleep(600 * time.Millisecond)
-
-	// Generate a random payment ID
-	paymentID := fmt.Sprintf("py_%d", rand.Intn(1000000))
-
-	// Simulate payment success (90% chance)
-	paymentStatus := "succeeded"
-	if rand.Float64() < 0.1 {
-		paymentStatus = "failed"
+// ProcessPaymentActivity charges an invoice through the payment gateway. Each charge attempt is
+// identified by an idempotency key derived from the invoice ID and attempt number, with 0 for the
+// first charge. The key is sent to the gateway and the successful charge is recorded under it in the
+// payment ledger, so a retry of the same attempt returns the recorded payment instead of charging
+// again. The cash collected is posted to the general ledger under the same key.
+// A failed charge is returned as a CardDeclined, InsufficientFunds, GatewayTimeout or FraudSuspected
+// application error.
+func ProcessPaymentActivity(ctx context.Context, invoice InvoiceDetails, subscription SubscriptionDetails, attempt int) (PaymentDetails, error) {
+	key := payments.IdempotencyKey(invoice.ID, attempt)
+	fmt.Printf("[Subscription Activity] Processing payment for invoice %s (idempotency key %s)\n", invoice.ID, key)
+
+	// A previous attempt with this key already charged the customer
+	existing, found, err := paymentLedger.Get(ctx, key)
+	if err != nil {
+		return PaymentDetails{}, err
+	}
+	if found {
+		fmt.Printf("[Subscription Activity] Payment %s for invoice %s was already processed with status: %s\n",
+			existing.ID, invoice.ID, existing.Status)
+		// The attempt that recorded it may have failed before posting it to the ledger
+		if err := postPayment(ctx, existing, subscription.CustomerID); err != nil {
+			return PaymentDetails{}, err
+		}
+		return paymentDetails(existing), nil
 	}
 
-	// Create payment details
-	payment := PaymentDetails{
-		ID:              paymentID,
+	payment := payments.Payment{
+		IdempotencyKey:  key,
 		InvoiceID:       invoice.ID,
+		SubscriptionID:  subscription.ID,
 		Amount:          invoice.Amount,
-		Currency:        invoice.Currency,
-		Status:          paymentStatus,
+		Status:          payments.StatusSucceeded,
 		PaymentMethodID: subscription.PaymentMethodID,
-		ProcessedAt:     time.Now(),
+	}
+
+	if invoice.Amount.Sign() <= 0 {
+		// Credits covered the invoice, there is nothing to charge
+		payment.ID = "py_" + key
+		payment.ProcessedAt = time.Now()
+	} else {
+		charge, err := paymentGateway.Charge(ctx, payments.ChargeRequest{
+			IdempotencyKey:  key,
+			PaymentMethodID: subscription.PaymentMethodID,
+			CustomerID:      subscription.CustomerID,
+			Amount:          invoice.Amount,
+			Description:     fmt.Sprintf("Invoice %s for subscription %s", invoice.ID, subscription.ID),
+		})
+		if err != nil {
+			fmt.Printf("[Subscription Activity] Payment for invoice %s failed: %v\n", invoice.ID, err)
+			failed := paymentDetails(payment)
+			failed.Status = payments.StatusFailed
+			failed.ProcessedAt = time.Now()
+			return PaymentDetails{}, paymentError(err, failed)
+		}
+		payment.ID = charge.ID
+		payment.ProcessedAt = charge.CreatedAt
+	}
+
+	// Record the charge under its key; if a concurrent attempt recorded first, that one counts
+	payment, recorded, err := paymentLedger.Record(ctx, payment)
+	if err != nil {
+		return PaymentDetails{}, err
+	}
+	if !recorded {
+		fmt.Printf("[Subscription Activity] Payment for invoice %s was recorded by a concurrent attempt as %s\n",
+			invoice.ID, payment.ID)
+	}
+	if err := postPayment(ctx, payment, subscription.CustomerID); err != nil {
+		return PaymentDetails{}, err
 	}
 
 	fmt.Printf("[Subscription Activity] Processed payment %s for invoice %s with status: %s\n",
 		payment.ID, invoice.ID, payment.Status)
 
-	return payment, nil
+	return paymentDetails(payment), nil
 }
 
-// SendInvoiceEmailActivity simulates sending an invoice email
-func SendInvoiceEmailActivity(ctx context.Context, invoice InvoiceDetails, customerID string) error {
+// SendInvoiceEmailActivity emails an invoice to the customer, with its payment status and the
+// rendered invoice attached. Each invoice is emailed once, however often the activity is retried.
+func SendInvoiceEmailActivity(ctx context.Context, invoice InvoiceDetails, subscription SubscriptionDetails, payment PaymentDetails, documents InvoiceDocuments) error {
 	fmt.Printf("[Subscription Activity] Sending invoice email for invoice %s to customer %s\n",
-		invoice.ID, customerID)
+		invoice.ID, subscription.CustomerID)
 
-	// Simulate processing time
-	time.Sleep(200 * time.Millisecond)
+	attachments, err := invoiceAttachments(ctx, documents)
+	if err != nil {
+		return err
+	}
+	return sendNotification(ctx, notify.InvoiceIssued, invoice.ID, subscription.CustomerID, notify.Data{
+		CustomerID:     subscription.CustomerID,
+		SubscriptionID: subscription.ID,
+		PlanName:       planName(subscription.PlanID),
+		InvoiceID:      invoice.ID,
+		InvoiceNumber:  invoice.Number,
+		Amount:         invoice.Amount,
+		DueDate:        invoice.DueDate,
+		PaymentStatus:  payment.Status,
+		PaymentID:      payment.ID,
+		Attached:       len(attachments) > 0,
+	}, attachments)
+}
 
-	fmt.Printf("[Subscription Activity] Invoice email sent successfully for invoice %s\n", invoice.ID)
+// ChangeSubscriptionPlanActivity moves a subscription to another plan, repricing it from the catalog
+func ChangeSubscriptionPlanActivity(ctx context.Context, subscriptionID string, planID string, quantity int64) (SubscriptionDetails, error) {
+	fmt.Printf("[Subscription Activity] Changing subscription %s to plan %s\n", subscriptionID, planID)
 
-	return nil
+	plan, err := lookupPlan(planID)
+	if err != nil {
+		return SubscriptionDetails{}, err
+	}
+	if quantity <= 0 {
+		quantity = 1
+	}
+
+	// Keep billing in the subscription's currency
+	current, err := loadSubscription(ctx, subscriptionID)
+	if err != nil {
+		return SubscriptionDetails{}, err
+	}
+	price, _, err := planPrice(ctx, plan, quantity, current.PricePerMonth.Currency())
+	if err != nil {
+		return SubscriptionDetails{}, err
+	}
+	err = subscriptionStore.UpdatePlan(ctx, subscriptionID, plan.ID, quantity, price)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return SubscriptionDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "SubscriptionNotFound", err)
+	}
+	if err != nil {
+		return SubscriptionDetails{}, err
+	}
+
+	subscription, err := loadSubscription(ctx, subscriptionID)
+	if err != nil {
+		return SubscriptionDetails{}, err
+	}
+
+	fmt.Printf("[Subscription Activity] Changed subscription %s to plan %s with price %s\n",
+		subscription.ID, subscription.PlanID, subscription.PricePerMonth)
+
+	return subscription, nil
 }
 
-// UpdateSubscriptionStatusActivity simulates updating a subscription status
-func UpdateSubscriptionStatusActivity(ctx context.Context, subscriptionID string, status string) error {
+// UpdateSubscriptionStatusActivity moves a subscription to a new status in the subscription store.
+// Transitions the subscription lifecycle does not allow fail with a non-retryable error.
+func UpdateSubscriptionStatusActivity(ctx context.Context, subscriptionID string, status lifecycle.Status) error {
 	fmt.Printf("[Subscription Activity] Updating subscription %s status to: %s\n",
 		subscriptionID, status)
 
-	// Simulate processing time
-	time.Sleep(100 * time.Millisecond)
+	err := subscriptionStore.UpdateSubscriptionStatus(ctx, subscriptionID, status)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "SubscriptionNotFound", err)
+	}
+	if errors.Is(err, lifecycle.ErrInvalidTransition) || errors.Is(err, lifecycle.ErrUnknownStatus) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), lifecycle.InvalidTransitionErrorType, err)
+	}
+	if err != nil {
+		return err
+	}
 
 	fmt.Printf("[Subscription Activity] Updated subscription %s status to: %s\n",
 		subscriptionID, status)
@@ -0,0 +1,61 @@
+package activities
+
+import (
+	"testing"
+
+	"github.com/tanint/play-temporal/catalog"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// useTestCatalog loads the example plan catalog for the duration of a test
+func useTestCatalog(t *testing.T) {
+	t.Helper()
+	c, err := catalog.Load("../config/plans.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := planCatalog
+	SetPlanCatalog(c)
+	t.Cleanup(func() { SetPlanCatalog(previous) })
+}
+
+func TestCreateSubscriptionIsIdempotent(t *testing.T) {
+	useTestCatalog(t)
+	store := useMemorySubscriptionStore(t)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(CreateSubscriptionActivity)
+	create := func(request CreateSubscriptionRequest) (SubscriptionDetails, error) {
+		result, err := env.ExecuteActivity(CreateSubscriptionActivity, request)
+		if err != nil {
+			return SubscriptionDetails{}, err
+		}
+		var subscription SubscriptionDetails
+		err = result.Get(&subscription)
+		return subscription, err
+	}
+
+	request := CreateSubscriptionRequest{SubscriptionID: "sub_retried", CustomerID: "cust_1", PlanID: "premium-monthly", TrialDays: 14}
+	first, err := create(request)
+	if err != nil {
+		t.Fatalf("CreateSubscriptionActivity failed: %v", err)
+	}
+
+	// A retry after the insert went through returns the stored subscription, not a second one
+	request.TrialDays = 30
+	retried, err := create(request)
+	if err != nil {
+		t.Fatalf("retried CreateSubscriptionActivity failed: %v", err)
+	}
+	if retried.ID != first.ID || !retried.StartDate.Equal(first.StartDate) || !retried.TrialEnd.Equal(first.TrialEnd) {
+		t.Errorf("retry created %+v, want the stored %+v", retried, first)
+	}
+	if len(store.subscriptions) != 1 {
+		t.Errorf("store holds %d subscriptions, want 1", len(store.subscriptions))
+	}
+
+	if _, err := create(CreateSubscriptionRequest{CustomerID: "cust_1", PlanID: "premium-monthly"}); err == nil {
+		t.Error("creating a subscription without an ID succeeded")
+	}
+}
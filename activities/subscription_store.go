@@ -0,0 +1,153 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/money"
+)
+
+// ErrSubscriptionNotFound is returned when a subscription does not exist in the store
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// SubscriptionStore persists subscriptions so that billing runs operate on real data
+type SubscriptionStore interface {
+	// CreateSubscription inserts a new subscription. Inserting one whose ID already exists leaves
+	// the stored subscription as it is, so that a retried insert succeeds.
+	CreateSubscription(ctx context.Context, subscription SubscriptionDetails) error
+	// GetSubscription loads a subscription by ID
+	GetSubscription(ctx context.Context, subscriptionID string) (SubscriptionDetails, error)
+	// UpdateSubscriptionStatus moves an existing subscription to a new status. A change the
+	// lifecycle does not allow fails with a *lifecycle.TransitionError and leaves it unchanged.
+	UpdateSubscriptionStatus(ctx context.Context, subscriptionID string, status lifecycle.Status) error
+	// UpdatePaymentMethod changes the payment method charged for an existing subscription
+	UpdatePaymentMethod(ctx context.Context, subscriptionID string, paymentMethodID string) error
+	// UpdatePlan moves an existing subscription to a new plan, quantity and price
+	UpdatePlan(ctx context.Context, subscriptionID string, planID string, quantity int64, price money.Money) error
+	// UpdateTrialEnd moves the end of an existing subscription's trial, and with it the billing
+	// day its periods start on once the trial converts
+	UpdateTrialEnd(ctx context.Context, subscriptionID string, trialEnd time.Time, billingDay int) error
+	// DeleteSubscription removes a subscription. Deleting one that does not exist does nothing.
+	DeleteSubscription(ctx context.Context, subscriptionID string) error
+}
+
+// subscriptionStore is the store used by the subscription activities.
+// It defaults to an in-memory store and is replaced by the worker at startup.
+var subscriptionStore SubscriptionStore = NewMemorySubscriptionStore()
+
+// SetSubscriptionStore configures the store used by the subscription activities
+func SetSubscriptionStore(store SubscriptionStore) {
+	subscriptionStore = store
+}
+
+// MemorySubscriptionStore is an in-memory SubscriptionStore, useful for local runs and tests
+type MemorySubscriptionStore struct {
+	mu            sync.RWMutex
+	subscriptions map[string]SubscriptionDetails
+}
+
+// NewMemorySubscriptionStore creates an empty in-memory subscription store
+func NewMemorySubscriptionStore() *MemorySubscriptionStore {
+	return &MemorySubscriptionStore{
+		subscriptions: make(map[string]SubscriptionDetails),
+	}
+}
+
+// CreateSubscription inserts a new subscription, unless one with its ID already exists
+func (s *MemorySubscriptionStore) CreateSubscription(ctx context.Context, subscription SubscriptionDetails) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.subscriptions[subscription.ID]; exists {
+		return nil
+	}
+	s.subscriptions[subscription.ID] = subscription
+	return nil
+}
+
+// GetSubscription loads a subscription by ID
+func (s *MemorySubscriptionStore) GetSubscription(ctx context.Context, subscriptionID string) (SubscriptionDetails, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return SubscriptionDetails{}, fmt.Errorf("%w: %s", ErrSubscriptionNotFound, subscriptionID)
+	}
+	return subscription, nil
+}
+
+// UpdateSubscriptionStatus moves an existing subscription to a new status
+func (s *MemorySubscriptionStore) UpdateSubscriptionStatus(ctx context.Context, subscriptionID string, status lifecycle.Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSubscriptionNotFound, subscriptionID)
+	}
+	if err := lifecycle.Transition(subscription.Status, status); err != nil {
+		return err
+	}
+	subscription.Status = status
+	s.subscriptions[subscriptionID] = subscription
+	return nil
+}
+
+// UpdatePaymentMethod changes the payment method charged for an existing subscription
+func (s *MemorySubscriptionStore) UpdatePaymentMethod(ctx context.Context, subscriptionID string, paymentMethodID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSubscriptionNotFound, subscriptionID)
+	}
+	subscription.PaymentMethodID = paymentMethodID
+	s.subscriptions[subscriptionID] = subscription
+	return nil
+}
+
+// UpdatePlan moves an existing subscription to a new plan, quantity and price
+func (s *MemorySubscriptionStore) UpdatePlan(ctx context.Context, subscriptionID string, planID string, quantity int64, price money.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSubscriptionNotFound, subscriptionID)
+	}
+	subscription.PlanID = planID
+	subscription.Quantity = quantity
+	subscription.PricePerMonth = price
+	s.subscriptions[subscriptionID] = subscription
+	return nil
+}
+
+// UpdateTrialEnd moves the end of an existing subscription's trial and its billing day
+func (s *MemorySubscriptionStore) UpdateTrialEnd(ctx context.Context, subscriptionID string, trialEnd time.Time, billingDay int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSubscriptionNotFound, subscriptionID)
+	}
+	subscription.TrialEnd = trialEnd
+	subscription.BillingDay = billingDay
+	s.subscriptions[subscriptionID] = subscription
+	return nil
+}
+
+// DeleteSubscription removes a subscription
+func (s *MemorySubscriptionStore) DeleteSubscription(ctx context.Context, subscriptionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscriptions, subscriptionID)
+	return nil
+}
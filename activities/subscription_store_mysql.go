@@ -0,0 +1,203 @@
+package activities
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/money"
+)
+
+const createSubscriptionsTable = `
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id                VARCHAR(64)   NOT NULL PRIMARY KEY,
+	customer_id       VARCHAR(64)   NOT NULL,
+	plan_id           VARCHAR(64)   NOT NULL,
+	quantity          BIGINT        NOT NULL DEFAULT 1,
+	price_minor       BIGINT        NOT NULL,
+	currency          CHAR(3)       NOT NULL,
+	start_date        DATETIME(6)   NOT NULL,
+	trial_end         DATETIME(6)   NULL,
+	billing_day       INT           NOT NULL,
+	time_zone         VARCHAR(64)   NOT NULL DEFAULT '',
+	status            VARCHAR(32)   NOT NULL,
+	payment_method_id VARCHAR(64)   NOT NULL,
+	created_at        TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at        TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	INDEX idx_subscriptions_customer (customer_id)
+)`
+
+// MySQLSubscriptionStore is a SubscriptionStore backed by a MySQL table
+type MySQLSubscriptionStore struct {
+	db *sql.DB
+}
+
+// NewMySQLSubscriptionStore creates a MySQL-backed store and makes sure its table exists.
+// The DSN used to open db must set parseTime=true so DATETIME columns scan into time.Time.
+func NewMySQLSubscriptionStore(ctx context.Context, db *sql.DB) (*MySQLSubscriptionStore, error) {
+	if _, err := db.ExecContext(ctx, createSubscriptionsTable); err != nil {
+		return nil, fmt.Errorf("creating subscriptions table: %w", err)
+	}
+	return &MySQLSubscriptionStore{db: db}, nil
+}
+
+// CreateSubscription inserts a new subscription, unless one with its ID already exists
+func (s *MySQLSubscriptionStore) CreateSubscription(ctx context.Context, subscription SubscriptionDetails) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO subscriptions
+			(id, customer_id, plan_id, quantity, price_minor, currency, start_date, trial_end, billing_day, time_zone, status, payment_method_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE id = id`,
+		subscription.ID,
+		subscription.CustomerID,
+		subscription.PlanID,
+		subscription.Quantity,
+		subscription.PricePerMonth.MinorUnits(),
+		subscription.PricePerMonth.Currency(),
+		subscription.StartDate.UTC(),
+		nullTime(subscription.TrialEnd),
+		subscription.BillingDay,
+		subscription.TimeZone,
+		subscription.Status,
+		subscription.PaymentMethodID,
+	)
+	if err != nil {
+		return fmt.Errorf("inserting subscription %s: %w", subscription.ID, err)
+	}
+	return nil
+}
+
+// GetSubscription loads a subscription by ID
+func (s *MySQLSubscriptionStore) GetSubscription(ctx context.Context, subscriptionID string) (SubscriptionDetails, error) {
+	var subscription SubscriptionDetails
+	var priceMinor int64
+	var currency string
+	var trialEnd sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, customer_id, plan_id, quantity, price_minor, currency, start_date, trial_end, billing_day, time_zone, status, payment_method_id
+		FROM subscriptions WHERE id = ?`,
+		subscriptionID,
+	).Scan(
+		&subscription.ID,
+		&subscription.CustomerID,
+		&subscription.PlanID,
+		&subscription.Quantity,
+		&priceMinor,
+		&currency,
+		&subscription.StartDate,
+		&trialEnd,
+		&subscription.BillingDay,
+		&subscription.TimeZone,
+		&subscription.Status,
+		&subscription.PaymentMethodID,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SubscriptionDetails{}, fmt.Errorf("%w: %s", ErrSubscriptionNotFound, subscriptionID)
+	}
+	if err != nil {
+		return SubscriptionDetails{}, fmt.Errorf("loading subscription %s: %w", subscriptionID, err)
+	}
+	subscription.PricePerMonth = money.New(priceMinor, currency)
+	if trialEnd.Valid {
+		subscription.TrialEnd = trialEnd.Time
+	}
+	return subscription, nil
+}
+
+// UpdateSubscriptionStatus moves an existing subscription to a new status
+func (s *MySQLSubscriptionStore) UpdateSubscriptionStatus(ctx context.Context, subscriptionID string, status lifecycle.Status) error {
+	current, err := s.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+	if err := lifecycle.Transition(current.Status, status); err != nil {
+		return err
+	}
+
+	// Only apply the change if nothing else moved the subscription since it was read
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE subscriptions SET status = ? WHERE id = ? AND status = ?`,
+		status, subscriptionID, current.Status,
+	)
+	if err != nil {
+		return fmt.Errorf("updating status of subscription %s: %w", subscriptionID, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 && current.Status != status {
+		return fmt.Errorf("status of subscription %s changed while updating it to %s", subscriptionID, status)
+	}
+	return nil
+}
+
+// UpdatePaymentMethod changes the payment method charged for an existing subscription
+func (s *MySQLSubscriptionStore) UpdatePaymentMethod(ctx context.Context, subscriptionID string, paymentMethodID string) error {
+	return s.updateColumn(ctx, subscriptionID, "payment_method_id", paymentMethodID)
+}
+
+// UpdatePlan moves an existing subscription to a new plan, quantity and price
+func (s *MySQLSubscriptionStore) UpdatePlan(ctx context.Context, subscriptionID string, planID string, quantity int64, price money.Money) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE subscriptions SET plan_id = ?, quantity = ?, price_minor = ?, currency = ? WHERE id = ?`,
+		planID, quantity, price.MinorUnits(), price.Currency(), subscriptionID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating plan of subscription %s: %w", subscriptionID, err)
+	}
+
+	// Confirm the row exists, since an unchanged row reports zero affected rows
+	_, err = s.GetSubscription(ctx, subscriptionID)
+	return err
+}
+
+// UpdateTrialEnd moves the end of an existing subscription's trial and its billing day
+func (s *MySQLSubscriptionStore) UpdateTrialEnd(ctx context.Context, subscriptionID string, trialEnd time.Time, billingDay int) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE subscriptions SET trial_end = ?, billing_day = ? WHERE id = ?`,
+		nullTime(trialEnd), billingDay, subscriptionID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating subscription %s: %w", subscriptionID, err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+		return nil
+	}
+	// Confirm the row exists, since an unchanged row reports zero affected rows
+	_, err = s.GetSubscription(ctx, subscriptionID)
+	return err
+}
+
+// DeleteSubscription removes a subscription
+func (s *MySQLSubscriptionStore) DeleteSubscription(ctx context.Context, subscriptionID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM subscriptions WHERE id = ?`, subscriptionID); err != nil {
+		return fmt.Errorf("deleting subscription %s: %w", subscriptionID, err)
+	}
+	return nil
+}
+
+// updateColumn sets a single column of a subscription row. The column name is
+// never user input; it is always one of the constants used by the methods above.
+func (s *MySQLSubscriptionStore) updateColumn(ctx context.Context, subscriptionID string, column string, value interface{}) error {
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE subscriptions SET %s = ? WHERE id = ?", column),
+		value, subscriptionID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating subscription %s: %w", subscriptionID, err)
+	}
+
+	// MySQL reports zero affected rows when the value is unchanged, so only
+	// treat it as missing if the row really does not exist
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
@@ -0,0 +1,48 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/tax"
+	"go.temporal.io/sdk/temporal"
+)
+
+// taxCalculator works out the tax on invoices.
+// It is loaded from the tax rules file by the worker at startup.
+var taxCalculator tax.Calculator
+
+// SetTaxCalculator configures the calculator used by CalculateTaxActivity
+func SetTaxCalculator(calculator tax.Calculator) {
+	taxCalculator = calculator
+}
+
+// CalculateTaxActivity works out the tax on a subscription's charges, after discounts, for the
+// customer's jurisdiction. A customer in a jurisdiction the rules do not know fails with a
+// non-retryable UnknownTaxJurisdiction error.
+func CalculateTaxActivity(ctx context.Context, subscription SubscriptionDetails, charges Charges) (tax.Summary, error) {
+	fmt.Printf("[Tax Activity] Calculating tax on %s for customer %s\n", charges.Total, subscription.CustomerID)
+
+	if taxCalculator == nil {
+		return tax.Summary{}, errors.New("tax calculator is not configured")
+	}
+	summary, err := taxCalculator.Calculate(ctx, tax.Request{
+		CustomerID: subscription.CustomerID,
+		Amount:     charges.Total,
+	})
+	if errors.Is(err, tax.ErrUnknownJurisdiction) {
+		return tax.Summary{}, temporal.NewNonRetryableApplicationError(err.Error(), "UnknownTaxJurisdiction", err)
+	}
+	if err != nil {
+		return tax.Summary{}, err
+	}
+
+	if summary.Exempt {
+		fmt.Printf("[Tax Activity] Customer %s is exempt from tax in %s: %s\n",
+			subscription.CustomerID, summary.Jurisdiction, summary.ExemptionReason)
+	} else {
+		fmt.Printf("[Tax Activity] Tax of %s in %s on a subtotal of %s\n", summary.Tax, summary.Jurisdiction, summary.Subtotal)
+	}
+	return summary, nil
+}
@@ -0,0 +1,72 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/notify"
+	"github.com/tanint/play-temporal/periods"
+	"go.temporal.io/sdk/temporal"
+)
+
+// TrialReminder describes the email sent shortly before a trial ends
+type TrialReminder struct {
+	SubscriptionID string
+	CustomerID     string
+	PlanID         string
+	Price          money.Money // what each period costs once the trial ends
+	TrialEnd       time.Time
+}
+
+// SendTrialEndingEmailActivity emails a reminder that a trial is about to end. A trial that is
+// extended gets a new reminder for its new end; each is sent once.
+func SendTrialEndingEmailActivity(ctx context.Context, reminder TrialReminder) error {
+	fmt.Printf("[Trial Activity] Sending trial ending reminder for subscription %s to customer %s\n",
+		reminder.SubscriptionID, reminder.CustomerID)
+
+	key := reminder.SubscriptionID + "/" + reminder.TrialEnd.UTC().Format(time.RFC3339)
+	err := sendNotification(ctx, notify.TrialEnding, key, reminder.CustomerID, notify.Data{
+		CustomerID:     reminder.CustomerID,
+		SubscriptionID: reminder.SubscriptionID,
+		PlanName:       planName(reminder.PlanID),
+		Amount:         reminder.Price,
+		TrialEnd:       reminder.TrialEnd,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[Trial Activity] Reminder sent: trial of %s ends at %s\n",
+		reminder.PlanID, reminder.TrialEnd.Format(time.RFC3339))
+
+	return nil
+}
+
+// ExtendTrialActivity stores a new trial end for a subscription. The billing day moves with it,
+// since billing periods start when the trial ends.
+func ExtendTrialActivity(ctx context.Context, subscriptionID string, trialEnd time.Time) error {
+	fmt.Printf("[Trial Activity] Extending trial of subscription %s to %s\n",
+		subscriptionID, trialEnd.Format(time.RFC3339))
+
+	// The billing day is the trial end's day of the month in the customer's time zone
+	subscription, err := subscriptionStore.GetSubscription(ctx, subscriptionID)
+	if err == nil {
+		err = updateTrialEnd(ctx, subscription, trialEnd)
+	}
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "SubscriptionNotFound", err)
+	}
+	return err
+}
+
+// updateTrialEnd stores a subscription's new trial end and the billing day that goes with it
+func updateTrialEnd(ctx context.Context, subscription SubscriptionDetails, trialEnd time.Time) error {
+	billingDay, err := periods.AnchorDay(trialEnd, subscription.TimeZone)
+	if err != nil {
+		return err
+	}
+	return subscriptionStore.UpdateTrialEnd(ctx, subscription.ID, trialEnd, billingDay)
+}
@@ -0,0 +1,56 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tanint/play-temporal/usage"
+	"go.temporal.io/sdk/temporal"
+)
+
+// usageStore is the store used to record and aggregate metered usage.
+// It defaults to an in-memory store and is replaced by the worker at startup.
+var usageStore usage.Store = usage.NewMemoryStore()
+
+// SetUsageStore configures the store used by the usage activities
+func SetUsageStore(store usage.Store) {
+	usageStore = store
+}
+
+// RecordUsageActivity records a usage event for a metered price of the subscription's plan.
+// Recording the same event ID twice is a no-op, so the activity is safe to retry.
+func RecordUsageActivity(ctx context.Context, event usage.Event) (bool, error) {
+	fmt.Printf("[Usage Activity] Recording %d %s for subscription %s (event %s)\n",
+		event.Quantity, event.Meter, event.SubscriptionID, event.EventID)
+
+	// Only accept usage for meters the subscription is actually billed on
+	subscription, err := loadSubscription(ctx, event.SubscriptionID)
+	if err != nil {
+		return false, err
+	}
+	plan, err := lookupPlan(subscription.PlanID)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := plan.MeteredPrice(event.Meter); !ok {
+		err := fmt.Errorf("plan %s has no meter %q", plan.ID, event.Meter)
+		return false, temporal.NewNonRetryableApplicationError(err.Error(), "UnknownMeter", err)
+	}
+
+	if err := event.Validate(); err != nil {
+		return false, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidUsageEvent", err)
+	}
+
+	recorded, err := usageStore.Record(ctx, event)
+	if err != nil {
+		return false, err
+	}
+
+	if recorded {
+		fmt.Printf("[Usage Activity] Recorded usage event %s\n", event.EventID)
+	} else {
+		fmt.Printf("[Usage Activity] Usage event %s was already recorded\n", event.EventID)
+	}
+
+	return recorded, nil
+}
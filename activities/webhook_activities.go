@@ -0,0 +1,81 @@
+package activities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/blobs"
+	"github.com/tanint/play-temporal/webhooks"
+	"go.temporal.io/sdk/temporal"
+)
+
+// webhookEndpoints are the endpoints billing events are sent to.
+// They are loaded from the webhook endpoints file by the worker at startup.
+var webhookEndpoints = &webhooks.Config{}
+
+// webhookSender POSTs events to endpoints
+var webhookSender = webhooks.NewSender(nil)
+
+// SetWebhookEndpoints configures the endpoints billing events are sent to
+func SetWebhookEndpoints(config *webhooks.Config) {
+	webhookEndpoints = config
+}
+
+// ListWebhookTargetsActivity lists the endpoints that receive events of a type. The targets carry
+// no secrets, so that none end up in workflow histories.
+func ListWebhookTargetsActivity(ctx context.Context, eventType string) ([]webhooks.Target, error) {
+	return webhookEndpoints.Targets(eventType), nil
+}
+
+// DeliverWebhookActivity makes one attempt to deliver an event to an endpoint. A failed delivery is
+// returned as a failed attempt rather than an error, so that the workflow can log it and decide
+// when to try again.
+func DeliverWebhookActivity(ctx context.Context, endpointID string, event webhooks.Event, number int) (webhooks.Attempt, error) {
+	endpoint, err := webhookEndpoints.Endpoint(endpointID)
+	if errors.Is(err, webhooks.ErrEndpointNotFound) {
+		return webhooks.Attempt{}, temporal.NewNonRetryableApplicationError(err.Error(), "UnknownWebhookEndpoint", err)
+	}
+	if err != nil {
+		return webhooks.Attempt{}, err
+	}
+
+	attempt := webhookSender.Send(ctx, endpoint, event)
+	attempt.Number = number
+	if attempt.Succeeded() {
+		fmt.Printf("[Webhook Activity] Delivered %s %s to %s (attempt %d, status %d)\n",
+			event.Type, event.ID, endpoint.ID, number, attempt.StatusCode)
+	} else {
+		fmt.Printf("[Webhook Activity] Failed to deliver %s %s to %s (attempt %d): %s\n",
+			event.Type, event.ID, endpoint.ID, number, attempt.Error)
+	}
+	return attempt, nil
+}
+
+// DeadLetter is an event that could not be delivered to an endpoint, with every attempt made
+type DeadLetter struct {
+	EndpointID string             `json:"endpoint_id"`
+	URL        string             `json:"url"`
+	Event      webhooks.Event     `json:"event"`
+	Attempts   []webhooks.Attempt `json:"attempts"`
+}
+
+// DeadLetterWebhookActivity stores an event that could not be delivered to an endpoint in the blob
+// store under webhooks/dead_letter/<endpoint ID>/<event ID>.json, for it to be looked into and
+// sent again by hand
+func DeadLetterWebhookActivity(ctx context.Context, letter DeadLetter) (blobs.Object, error) {
+	data, err := json.MarshalIndent(letter, "", "  ")
+	if err != nil {
+		return blobs.Object{}, err
+	}
+	key := fmt.Sprintf("webhooks/dead_letter/%s/%s.json", letter.EndpointID, letter.Event.ID)
+	object, err := blobStore.Put(ctx, key, data, "application/json")
+	if err != nil {
+		return blobs.Object{}, err
+	}
+
+	fmt.Printf("[Webhook Activity] Dead-lettered %s %s for %s after %d attempts: %s\n",
+		letter.Event.Type, letter.Event.ID, letter.EndpointID, len(letter.Attempts), object.URL)
+	return object, nil
+}
@@ -0,0 +1,104 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned when no blob is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// Object describes a stored blob
+type Object struct {
+	Key         string
+	ContentType string
+	Size        int64
+	// URL is where the blob can be read from, e.g. a file:// URL for the file store
+	URL string
+}
+
+// Store keeps documents such as rendered invoices. Writing a key again replaces its blob, so a
+// retried write leaves the same result.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) (Object, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// FileStore is a Store that keeps each blob as a file under a directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a file store rooted at dir. The directory is created on the first write.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Put writes a blob to the file for its key. The file is written to a temporary name first and
+// renamed, so readers never see a partly written blob.
+func (s *FileStore) Put(ctx context.Context, key string, data []byte, contentType string) (Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return Object{}, fmt.Errorf("storing blob %s: %w", key, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return Object{}, fmt.Errorf("storing blob %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return Object{}, fmt.Errorf("storing blob %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return Object{}, fmt.Errorf("storing blob %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return Object{}, fmt.Errorf("storing blob %s: %w", key, err)
+	}
+
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		absolute = path
+	}
+	return Object{
+		Key:         key,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		URL:         "file://" + filepath.ToSlash(absolute),
+	}, nil
+}
+
+// Get reads the blob stored under a key
+func (s *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading blob %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// path maps a key such as invoices/inv_1.pdf to a file under the store's directory, refusing keys
+// that would reach outside it
+func (s *FileStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, clean), nil
+}
@@ -0,0 +1,224 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/usage"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPlanNotFound is returned when a plan ID is not part of the catalog
+var ErrPlanNotFound = errors.New("plan not found")
+
+// Interval is the length of a billing cycle
+type Interval string
+
+const (
+	IntervalWeek    Interval = "week"
+	IntervalMonth   Interval = "month"
+	IntervalQuarter Interval = "quarter"
+	IntervalYear    Interval = "year"
+)
+
+// ProrationPolicy decides how a mid-cycle switch to a plan is billed
+type ProrationPolicy string
+
+const (
+	// ProrationInvoiceImmediately bills the prorated difference right away
+	ProrationInvoiceImmediately ProrationPolicy = "invoice_immediately"
+	// ProrationNextCycle carries the prorated difference to the next invoice
+	ProrationNextCycle ProrationPolicy = "next_cycle"
+)
+
+// Plan is a sellable subscription plan
+type Plan struct {
+	ID       string   `yaml:"id" json:"id"`
+	Name     string   `yaml:"name" json:"name"`
+	Currency string   `yaml:"currency" json:"currency"`
+	Interval Interval `yaml:"interval" json:"interval"`
+	Price    Price    `yaml:"price" json:"price"`
+	// Prices are the plan's price in other currencies. Subscriptions in a currency without a
+	// price here are charged Price converted at the exchange rate of each invoice.
+	Prices    map[string]Price `yaml:"prices,omitempty" json:"prices,omitempty"`
+	Metered   []MeteredPrice   `yaml:"metered,omitempty" json:"metered,omitempty"`
+	Proration ProrationPolicy  `yaml:"proration,omitempty" json:"proration,omitempty"`
+}
+
+// MeteredPrice is a usage-based component of a plan, priced per unit of a meter.
+// The meter's events are combined with Aggregation over each billing period.
+type MeteredPrice struct {
+	Meter       string            `yaml:"meter" json:"meter"`
+	Description string            `yaml:"description,omitempty" json:"description,omitempty"`
+	Aggregation usage.Aggregation `yaml:"aggregation" json:"aggregation"`
+	Price       Price             `yaml:"price" json:"price"`
+}
+
+// PriceIn returns the plan's price defined in a currency. It reports false when the plan has no
+// price in the currency and must be converted from its own.
+func (p Plan) PriceIn(currency string) (Price, bool) {
+	if currency == p.Currency {
+		return p.Price, true
+	}
+	price, ok := p.Prices[currency]
+	return price, ok
+}
+
+// MeteredPrice looks up the metered price for a meter
+func (p Plan) MeteredPrice(meter string) (MeteredPrice, bool) {
+	for _, metered := range p.Metered {
+		if metered.Meter == meter {
+			return metered, true
+		}
+	}
+	return MeteredPrice{}, false
+}
+
+// Catalog holds the set of plans the worker can bill for and the coupons customers can redeem
+type Catalog struct {
+	Plans   []Plan   `yaml:"plans" json:"plans"`
+	Coupons []Coupon `yaml:"coupons,omitempty" json:"coupons,omitempty"`
+
+	byID        map[string]Plan
+	couponsByID map[string]Coupon
+}
+
+// Load reads a catalog from a YAML or JSON file, chosen by the file extension, and validates it
+func Load(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading plan catalog: %w", err)
+	}
+
+	var c Catalog
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &c)
+	case ".json":
+		err = json.Unmarshal(data, &c)
+	default:
+		return nil, fmt.Errorf("unsupported plan catalog format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing plan catalog %s: %w", path, err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid plan catalog %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Validate checks every plan and coupon and indexes them by ID
+func (c *Catalog) Validate() error {
+	if len(c.Plans) == 0 {
+		return errors.New("catalog has no plans")
+	}
+
+	byID := make(map[string]Plan, len(c.Plans))
+	for i := range c.Plans {
+		// Plans without a proration policy bill plan changes immediately
+		if c.Plans[i].Proration == "" {
+			c.Plans[i].Proration = ProrationInvoiceImmediately
+		}
+
+		plan := c.Plans[i]
+		if err := plan.Validate(); err != nil {
+			return err
+		}
+		if _, exists := byID[plan.ID]; exists {
+			return fmt.Errorf("duplicate plan ID %q", plan.ID)
+		}
+		byID[plan.ID] = plan
+	}
+
+	couponsByID := make(map[string]Coupon, len(c.Coupons))
+	for _, coupon := range c.Coupons {
+		if err := coupon.Validate(); err != nil {
+			return err
+		}
+		if _, exists := couponsByID[coupon.ID]; exists {
+			return fmt.Errorf("duplicate coupon ID %q", coupon.ID)
+		}
+		couponsByID[coupon.ID] = coupon
+	}
+
+	c.byID = byID
+	c.couponsByID = couponsByID
+	return nil
+}
+
+// Plan looks up a plan by ID
+func (c *Catalog) Plan(id string) (Plan, error) {
+	plan, ok := c.byID[id]
+	if !ok {
+		return Plan{}, fmt.Errorf("%w: %s", ErrPlanNotFound, id)
+	}
+	return plan, nil
+}
+
+// Coupon looks up a coupon by ID
+func (c *Catalog) Coupon(id string) (Coupon, error) {
+	coupon, ok := c.couponsByID[id]
+	if !ok {
+		return Coupon{}, fmt.Errorf("%w: %s", ErrCouponNotFound, id)
+	}
+	return coupon, nil
+}
+
+// Validate checks that a plan is complete and its prices are well formed
+func (p Plan) Validate() error {
+	if p.ID == "" {
+		return errors.New("plan is missing an ID")
+	}
+	if !money.IsCurrencyCode(p.Currency) {
+		return fmt.Errorf("plan %q: invalid currency %q", p.ID, p.Currency)
+	}
+	switch p.Interval {
+	case IntervalWeek, IntervalMonth, IntervalQuarter, IntervalYear:
+	default:
+		return fmt.Errorf("plan %q: invalid interval %q", p.ID, p.Interval)
+	}
+	if err := p.Price.Validate(); err != nil {
+		return fmt.Errorf("plan %q: %w", p.ID, err)
+	}
+	for currency, price := range p.Prices {
+		if !money.IsCurrencyCode(currency) || currency == p.Currency {
+			return fmt.Errorf("plan %q: invalid price currency %q", p.ID, currency)
+		}
+		if err := price.Validate(); err != nil {
+			return fmt.Errorf("plan %q: %s price: %w", p.ID, currency, err)
+		}
+	}
+	switch p.Proration {
+	case ProrationInvoiceImmediately, ProrationNextCycle:
+	default:
+		return fmt.Errorf("plan %q: invalid proration policy %q", p.ID, p.Proration)
+	}
+
+	meters := make(map[string]bool, len(p.Metered))
+	for _, metered := range p.Metered {
+		if metered.Meter == "" {
+			return fmt.Errorf("plan %q: metered price is missing a meter", p.ID)
+		}
+		if meters[metered.Meter] {
+			return fmt.Errorf("plan %q: duplicate meter %q", p.ID, metered.Meter)
+		}
+		meters[metered.Meter] = true
+		if err := metered.Aggregation.Validate(); err != nil {
+			return fmt.Errorf("plan %q: meter %q: %w", p.ID, metered.Meter, err)
+		}
+		if metered.Price.Model == PricingFlat {
+			return fmt.Errorf("plan %q: meter %q cannot use flat pricing", p.ID, metered.Meter)
+		}
+		if err := metered.Price.Validate(); err != nil {
+			return fmt.Errorf("plan %q: meter %q: %w", p.ID, metered.Meter, err)
+		}
+	}
+	return nil
+}
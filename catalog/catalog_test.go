@@ -0,0 +1,140 @@
+package catalog
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/usage"
+)
+
+func TestLoadExampleCatalog(t *testing.T) {
+	c, err := Load("../config/plans.yaml")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	plan, err := c.Plan("basic-monthly")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if plan.Proration != ProrationNextCycle {
+		t.Errorf("basic-monthly proration = %q, want %q", plan.Proration, ProrationNextCycle)
+	}
+	plan, err = c.Plan("premium-annual")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if plan.Proration != ProrationInvoiceImmediately {
+		t.Errorf("premium-annual proration = %q, want the default %q", plan.Proration, ProrationInvoiceImmediately)
+	}
+
+	if _, err := c.Plan("missing"); !errors.Is(err, ErrPlanNotFound) {
+		t.Errorf("Plan(missing) = %v, want ErrPlanNotFound", err)
+	}
+	if _, err := c.Coupon("missing"); !errors.Is(err, ErrCouponNotFound) {
+		t.Errorf("Coupon(missing) = %v, want ErrCouponNotFound", err)
+	}
+}
+
+func TestCatalogValidate(t *testing.T) {
+	flat := Price{Model: PricingFlat, Amount: money.MustDecimal("9.99")}
+	plan := func(edit func(*Plan)) Plan {
+		p := Plan{ID: "basic", Currency: "USD", Interval: IntervalMonth, Price: flat}
+		if edit != nil {
+			edit(&p)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		catalog Catalog
+		err     string
+	}{
+		{name: "valid", catalog: Catalog{Plans: []Plan{plan(nil)}}},
+		{name: "no plans", catalog: Catalog{}, err: "catalog has no plans"},
+		{name: "missing ID", catalog: Catalog{Plans: []Plan{plan(func(p *Plan) { p.ID = "" })}}, err: "missing an ID"},
+		{name: "duplicate plan", catalog: Catalog{Plans: []Plan{plan(nil), plan(nil)}}, err: `duplicate plan ID "basic"`},
+		{name: "invalid currency", catalog: Catalog{Plans: []Plan{plan(func(p *Plan) { p.Currency = "usd" })}}, err: `invalid currency "usd"`},
+		{name: "invalid interval", catalog: Catalog{Plans: []Plan{plan(func(p *Plan) { p.Interval = "day" })}}, err: `invalid interval "day"`},
+		{name: "invalid price", catalog: Catalog{Plans: []Plan{plan(func(p *Plan) { p.Price.Model = "" })}}, err: "unknown pricing model"},
+		{name: "invalid proration", catalog: Catalog{Plans: []Plan{plan(func(p *Plan) { p.Proration = "never" })}}, err: `invalid proration policy "never"`},
+		{
+			name:    "price in other currencies",
+			catalog: Catalog{Plans: []Plan{plan(func(p *Plan) { p.Prices = map[string]Price{"EUR": flat, "JPY": flat} })}},
+		},
+		{
+			name:    "price in the plan's own currency",
+			catalog: Catalog{Plans: []Plan{plan(func(p *Plan) { p.Prices = map[string]Price{"USD": flat} })}},
+			err:     `invalid price currency "USD"`,
+		},
+		{
+			name:    "invalid price in another currency",
+			catalog: Catalog{Plans: []Plan{plan(func(p *Plan) { p.Prices = map[string]Price{"EUR": {Model: PricingGraduated}} })}},
+			err:     "EUR price: tiered price needs at least one tier",
+		},
+		{
+			name: "metered prices",
+			catalog: Catalog{Plans: []Plan{plan(func(p *Plan) {
+				p.Metered = []MeteredPrice{
+					{Meter: "api_calls", Aggregation: usage.AggregationSum, Price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("0.002")}},
+					{Meter: "storage_gb", Aggregation: usage.AggregationMax, Price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("0.10")}},
+				}
+			})}},
+		},
+		{
+			name: "metered price without a meter",
+			catalog: Catalog{Plans: []Plan{plan(func(p *Plan) {
+				p.Metered = []MeteredPrice{{Aggregation: usage.AggregationSum, Price: Price{Model: PricingPerSeat}}}
+			})}},
+			err: "missing a meter",
+		},
+		{
+			name: "duplicate meter",
+			catalog: Catalog{Plans: []Plan{plan(func(p *Plan) {
+				metered := MeteredPrice{Meter: "api_calls", Aggregation: usage.AggregationSum, Price: Price{Model: PricingPerSeat}}
+				p.Metered = []MeteredPrice{metered, metered}
+			})}},
+			err: `duplicate meter "api_calls"`,
+		},
+		{
+			name: "flat metered price",
+			catalog: Catalog{Plans: []Plan{plan(func(p *Plan) {
+				p.Metered = []MeteredPrice{{Meter: "api_calls", Aggregation: usage.AggregationSum, Price: flat}}
+			})}},
+			err: "cannot use flat pricing",
+		},
+		{
+			name: "unknown aggregation",
+			catalog: Catalog{Plans: []Plan{plan(func(p *Plan) {
+				p.Metered = []MeteredPrice{{Meter: "api_calls", Aggregation: "avg", Price: Price{Model: PricingPerSeat}}}
+			})}},
+			err: `meter "api_calls"`,
+		},
+		{
+			name: "duplicate coupon",
+			catalog: Catalog{
+				Plans: []Plan{plan(nil)},
+				Coupons: []Coupon{
+					{ID: "WELCOME", PercentOff: money.MustDecimal("10"), Duration: CouponOnce},
+					{ID: "WELCOME", PercentOff: money.MustDecimal("20"), Duration: CouponOnce},
+				},
+			},
+			err: `duplicate coupon ID "WELCOME"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.catalog.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate() = %v, want no error", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
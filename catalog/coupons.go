@@ -0,0 +1,117 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// ErrCouponNotFound is returned when a coupon ID is not part of the catalog
+var ErrCouponNotFound = errors.New("coupon not found")
+
+// CouponDuration decides how many invoices a redeemed coupon discounts
+type CouponDuration string
+
+const (
+	// CouponOnce discounts the first invoice after the coupon is redeemed
+	CouponOnce CouponDuration = "once"
+	// CouponRepeating discounts the invoices of DurationCycles billing cycles
+	CouponRepeating CouponDuration = "repeating"
+	// CouponForever discounts every invoice for as long as the subscription lasts
+	CouponForever CouponDuration = "forever"
+)
+
+// percentScale turns a percentage into a fraction
+var percentScale = money.MustDecimal("0.01")
+
+// Coupon is a discount a customer can redeem on their subscription. It takes either a
+// percentage or a fixed amount off each discounted invoice, never more than the invoice's total.
+type Coupon struct {
+	ID         string        `yaml:"id" json:"id"`
+	Name       string        `yaml:"name" json:"name"`
+	PercentOff money.Decimal `yaml:"percent_off,omitempty" json:"percent_off,omitempty"`
+	AmountOff  money.Decimal `yaml:"amount_off,omitempty" json:"amount_off,omitempty"`
+	// Currency is the currency of AmountOff; percentage coupons work in any currency
+	Currency       string         `yaml:"currency,omitempty" json:"currency,omitempty"`
+	Duration       CouponDuration `yaml:"duration" json:"duration"`
+	DurationCycles int            `yaml:"duration_cycles,omitempty" json:"duration_cycles,omitempty"`
+}
+
+// Validate checks that a coupon has exactly one kind of discount and a valid duration
+func (c Coupon) Validate() error {
+	if c.ID == "" {
+		return errors.New("coupon is missing an ID")
+	}
+
+	switch {
+	case c.PercentOff.IsZero() == c.AmountOff.IsZero():
+		return fmt.Errorf("coupon %q: needs either percent_off or amount_off", c.ID)
+	case !c.PercentOff.IsZero():
+		if c.PercentOff.Sign() < 0 || c.PercentOff.Rat().Cmp(money.MustDecimal("100").Rat()) > 0 {
+			return fmt.Errorf("coupon %q: percent_off must be between 0 and 100", c.ID)
+		}
+	default:
+		if c.AmountOff.Sign() < 0 {
+			return fmt.Errorf("coupon %q: amount_off must not be negative", c.ID)
+		}
+		if !money.IsCurrencyCode(c.Currency) {
+			return fmt.Errorf("coupon %q: invalid currency %q", c.ID, c.Currency)
+		}
+	}
+
+	switch c.Duration {
+	case CouponOnce, CouponForever:
+		if c.DurationCycles != 0 {
+			return fmt.Errorf("coupon %q: duration_cycles only applies to repeating coupons", c.ID)
+		}
+	case CouponRepeating:
+		if c.DurationCycles <= 0 {
+			return fmt.Errorf("coupon %q: repeating coupon needs a positive duration_cycles", c.ID)
+		}
+	default:
+		return fmt.Errorf("coupon %q: invalid duration %q", c.ID, c.Duration)
+	}
+	return nil
+}
+
+// AppliesTo reports whether the coupon still discounts an invoice after it has already
+// discounted cyclesApplied invoices
+func (c Coupon) AppliesTo(cyclesApplied int) bool {
+	switch c.Duration {
+	case CouponOnce:
+		return cyclesApplied < 1
+	case CouponRepeating:
+		return cyclesApplied < c.DurationCycles
+	case CouponForever:
+		return true
+	}
+	return false
+}
+
+// Discount calculates how much the coupon takes off a subtotal. The discount is rounded half-up
+// to the currency's minor unit and never exceeds the subtotal; nothing is taken off a credit.
+func (c Coupon) Discount(subtotal money.Money) (money.Money, error) {
+	if subtotal.Sign() <= 0 {
+		return money.Zero(subtotal.Currency()), nil
+	}
+
+	var discount money.Money
+	if !c.PercentOff.IsZero() {
+		discount = subtotal.MulDecimal(c.PercentOff.Mul(percentScale), money.RoundHalfUp)
+	} else {
+		if c.Currency != subtotal.Currency() {
+			return money.Money{}, fmt.Errorf("coupon %q is in %s, cannot discount %s", c.ID, c.Currency, subtotal)
+		}
+		discount = money.FromDecimal(c.AmountOff, c.Currency, money.RoundHalfUp)
+	}
+
+	cmp, err := discount.Cmp(subtotal)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if cmp > 0 {
+		return subtotal, nil
+	}
+	return discount, nil
+}
@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// PricingModel determines how a quantity is turned into an amount
+type PricingModel string
+
+const (
+	// PricingFlat charges a fixed amount regardless of quantity
+	PricingFlat PricingModel = "flat"
+	// PricingPerSeat charges the unit amount for every unit
+	PricingPerSeat PricingModel = "per_seat"
+	// PricingTieredVolume charges every unit at the rate of the tier the total quantity falls into
+	PricingTieredVolume PricingModel = "tiered_volume"
+	// PricingGraduated charges the units in each tier at that tier's rate
+	PricingGraduated PricingModel = "graduated"
+)
+
+// Tier is one band of a tiered price. UpTo is inclusive; zero means the tier is unbounded.
+type Tier struct {
+	UpTo       int64         `yaml:"up_to,omitempty" json:"up_to,omitempty"`
+	UnitAmount money.Decimal `yaml:"unit_amount" json:"unit_amount"`
+	FlatAmount money.Decimal `yaml:"flat_amount,omitempty" json:"flat_amount,omitempty"`
+}
+
+// Price describes how a plan or meter is charged.
+// Amounts are decimals in major units and may be finer than a cent, e.g. 0.002 per API call.
+type Price struct {
+	Model      PricingModel  `yaml:"model" json:"model"`
+	Amount     money.Decimal `yaml:"amount,omitempty" json:"amount,omitempty"`
+	UnitAmount money.Decimal `yaml:"unit_amount,omitempty" json:"unit_amount,omitempty"`
+	Tiers      []Tier        `yaml:"tiers,omitempty" json:"tiers,omitempty"`
+}
+
+// Validate checks that the price has the fields its model needs
+func (p Price) Validate() error {
+	switch p.Model {
+	case PricingFlat:
+		if p.Amount.Sign() < 0 {
+			return errors.New("flat price amount must not be negative")
+		}
+	case PricingPerSeat:
+		if p.UnitAmount.Sign() < 0 {
+			return errors.New("per-seat unit amount must not be negative")
+		}
+	case PricingTieredVolume, PricingGraduated:
+		return validateTiers(p.Tiers)
+	default:
+		return fmt.Errorf("unknown pricing model %q", p.Model)
+	}
+	return nil
+}
+
+// validateTiers checks that tiers are ascending and end with an unbounded tier
+func validateTiers(tiers []Tier) error {
+	if len(tiers) == 0 {
+		return errors.New("tiered price needs at least one tier")
+	}
+
+	var previous int64
+	for i, tier := range tiers {
+		if tier.UnitAmount.Sign() < 0 || tier.FlatAmount.Sign() < 0 {
+			return fmt.Errorf("tier %d has a negative amount", i+1)
+		}
+		last := i == len(tiers)-1
+		if tier.UpTo == 0 {
+			if !last {
+				return fmt.Errorf("tier %d is unbounded but is not the last tier", i+1)
+			}
+			continue
+		}
+		if tier.UpTo <= previous {
+			return fmt.Errorf("tier %d must end above %d", i+1, previous)
+		}
+		if last {
+			return errors.New("last tier must be unbounded")
+		}
+		previous = tier.UpTo
+	}
+	return nil
+}
+
+// AmountFor calculates the charge for a quantity of units in the given currency.
+// The exact total is rounded half-up to the currency's minor unit once, at the end.
+func (p Price) AmountFor(quantity int64, currency string) money.Money {
+	return money.FromDecimal(p.exactAmount(quantity), currency, money.RoundHalfUp)
+}
+
+// ConvertedAmountFor calculates the charge for a quantity of units converted into another currency
+// at rate, the units of that currency one unit of the price's currency is worth. The exact total is
+// converted and then rounded half-up once, so converting does not round twice.
+func (p Price) ConvertedAmountFor(quantity int64, currency string, rate money.Decimal) money.Money {
+	return money.FromDecimal(p.exactAmount(quantity).Mul(rate), currency, money.RoundHalfUp)
+}
+
+// exactAmount calculates the unrounded charge for a quantity of units
+func (p Price) exactAmount(quantity int64) money.Decimal {
+	if quantity < 0 {
+		quantity = 0
+	}
+
+	switch p.Model {
+	case PricingFlat:
+		return p.Amount
+	case PricingPerSeat:
+		return p.UnitAmount.MulInt(quantity)
+	case PricingTieredVolume:
+		if quantity == 0 {
+			return money.Decimal{}
+		}
+		tier := p.tierFor(quantity)
+		return tier.FlatAmount.Add(tier.UnitAmount.MulInt(quantity))
+	case PricingGraduated:
+		var total money.Decimal
+		var lower int64
+		for _, tier := range p.Tiers {
+			if quantity <= lower {
+				break
+			}
+			upper := quantity
+			if tier.UpTo != 0 && tier.UpTo < quantity {
+				upper = tier.UpTo
+			}
+			total = total.Add(tier.FlatAmount).Add(tier.UnitAmount.MulInt(upper - lower))
+			lower = upper
+		}
+		return total
+	}
+	return money.Decimal{}
+}
+
+// tierFor returns the tier that contains the given quantity
+func (p Price) tierFor(quantity int64) Tier {
+	for _, tier := range p.Tiers {
+		if tier.UpTo == 0 || quantity <= tier.UpTo {
+			return tier
+		}
+	}
+	return p.Tiers[len(p.Tiers)-1]
+}
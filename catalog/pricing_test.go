@@ -0,0 +1,147 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// volumeTiers charge every unit at the rate of the tier the quantity falls into
+var volumeTiers = Price{
+	Model: PricingTieredVolume,
+	Tiers: []Tier{
+		{UpTo: 10, UnitAmount: money.MustDecimal("20.00")},
+		{UpTo: 50, UnitAmount: money.MustDecimal("17.50")},
+		{UnitAmount: money.MustDecimal("15.00"), FlatAmount: money.MustDecimal("100")},
+	},
+}
+
+// graduatedTiers charge the first 1000 calls free after a platform fee, then less per call above 10000
+var graduatedTiers = Price{
+	Model: PricingGraduated,
+	Tiers: []Tier{
+		{UpTo: 1000, FlatAmount: money.MustDecimal("5.00")},
+		{UpTo: 10000, UnitAmount: money.MustDecimal("0.002")},
+		{UnitAmount: money.MustDecimal("0.001")},
+	},
+}
+
+func TestPriceAmountFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		price    Price
+		quantity int64
+		currency string
+		want     string
+	}{
+		{name: "flat ignores quantity", price: Price{Model: PricingFlat, Amount: money.MustDecimal("49.99")}, quantity: 7, currency: "USD", want: "49.99"},
+		{name: "flat without quantity", price: Price{Model: PricingFlat, Amount: money.MustDecimal("49.99")}, currency: "USD", want: "49.99"},
+		{name: "per seat", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("12.00")}, quantity: 3, currency: "USD", want: "36.00"},
+		{name: "per seat with negative quantity", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("12.00")}, quantity: -3, currency: "USD", want: "0.00"},
+		{name: "per seat rounds the total once", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("0.333")}, quantity: 3, currency: "USD", want: "1.00"},
+		{name: "sub-cent total rounds half up", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("0.005")}, quantity: 1, currency: "USD", want: "0.01"},
+		{name: "sub-cent total below half rounds down", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("0.002")}, quantity: 2, currency: "USD", want: "0.00"},
+		{name: "zero-exponent currency rounds to whole units", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("0.5")}, quantity: 3, currency: "JPY", want: "2"},
+		{name: "three-exponent currency keeps mills", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("0.0125")}, quantity: 3, currency: "KWD", want: "0.038"},
+
+		{name: "volume without quantity", price: volumeTiers, currency: "USD", want: "0.00"},
+		{name: "volume in the first tier", price: volumeTiers, quantity: 1, currency: "USD", want: "20.00"},
+		{name: "volume at the first tier's bound", price: volumeTiers, quantity: 10, currency: "USD", want: "200.00"},
+		{name: "volume just past the first tier", price: volumeTiers, quantity: 11, currency: "USD", want: "192.50"},
+		{name: "volume at the second tier's bound", price: volumeTiers, quantity: 50, currency: "USD", want: "875.00"},
+		{name: "volume in the unbounded tier adds its flat amount", price: volumeTiers, quantity: 51, currency: "USD", want: "865.00"},
+
+		{name: "graduated without quantity", price: graduatedTiers, currency: "USD", want: "0.00"},
+		{name: "graduated in the first tier charges its flat amount", price: graduatedTiers, quantity: 1, currency: "USD", want: "5.00"},
+		{name: "graduated at the first tier's bound", price: graduatedTiers, quantity: 1000, currency: "USD", want: "5.00"},
+		{name: "graduated just past the first tier", price: graduatedTiers, quantity: 1001, currency: "USD", want: "5.00"},
+		{name: "graduated rounds the total once", price: graduatedTiers, quantity: 1003, currency: "USD", want: "5.01"},
+		{name: "graduated at the second tier's bound", price: graduatedTiers, quantity: 10000, currency: "USD", want: "23.00"},
+		{name: "graduated across every tier", price: graduatedTiers, quantity: 12345, currency: "USD", want: "25.35"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.price.AmountFor(tt.quantity, tt.currency)
+			if want := money.MustParse(tt.want, tt.currency); got != want {
+				t.Errorf("AmountFor(%d, %s) = %s, want %s", tt.quantity, tt.currency, got, want)
+			}
+		})
+	}
+}
+
+func TestPriceConvertedAmountForRoundsOnce(t *testing.T) {
+	// 3 x 0.333 = 0.999 USD is 0.999 x 150.5 = 150.3495 JPY. Rounding to cents first would give
+	// 1.00 USD and 150.5, then 151 JPY.
+	price := Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("0.333")}
+	got := price.ConvertedAmountFor(3, "JPY", money.MustDecimal("150.5"))
+	if want := money.MustParse("150", "JPY"); got != want {
+		t.Errorf("ConvertedAmountFor = %s, want %s", got, want)
+	}
+}
+
+func TestPriceValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		price Price
+		err   string
+	}{
+		{name: "flat", price: Price{Model: PricingFlat, Amount: money.MustDecimal("9.99")}},
+		{name: "free flat", price: Price{Model: PricingFlat}},
+		{name: "negative flat", price: Price{Model: PricingFlat, Amount: money.MustDecimal("-1")}, err: "must not be negative"},
+		{name: "per seat", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("12")}},
+		{name: "negative per seat", price: Price{Model: PricingPerSeat, UnitAmount: money.MustDecimal("-12")}, err: "must not be negative"},
+		{name: "volume", price: volumeTiers},
+		{name: "graduated", price: graduatedTiers},
+		{name: "single unbounded tier", price: Price{Model: PricingGraduated, Tiers: []Tier{{UnitAmount: money.MustDecimal("1")}}}},
+		{name: "unknown model", price: Price{Model: "metered"}, err: "unknown pricing model"},
+		{name: "missing model", price: Price{Amount: money.MustDecimal("9.99")}, err: "unknown pricing model"},
+		{name: "no tiers", price: Price{Model: PricingTieredVolume}, err: "at least one tier"},
+		{
+			name: "negative tier amount",
+			price: Price{Model: PricingGraduated, Tiers: []Tier{
+				{UpTo: 10, FlatAmount: money.MustDecimal("-5")},
+				{UnitAmount: money.MustDecimal("1")},
+			}},
+			err: "tier 1 has a negative amount",
+		},
+		{
+			name: "unbounded tier before the last",
+			price: Price{Model: PricingTieredVolume, Tiers: []Tier{
+				{UnitAmount: money.MustDecimal("2")},
+				{UpTo: 10, UnitAmount: money.MustDecimal("1")},
+			}},
+			err: "tier 1 is unbounded but is not the last tier",
+		},
+		{
+			name: "bounded last tier",
+			price: Price{Model: PricingTieredVolume, Tiers: []Tier{
+				{UpTo: 10, UnitAmount: money.MustDecimal("2")},
+				{UpTo: 20, UnitAmount: money.MustDecimal("1")},
+			}},
+			err: "last tier must be unbounded",
+		},
+		{
+			name: "tier bounds that do not ascend",
+			price: Price{Model: PricingGraduated, Tiers: []Tier{
+				{UpTo: 10, UnitAmount: money.MustDecimal("2")},
+				{UpTo: 10, UnitAmount: money.MustDecimal("1")},
+				{UnitAmount: money.MustDecimal("0.5")},
+			}},
+			err: "tier 2 must end above 10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.price.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate() = %v, want no error", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/tanint/play-temporal/config"
+	"github.com/tanint/play-temporal/workflows"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+func main() {
+	// Define command line flags
+	action := flag.String("action", "start", "Action to perform: start, status")
+	subscriptionID := flag.String("subscription", "", "Subscription ID to backfill billing for")
+	from := flag.String("from", "", "RFC3339 time from which to backfill periods that ended (empty means from the first period)")
+	to := flag.String("to", "", "RFC3339 time by which backfilled periods ended (empty means now)")
+	dryRun := flag.Bool("dry-run", false, "List the periods that would be billed without billing them")
+	flag.Parse()
+
+	if *subscriptionID == "" {
+		log.Fatalln("Subscription ID is required. Use -subscription flag to specify it.")
+	}
+
+	// Create the client object
+	c, err := client.Dial(config.GetTemporalClientOptions())
+	if err != nil {
+		log.Fatalln("Unable to create Temporal client", err)
+	}
+	defer c.Close()
+
+	// One backfill at a time per subscription
+	workflowID := "backfill-billing-" + *subscriptionID
+
+	// Perform the requested action
+	switch *action {
+	case "start":
+		params := workflows.BackfillBillingParams{SubscriptionID: *subscriptionID, DryRun: *dryRun}
+		if *from != "" {
+			if params.From, err = time.Parse(time.RFC3339, *from); err != nil {
+				log.Fatalln("Invalid -from, expected an RFC3339 time:", err)
+			}
+		}
+		if *to != "" {
+			if params.To, err = time.Parse(time.RFC3339, *to); err != nil {
+				log.Fatalln("Invalid -to, expected an RFC3339 time:", err)
+			}
+		}
+
+		if !params.DryRun {
+			refuseBilledSubscription(c, *subscriptionID)
+		}
+
+		workflowOptions := client.StartWorkflowOptions{
+			ID:        workflowID,
+			TaskQueue: "temporal-learning-task-queue",
+		}
+		we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.BackfillBillingWorkflow, params)
+		if err != nil {
+			log.Fatalln("Unable to execute workflow", err)
+		}
+		log.Printf("Backfill billing workflow started with ID: %s and RunID: %s\n", we.GetID(), we.GetRunID())
+
+		var state workflows.BackfillBillingState
+		if err := we.Get(context.Background(), &state); err != nil {
+			log.Fatalln("Backfill failed", err)
+		}
+		printBackfill(state)
+
+	case "status":
+		response, err := c.QueryWorkflow(context.Background(), workflowID, "", workflows.BackfillStatusQuery)
+		if err != nil {
+			log.Fatalln("Unable to query workflow", err)
+		}
+		var state workflows.BackfillBillingState
+		if err := response.Get(&state); err != nil {
+			log.Fatalln("Unable to decode query result", err)
+		}
+		printBackfill(state)
+
+	default:
+		log.Fatalf("Unknown action: %s. Use 'start' or 'status'.", *action)
+	}
+}
+
+// refuseBilledSubscription exits when the subscription is billed by its running entity workflow or
+// by an unpaused billing schedule, either of which could bill a backfilled period a second time
+func refuseBilledSubscription(c client.Client, subscriptionID string) {
+	ctx := context.Background()
+	var notFound *serviceerror.NotFound
+
+	entityID := "subscription-" + subscriptionID
+	entity, err := c.DescribeWorkflowExecution(ctx, entityID, "")
+	switch {
+	case errors.As(err, &notFound):
+	case err != nil:
+		log.Fatalln("Unable to check for the subscription's entity workflow", err)
+	case entity.GetWorkflowExecutionInfo().GetStatus() == enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING:
+		log.Fatalf("Subscription %s is billed by its entity workflow %s; not backfilling it\n", subscriptionID, entityID)
+	}
+
+	scheduleID := "recurring-billing-schedule-" + subscriptionID
+	schedule, err := c.ScheduleClient().GetHandle(ctx, scheduleID).Describe(ctx)
+	switch {
+	case errors.As(err, &notFound):
+	case err != nil:
+		log.Fatalln("Unable to check for the subscription's billing schedule", err)
+	case !schedule.Schedule.State.Paused:
+		log.Fatalf("Subscription %s is billed by the schedule %s; pause it before backfilling\n", subscriptionID, scheduleID)
+	}
+}
+
+// printBackfill prints the unbilled periods a backfill found and what it billed
+func printBackfill(state workflows.BackfillBillingState) {
+	verb := "Billing"
+	if state.DryRun {
+		verb = "Would bill"
+	}
+	log.Printf("Subscription %s has %d unbilled periods\n", state.SubscriptionID, len(state.Unbilled))
+	for _, period := range state.Unbilled {
+		log.Printf("  %s %s to %s\n", verb, period.Start.Format(time.RFC3339), period.End.Format(time.RFC3339))
+	}
+	for _, cycle := range state.Billed {
+		log.Printf("  Billed %s to %s: invoice %s, payment %s\n",
+			cycle.Period.Start.Format(time.RFC3339), cycle.Period.End.Format(time.RFC3339), cycle.InvoiceID, cycle.PaymentStatus)
+	}
+	if state.Stopped != "" {
+		log.Printf("Stopped: %s\n", state.Stopped)
+	}
+	if state.Error != "" {
+		log.Printf("Error: %s\n", state.Error)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/config"
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/workflows"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+)
+
+func main() {
+	// Define command line flags
+	action := flag.String("action", "start", "Action to perform: start, change-plan, pause, resume, cancel, extend-trial, apply-coupon, draft-invoice, add-item, remove-item, finalize-invoice, status, balance, history")
+	subscriptionID := flag.String("subscription", "", "Subscription ID")
+	planID := flag.String("plan", "", "New plan ID for the change-plan action")
+	quantity := flag.Int64("quantity", 0, "New quantity for the change-plan action (0 keeps the current quantity), or the add-item line's quantity (0 means 1)")
+	days := flag.Int("days", 0, "Number of days for the extend-trial action")
+	resumeAt := flag.String("resume-at", "", "RFC3339 time at which a paused subscription resumes by itself (empty stays paused)")
+	atPeriodEnd := flag.Bool("at-period-end", false, "Cancel at the end of the current period instead of now")
+	refund := flag.Bool("refund", false, "Refund the unused time of the current period when canceling now")
+	couponID := flag.String("coupon", "", "Coupon ID for the apply-coupon action")
+	invoiceGrace := flag.Duration("invoice-grace", 0, "How long renewal invoices stay drafts open to edits, for the start action (0 means 1h, negative finalizes right away)")
+	description := flag.String("description", "", "Line description for the add-item action")
+	amount := flag.String("amount", "", "Line amount for the add-item action, negative for a credit, in the invoice's currency")
+	item := flag.Int("item", -1, "Index of the draft invoice line to remove, from 0, for the remove-item action")
+	flag.Parse()
+
+	if *subscriptionID == "" {
+		log.Fatalln("Subscription ID is required. Use -subscription flag to specify it.")
+	}
+
+	// Create the client object
+	c, err := client.Dial(config.GetTemporalClientOptions())
+	if err != nil {
+		log.Fatalln("Unable to create Temporal client", err)
+	}
+	defer c.Close()
+
+	// One entity workflow per subscription
+	workflowID := "subscription-" + *subscriptionID
+
+	// Perform the requested action
+	switch *action {
+	case "start":
+		workflowOptions := client.StartWorkflowOptions{
+			ID:        workflowID,
+			TaskQueue: "temporal-learning-task-queue",
+		}
+		params := workflows.SubscriptionEntityParams{SubscriptionID: *subscriptionID, InvoiceGracePeriod: *invoiceGrace}
+
+		we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.SubscriptionEntityWorkflow, params)
+		if err != nil {
+			log.Fatalln("Unable to execute workflow", err)
+		}
+		log.Printf("Subscription entity workflow started with ID: %s and RunID: %s\n", we.GetID(), we.GetRunID())
+
+	case "change-plan":
+		if *planID == "" {
+			log.Fatalln("Plan ID is required. Use -plan flag to specify it.")
+		}
+
+		var result workflows.ChangePlanResult
+		request := workflows.ChangePlanRequest{PlanID: *planID, Quantity: *quantity}
+		update(c, workflowID, workflows.ChangePlanUpdateName, &result, request)
+
+		log.Printf("Changed plan from %s to %s (%s)\n", result.FromPlanID, result.ToPlanID, result.Policy)
+		log.Printf("  Credit: %s, charge: %s, net: %s\n",
+			result.Proration.Credit, result.Proration.Charge, result.Proration.Net)
+		if result.Carried {
+			log.Println("  Adjustment carried to the next invoice")
+		} else {
+			log.Printf("  Invoiced immediately: %s (%s)\n", result.InvoiceNumber, result.InvoiceID)
+		}
+
+	case "pause":
+		var request workflows.PauseRequest
+		if *resumeAt != "" {
+			if request.ResumeAt, err = time.Parse(time.RFC3339, *resumeAt); err != nil {
+				log.Fatalln("Invalid -resume-at, expected an RFC3339 time:", err)
+			}
+		}
+
+		var status lifecycle.Status
+		update(c, workflowID, workflows.PauseUpdateName, &status, request)
+		log.Printf("Subscription %s is now %s\n", *subscriptionID, status)
+
+	case "resume":
+		var status lifecycle.Status
+		update(c, workflowID, workflows.ResumeUpdateName, &status)
+		log.Printf("Subscription %s is now %s\n", *subscriptionID, status)
+
+	case "cancel":
+		var result workflows.CancelResult
+		request := workflows.CancelRequest{AtPeriodEnd: *atPeriodEnd, Refund: *refund}
+		update(c, workflowID, workflows.CancelUpdateName, &result, request)
+
+		if *atPeriodEnd {
+			log.Printf("Subscription %s is %s and will be canceled at %s\n",
+				*subscriptionID, result.Status, result.CancelAt.Format(time.RFC3339))
+		} else {
+			log.Printf("Subscription %s is now %s\n", *subscriptionID, result.Status)
+		}
+		if result.Refund != nil {
+			log.Printf("  Refunded %s for unused time: %s\n", result.Refund.Amount, result.Refund.ID)
+		}
+
+	case "extend-trial":
+		if *days <= 0 {
+			log.Fatalln("A positive number of days is required. Use -days flag to specify it.")
+		}
+
+		var trialEnd time.Time
+		update(c, workflowID, workflows.ExtendTrialUpdateName, &trialEnd, workflows.ExtendTrialRequest{Days: *days})
+		log.Printf("Trial of subscription %s now ends at %s\n", *subscriptionID, trialEnd.Format(time.RFC3339))
+
+	case "apply-coupon":
+		if *couponID == "" {
+			log.Fatalln("Coupon ID is required. Use -coupon flag to specify it.")
+		}
+
+		var redemption activities.CouponRedemption
+		update(c, workflowID, workflows.ApplyCouponUpdateName, &redemption, workflows.ApplyCouponRequest{CouponID: *couponID})
+		log.Printf("Applied coupon %s (%s) to subscription %s\n", redemption.Coupon.ID, redemption.Coupon.Name, *subscriptionID)
+		log.Printf("  Duration: %s\n", couponDuration(redemption.Coupon))
+
+	case "draft-invoice":
+		var draft workflows.DraftInvoice
+		query(c, workflowID, workflows.DraftInvoiceQuery, &draft)
+		printDraft(draft.Invoice)
+		log.Printf("  Finalizes at: %s\n", draft.FinalizesAt.Format(time.RFC3339))
+
+	case "add-item":
+		if *description == "" || *amount == "" {
+			log.Fatalln("Description and amount are required. Use -description and -amount flags to specify them.")
+		}
+
+		// The amount is in the draft's currency
+		var draft workflows.DraftInvoice
+		query(c, workflowID, workflows.DraftInvoiceQuery, &draft)
+		lineAmount, err := money.Parse(*amount, draft.Invoice.Currency)
+		if err != nil {
+			log.Fatalln("Invalid amount", err)
+		}
+
+		var invoice activities.InvoiceDetails
+		request := workflows.AddInvoiceItemRequest{Description: *description, Amount: lineAmount, Quantity: *quantity}
+		update(c, workflowID, workflows.AddInvoiceItemUpdateName, &invoice, request)
+		printDraft(invoice)
+
+	case "remove-item":
+		if *item < 0 {
+			log.Fatalln("Item index is required. Use -item flag to specify it.")
+		}
+
+		var invoice activities.InvoiceDetails
+		update(c, workflowID, workflows.RemoveInvoiceItemUpdateName, &invoice, workflows.RemoveInvoiceItemRequest{Index: *item})
+		printDraft(invoice)
+
+	case "finalize-invoice":
+		var invoice activities.InvoiceDetails
+		update(c, workflowID, workflows.FinalizeInvoiceUpdateName, &invoice)
+		log.Printf("Draft invoice %s will be finalized now\n", invoice.ID)
+
+	case "status":
+		var status workflows.SubscriptionStatus
+		query(c, workflowID, "get_status", &status)
+		log.Printf("Subscription %s: %s on %s (quantity %d)\n", status.SubscriptionID, status.Status, status.PlanID, status.Quantity)
+		log.Printf("  Cycles billed: %d, next billing date: %s\n", status.CyclesBilled, status.NextBillingDate.Format(time.RFC3339))
+		if status.Status == lifecycle.StatusTrialing {
+			log.Printf("  Trial ends: %s\n", status.TrialEnd.Format(time.RFC3339))
+		}
+		if status.Status == lifecycle.StatusPaused && !status.ResumeAt.IsZero() {
+			log.Printf("  Resumes at: %s\n", status.ResumeAt.Format(time.RFC3339))
+		}
+		if status.CancelAtPeriodEnd {
+			log.Printf("  Cancels at the end of the period: %s\n", status.NextBillingDate.Format(time.RFC3339))
+		}
+		if status.Coupon.Active() {
+			log.Printf("  Coupon: %s (%s), applied to %d cycles\n",
+				status.Coupon.Coupon.ID, couponDuration(status.Coupon.Coupon), status.Coupon.CyclesApplied)
+		}
+
+	case "balance":
+		var balance money.Money
+		query(c, workflowID, "get_balance", &balance)
+		log.Printf("Balance carried to the next invoice: %s\n", balance)
+
+	case "history":
+		var history []workflows.SubscriptionEvent
+		query(c, workflowID, "get_history", &history)
+		log.Printf("Subscription %s has %d history events:\n", *subscriptionID, len(history))
+		for _, event := range history {
+			log.Printf("  %s %s %s\n", event.Time.Format(time.RFC3339), event.Type, event.Detail)
+		}
+
+	default:
+		log.Fatalf("Unknown action: %s. Use 'start', 'change-plan', 'pause', 'resume', 'cancel', 'extend-trial', 'apply-coupon', 'draft-invoice', 'add-item', 'remove-item', 'finalize-invoice', 'status', 'balance' or 'history'.", *action)
+	}
+}
+
+// couponDuration describes how long a coupon discounts invoices
+func couponDuration(coupon catalog.Coupon) string {
+	if coupon.Duration == catalog.CouponRepeating {
+		return fmt.Sprintf("%d cycles", coupon.DurationCycles)
+	}
+	return string(coupon.Duration)
+}
+
+// printDraft lists a draft invoice's items
+func printDraft(invoice activities.InvoiceDetails) {
+	log.Printf("Draft invoice %s: %s\n", invoice.ID, invoice.Amount)
+	for i, item := range invoice.Items {
+		log.Printf("  %d. %s x%d: %s\n", i, item.Description, item.Quantity, item.Amount)
+	}
+}
+
+// update sends an update to the entity workflow and waits for its result
+func update(c client.Client, workflowID, name string, result interface{}, args ...interface{}) {
+	updateOptions := client.UpdateWorkflowOptions{
+		WorkflowID:   workflowID,
+		UpdateName:   name,
+		Args:         args,
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	}
+
+	resp, err := c.UpdateWorkflow(context.Background(), updateOptions)
+	if err != nil {
+		log.Fatalln("Failed to update workflow", err)
+	}
+	if err := resp.Get(context.Background(), result); err != nil {
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && (appErr.Type() == lifecycle.InvalidTransitionErrorType || appErr.Type() == activities.InvoiceNotEditableErrorType) {
+			log.Fatalf("Rejected %s: %s", name, appErr.Message())
+		}
+		log.Fatalf("Failed to %s: %v", name, err)
+	}
+}
+
+// query queries the entity workflow and decodes the result
+func query(c client.Client, workflowID, queryType string, result interface{}) {
+	resp, err := c.QueryWorkflow(context.Background(), workflowID, "", queryType)
+	if err != nil {
+		log.Fatalln("Failed to query workflow", err)
+	}
+	if err := resp.Get(result); err != nil {
+		log.Fatalln("Failed to decode query result", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/tanint/play-temporal/config"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/workflows"
+	"go.temporal.io/sdk/client"
+)
+
+func main() {
+	// Define command line flags
+	action := flag.String("action", "start", "Action to perform: start, status")
+	invoiceID := flag.String("invoice", "", "Invoice ID to refund")
+	amount := flag.String("amount", "", "Amount to refund, such as 10.00 (empty refunds everything left)")
+	currency := flag.String("currency", "USD", "Currency of the amount")
+	reason := flag.String("reason", "requested_by_customer", "Reason for the refund")
+	toBalance := flag.Bool("to-balance", false, "Credit the customer balance instead of refunding the payment method")
+	flag.Parse()
+
+	if *invoiceID == "" {
+		log.Fatalln("Invoice ID is required. Use -invoice flag to specify it.")
+	}
+
+	// Create the client object
+	c, err := client.Dial(config.GetTemporalClientOptions())
+	if err != nil {
+		log.Fatalln("Unable to create Temporal client", err)
+	}
+	defer c.Close()
+
+	// One refund at a time per invoice; a finished refund's ID can be reused for the next one
+	workflowID := "refund-" + *invoiceID
+
+	// Perform the requested action
+	switch *action {
+	case "start":
+		params := workflows.RefundParams{
+			InvoiceID: *invoiceID,
+			Reason:    *reason,
+			ToBalance: *toBalance,
+		}
+		if *amount != "" {
+			if params.Amount, err = money.Parse(*amount, *currency); err != nil {
+				log.Fatalln("Invalid amount", err)
+			}
+		}
+
+		workflowOptions := client.StartWorkflowOptions{
+			ID:        workflowID,
+			TaskQueue: "temporal-learning-task-queue",
+		}
+		we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.RefundWorkflow, params)
+		if err != nil {
+			log.Fatalln("Unable to execute workflow", err)
+		}
+		log.Printf("Refund workflow started with ID: %s and RunID: %s\n", we.GetID(), we.GetRunID())
+
+		var state workflows.RefundState
+		if err := we.Get(context.Background(), &state); err != nil {
+			log.Fatalln("Refund failed", err)
+		}
+		log.Printf("Issued credit note %s for %s (%s)\n", state.CreditNote.ID, state.Amount, state.CreditNote.Destination)
+		if state.Refund.ID != "" {
+			log.Printf("Refund: %s\n", state.Refund.ID)
+		}
+		if params.ToBalance {
+			log.Printf("Customer credit balance: %s\n", state.Balance)
+		}
+		log.Printf("Left to refund on invoice %s: %s\n", *invoiceID, state.Remaining)
+
+	case "status":
+		response, err := c.QueryWorkflow(context.Background(), workflowID, "", workflows.RefundStatusQuery)
+		if err != nil {
+			log.Fatalln("Unable to query workflow", err)
+		}
+		var state workflows.RefundState
+		if err := response.Get(&state); err != nil {
+			log.Fatalln("Unable to decode query result", err)
+		}
+		log.Printf("Refund of invoice %s: %s, amount %s\n", state.InvoiceID, state.Status, state.Amount)
+		if state.CreditNote.ID != "" {
+			log.Printf("Credit note: %s\n", state.CreditNote.ID)
+		}
+		if state.Error != "" {
+			log.Printf("Error: %s\n", state.Error)
+		}
+
+	default:
+		log.Fatalf("Unknown action: %s. Use 'start' or 'status'.", *action)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tanint/play-temporal/config"
+	"github.com/tanint/play-temporal/workflows"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+)
+
+// overlapPolicies are the names the -overlap flag accepts for what a schedule does with a run that
+// is due while the previous one is still running
+var overlapPolicies = map[string]enumspb.ScheduleOverlapPolicy{
+	"skip":            enumspb.SCHEDULE_OVERLAP_POLICY_SKIP,
+	"buffer-one":      enumspb.SCHEDULE_OVERLAP_POLICY_BUFFER_ONE,
+	"buffer-all":      enumspb.SCHEDULE_OVERLAP_POLICY_BUFFER_ALL,
+	"cancel-other":    enumspb.SCHEDULE_OVERLAP_POLICY_CANCEL_OTHER,
+	"terminate-other": enumspb.SCHEDULE_OVERLAP_POLICY_TERMINATE_OTHER,
+	"allow-all":       enumspb.SCHEDULE_OVERLAP_POLICY_ALLOW_ALL,
+}
+
+func main() {
+	// Define command line flags
+	action := flag.String("action", "describe", "Action to perform: create, describe, pause, unpause, trigger, backfill, update, delete")
+	subscriptionID := flag.String("subscription", "", "Subscription ID whose billing schedule to manage")
+	customerID := flag.String("customer", "", "Customer ID, for the create action")
+	cron := flag.String("cron", "0 0 1 * *", "Cron expression of the billing runs")
+	timeZone := flag.String("timezone", "", "IANA time zone the cron expression is in (empty means UTC)")
+	overlap := flag.String("overlap", "buffer-all", "What to do with a run due while the last is still running: skip, buffer-one, buffer-all, cancel-other, terminate-other, allow-all")
+	catchupWindow := flag.Duration("catchup-window", 7*24*time.Hour, "How late a run missed while the schedule could not start it is still started")
+	invoiceGrace := flag.Duration("invoice-grace", 0, "How long invoices stay drafts open to edits (0 means 1h, negative finalizes right away)")
+	lookback := flag.Duration("lookback", 31*24*time.Hour, "How long before its time a run bills the periods that ended: the longest time between runs of the cron")
+	note := flag.String("note", "", "Note recorded with the pause and unpause actions")
+	from := flag.String("from", "", "RFC3339 start of the time range to backfill")
+	to := flag.String("to", "", "RFC3339 end of the time range to backfill")
+	flag.Parse()
+
+	if *subscriptionID == "" {
+		log.Fatalln("Subscription ID is required. Use -subscription flag to specify it.")
+	}
+	policy, ok := overlapPolicies[*overlap]
+	if !ok {
+		log.Fatalf("Unknown overlap policy: %s\n", *overlap)
+	}
+	if *catchupWindow < 10*time.Second {
+		log.Fatalln("The catchup window must be at least 10s, the shortest Temporal accepts")
+	}
+
+	// Create the client object
+	c, err := client.Dial(config.GetTemporalClientOptions())
+	if err != nil {
+		log.Fatalln("Unable to create Temporal client", err)
+	}
+	defer c.Close()
+
+	// One billing schedule per subscription
+	ctx := context.Background()
+	scheduleID := "recurring-billing-schedule-" + *subscriptionID
+	handle := c.ScheduleClient().GetHandle(ctx, scheduleID)
+
+	// Perform the requested action
+	switch *action {
+	case "create":
+		if *customerID == "" {
+			log.Fatalln("Customer ID is required. Use -customer flag to specify it.")
+		}
+		// A subscription with a running entity workflow is billed by it, and a schedule would bill it twice
+		entityID := "subscription-" + *subscriptionID
+		entity, err := c.DescribeWorkflowExecution(ctx, entityID, "")
+		var notFound *serviceerror.NotFound
+		switch {
+		case errors.As(err, &notFound):
+		case err != nil:
+			log.Fatalln("Unable to check for the subscription's entity workflow", err)
+		case entity.GetWorkflowExecutionInfo().GetStatus() == enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING:
+			log.Fatalf("Subscription %s is billed by its entity workflow %s; not creating a schedule\n", *subscriptionID, entityID)
+		}
+		params := workflows.RecurringBillingParams{
+			SubscriptionID:     *subscriptionID,
+			CustomerID:         *customerID,
+			InvoiceGracePeriod: *invoiceGrace,
+			Lookback:           *lookback,
+		}
+
+		handle, err = c.ScheduleClient().Create(ctx, client.ScheduleOptions{
+			ID: scheduleID,
+			Spec: client.ScheduleSpec{
+				CronExpressions: []string{*cron},
+				TimeZoneName:    *timeZone,
+			},
+			Action: &client.ScheduleWorkflowAction{
+				// Each run's workflow ID is this with the time it was scheduled for appended
+				ID:                  "recurring-billing-" + *subscriptionID,
+				Workflow:            workflows.RecurringBillingWorkflow,
+				Args:                []interface{}{params},
+				TaskQueue:           "temporal-learning-task-queue",
+				WorkflowRunTimeout:  24 * time.Hour,
+				WorkflowTaskTimeout: 10 * time.Minute,
+			},
+			Overlap:       policy,
+			CatchupWindow: *catchupWindow,
+		})
+		if err != nil {
+			log.Fatalln("Unable to create schedule", err)
+		}
+		log.Printf("Created schedule %s running %q in %s\n", handle.GetID(), *cron, zoneName(*timeZone))
+		log.Printf("  Overlap policy: %s, catchup window: %s\n", *overlap, *catchupWindow)
+
+	case "describe":
+		description, err := handle.Describe(ctx)
+		if err != nil {
+			log.Fatalln("Unable to describe schedule", err)
+		}
+		printSchedule(scheduleID, description)
+
+	case "pause":
+		if err := handle.Pause(ctx, client.SchedulePauseOptions{Note: *note}); err != nil {
+			log.Fatalln("Unable to pause schedule", err)
+		}
+		log.Printf("Paused schedule %s\n", scheduleID)
+
+	case "unpause":
+		if err := handle.Unpause(ctx, client.ScheduleUnpauseOptions{Note: *note}); err != nil {
+			log.Fatalln("Unable to unpause schedule", err)
+		}
+		log.Printf("Unpaused schedule %s\n", scheduleID)
+
+	case "trigger":
+		// Started now, the run bills the last period that has ended, unless it is already invoiced
+		if err := handle.Trigger(ctx, client.ScheduleTriggerOptions{Overlap: policy}); err != nil {
+			log.Fatalln("Unable to trigger schedule", err)
+		}
+		log.Printf("Triggered a billing run of schedule %s\n", scheduleID)
+
+	case "backfill":
+		start, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			log.Fatalln("Invalid -from, expected an RFC3339 time:", err)
+		}
+		end, err := time.Parse(time.RFC3339, *to)
+		if err != nil {
+			log.Fatalln("Invalid -to, expected an RFC3339 time:", err)
+		}
+		if !start.Before(end) {
+			log.Fatalln("The backfill must start before it ends")
+		}
+
+		// Each run the schedule would have started in the range is started now, as of the time
+		// it was scheduled for, and bills the period that had ended by then
+		err = handle.Backfill(ctx, client.ScheduleBackfillOptions{
+			Backfill: []client.ScheduleBackfill{{Start: start, End: end, Overlap: policy}},
+		})
+		if err != nil {
+			log.Fatalln("Unable to backfill schedule", err)
+		}
+		log.Printf("Backfilled schedule %s from %s to %s\n", scheduleID, start.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	case "update":
+		// Only the flags given on the command line are changed
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+		err := handle.Update(ctx, client.ScheduleUpdateOptions{
+			DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+				schedule := input.Description.Schedule
+				if set["cron"] {
+					// The server keeps a cron expression as calendars, which would otherwise
+					// run alongside the new one
+					schedule.Spec.CronExpressions = []string{*cron}
+					schedule.Spec.Calendars = nil
+				}
+				if set["timezone"] {
+					schedule.Spec.TimeZoneName = *timeZone
+				}
+				if set["overlap"] {
+					schedule.Policy.Overlap = policy
+				}
+				if set["catchup-window"] {
+					schedule.Policy.CatchupWindow = *catchupWindow
+				}
+				if set["invoice-grace"] || set["lookback"] {
+					err := setParams(schedule.Action, func(params *workflows.RecurringBillingParams) {
+						if set["invoice-grace"] {
+							params.InvoiceGracePeriod = *invoiceGrace
+						}
+						if set["lookback"] {
+							params.Lookback = *lookback
+						}
+					})
+					if err != nil {
+						return nil, err
+					}
+				}
+				return &client.ScheduleUpdate{Schedule: &schedule}, nil
+			},
+		})
+		if err != nil {
+			log.Fatalln("Unable to update schedule", err)
+		}
+		log.Printf("Updated schedule %s\n", scheduleID)
+
+	case "delete":
+		// Runs already started finish; no more are started
+		if err := handle.Delete(ctx); err != nil {
+			log.Fatalln("Unable to delete schedule", err)
+		}
+		log.Printf("Deleted schedule %s\n", scheduleID)
+
+	default:
+		log.Fatalf("Unknown action: %s. Use 'create', 'describe', 'pause', 'unpause', 'trigger', 'backfill', 'update' or 'delete'.", *action)
+	}
+}
+
+// setParams changes the parameters of the billing workflow a schedule starts. The schedule
+// describes its action with the workflow's arguments still encoded.
+func setParams(action client.ScheduleAction, set func(*workflows.RecurringBillingParams)) error {
+	workflowAction, ok := action.(*client.ScheduleWorkflowAction)
+	if !ok || len(workflowAction.Args) != 1 {
+		return errors.New("schedule does not start a recurring billing workflow")
+	}
+	payload, ok := workflowAction.Args[0].(*commonpb.Payload)
+	if !ok {
+		return errors.New("schedule does not start a recurring billing workflow")
+	}
+
+	var params workflows.RecurringBillingParams
+	if err := converter.GetDefaultDataConverter().FromPayload(payload, &params); err != nil {
+		return fmt.Errorf("decoding recurring billing parameters: %w", err)
+	}
+	set(&params)
+	workflowAction.Args = []interface{}{params}
+	return nil
+}
+
+// printSchedule prints a schedule's spec, policies, state and recent and upcoming runs
+func printSchedule(scheduleID string, description *client.ScheduleDescription) {
+	schedule, info := description.Schedule, description.Info
+
+	log.Printf("Schedule %s\n", scheduleID)
+	if spec := schedule.Spec; spec != nil {
+		log.Printf("  Time zone: %s\n", zoneName(spec.TimeZoneName))
+	}
+	if policy := schedule.Policy; policy != nil {
+		log.Printf("  Overlap policy: %s, catchup window: %s\n", overlapName(policy.Overlap), policy.CatchupWindow)
+	}
+	if state := schedule.State; state != nil {
+		status := "active"
+		if state.Paused {
+			status = "paused"
+		}
+		if state.Note != "" {
+			status += " (" + state.Note + ")"
+		}
+		log.Printf("  State: %s\n", status)
+	}
+	log.Printf("  Runs started: %d, missed the catchup window: %d, skipped while another ran: %d\n",
+		info.NumActions, info.NumActionsMissedCatchupWindow, info.NumActionsSkippedOverlap)
+
+	for _, running := range info.RunningWorkflows {
+		log.Printf("  Running: %s (%s)\n", running.WorkflowID, running.FirstExecutionRunID)
+	}
+	for _, recent := range info.RecentActions {
+		workflowID := ""
+		if recent.StartWorkflowResult != nil {
+			workflowID = recent.StartWorkflowResult.WorkflowID
+		}
+		log.Printf("  Ran: %s for %s, started %s\n", workflowID,
+			recent.ScheduleTime.Format(time.RFC3339), recent.ActualTime.Format(time.RFC3339))
+	}
+	for _, next := range info.NextActionTimes {
+		log.Printf("  Next: %s\n", next.Format(time.RFC3339))
+	}
+}
+
+// overlapName is the -overlap flag name of an overlap policy
+func overlapName(policy enumspb.ScheduleOverlapPolicy) string {
+	for name, p := range overlapPolicies {
+		if p == policy {
+			return name
+		}
+	}
+	return policy.String()
+}
+
+// zoneName names a schedule's time zone, which is UTC when empty
+func zoneName(timeZone string) string {
+	if timeZone == "" {
+		return "UTC"
+	}
+	return timeZone
+}
@@ -15,8 +15,9 @@ func main() {
 	// Define command line flags
 	workflowID := flag.String("w", "", "Workflow ID to send signal to")
 	runID := flag.String("r", "", "Run ID of the workflow (optional)")
-	action := flag.String("action", "signal", "Action to perform: signal, query")
+	action := flag.String("action", "signal", "Action to perform: signal, query, payment-updated, dunning-state")
 	message := flag.String("message", "Signal from command line", "Message to send in the signal")
+	paymentMethodID := flag.String("payment-method", "", "New payment method ID for the payment-updated action")
 	flag.Parse()
 
 	if *workflowID == "" {
@@ -65,7 +66,37 @@ func main() {
 			log.Printf("  %d: %s (received at %v)\n", i+1, signal.Message, signal.Time.Format(time.RFC3339))
 		}
 
+	case "payment-updated":
+		if *paymentMethodID == "" {
+			log.Fatalln("Payment method ID is required. Use -payment-method flag to specify it.")
+		}
+
+		// Tell the dunning workflow the customer has a new payment method
+		signalData := workflows.PaymentUpdatedSignal{PaymentMethodID: *paymentMethodID}
+		err = c.SignalWorkflow(context.Background(), *workflowID, *runID, workflows.PaymentUpdatedSignalName, signalData)
+		if err != nil {
+			log.Fatalln("Failed to send signal", err)
+		}
+		log.Println("Payment updated signal sent successfully")
+
+	case "dunning-state":
+		// Query the dunning workflow
+		resp, err := c.QueryWorkflow(context.Background(), *workflowID, *runID, "get_dunning_state")
+		if err != nil {
+			log.Fatalln("Failed to query workflow", err)
+		}
+
+		var state workflows.DunningState
+		if err := resp.Get(&state); err != nil {
+			log.Fatalln("Failed to decode query result", err)
+		}
+
+		log.Printf("Dunning status: %s, attempts: %d, outcome: %s\n", state.Status, state.Attempts, state.Outcome)
+		if !state.NextRetryAt.IsZero() {
+			log.Printf("Next retry at: %s\n", state.NextRetryAt.Format(time.RFC3339))
+		}
+
 	default:
-		log.Fatalf("Unknown action: %s. Use 'signal' or 'query'.", *action)
+		log.Fatalf("Unknown action: %s. Use 'signal', 'query', 'payment-updated' or 'dunning-state'.", *action)
 	}
 }
@@ -16,6 +16,12 @@ func main() {
 	// Define command line flags
 	customerID := flag.String("customer", "cust123", "Customer ID for the subscription")
 	planID := flag.String("plan", "basic-monthly", "Plan ID for the subscription")
+	quantity := flag.Int64("quantity", 1, "Number of seats for per-seat and tiered plans")
+	paymentMethodID := flag.String("payment-method", "pm_card_visa", "Payment method ID to charge")
+	trialDays := flag.Int("trial-days", 0, "Length of the free trial in days (0 charges right away)")
+	trialReminderDays := flag.Int("trial-reminder-days", 0, "Days before the trial ends to send a reminder (0 uses the default)")
+	currency := flag.String("currency", "", "Currency the customer is billed in (empty uses the plan's currency)")
+	timeZone := flag.String("timezone", "", "Customer's IANA time zone, such as America/New_York, whose calendar billing periods follow (empty uses UTC)")
 	flag.Parse()
 
 	// Create the client object
@@ -33,8 +39,14 @@ func main() {
 
 	// Create subscription parameters
 	params := workflows.SubscriptionParams{
-		CustomerID: *customerID,
-		PlanID:     *planID,
+		CustomerID:        *customerID,
+		PlanID:            *planID,
+		Quantity:          *quantity,
+		PaymentMethodID:   *paymentMethodID,
+		TrialDays:         *trialDays,
+		TrialReminderDays: *trialReminderDays,
+		Currency:          *currency,
+		TimeZone:          *timeZone,
 	}
 
 	// Start the subscription workflow
@@ -54,5 +66,9 @@ func main() {
 	}
 
 	log.Printf("Subscription created successfully with ID: %s\n", subscriptionID)
-	log.Println("The recurring billing workflow has been scheduled and will run monthly.")
+	if *trialDays > 0 {
+		log.Printf("The subscription is on a %d day trial and will be charged when it ends.\n", *trialDays)
+	} else {
+		log.Println("Its subscription entity workflow bills each following billing period.")
+	}
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tanint/play-temporal/config"
+	"github.com/tanint/play-temporal/usage"
+	"github.com/tanint/play-temporal/workflows"
+	"go.temporal.io/sdk/client"
+)
+
+func main() {
+	// Define command line flags
+	subscriptionID := flag.String("subscription", "", "Subscription ID the usage belongs to")
+	meter := flag.String("meter", "", "Meter to record usage for (e.g. api_calls, storage_gb)")
+	quantity := flag.Int64("quantity", 1, "Quantity of usage to record")
+	eventID := flag.String("event", "", "Idempotency key for the event (default: generated)")
+	flag.Parse()
+
+	if *subscriptionID == "" || *meter == "" {
+		log.Fatalln("Subscription ID and meter are required")
+	}
+	if *eventID == "" {
+		*eventID = fmt.Sprintf("evt_%d", time.Now().UnixNano())
+	}
+
+	// Create the client object
+	c, err := client.Dial(config.GetTemporalClientOptions())
+	if err != nil {
+		log.Fatalln("Unable to create Temporal client", err)
+	}
+	defer c.Close()
+
+	event := usage.Event{
+		SubscriptionID: *subscriptionID,
+		Meter:          *meter,
+		EventID:        *eventID,
+		Quantity:       *quantity,
+		Timestamp:      time.Now(),
+	}
+
+	// Create workflow options
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        fmt.Sprintf("usage-%s-%s-%s", *subscriptionID, *meter, *eventID),
+		TaskQueue: "temporal-learning-task-queue",
+	}
+
+	workflowRun, err := c.ExecuteWorkflow(context.Background(), workflowOptions, workflows.RecordUsageWorkflow, event)
+	if err != nil {
+		log.Fatalln("Unable to execute workflow", err)
+	}
+
+	// Wait for workflow completion
+	var recorded bool
+	if err := workflowRun.Get(context.Background(), &recorded); err != nil {
+		log.Fatalln("Workflow failed", err)
+	}
+
+	if recorded {
+		log.Printf("Recorded %d %s for subscription %s (event %s)\n", *quantity, *meter, *subscriptionID, *eventID)
+	} else {
+		log.Printf("Event %s was already recorded, nothing changed\n", *eventID)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tanint/play-temporal/config"
+	"github.com/tanint/play-temporal/webhooks"
+	"github.com/tanint/play-temporal/workflows"
+	"go.temporal.io/sdk/client"
+)
+
+func main() {
+	// Define command line flags
+	action := flag.String("action", "status", "Action to perform: status, receive")
+	eventID := flag.String("event", "", "Event ID to show the deliveries of")
+	addr := flag.String("addr", ":8088", "Address the receiver listens on")
+	secret := flag.String("secret", "whsec_local_development", "Secret the receiver verifies signatures with")
+	flag.Parse()
+
+	switch *action {
+	case "status":
+		if *eventID == "" {
+			log.Fatalln("Event ID is required. Use -event flag to specify it.")
+		}
+
+		// Create the client object
+		c, err := client.Dial(config.GetTemporalClientOptions())
+		if err != nil {
+			log.Fatalln("Unable to create Temporal client", err)
+		}
+		defer c.Close()
+
+		response, err := c.QueryWorkflow(context.Background(), "webhook-"+*eventID, "", workflows.WebhookDeliveriesQuery)
+		if err != nil {
+			log.Fatalln("Unable to query workflow", err)
+		}
+		var state workflows.WebhookDeliveryState
+		if err := response.Get(&state); err != nil {
+			log.Fatalln("Unable to decode query result", err)
+		}
+		log.Printf("Event %s (%s) to %d endpoints\n", state.EventID, state.EventType, len(state.Deliveries))
+		for _, delivery := range state.Deliveries {
+			log.Printf("  %s %s: %s after %d attempts\n", delivery.EndpointID, delivery.URL, delivery.Status, len(delivery.Attempts))
+			for _, attempt := range delivery.Attempts {
+				result := "ok"
+				if !attempt.Succeeded() {
+					result = attempt.Error
+				}
+				log.Printf("    #%d at %s: %s\n", attempt.Number, attempt.At.Format(time.RFC3339), result)
+			}
+			if !delivery.NextAttemptAt.IsZero() {
+				log.Printf("    next attempt at %s\n", delivery.NextAttemptAt.Format(time.RFC3339))
+			}
+			if delivery.DeadLetterURL != "" {
+				log.Printf("    dead-lettered to %s\n", delivery.DeadLetterURL)
+			}
+		}
+
+	case "receive":
+		// A local endpoint that verifies and prints the webhooks it receives
+		http.HandleFunc("/webhooks", func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := webhooks.Verify(*secret, r.Header.Get(webhooks.SignatureHeader), body, 5*time.Minute, time.Now()); err != nil {
+				log.Printf("Rejected %s: %v\n", r.Header.Get(webhooks.IDHeader), err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			var event webhooks.Event
+			if err := json.Unmarshal(body, &event); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Received %s %s for %s: %s\n", event.Type, event.ID, event.SubscriptionID, event.Data)
+		})
+		log.Printf("Receiving webhooks on %s/webhooks\n", *addr)
+		log.Fatalln(http.ListenAndServe(*addr, nil))
+
+	default:
+		log.Fatalf("Unknown action: %s. Use 'status' or 'receive'.", *action)
+	}
+}
@@ -1,10 +1,24 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"log"
 
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/blobs"
+	"github.com/tanint/play-temporal/catalog"
 	"github.com/tanint/play-temporal/config"
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/exchange"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/ledger"
+	"github.com/tanint/play-temporal/notify"
+	"github.com/tanint/play-temporal/payments"
+	"github.com/tanint/play-temporal/tax"
+	"github.com/tanint/play-temporal/usage"
+	"github.com/tanint/play-temporal/webhooks"
 	"github.com/tanint/play-temporal/workflows"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
@@ -18,6 +32,124 @@ func main() {
 	}
 	defer c.Close()
 
+	// Load and validate the plan catalog before accepting any work
+	plans, err := catalog.Load(config.GetPlanCatalogPath())
+	if err != nil {
+		log.Fatalln("Unable to load plan catalog", err)
+	}
+	activities.SetPlanCatalog(plans)
+	log.Printf("Loaded %d plans from %s\n", len(plans.Plans), config.GetPlanCatalogPath())
+
+	// Load and validate the tax rules the same way
+	taxRules, err := tax.Load(config.GetTaxRulesPath())
+	if err != nil {
+		log.Fatalln("Unable to load tax rules", err)
+	}
+	activities.SetTaxCalculator(taxRules)
+	log.Printf("Loaded %d tax jurisdictions from %s\n", len(taxRules.Jurisdictions), config.GetTaxRulesPath())
+
+	// And the exchange rates for subscriptions billed in other currencies than their plan
+	rates, err := exchange.Load(config.GetExchangeRatesPath())
+	if err != nil {
+		log.Fatalln("Unable to load exchange rates", err)
+	}
+	activities.SetExchangeRates(rates)
+	log.Printf("Loaded %d %s exchange rates as of %s from %s\n",
+		len(rates.Rates), rates.Base, rates.AsOf.Format("2006-01-02"), config.GetExchangeRatesPath())
+
+	// Write rendered invoices to the blob directory
+	blobStore := blobs.NewFileStore(config.GetBlobDir())
+	activities.SetBlobStore(blobStore)
+	log.Printf("Writing rendered invoices to %s\n", config.GetBlobDir())
+
+	// Load the endpoints billing events are sent to
+	endpoints, err := webhooks.Load(config.GetWebhooksPath())
+	if err != nil {
+		log.Fatalln("Unable to load webhook endpoints", err)
+	}
+	activities.SetWebhookEndpoints(endpoints)
+	log.Printf("Loaded %d webhook endpoints from %s\n", len(endpoints.Endpoints), config.GetWebhooksPath())
+
+	// Send billing emails through SMTP when configured, otherwise write them to the outbox
+	activities.SetCustomerEmailDomain(config.GetCustomerEmailDomain())
+	if addr := config.GetSMTPAddr(); addr != "" {
+		username, password := config.GetSMTPCredentials()
+		activities.SetNotifier(notify.NewSMTPNotifier(addr, config.GetEmailFrom(), username, password))
+		log.Printf("Sending emails through SMTP server %s\n", addr)
+	} else {
+		activities.SetNotifier(notify.NewOutbox(blobStore, config.GetEmailFrom()))
+		log.Printf("SMTP_ADDR not set, writing emails to %s/outbox\n", config.GetBlobDir())
+	}
+
+	// Use MySQL for billing data when configured, otherwise keep the in-memory store
+	if dsn := config.GetBillingDatabaseDSN(); dsn != "" {
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			log.Fatalln("Unable to open billing database", err)
+		}
+		defer db.Close()
+
+		store, err := activities.NewMySQLSubscriptionStore(context.Background(), db)
+		if err != nil {
+			log.Fatalln("Unable to initialize subscription store", err)
+		}
+		activities.SetSubscriptionStore(store)
+
+		usageStore, err := usage.NewMySQLStore(context.Background(), db)
+		if err != nil {
+			log.Fatalln("Unable to initialize usage store", err)
+		}
+		activities.SetUsageStore(usageStore)
+
+		paymentLedger, err := payments.NewMySQLLedger(context.Background(), db)
+		if err != nil {
+			log.Fatalln("Unable to initialize payment ledger", err)
+		}
+		activities.SetPaymentLedger(paymentLedger)
+
+		creditStore, err := credits.NewMySQLStore(context.Background(), db)
+		if err != nil {
+			log.Fatalln("Unable to initialize credit store", err)
+		}
+		activities.SetCreditStore(creditStore)
+
+		sentLog, err := notify.NewMySQLSentLog(context.Background(), db)
+		if err != nil {
+			log.Fatalln("Unable to initialize notification log", err)
+		}
+		activities.SetNotificationLog(sentLog)
+
+		journal, err := ledger.NewMySQLStore(context.Background(), db)
+		if err != nil {
+			log.Fatalln("Unable to initialize ledger", err)
+		}
+		activities.SetLedgerStore(journal)
+
+		invoiceStore, err := activities.NewMySQLInvoiceStore(context.Background(), db)
+		if err != nil {
+			log.Fatalln("Unable to initialize invoice store", err)
+		}
+		activities.SetInvoiceStore(invoiceStore)
+
+		sequence, err := invoices.NewMySQLSequence(context.Background(), db)
+		if err != nil {
+			log.Fatalln("Unable to initialize invoice numbers", err)
+		}
+		activities.SetInvoiceNumbering(sequence, config.GetMerchantID(), config.GetInvoiceNumberPrefix())
+		log.Println("Using MySQL subscription, usage, payment, credit, notification, ledger and invoice stores")
+	} else {
+		activities.SetInvoiceNumbering(invoices.NewMemorySequence(), config.GetMerchantID(), config.GetInvoiceNumberPrefix())
+		log.Println("BILLING_DB_DSN not set, using in-memory subscription, usage, payment, credit, notification, ledger and invoice stores")
+	}
+
+	// Charge a real payment gateway when configured, otherwise the local fake gateway
+	if url := config.GetPaymentGatewayURL(); url != "" {
+		activities.SetPaymentGateway(payments.NewHTTPGateway(url, config.GetPaymentGatewayAPIKey(), nil))
+		log.Printf("Using payment gateway at %s\n", url)
+	} else {
+		log.Println("PAYMENT_GATEWAY_URL not set, using the local fake payment gateway")
+	}
+
 	// Create a Worker instance
 	w := worker.New(c, "temporal-learning-task-queue", worker.Options{})
 
@@ -41,6 +173,12 @@ func main() {
 	// Register subscription workflows
 	w.RegisterWorkflow(workflows.SubscriptionWorkflow)
 	w.RegisterWorkflow(workflows.RecurringBillingWorkflow)
+	w.RegisterWorkflow(workflows.BackfillBillingWorkflow)
+	w.RegisterWorkflow(workflows.RecordUsageWorkflow)
+	w.RegisterWorkflow(workflows.DunningWorkflow)
+	w.RegisterWorkflow(workflows.SubscriptionEntityWorkflow)
+	w.RegisterWorkflow(workflows.RefundWorkflow)
+	w.RegisterWorkflow(workflows.WebhookDeliveryWorkflow)
 
 	// Register activities
 	w.RegisterActivity(activities.GreetingActivity)
@@ -50,11 +188,54 @@ func main() {
 
 	// Register subscription activities
 	w.RegisterActivity(activities.CreateSubscriptionActivity)
+	w.RegisterActivity(activities.LoadSubscriptionActivity)
 	w.RegisterActivity(activities.CalculateChargesActivity)
+	w.RegisterActivity(activities.CalculateTaxActivity)
 	w.RegisterActivity(activities.GenerateInvoiceActivity)
 	w.RegisterActivity(activities.ProcessPaymentActivity)
+	w.RegisterActivity(activities.RenderInvoiceActivity)
 	w.RegisterActivity(activities.SendInvoiceEmailActivity)
+	w.RegisterActivity(activities.SendPaymentReceiptEmailActivity)
+	w.RegisterActivity(activities.SendSubscriptionCanceledEmailActivity)
 	w.RegisterActivity(activities.UpdateSubscriptionStatusActivity)
+	w.RegisterActivity(activities.RecordUsageActivity)
+	w.RegisterActivity(activities.SendPaymentReminderEmailActivity)
+	w.RegisterActivity(activities.UpdatePaymentMethodActivity)
+	w.RegisterActivity(activities.LoadPlanActivity)
+	w.RegisterActivity(activities.PricePlanActivity)
+	w.RegisterActivity(activities.ChangeSubscriptionPlanActivity)
+	w.RegisterActivity(activities.SendTrialEndingEmailActivity)
+	w.RegisterActivity(activities.ExtendTrialActivity)
+	w.RegisterActivity(activities.LoadCouponActivity)
+	w.RegisterActivity(activities.ApplyCustomerBalanceActivity)
+
+	// Register invoice activities
+	w.RegisterActivity(activities.CreateDraftInvoiceActivity)
+	w.RegisterActivity(activities.UpdateDraftInvoiceActivity)
+	w.RegisterActivity(activities.FinalizeInvoiceActivity)
+	w.RegisterActivity(activities.UpdateInvoiceStatusActivity)
+	w.RegisterActivity(activities.FindUnbilledPeriodsActivity)
+	w.RegisterActivity(activities.ListOpenInvoicesActivity)
+
+	// Register refund activities
+	w.RegisterActivity(activities.LoadRefundableInvoiceActivity)
+	w.RegisterActivity(activities.RefundPaymentActivity)
+	w.RegisterActivity(activities.IssueCreditNoteActivity)
+	w.RegisterActivity(activities.CreditCustomerBalanceActivity)
+
+	// Register compensation activities
+	w.RegisterActivity(activities.DeleteSubscriptionActivity)
+	w.RegisterActivity(activities.VoidInvoiceActivity)
+	w.RegisterActivity(activities.ReversePaymentActivity)
+
+	// Register ledger activities
+	w.RegisterActivity(activities.PostInvoiceActivity)
+	w.RegisterActivity(activities.ReconcileLedgerActivity)
+
+	// Register webhook activities
+	w.RegisterActivity(activities.ListWebhookTargetsActivity)
+	w.RegisterActivity(activities.DeliverWebhookActivity)
+	w.RegisterActivity(activities.DeadLetterWebhookActivity)
 
 	// Start listening to the Task Queue
 	log.Println("Starting Temporal worker...")
@@ -25,3 +25,121 @@ func GetTemporalClientOptions() client.Options {
 		Namespace: namespace,
 	}
 }
+
+// GetBillingDatabaseDSN returns the MySQL DSN for the billing database.
+// An empty string means the worker should fall back to in-memory storage.
+func GetBillingDatabaseDSN() string {
+	return os.Getenv("BILLING_DB_DSN")
+}
+
+// GetPlanCatalogPath returns the path of the plan catalog file loaded by the worker
+func GetPlanCatalogPath() string {
+	// Default to the catalog shipped with the repository if PLAN_CATALOG_PATH is not set
+	path := os.Getenv("PLAN_CATALOG_PATH")
+	if path == "" {
+		path = "config/plans.yaml"
+	}
+	return path
+}
+
+// GetTaxRulesPath returns the path of the tax rules file loaded by the worker
+func GetTaxRulesPath() string {
+	// Default to the rules shipped with the repository if TAX_RULES_PATH is not set
+	path := os.Getenv("TAX_RULES_PATH")
+	if path == "" {
+		path = "config/tax.yaml"
+	}
+	return path
+}
+
+// GetExchangeRatesPath returns the path of the exchange rates file loaded by the worker
+func GetExchangeRatesPath() string {
+	// Default to the rates shipped with the repository if EXCHANGE_RATES_PATH is not set
+	path := os.Getenv("EXCHANGE_RATES_PATH")
+	if path == "" {
+		path = "config/exchange_rates.yaml"
+	}
+	return path
+}
+
+// GetBlobDir returns the directory the worker keeps rendered invoices in
+func GetBlobDir() string {
+	// Default to a data directory next to the worker if BLOB_DIR is not set
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = "data/blobs"
+	}
+	return dir
+}
+
+// GetWebhooksPath returns the path of the webhook endpoints file loaded by the worker
+func GetWebhooksPath() string {
+	// Default to the endpoints shipped with the repository if WEBHOOKS_PATH is not set
+	path := os.Getenv("WEBHOOKS_PATH")
+	if path == "" {
+		path = "config/webhooks.yaml"
+	}
+	return path
+}
+
+// GetSMTPAddr returns the host:port of the SMTP server billing emails are sent through.
+// An empty string means the worker should write emails to the outbox in the blob directory.
+func GetSMTPAddr() string {
+	return os.Getenv("SMTP_ADDR")
+}
+
+// GetSMTPCredentials returns the username and password for the SMTP server, empty for none
+func GetSMTPCredentials() (username, password string) {
+	return os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")
+}
+
+// GetEmailFrom returns the address billing emails are sent from
+func GetEmailFrom() string {
+	// Default to a placeholder address if EMAIL_FROM is not set
+	from := os.Getenv("EMAIL_FROM")
+	if from == "" {
+		from = "billing@example.com"
+	}
+	return from
+}
+
+// GetCustomerEmailDomain returns the domain of customers' email addresses, <customer ID>@<domain>
+func GetCustomerEmailDomain() string {
+	// Default to a domain that never receives mail if CUSTOMER_EMAIL_DOMAIN is not set
+	domain := os.Getenv("CUSTOMER_EMAIL_DOMAIN")
+	if domain == "" {
+		domain = "example.com"
+	}
+	return domain
+}
+
+// GetMerchantID returns the merchant whose invoice number sequence the worker allocates from
+func GetMerchantID() string {
+	// Default to a single merchant if MERCHANT_ID is not set
+	merchant := os.Getenv("MERCHANT_ID")
+	if merchant == "" {
+		merchant = "default"
+	}
+	return merchant
+}
+
+// GetInvoiceNumberPrefix returns the prefix invoice numbers are printed with, as in INV-000042
+func GetInvoiceNumberPrefix() string {
+	// Default to INV if INVOICE_NUMBER_PREFIX is not set
+	prefix := os.Getenv("INVOICE_NUMBER_PREFIX")
+	if prefix == "" {
+		prefix = "INV"
+	}
+	return prefix
+}
+
+// GetPaymentGatewayURL returns the base URL of the payment gateway API.
+// An empty string means the worker should use the local fake gateway.
+func GetPaymentGatewayURL() string {
+	return os.Getenv("PAYMENT_GATEWAY_URL")
+}
+
+// GetPaymentGatewayAPIKey returns the API key sent to the payment gateway
+func GetPaymentGatewayAPIKey() string {
+	return os.Getenv("PAYMENT_GATEWAY_API_KEY")
+}
@@ -0,0 +1,178 @@
+package credits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// Where the amount of a credit note goes
+const (
+	// DestinationPaymentMethod refunds the amount to the payment method that paid the invoice
+	DestinationPaymentMethod = "payment_method"
+	// DestinationBalance adds the amount to the customer's credit balance for future invoices
+	DestinationBalance = "balance"
+)
+
+// CreditNote reduces the amount paid for an invoice. Credit notes are idempotent on ID:
+// issuing a second note with the same ID returns the first.
+type CreditNote struct {
+	ID             string
+	InvoiceID      string
+	SubscriptionID string
+	CustomerID     string
+	Amount         money.Money
+	Reason         string
+	Destination    string
+	RefundID       string // gateway refund, when the amount went to the payment method
+	CreatedAt      time.Time
+}
+
+// Validate checks that a credit note has everything needed to be issued
+func (n CreditNote) Validate() error {
+	if n.ID == "" || n.InvoiceID == "" || n.CustomerID == "" {
+		return errors.New("credit note needs an ID, invoice ID and customer ID")
+	}
+	if n.Amount.Sign() <= 0 {
+		return fmt.Errorf("credit note amount %s must be positive", n.Amount)
+	}
+	if n.Destination != DestinationPaymentMethod && n.Destination != DestinationBalance {
+		return fmt.Errorf("unknown credit note destination %q", n.Destination)
+	}
+	return nil
+}
+
+// BalanceTransaction changes a customer's credit balance. Positive amounts add credit and
+// negative amounts use it. Transactions are idempotent on ID.
+type BalanceTransaction struct {
+	ID          string
+	CustomerID  string
+	Amount      money.Money
+	Description string
+	CreatedAt   time.Time
+}
+
+// Validate checks that a transaction has everything needed to be recorded
+func (t BalanceTransaction) Validate() error {
+	if t.ID == "" || t.CustomerID == "" {
+		return errors.New("balance transaction needs an ID and customer ID")
+	}
+	if t.Amount.Currency() == "" {
+		return errors.New("balance transaction amount needs a currency")
+	}
+	return nil
+}
+
+// Store keeps credit notes and customer credit balances
+type Store interface {
+	// IssueCreditNote stores a credit note unless its ID is taken. It returns the note stored
+	// under the ID and reports false if that is an earlier note rather than this one.
+	IssueCreditNote(ctx context.Context, note CreditNote) (CreditNote, bool, error)
+	// ListCreditNotes returns the credit notes issued for an invoice, oldest first
+	ListCreditNotes(ctx context.Context, invoiceID string) ([]CreditNote, error)
+	// RecordTransaction stores a balance transaction unless its ID is taken, with the same
+	// results as IssueCreditNote
+	RecordTransaction(ctx context.Context, transaction BalanceTransaction) (BalanceTransaction, bool, error)
+	// GetTransaction returns the balance transaction recorded under an ID, reporting false if none was
+	GetTransaction(ctx context.Context, id string) (BalanceTransaction, bool, error)
+	// Balance returns a customer's credit balance in a currency, the sum of their transactions
+	Balance(ctx context.Context, customerID, currency string) (money.Money, error)
+}
+
+// MemoryStore is an in-memory Store, useful for local runs and tests
+type MemoryStore struct {
+	mu           sync.RWMutex
+	notes        map[string]CreditNote
+	byInvoice    map[string][]string // credit note IDs keyed by invoice ID, in issue order
+	transactions map[string]BalanceTransaction
+	byCustomer   map[string][]string // transaction IDs keyed by customer ID, in recording order
+}
+
+// NewMemoryStore creates an empty in-memory credit store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		notes:        make(map[string]CreditNote),
+		byInvoice:    make(map[string][]string),
+		transactions: make(map[string]BalanceTransaction),
+		byCustomer:   make(map[string][]string),
+	}
+}
+
+// IssueCreditNote stores a credit note unless its ID is taken
+func (s *MemoryStore) IssueCreditNote(ctx context.Context, note CreditNote) (CreditNote, bool, error) {
+	if err := note.Validate(); err != nil {
+		return CreditNote{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.notes[note.ID]; ok {
+		return existing, false, nil
+	}
+	s.notes[note.ID] = note
+	s.byInvoice[note.InvoiceID] = append(s.byInvoice[note.InvoiceID], note.ID)
+	return note, true, nil
+}
+
+// ListCreditNotes returns the credit notes issued for an invoice, oldest first
+func (s *MemoryStore) ListCreditNotes(ctx context.Context, invoiceID string) ([]CreditNote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.byInvoice[invoiceID]
+	notes := make([]CreditNote, len(ids))
+	for i, id := range ids {
+		notes[i] = s.notes[id]
+	}
+	return notes, nil
+}
+
+// RecordTransaction stores a balance transaction unless its ID is taken
+func (s *MemoryStore) RecordTransaction(ctx context.Context, transaction BalanceTransaction) (BalanceTransaction, bool, error) {
+	if err := transaction.Validate(); err != nil {
+		return BalanceTransaction{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.transactions[transaction.ID]; ok {
+		return existing, false, nil
+	}
+	s.transactions[transaction.ID] = transaction
+	s.byCustomer[transaction.CustomerID] = append(s.byCustomer[transaction.CustomerID], transaction.ID)
+	return transaction, true, nil
+}
+
+// GetTransaction returns the balance transaction recorded under an ID
+func (s *MemoryStore) GetTransaction(ctx context.Context, id string) (BalanceTransaction, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transaction, ok := s.transactions[id]
+	return transaction, ok, nil
+}
+
+// Balance returns a customer's credit balance in a currency
+func (s *MemoryStore) Balance(ctx context.Context, customerID, currency string) (money.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	balance := money.Zero(currency)
+	for _, id := range s.byCustomer[customerID] {
+		transaction := s.transactions[id]
+		if transaction.Amount.Currency() != currency {
+			continue
+		}
+		var err error
+		if balance, err = balance.Add(transaction.Amount); err != nil {
+			return money.Money{}, err
+		}
+	}
+	return balance, nil
+}
@@ -0,0 +1,220 @@
+package credits
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+const createCreditNotesTable = `
+CREATE TABLE IF NOT EXISTS credit_notes (
+	id              VARCHAR(191) NOT NULL PRIMARY KEY,
+	invoice_id      VARCHAR(64)  NOT NULL,
+	subscription_id VARCHAR(64)  NOT NULL,
+	customer_id     VARCHAR(64)  NOT NULL,
+	amount_minor    BIGINT       NOT NULL,
+	currency        CHAR(3)      NOT NULL,
+	reason          VARCHAR(255) NOT NULL,
+	destination     VARCHAR(32)  NOT NULL,
+	refund_id       VARCHAR(64)  NOT NULL,
+	created_at      DATETIME(6)  NOT NULL,
+	seq             BIGINT       NOT NULL AUTO_INCREMENT UNIQUE,
+	INDEX idx_credit_notes_invoice (invoice_id, seq)
+)`
+
+const createBalanceTransactionsTable = `
+CREATE TABLE IF NOT EXISTS balance_transactions (
+	id           VARCHAR(191) NOT NULL PRIMARY KEY,
+	customer_id  VARCHAR(64)  NOT NULL,
+	amount_minor BIGINT       NOT NULL,
+	currency     CHAR(3)      NOT NULL,
+	description  VARCHAR(255) NOT NULL,
+	created_at   DATETIME(6)  NOT NULL,
+	INDEX idx_balance_transactions_customer (customer_id, currency)
+)`
+
+// MySQLStore is a Store backed by MySQL tables
+type MySQLStore struct {
+	db *sql.DB
+}
+
+// NewMySQLStore creates a MySQL-backed credit store and makes sure its tables exist
+func NewMySQLStore(ctx context.Context, db *sql.DB) (*MySQLStore, error) {
+	if _, err := db.ExecContext(ctx, createCreditNotesTable); err != nil {
+		return nil, fmt.Errorf("creating credit_notes table: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, createBalanceTransactionsTable); err != nil {
+		return nil, fmt.Errorf("creating balance_transactions table: %w", err)
+	}
+	return &MySQLStore{db: db}, nil
+}
+
+// IssueCreditNote stores a credit note unless its ID is taken
+func (s *MySQLStore) IssueCreditNote(ctx context.Context, note CreditNote) (CreditNote, bool, error) {
+	if err := note.Validate(); err != nil {
+		return CreditNote{}, false, err
+	}
+
+	// INSERT IGNORE skips notes that were already issued
+	result, err := s.db.ExecContext(ctx,
+		`INSERT IGNORE INTO credit_notes
+			(id, invoice_id, subscription_id, customer_id, amount_minor, currency, reason, destination, refund_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		note.ID,
+		note.InvoiceID,
+		note.SubscriptionID,
+		note.CustomerID,
+		note.Amount.MinorUnits(),
+		note.Amount.Currency(),
+		note.Reason,
+		note.Destination,
+		note.RefundID,
+		note.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return CreditNote{}, false, fmt.Errorf("issuing credit note %s: %w", note.ID, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return CreditNote{}, false, fmt.Errorf("issuing credit note %s: %w", note.ID, err)
+	}
+	if affected == 1 {
+		return note, true, nil
+	}
+
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, invoice_id, subscription_id, customer_id, amount_minor, currency, reason, destination, refund_id, created_at
+		FROM credit_notes WHERE id = ?`,
+		note.ID,
+	)
+	existing, err := scanCreditNote(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CreditNote{}, false, fmt.Errorf("credit note %s was neither issued nor found", note.ID)
+	}
+	if err != nil {
+		return CreditNote{}, false, fmt.Errorf("loading credit note %s: %w", note.ID, err)
+	}
+	return existing, false, nil
+}
+
+// ListCreditNotes returns the credit notes issued for an invoice, oldest first
+func (s *MySQLStore) ListCreditNotes(ctx context.Context, invoiceID string) ([]CreditNote, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, invoice_id, subscription_id, customer_id, amount_minor, currency, reason, destination, refund_id, created_at
+		FROM credit_notes WHERE invoice_id = ? ORDER BY seq`,
+		invoiceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing credit notes for invoice %s: %w", invoiceID, err)
+	}
+	defer rows.Close()
+
+	var notes []CreditNote
+	for rows.Next() {
+		note, err := scanCreditNote(rows)
+		if err != nil {
+			return nil, fmt.Errorf("listing credit notes for invoice %s: %w", invoiceID, err)
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// RecordTransaction stores a balance transaction unless its ID is taken
+func (s *MySQLStore) RecordTransaction(ctx context.Context, transaction BalanceTransaction) (BalanceTransaction, bool, error) {
+	if err := transaction.Validate(); err != nil {
+		return BalanceTransaction{}, false, err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`INSERT IGNORE INTO balance_transactions (id, customer_id, amount_minor, currency, description, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		transaction.ID,
+		transaction.CustomerID,
+		transaction.Amount.MinorUnits(),
+		transaction.Amount.Currency(),
+		transaction.Description,
+		transaction.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return BalanceTransaction{}, false, fmt.Errorf("recording balance transaction %s: %w", transaction.ID, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return BalanceTransaction{}, false, fmt.Errorf("recording balance transaction %s: %w", transaction.ID, err)
+	}
+	if affected == 1 {
+		return transaction, true, nil
+	}
+
+	existing, found, err := s.GetTransaction(ctx, transaction.ID)
+	if err != nil {
+		return BalanceTransaction{}, false, err
+	}
+	if !found {
+		return BalanceTransaction{}, false, fmt.Errorf("balance transaction %s was neither recorded nor found", transaction.ID)
+	}
+	return existing, false, nil
+}
+
+// GetTransaction returns the balance transaction recorded under an ID
+func (s *MySQLStore) GetTransaction(ctx context.Context, id string) (BalanceTransaction, bool, error) {
+	var transaction BalanceTransaction
+	var amountMinor int64
+	var currency string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, customer_id, amount_minor, currency, description, created_at
+		FROM balance_transactions WHERE id = ?`,
+		id,
+	).Scan(&transaction.ID, &transaction.CustomerID, &amountMinor, &currency, &transaction.Description, &transaction.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return BalanceTransaction{}, false, nil
+	}
+	if err != nil {
+		return BalanceTransaction{}, false, fmt.Errorf("loading balance transaction %s: %w", id, err)
+	}
+	transaction.Amount = money.New(amountMinor, currency)
+	return transaction, true, nil
+}
+
+// Balance returns a customer's credit balance in a currency
+func (s *MySQLStore) Balance(ctx context.Context, customerID, currency string) (money.Money, error) {
+	var total int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(amount_minor), 0) FROM balance_transactions WHERE customer_id = ? AND currency = ?`,
+		customerID, currency,
+	).Scan(&total)
+	if err != nil {
+		return money.Money{}, fmt.Errorf("loading balance of customer %s: %w", customerID, err)
+	}
+	return money.New(total, currency), nil
+}
+
+// scanCreditNote reads a credit note from a row selected with the columns used above
+func scanCreditNote(row interface {
+	Scan(dest ...interface{}) error
+}) (CreditNote, error) {
+	var note CreditNote
+	var amountMinor int64
+	var currency string
+	err := row.Scan(
+		&note.ID,
+		&note.InvoiceID,
+		&note.SubscriptionID,
+		&note.CustomerID,
+		&amountMinor,
+		&currency,
+		&note.Reason,
+		&note.Destination,
+		&note.RefundID,
+		&note.CreatedAt,
+	)
+	if err != nil {
+		return CreditNote{}, err
+	}
+	note.Amount = money.New(amountMinor, currency)
+	return note, nil
+}
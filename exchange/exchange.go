@@ -0,0 +1,131 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrRateNotFound is returned when there is no rate between two currencies
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// rateDigits is how many decimal places crossed rates are rounded to, so that the rate recorded on
+// an invoice is exactly the rate its amounts were converted at
+const rateDigits = 10
+
+// Rate converts amounts in From into To: one unit of From is worth Rate units of To.
+// It is recorded on invoices to show how their amounts were converted.
+type Rate struct {
+	From   string
+	To     string
+	Rate   money.Decimal
+	AsOf   time.Time
+	Source string
+}
+
+// IsZero reports whether r is the zero Rate, which stands for no conversion
+func (r Rate) IsZero() bool {
+	return r.From == "" && r.To == ""
+}
+
+// Provider looks up exchange rates
+type Provider interface {
+	Rate(ctx context.Context, from, to string) (Rate, error)
+}
+
+// Table is a snapshot of exchange rates against a base currency, stored in a local file.
+// Rates between two other currencies are crossed through the base.
+type Table struct {
+	Base string `yaml:"base" json:"base"`
+	// AsOf is when the rates were taken
+	AsOf time.Time `yaml:"as_of" json:"as_of"`
+	// Rates are how many units of each currency one unit of Base is worth
+	Rates map[string]money.Decimal `yaml:"rates" json:"rates"`
+
+	source string
+}
+
+// Load reads a rate table from a YAML or JSON file, chosen by the file extension, and validates it
+func Load(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading exchange rates: %w", err)
+	}
+
+	var t Table
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &t)
+	case ".json":
+		err = json.Unmarshal(data, &t)
+	default:
+		return nil, fmt.Errorf("unsupported exchange rates format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing exchange rates %s: %w", path, err)
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid exchange rates %s: %w", path, err)
+	}
+	t.source = filepath.Base(path)
+	return &t, nil
+}
+
+// Validate checks that the base and every rate are well formed
+func (t *Table) Validate() error {
+	if !money.IsCurrencyCode(t.Base) {
+		return fmt.Errorf("invalid base currency %q", t.Base)
+	}
+	if t.AsOf.IsZero() {
+		return errors.New("exchange rates need an as_of time")
+	}
+	for currency, rate := range t.Rates {
+		if !money.IsCurrencyCode(currency) {
+			return fmt.Errorf("invalid currency %q", currency)
+		}
+		if rate.Sign() <= 0 {
+			return fmt.Errorf("rate for %s must be positive", currency)
+		}
+	}
+	return nil
+}
+
+// Rate returns the rate from one currency to another
+func (t *Table) Rate(ctx context.Context, from, to string) (Rate, error) {
+	fromRate, ok := t.baseRate(from)
+	if !ok {
+		return Rate{}, fmt.Errorf("%w: %s to %s", ErrRateNotFound, from, to)
+	}
+	toRate, ok := t.baseRate(to)
+	if !ok {
+		return Rate{}, fmt.Errorf("%w: %s to %s", ErrRateNotFound, from, to)
+	}
+
+	crossed := new(big.Rat).Quo(toRate.Rat(), fromRate.Rat())
+	return Rate{
+		From:   from,
+		To:     to,
+		Rate:   money.MustDecimal(crossed.FloatString(rateDigits)),
+		AsOf:   t.AsOf,
+		Source: t.source,
+	}, nil
+}
+
+// baseRate returns how many units of currency one unit of the base is worth
+func (t *Table) baseRate(currency string) (money.Decimal, bool) {
+	if currency == t.Base {
+		return money.MustDecimal("1"), true
+	}
+	rate, ok := t.Rates[currency]
+	return rate, ok
+}
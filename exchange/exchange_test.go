@@ -0,0 +1,59 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+func TestTableRate(t *testing.T) {
+	table, err := Load("../config/exchange_rates.yaml")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"USD", "EUR", "0.8772"},
+		{"USD", "USD", "1"},
+		{"EUR", "USD", "1.1399908801"},   // 1 / 0.8772
+		{"EUR", "GBP", "0.8426812585"},   // 0.7392 / 0.8772
+		{"GBP", "JPY", "194.6022727273"}, // 143.85 / 0.7392
+	}
+	for _, tt := range tests {
+		rate, err := table.Rate(context.Background(), tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("Rate(%s, %s) failed: %v", tt.from, tt.to, err)
+		}
+		if rate.Rate.String() != tt.want || rate.From != tt.from || rate.To != tt.to {
+			t.Errorf("Rate(%s, %s) = %s %s/%s, want %s", tt.from, tt.to, rate.Rate, rate.To, rate.From, tt.want)
+		}
+		if rate.AsOf != table.AsOf || rate.Source != "exchange_rates.yaml" {
+			t.Errorf("Rate(%s, %s) is from %s as of %s, want the table's snapshot", tt.from, tt.to, rate.Source, rate.AsOf)
+		}
+	}
+
+	if _, err := table.Rate(context.Background(), "USD", "CHF"); !errors.Is(err, ErrRateNotFound) {
+		t.Errorf("Rate(USD, CHF) = %v, want ErrRateNotFound", err)
+	}
+}
+
+func TestTableValidate(t *testing.T) {
+	asOf := time.Date(2025, time.June, 2, 0, 0, 0, 0, time.UTC)
+	tables := map[string]Table{
+		"missing as_of":         {Base: "USD", Rates: map[string]money.Decimal{"EUR": money.MustDecimal("0.9")}},
+		"invalid base":          {Base: "usd", AsOf: asOf},
+		"zero rate":             {Base: "USD", AsOf: asOf, Rates: map[string]money.Decimal{"EUR": money.MustDecimal("0")}},
+		"invalid rate currency": {Base: "USD", AsOf: asOf, Rates: map[string]money.Decimal{"Euro": money.MustDecimal("0.9")}},
+	}
+	for name, table := range tables {
+		if err := table.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package invoices
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Status is where an invoice is in its lifecycle
+type Status string
+
+const (
+	// StatusDraft is an invoice that can still be edited. It has no number and is not owed yet.
+	StatusDraft Status = "draft"
+	// StatusOpen is a finalized invoice that is owed and waiting to be paid
+	StatusOpen Status = "open"
+	// StatusPaid is an invoice that has been paid in full
+	StatusPaid Status = "paid"
+	// StatusVoid is an invoice that was canceled and is no longer owed
+	StatusVoid Status = "void"
+	// StatusUncollectible is an open invoice that is not expected to be paid, such as one whose
+	// dunning ran out. It can still be paid or voided.
+	StatusUncollectible Status = "uncollectible"
+)
+
+// InvalidTransitionErrorType is the application error type used when a transition is rejected
+const InvalidTransitionErrorType = "InvalidInvoiceTransition"
+
+// ErrInvalidTransition is matched by every TransitionError
+var ErrInvalidTransition = errors.New("invalid invoice status transition")
+
+// ErrUnknownStatus is returned when parsing a status that is not part of the lifecycle
+var ErrUnknownStatus = errors.New("unknown invoice status")
+
+// transitions lists the statuses each status may move to. Moving to the current status is always
+// allowed so that retried status updates are harmless. A paid invoice is reopened when its payment
+// is reversed.
+var transitions = map[Status][]Status{
+	StatusDraft:         {StatusOpen, StatusVoid},
+	StatusOpen:          {StatusPaid, StatusVoid, StatusUncollectible},
+	StatusPaid:          {StatusOpen},
+	StatusUncollectible: {StatusPaid, StatusVoid},
+	StatusVoid:          {},
+}
+
+// TransitionError describes a status change the lifecycle does not allow
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move invoice from %s to %s", e.From, e.To)
+}
+
+// Is makes every TransitionError match ErrInvalidTransition
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// ParseStatus checks that s is a known status
+func ParseStatus(s string) (Status, error) {
+	status := Status(s)
+	if _, ok := transitions[status]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownStatus, s)
+	}
+	return status, nil
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Terminal reports whether nothing can follow s
+func (s Status) Terminal() bool {
+	next, ok := transitions[s]
+	return ok && len(next) == 0
+}
+
+// Editable reports whether an invoice in status s can still have its items changed
+func (s Status) Editable() bool {
+	return s == StatusDraft
+}
+
+// CanTransition reports whether an invoice may move from one status to another
+func CanTransition(from, to Status) bool {
+	return Transition(from, to) == nil
+}
+
+// Transition checks a status change, returning a *TransitionError if it is not allowed
+func Transition(from, to Status) error {
+	if !from.Valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownStatus, from)
+	}
+	if !to.Valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownStatus, to)
+	}
+	if from == to {
+		return nil
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
@@ -0,0 +1,107 @@
+package invoices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		allowed  bool
+	}{
+		{StatusDraft, StatusOpen, true},
+		{StatusDraft, StatusVoid, true},
+		{StatusDraft, StatusPaid, false},
+		{StatusDraft, StatusUncollectible, false},
+		{StatusOpen, StatusOpen, true},
+		{StatusOpen, StatusPaid, true},
+		{StatusOpen, StatusVoid, true},
+		{StatusOpen, StatusUncollectible, true},
+		{StatusOpen, StatusDraft, false},
+		{StatusPaid, StatusOpen, true},
+		{StatusPaid, StatusVoid, false},
+		{StatusPaid, StatusUncollectible, false},
+		{StatusUncollectible, StatusPaid, true},
+		{StatusUncollectible, StatusVoid, true},
+		{StatusUncollectible, StatusOpen, false},
+		{StatusVoid, StatusOpen, false},
+		{StatusVoid, StatusVoid, true},
+	}
+
+	for _, tt := range tests {
+		err := Transition(tt.from, tt.to)
+		if tt.allowed {
+			if err != nil {
+				t.Errorf("Transition(%s, %s) = %v, want nil", tt.from, tt.to, err)
+			}
+			continue
+		}
+
+		var transitionErr *TransitionError
+		if !errors.As(err, &transitionErr) {
+			t.Errorf("Transition(%s, %s) = %v, want a *TransitionError", tt.from, tt.to, err)
+			continue
+		}
+		if !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Transition(%s, %s) error does not match ErrInvalidTransition", tt.from, tt.to)
+		}
+	}
+
+	if err := Transition(StatusOpen, "pending"); !errors.Is(err, ErrUnknownStatus) {
+		t.Errorf("Transition to an unknown status = %v, want ErrUnknownStatus", err)
+	}
+	if !StatusDraft.Editable() || StatusOpen.Editable() {
+		t.Error("only drafts should be editable")
+	}
+}
+
+func TestMemorySequenceIsGapless(t *testing.T) {
+	sequence := NewMemorySequence()
+	ctx := context.Background()
+
+	// Invoices finalized concurrently, each allocated twice as a retried activity would
+	var wg sync.WaitGroup
+	numbers := make([]int64, 20)
+	for i := range numbers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			invoiceID := fmt.Sprintf("inv_%d", i)
+			first, err := sequence.Allocate(ctx, "acme", invoiceID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			retried, err := sequence.Allocate(ctx, "acme", invoiceID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if retried != first {
+				t.Errorf("%s was numbered %d, then %d when retried", invoiceID, first, retried)
+			}
+			numbers[i] = first
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	for i, number := range numbers {
+		if number != int64(i+1) {
+			t.Fatalf("numbers = %v, want 1 to %d without gaps", numbers, len(numbers))
+		}
+	}
+
+	// Another merchant has its own sequence
+	if number, err := sequence.Allocate(ctx, "globex", "inv_0"); err != nil || number != 1 {
+		t.Errorf("first number of another merchant = %d, %v, want 1", number, err)
+	}
+	if got := FormatNumber("ACME", 42); got != "ACME-000042" {
+		t.Errorf("FormatNumber = %q, want ACME-000042", got)
+	}
+}
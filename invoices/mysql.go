@@ -0,0 +1,104 @@
+package invoices
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const createInvoiceSequencesTable = `
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+	merchant_id VARCHAR(64) NOT NULL PRIMARY KEY,
+	last_number BIGINT      NOT NULL
+)`
+
+const createInvoiceNumbersTable = `
+CREATE TABLE IF NOT EXISTS invoice_numbers (
+	merchant_id VARCHAR(64) NOT NULL,
+	invoice_id  VARCHAR(64) NOT NULL,
+	number      BIGINT      NOT NULL,
+	PRIMARY KEY (merchant_id, invoice_id),
+	UNIQUE KEY uq_invoice_numbers_number (merchant_id, number)
+)`
+
+// MySQLSequence is a Sequence backed by MySQL tables. A number is allocated in the same
+// transaction that records which invoice it went to, while the merchant's sequence row is locked,
+// so concurrent workers never hand out the same number and a failed allocation uses none up.
+type MySQLSequence struct {
+	db *sql.DB
+}
+
+// NewMySQLSequence creates a MySQL-backed sequence and makes sure its tables exist
+func NewMySQLSequence(ctx context.Context, db *sql.DB) (*MySQLSequence, error) {
+	if _, err := db.ExecContext(ctx, createInvoiceSequencesTable); err != nil {
+		return nil, fmt.Errorf("creating invoice_sequences table: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, createInvoiceNumbersTable); err != nil {
+		return nil, fmt.Errorf("creating invoice_numbers table: %w", err)
+	}
+	return &MySQLSequence{db: db}, nil
+}
+
+// Allocate returns the number of an invoice, allocating the next one the first time
+func (s *MySQLSequence) Allocate(ctx context.Context, merchantID, invoiceID string) (int64, error) {
+	if merchantID == "" || invoiceID == "" {
+		return 0, errors.New("allocating an invoice number needs a merchant and an invoice ID")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("allocating number for invoice %s: %w", invoiceID, err)
+	}
+	defer tx.Rollback()
+
+	// Make sure the merchant has a sequence row, then lock it so allocations take turns
+	_, err = tx.ExecContext(ctx,
+		`INSERT IGNORE INTO invoice_sequences (merchant_id, last_number) VALUES (?, 0)`,
+		merchantID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("allocating number for invoice %s: %w", invoiceID, err)
+	}
+	var last int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT last_number FROM invoice_sequences WHERE merchant_id = ? FOR UPDATE`,
+		merchantID,
+	).Scan(&last)
+	if err != nil {
+		return 0, fmt.Errorf("allocating number for invoice %s: %w", invoiceID, err)
+	}
+
+	// A previous attempt already numbered the invoice
+	var number int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT number FROM invoice_numbers WHERE merchant_id = ? AND invoice_id = ?`,
+		merchantID, invoiceID,
+	).Scan(&number)
+	if err == nil {
+		return number, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("allocating number for invoice %s: %w", invoiceID, err)
+	}
+
+	number = last + 1
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO invoice_numbers (merchant_id, invoice_id, number) VALUES (?, ?, ?)`,
+		merchantID, invoiceID, number,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("allocating number for invoice %s: %w", invoiceID, err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE invoice_sequences SET last_number = ? WHERE merchant_id = ?`,
+		number, merchantID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("allocating number for invoice %s: %w", invoiceID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("allocating number for invoice %s: %w", invoiceID, err)
+	}
+	return number, nil
+}
@@ -0,0 +1,55 @@
+package invoices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Sequence allocates invoice numbers. Each merchant has its own sequence, starting at 1, and
+// numbers are only allocated to invoices being finalized, so drafts that are voided leave no gaps.
+type Sequence interface {
+	// Allocate returns the number of an invoice, allocating the merchant's next number the first
+	// time it is called for the invoice. Calling it again for the same invoice, as a retried
+	// activity does, returns the same number rather than using up another.
+	Allocate(ctx context.Context, merchantID, invoiceID string) (int64, error)
+}
+
+// FormatNumber formats an invoice number for people to read, e.g. INV-000042
+func FormatNumber(prefix string, number int64) string {
+	return fmt.Sprintf("%s-%06d", prefix, number)
+}
+
+// MemorySequence is an in-memory Sequence, useful for local runs and tests
+type MemorySequence struct {
+	mu        sync.Mutex
+	last      map[string]int64 // last number allocated, keyed by merchant ID
+	allocated map[string]int64 // numbers keyed by merchant and invoice ID
+}
+
+// NewMemorySequence creates an in-memory sequence with no numbers allocated
+func NewMemorySequence() *MemorySequence {
+	return &MemorySequence{
+		last:      make(map[string]int64),
+		allocated: make(map[string]int64),
+	}
+}
+
+// Allocate returns the number of an invoice, allocating the next one the first time
+func (s *MemorySequence) Allocate(ctx context.Context, merchantID, invoiceID string) (int64, error) {
+	if merchantID == "" || invoiceID == "" {
+		return 0, errors.New("allocating an invoice number needs a merchant and an invoice ID")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := merchantID + "/" + invoiceID
+	if number, ok := s.allocated[key]; ok {
+		return number, nil
+	}
+	s.last[merchantID]++
+	s.allocated[key] = s.last[merchantID]
+	return s.last[merchantID], nil
+}
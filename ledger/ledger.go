@@ -0,0 +1,310 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// Account is an account in the chart of accounts
+type Account string
+
+// The chart of accounts
+const (
+	// Cash is money collected from customers and not refunded (asset)
+	Cash Account = "cash"
+	// AccountsReceivable is money invoiced to customers and not yet collected (asset)
+	AccountsReceivable Account = "accounts_receivable"
+	// CustomerCredit is credit owed to customers, to be used on future invoices (liability)
+	CustomerCredit Account = "customer_credit"
+	// TaxPayable is tax invoiced to customers, owed to the tax authorities (liability)
+	TaxPayable Account = "tax_payable"
+	// Revenue is the invoiced price of plans and usage, before tax (income)
+	Revenue Account = "revenue"
+	// Refunds is revenue given back through credit notes and refunds (contra-income)
+	Refunds Account = "refunds"
+)
+
+// Accounts lists every account, in the order reports show them
+var Accounts = []Account{Cash, AccountsReceivable, CustomerCredit, TaxPayable, Revenue, Refunds}
+
+// DebitNormal reports whether debits increase the account's balance, as they do for assets and
+// contra-income. Credits increase the others.
+func (a Account) DebitNormal() bool {
+	return a == Cash || a == AccountsReceivable || a == Refunds
+}
+
+// Valid reports whether the account is in the chart of accounts
+func (a Account) Valid() bool {
+	for _, account := range Accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
+// Side is the side of an account a posting is made to
+type Side string
+
+// Posting sides
+const (
+	Debit  Side = "debit"
+	Credit Side = "credit"
+)
+
+// Posting moves an amount into one side of an account. Amounts are always positive.
+type Posting struct {
+	Account Account
+	Side    Side
+	Amount  money.Money
+}
+
+// Dr returns a debit of an amount to an account. A negative amount is a credit of its opposite.
+func Dr(account Account, amount money.Money) Posting {
+	if amount.Sign() < 0 {
+		return Posting{Account: account, Side: Credit, Amount: amount.Neg()}
+	}
+	return Posting{Account: account, Side: Debit, Amount: amount}
+}
+
+// Cr returns a credit of an amount to an account. A negative amount is a debit of its opposite.
+func Cr(account Account, amount money.Money) Posting {
+	if amount.Sign() < 0 {
+		return Posting{Account: account, Side: Debit, Amount: amount.Neg()}
+	}
+	return Posting{Account: account, Side: Credit, Amount: amount}
+}
+
+// Entry is a journal entry: postings whose debits and credits balance, all in one currency.
+// Entries are idempotent on ID: posting a second entry with the same ID returns the first.
+type Entry struct {
+	ID             string
+	Description    string
+	InvoiceID      string // the invoice the money movement belongs to, empty when none does
+	SubscriptionID string
+	CustomerID     string
+	PostedAt       time.Time
+	Postings       []Posting
+}
+
+// NewEntry creates an entry from postings, leaving out postings of zero so that callers can pass
+// optional ones, such as the tax on an untaxed invoice
+func NewEntry(id, description string, postings ...Posting) Entry {
+	entry := Entry{ID: id, Description: description}
+	for _, posting := range postings {
+		if !posting.Amount.IsZero() {
+			entry.Postings = append(entry.Postings, posting)
+		}
+	}
+	return entry
+}
+
+// Currency returns the currency of the entry's postings
+func (e Entry) Currency() string {
+	for _, posting := range e.Postings {
+		if currency := posting.Amount.Currency(); currency != "" {
+			return currency
+		}
+	}
+	return ""
+}
+
+// Reversal returns an entry that undoes this one: the same postings on the opposite sides, for the
+// same invoice, subscription and customer
+func (e Entry) Reversal(id, description string) Entry {
+	reversal := Entry{
+		ID:             id,
+		Description:    description,
+		InvoiceID:      e.InvoiceID,
+		SubscriptionID: e.SubscriptionID,
+		CustomerID:     e.CustomerID,
+	}
+	for _, posting := range e.Postings {
+		if posting.Side == Debit {
+			posting.Side = Credit
+		} else {
+			posting.Side = Debit
+		}
+		reversal.Postings = append(reversal.Postings, posting)
+	}
+	return reversal
+}
+
+// Validate checks that an entry is complete and balanced
+func (e Entry) Validate() error {
+	if e.ID == "" {
+		return errors.New("ledger entry needs an ID")
+	}
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("ledger entry %s needs at least two postings", e.ID)
+	}
+	currency := e.Currency()
+	if !money.IsCurrencyCode(currency) {
+		return fmt.Errorf("ledger entry %s has no valid currency", e.ID)
+	}
+
+	debits, credits := money.Zero(currency), money.Zero(currency)
+	for _, posting := range e.Postings {
+		if !posting.Account.Valid() {
+			return fmt.Errorf("ledger entry %s posts to unknown account %q", e.ID, posting.Account)
+		}
+		if posting.Amount.Currency() != currency {
+			return fmt.Errorf("ledger entry %s mixes %s and %s", e.ID, currency, posting.Amount.Currency())
+		}
+		if posting.Amount.Sign() <= 0 {
+			return fmt.Errorf("ledger entry %s posts %s to %s, amounts must be positive", e.ID, posting.Amount, posting.Account)
+		}
+		var err error
+		switch posting.Side {
+		case Debit:
+			debits, err = debits.Add(posting.Amount)
+		case Credit:
+			credits, err = credits.Add(posting.Amount)
+		default:
+			return fmt.Errorf("ledger entry %s posts to unknown side %q", e.ID, posting.Side)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if cmp, _ := debits.Cmp(credits); cmp != 0 {
+		return fmt.Errorf("ledger entry %s does not balance: debits %s, credits %s", e.ID, debits, credits)
+	}
+	return nil
+}
+
+// Totals are the debits and credits posted to an account in one currency
+type Totals struct {
+	Debits  money.Money
+	Credits money.Money
+}
+
+// Balance returns the account's balance: debits less credits for debit-normal accounts, credits
+// less debits for the others
+func (t Totals) Balance(account Account) money.Money {
+	balance, _ := t.Debits.Sub(t.Credits)
+	if !account.DebitNormal() {
+		return balance.Neg()
+	}
+	return balance
+}
+
+// add adds a posting to the totals
+func (t *Totals) add(posting Posting) error {
+	var err error
+	if posting.Side == Debit {
+		t.Debits, err = t.Debits.Add(posting.Amount)
+	} else {
+		t.Credits, err = t.Credits.Add(posting.Amount)
+	}
+	return err
+}
+
+// Sum totals the postings of entries per account, in one currency. Postings in other currencies
+// are left out.
+func Sum(entries []Entry, currency string) map[Account]Totals {
+	totals := make(map[Account]Totals)
+	for _, entry := range entries {
+		for _, posting := range entry.Postings {
+			if posting.Amount.Currency() != currency {
+				continue
+			}
+			t, ok := totals[posting.Account]
+			if !ok {
+				t = Totals{Debits: money.Zero(currency), Credits: money.Zero(currency)}
+			}
+			// Every posting has the currency, so this cannot fail
+			_ = t.add(posting)
+			totals[posting.Account] = t
+		}
+	}
+	return totals
+}
+
+// Store keeps the journal
+type Store interface {
+	// Post stores an entry unless its ID is taken. It returns the entry stored under the ID
+	// and reports false if that is an earlier entry rather than this one.
+	Post(ctx context.Context, entry Entry) (Entry, bool, error)
+	// GetEntry returns the entry posted under an ID, reporting false if none was
+	GetEntry(ctx context.Context, id string) (Entry, bool, error)
+	// ListByInvoice returns the entries posted for an invoice, oldest first
+	ListByInvoice(ctx context.Context, invoiceID string) ([]Entry, error)
+	// Totals returns the debits and credits posted to every account in a currency
+	Totals(ctx context.Context, currency string) (map[Account]Totals, error)
+}
+
+// MemoryStore is an in-memory Store, useful for local runs and tests
+type MemoryStore struct {
+	mu        sync.RWMutex
+	entries   map[string]Entry
+	order     []string            // entry IDs in posting order
+	byInvoice map[string][]string // entry IDs keyed by invoice ID, in posting order
+}
+
+// NewMemoryStore creates an empty in-memory journal
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[string]Entry),
+		byInvoice: make(map[string][]string),
+	}
+}
+
+// Post stores an entry unless its ID is taken
+func (s *MemoryStore) Post(ctx context.Context, entry Entry) (Entry, bool, error) {
+	if err := entry.Validate(); err != nil {
+		return Entry{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.entries[entry.ID]; ok {
+		return existing, false, nil
+	}
+	s.entries[entry.ID] = entry
+	s.order = append(s.order, entry.ID)
+	if entry.InvoiceID != "" {
+		s.byInvoice[entry.InvoiceID] = append(s.byInvoice[entry.InvoiceID], entry.ID)
+	}
+	return entry, true, nil
+}
+
+// GetEntry returns the entry posted under an ID
+func (s *MemoryStore) GetEntry(ctx context.Context, id string) (Entry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[id]
+	return entry, ok, nil
+}
+
+// ListByInvoice returns the entries posted for an invoice, oldest first
+func (s *MemoryStore) ListByInvoice(ctx context.Context, invoiceID string) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.byInvoice[invoiceID]
+	entries := make([]Entry, len(ids))
+	for i, id := range ids {
+		entries[i] = s.entries[id]
+	}
+	return entries, nil
+}
+
+// Totals returns the debits and credits posted to every account in a currency
+func (s *MemoryStore) Totals(ctx context.Context, currency string) (map[Account]Totals, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]Entry, len(s.order))
+	for i, id := range s.order {
+		entries[i] = s.entries[id]
+	}
+	return Sum(entries, currency), nil
+}
@@ -0,0 +1,109 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+func usd(amount string) money.Money {
+	return money.MustParse(amount, "USD")
+}
+
+func TestEntryValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry Entry
+		valid bool
+	}{
+		{
+			name:  "balanced",
+			entry: NewEntry("e1", "", Dr(AccountsReceivable, usd("55.00")), Cr(Revenue, usd("50.00")), Cr(TaxPayable, usd("5.00"))),
+			valid: true,
+		},
+		{
+			name:  "negative amounts switch sides",
+			entry: NewEntry("e2", "", Dr(Cash, usd("-10.00")), Cr(Refunds, usd("-10.00"))),
+			valid: true,
+		},
+		{
+			name:  "zero postings are left out",
+			entry: NewEntry("e3", "", Dr(Cash, usd("10.00")), Cr(AccountsReceivable, usd("10.00")), Cr(TaxPayable, usd("0"))),
+			valid: true,
+		},
+		{
+			name:  "unbalanced",
+			entry: NewEntry("e4", "", Dr(Cash, usd("10.00")), Cr(AccountsReceivable, usd("9.99"))),
+		},
+		{
+			name:  "mixed currencies",
+			entry: NewEntry("e5", "", Dr(Cash, usd("10.00")), Cr(AccountsReceivable, money.MustParse("10.00", "EUR"))),
+		},
+		{
+			name:  "single posting",
+			entry: NewEntry("e6", "", Dr(Cash, usd("10.00")), Cr(AccountsReceivable, usd("0"))),
+		},
+		{
+			name:  "unknown account",
+			entry: NewEntry("e7", "", Dr("petty_cash", usd("10.00")), Cr(AccountsReceivable, usd("10.00"))),
+		},
+		{
+			name:  "missing ID",
+			entry: NewEntry("", "", Dr(Cash, usd("10.00")), Cr(AccountsReceivable, usd("10.00"))),
+		},
+	}
+	for _, tt := range tests {
+		err := tt.entry.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: Validate failed: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: Validate succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestMemoryStorePostsOnceAndTotals(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	invoice := NewEntry("invoice/inv_1", "Invoice inv_1",
+		Dr(AccountsReceivable, usd("55.00")), Cr(Revenue, usd("50.00")), Cr(TaxPayable, usd("5.00")))
+	invoice.InvoiceID = "inv_1"
+	payment := NewEntry("payment/pay_inv_1", "Payment py_1", Dr(Cash, usd("55.00")), Cr(AccountsReceivable, usd("55.00")))
+	payment.InvoiceID = "inv_1"
+
+	for _, entry := range []Entry{invoice, payment, invoice} {
+		if _, _, err := store.Post(ctx, entry); err != nil {
+			t.Fatalf("Post(%s) failed: %v", entry.ID, err)
+		}
+	}
+	if _, created, _ := store.Post(ctx, payment); created {
+		t.Error("posting an entry twice created it again")
+	}
+	if _, _, err := store.Post(ctx, NewEntry("bad", "", Dr(Cash, usd("1.00")), Cr(Revenue, usd("2.00")))); err == nil {
+		t.Error("an unbalanced entry was posted")
+	}
+
+	entries, err := store.ListByInvoice(ctx, "inv_1")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ListByInvoice = %d entries, %v, want 2", len(entries), err)
+	}
+
+	totals, err := store.Totals(ctx, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Account]money.Money{
+		Cash:               usd("55.00"),
+		AccountsReceivable: usd("0"),
+		Revenue:            usd("50.00"),
+		TaxPayable:         usd("5.00"),
+	}
+	for account, balance := range want {
+		if got := totals[account].Balance(account); got != balance {
+			t.Errorf("balance of %s = %s, want %s", account, got, balance)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+const createLedgerEntriesTable = `
+CREATE TABLE IF NOT EXISTS ledger_entries (
+	id              VARCHAR(191) NOT NULL PRIMARY KEY,
+	description     VARCHAR(255) NOT NULL,
+	invoice_id      VARCHAR(64)  NOT NULL,
+	subscription_id VARCHAR(64)  NOT NULL,
+	customer_id     VARCHAR(64)  NOT NULL,
+	posted_at       DATETIME(6)  NOT NULL,
+	seq             BIGINT       NOT NULL AUTO_INCREMENT UNIQUE,
+	INDEX idx_ledger_entries_invoice (invoice_id, seq)
+)`
+
+const createLedgerPostingsTable = `
+CREATE TABLE IF NOT EXISTS ledger_postings (
+	entry_id     VARCHAR(191) NOT NULL,
+	line         INT          NOT NULL,
+	account      VARCHAR(64)  NOT NULL,
+	side         VARCHAR(8)   NOT NULL,
+	amount_minor BIGINT       NOT NULL,
+	currency     CHAR(3)      NOT NULL,
+	PRIMARY KEY (entry_id, line),
+	INDEX idx_ledger_postings_account (currency, account)
+)`
+
+// MySQLStore is a Store backed by MySQL tables. An entry and its postings are written in one
+// transaction, so the journal never holds half an entry.
+type MySQLStore struct {
+	db *sql.DB
+}
+
+// NewMySQLStore creates a MySQL-backed journal and makes sure its tables exist
+func NewMySQLStore(ctx context.Context, db *sql.DB) (*MySQLStore, error) {
+	if _, err := db.ExecContext(ctx, createLedgerEntriesTable); err != nil {
+		return nil, fmt.Errorf("creating ledger_entries table: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, createLedgerPostingsTable); err != nil {
+		return nil, fmt.Errorf("creating ledger_postings table: %w", err)
+	}
+	return &MySQLStore{db: db}, nil
+}
+
+// Post stores an entry unless its ID is taken
+func (s *MySQLStore) Post(ctx context.Context, entry Entry) (Entry, bool, error) {
+	if err := entry.Validate(); err != nil {
+		return Entry{}, false, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("posting ledger entry %s: %w", entry.ID, err)
+	}
+	defer tx.Rollback()
+
+	// INSERT IGNORE skips entries that were already posted
+	result, err := tx.ExecContext(ctx,
+		`INSERT IGNORE INTO ledger_entries (id, description, invoice_id, subscription_id, customer_id, posted_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.ID,
+		entry.Description,
+		entry.InvoiceID,
+		entry.SubscriptionID,
+		entry.CustomerID,
+		entry.PostedAt.UTC(),
+	)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("posting ledger entry %s: %w", entry.ID, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("posting ledger entry %s: %w", entry.ID, err)
+	}
+	if affected == 0 {
+		_ = tx.Rollback()
+		existing, found, err := s.GetEntry(ctx, entry.ID)
+		if err != nil {
+			return Entry{}, false, err
+		}
+		if !found {
+			return Entry{}, false, fmt.Errorf("ledger entry %s was neither posted nor found", entry.ID)
+		}
+		return existing, false, nil
+	}
+
+	for line, posting := range entry.Postings {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO ledger_postings (entry_id, line, account, side, amount_minor, currency)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			entry.ID,
+			line,
+			string(posting.Account),
+			string(posting.Side),
+			posting.Amount.MinorUnits(),
+			posting.Amount.Currency(),
+		)
+		if err != nil {
+			return Entry{}, false, fmt.Errorf("posting ledger entry %s: %w", entry.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Entry{}, false, fmt.Errorf("posting ledger entry %s: %w", entry.ID, err)
+	}
+	return entry, true, nil
+}
+
+// GetEntry returns the entry posted under an ID
+func (s *MySQLStore) GetEntry(ctx context.Context, id string) (Entry, bool, error) {
+	var entry Entry
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, description, invoice_id, subscription_id, customer_id, posted_at
+		FROM ledger_entries WHERE id = ?`,
+		id,
+	).Scan(&entry.ID, &entry.Description, &entry.InvoiceID, &entry.SubscriptionID, &entry.CustomerID, &entry.PostedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("loading ledger entry %s: %w", id, err)
+	}
+	if entry.Postings, err = s.postings(ctx, id); err != nil {
+		return Entry{}, false, err
+	}
+	return entry, true, nil
+}
+
+// ListByInvoice returns the entries posted for an invoice, oldest first
+func (s *MySQLStore) ListByInvoice(ctx context.Context, invoiceID string) ([]Entry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, description, invoice_id, subscription_id, customer_id, posted_at
+		FROM ledger_entries WHERE invoice_id = ? ORDER BY seq`,
+		invoiceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing ledger entries for invoice %s: %w", invoiceID, err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		err := rows.Scan(&entry.ID, &entry.Description, &entry.InvoiceID, &entry.SubscriptionID, &entry.CustomerID, &entry.PostedAt)
+		if err != nil {
+			return nil, fmt.Errorf("listing ledger entries for invoice %s: %w", invoiceID, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing ledger entries for invoice %s: %w", invoiceID, err)
+	}
+
+	for i := range entries {
+		if entries[i].Postings, err = s.postings(ctx, entries[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Totals returns the debits and credits posted to every account in a currency
+func (s *MySQLStore) Totals(ctx context.Context, currency string) (map[Account]Totals, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT account,
+			COALESCE(SUM(CASE WHEN side = 'debit' THEN amount_minor END), 0),
+			COALESCE(SUM(CASE WHEN side = 'credit' THEN amount_minor END), 0)
+		FROM ledger_postings WHERE currency = ? GROUP BY account`,
+		currency,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("totaling %s ledger accounts: %w", currency, err)
+	}
+	defer rows.Close()
+
+	totals := make(map[Account]Totals)
+	for rows.Next() {
+		var account string
+		var debits, credits int64
+		if err := rows.Scan(&account, &debits, &credits); err != nil {
+			return nil, fmt.Errorf("totaling %s ledger accounts: %w", currency, err)
+		}
+		totals[Account(account)] = Totals{Debits: money.New(debits, currency), Credits: money.New(credits, currency)}
+	}
+	return totals, rows.Err()
+}
+
+// postings loads the postings of an entry in the order they were made
+func (s *MySQLStore) postings(ctx context.Context, entryID string) ([]Posting, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT account, side, amount_minor, currency FROM ledger_postings WHERE entry_id = ? ORDER BY line`,
+		entryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("loading postings of ledger entry %s: %w", entryID, err)
+	}
+	defer rows.Close()
+
+	var postings []Posting
+	for rows.Next() {
+		var account, side, currency string
+		var amountMinor int64
+		if err := rows.Scan(&account, &side, &amountMinor, &currency); err != nil {
+			return nil, fmt.Errorf("loading postings of ledger entry %s: %w", entryID, err)
+		}
+		postings = append(postings, Posting{Account: Account(account), Side: Side(side), Amount: money.New(amountMinor, currency)})
+	}
+	return postings, rows.Err()
+}
@@ -0,0 +1,107 @@
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Status is where a subscription is in its lifecycle
+type Status string
+
+const (
+	// StatusTrialing is a subscription in its free trial, not charged yet
+	StatusTrialing Status = "trialing"
+	// StatusActive is a paid-up subscription that is billed every cycle
+	StatusActive Status = "active"
+	// StatusPastDue is a subscription whose last payment failed and is being retried
+	StatusPastDue Status = "past_due"
+	// StatusUnpaid is a past due subscription that has failed several retries
+	StatusUnpaid Status = "unpaid"
+	// StatusPaused is a subscription whose billing cycles are skipped until it resumes
+	StatusPaused Status = "paused"
+	// StatusCanceled is a subscription that has ended and is no longer billed
+	StatusCanceled Status = "canceled"
+	// StatusExpired is a trial that ended without a successful first payment
+	StatusExpired Status = "expired"
+)
+
+// InvalidTransitionErrorType is the application error type used when a transition is rejected
+const InvalidTransitionErrorType = "InvalidStatusTransition"
+
+// ErrInvalidTransition is matched by every TransitionError
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// ErrUnknownStatus is returned when parsing a status that is not part of the lifecycle
+var ErrUnknownStatus = errors.New("unknown subscription status")
+
+// transitions lists the statuses each status may move to. Moving to the current status is always
+// allowed so that retried status updates are harmless.
+var transitions = map[Status][]Status{
+	StatusTrialing: {StatusActive, StatusCanceled, StatusExpired},
+	StatusActive:   {StatusPastDue, StatusPaused, StatusCanceled},
+	StatusPastDue:  {StatusActive, StatusUnpaid, StatusCanceled},
+	StatusUnpaid:   {StatusActive, StatusCanceled},
+	StatusPaused:   {StatusActive, StatusCanceled},
+	StatusCanceled: {},
+	StatusExpired:  {},
+}
+
+// TransitionError describes a status change the lifecycle does not allow
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move a %s subscription to %s", e.From, e.To)
+}
+
+// Is makes every TransitionError match ErrInvalidTransition
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// ParseStatus checks that s is a known status
+func ParseStatus(s string) (Status, error) {
+	status := Status(s)
+	if _, ok := transitions[status]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownStatus, s)
+	}
+	return status, nil
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Terminal reports whether nothing can follow s
+func (s Status) Terminal() bool {
+	next, ok := transitions[s]
+	return ok && len(next) == 0
+}
+
+// CanTransition reports whether a subscription may move from one status to another
+func CanTransition(from, to Status) bool {
+	return Transition(from, to) == nil
+}
+
+// Transition checks a status change, returning a *TransitionError if it is not allowed
+func Transition(from, to Status) error {
+	if !from.Valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownStatus, from)
+	}
+	if !to.Valid() {
+		return fmt.Errorf("%w: %q", ErrUnknownStatus, to)
+	}
+	if from == to {
+		return nil
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
@@ -0,0 +1,83 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		allowed  bool
+	}{
+		{StatusTrialing, StatusActive, true},
+		{StatusTrialing, StatusExpired, true},
+		{StatusTrialing, StatusPaused, false},
+		{StatusActive, StatusActive, true},
+		{StatusActive, StatusPaused, true},
+		{StatusActive, StatusPastDue, true},
+		{StatusActive, StatusTrialing, false},
+		{StatusPastDue, StatusUnpaid, true},
+		{StatusPastDue, StatusPaused, false},
+		{StatusUnpaid, StatusActive, true},
+		{StatusPaused, StatusActive, true},
+		{StatusPaused, StatusPastDue, false},
+		{StatusCanceled, StatusActive, false},
+		{StatusCanceled, StatusCanceled, true},
+		{StatusExpired, StatusActive, false},
+	}
+
+	for _, tt := range tests {
+		err := Transition(tt.from, tt.to)
+		if tt.allowed {
+			if err != nil {
+				t.Errorf("Transition(%s, %s) = %v, want nil", tt.from, tt.to, err)
+			}
+			continue
+		}
+
+		var transitionErr *TransitionError
+		if !errors.As(err, &transitionErr) {
+			t.Errorf("Transition(%s, %s) = %v, want a *TransitionError", tt.from, tt.to, err)
+			continue
+		}
+		if transitionErr.From != tt.from || transitionErr.To != tt.to {
+			t.Errorf("Transition(%s, %s) error is %s to %s", tt.from, tt.to, transitionErr.From, transitionErr.To)
+		}
+		if !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Transition(%s, %s) error does not match ErrInvalidTransition", tt.from, tt.to)
+		}
+	}
+}
+
+func TestTransitionUnknownStatus(t *testing.T) {
+	if err := Transition(StatusActive, "frozen"); !errors.Is(err, ErrUnknownStatus) {
+		t.Errorf("Transition to an unknown status = %v, want ErrUnknownStatus", err)
+	}
+	if err := Transition("", StatusActive); !errors.Is(err, ErrUnknownStatus) {
+		t.Errorf("Transition from an empty status = %v, want ErrUnknownStatus", err)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	status, err := ParseStatus("past_due")
+	if err != nil || status != StatusPastDue {
+		t.Errorf(`ParseStatus("past_due") = %q, %v`, status, err)
+	}
+	if _, err := ParseStatus("Active"); !errors.Is(err, ErrUnknownStatus) {
+		t.Errorf(`ParseStatus("Active") = %v, want ErrUnknownStatus`, err)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	for _, status := range []Status{StatusCanceled, StatusExpired} {
+		if !status.Terminal() {
+			t.Errorf("%s should be terminal", status)
+		}
+	}
+	for _, status := range []Status{StatusTrialing, StatusActive, StatusPastDue, StatusUnpaid, StatusPaused} {
+		if status.Terminal() {
+			t.Errorf("%s should not be terminal", status)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Decimal is an exact decimal number used for unit prices and rates that can be
+// finer than a currency's minor unit, e.g. 0.002 USD per API call or a 7% tax rate.
+// The zero value is 0.
+type Decimal struct {
+	r *big.Rat
+}
+
+// ParseDecimal parses a decimal string such as "0.002" or "-12.5"
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, "/eE") {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{r: r}, nil
+}
+
+// MustDecimal is like ParseDecimal but panics on invalid input. Intended for constants.
+func MustDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// DecimalFromRat wraps a rational number
+func DecimalFromRat(r *big.Rat) Decimal {
+	return Decimal{r: new(big.Rat).Set(r)}
+}
+
+// Rat returns the value as a new big.Rat
+func (d Decimal) Rat() *big.Rat {
+	if d.r == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Set(d.r)
+}
+
+// Sign returns -1, 0 or +1
+func (d Decimal) Sign() int {
+	if d.r == nil {
+		return 0
+	}
+	return d.r.Sign()
+}
+
+// IsZero reports whether the value is zero
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Mul multiplies two decimals exactly
+func (d Decimal) Mul(other Decimal) Decimal {
+	return Decimal{r: new(big.Rat).Mul(d.Rat(), other.Rat())}
+}
+
+// MulInt multiplies the decimal by an integer exactly
+func (d Decimal) MulInt(n int64) Decimal {
+	return Decimal{r: new(big.Rat).Mul(d.Rat(), new(big.Rat).SetInt64(n))}
+}
+
+// Add adds two decimals exactly
+func (d Decimal) Add(other Decimal) Decimal {
+	return Decimal{r: new(big.Rat).Add(d.Rat(), other.Rat())}
+}
+
+// String formats the decimal without trailing zeros
+func (d Decimal) String() string {
+	r := d.Rat()
+	if r.IsInt() {
+		return r.Num().String()
+	}
+
+	// Exact decimals have a denominator of the form 2^a * 5^b, which needs at most
+	// max(a, b) digits; fall back to a generous precision for anything else
+	s := r.FloatString(decimalDigits(r.Denom()))
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// decimalDigits returns how many fractional digits are needed to print 1/denom exactly
+func decimalDigits(denom *big.Int) int {
+	d := new(big.Int).Set(denom)
+	two, five := big.NewInt(2), big.NewInt(5)
+	var twos, fives int
+	for new(big.Int).Mod(d, two).Sign() == 0 {
+		d.Div(d, two)
+		twos++
+	}
+	for new(big.Int).Mod(d, five).Sign() == 0 {
+		d.Div(d, five)
+		fives++
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return 18
+	}
+	if twos > fives {
+		return twos
+	}
+	return fives
+}
+
+// MarshalJSON encodes the decimal as a string to avoid float rounding
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts both strings and plain JSON numbers
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid decimal %s", data)
+		}
+		s = n.String()
+	}
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalYAML encodes the decimal as a string
+func (d Decimal) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// UnmarshalYAML reads the scalar's literal text so numbers like 0.1 stay exact
+func (d *Decimal) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: decimal must be a scalar", node.Line)
+	}
+	parsed, err := ParseDecimal(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = parsed
+	return nil
+}
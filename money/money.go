@@ -0,0 +1,400 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ErrCurrencyMismatch is returned when combining amounts in different currencies
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// ErrOverflow is returned when the result of arithmetic on amounts does not fit in an int64 of
+// minor units
+var ErrOverflow = errors.New("amount out of range")
+
+// RoundingMode decides how amounts that fall between two minor units are rounded
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds to the nearest minor unit, ties away from zero
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds to the nearest minor unit, ties to the even unit (banker's rounding)
+	RoundHalfEven
+	// RoundDown truncates towards zero
+	RoundDown
+	// RoundUp rounds away from zero
+	RoundUp
+)
+
+// Money is an exact amount of a currency, stored as an integer number of minor units
+// (cents for USD, yen for JPY). The zero value is zero with no currency and can be
+// added to any amount.
+type Money struct {
+	minor    int64
+	currency string
+}
+
+// New creates an amount from minor units, e.g. New(4999, "USD") is 49.99 USD
+func New(minor int64, currency string) Money {
+	return Money{minor: minor, currency: currency}
+}
+
+// Zero returns a zero amount in the given currency
+func Zero(currency string) Money {
+	return Money{currency: currency}
+}
+
+// Parse reads a decimal amount in major units, e.g. Parse("49.99", "USD").
+// It fails if the amount has more digits than the currency's minor unit.
+func Parse(amount string, currency string) (Money, error) {
+	if !IsCurrencyCode(currency) {
+		return Money{}, fmt.Errorf("invalid currency %q", currency)
+	}
+	d, err := ParseDecimal(amount)
+	if err != nil {
+		return Money{}, err
+	}
+
+	minor := new(big.Rat).Mul(d.Rat(), new(big.Rat).SetInt64(pow10(Exponent(currency))))
+	if !minor.IsInt() {
+		return Money{}, fmt.Errorf("amount %s has more precision than %s allows", amount, currency)
+	}
+	if !minor.Num().IsInt64() {
+		return Money{}, fmt.Errorf("amount %s is out of range", amount)
+	}
+	return Money{minor: minor.Num().Int64(), currency: currency}, nil
+}
+
+// MustParse is like Parse but panics on invalid input. Intended for constants.
+func MustParse(amount string, currency string) Money {
+	m, err := Parse(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// FromDecimal converts a decimal amount in major units, rounding to the currency's minor unit
+func FromDecimal(amount Decimal, currency string, mode RoundingMode) Money {
+	minor := new(big.Rat).Mul(amount.Rat(), new(big.Rat).SetInt64(pow10(Exponent(currency))))
+	return Money{minor: round(minor, mode), currency: currency}
+}
+
+// MinorUnits returns the amount in minor units
+func (m Money) MinorUnits() int64 {
+	return m.minor
+}
+
+// Currency returns the ISO-4217 currency code
+func (m Money) Currency() string {
+	return m.currency
+}
+
+// Decimal returns the amount in major units
+func (m Money) Decimal() Decimal {
+	return Decimal{r: new(big.Rat).SetFrac64(m.minor, pow10(Exponent(m.currency)))}
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.minor == 0
+}
+
+// Sign returns -1, 0 or +1
+func (m Money) Sign() int {
+	switch {
+	case m.minor < 0:
+		return -1
+	case m.minor > 0:
+		return 1
+	}
+	return 0
+}
+
+// Neg returns the negated amount
+func (m Money) Neg() Money {
+	return Money{minor: -m.minor, currency: m.currency}
+}
+
+// Abs returns the absolute amount
+func (m Money) Abs() Money {
+	if m.minor < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+// sameCurrency returns the currency two amounts share. A zero amount without
+// a currency adopts the other amount's currency.
+func (m Money) sameCurrency(other Money) (string, error) {
+	switch {
+	case m.currency == other.currency:
+		return m.currency, nil
+	case m.currency == "" && m.minor == 0:
+		return other.currency, nil
+	case other.currency == "" && other.minor == 0:
+		return m.currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency, other.currency)
+}
+
+// Add returns m + other
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.sameCurrency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	sum := m.minor + other.minor
+	if (other.minor > 0 && sum < m.minor) || (other.minor < 0 && sum > m.minor) {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrOverflow, m, other)
+	}
+	return Money{minor: sum, currency: currency}, nil
+}
+
+// Sub returns m - other
+func (m Money) Sub(other Money) (Money, error) {
+	currency, err := m.sameCurrency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	difference := m.minor - other.minor
+	if (other.minor > 0 && difference > m.minor) || (other.minor < 0 && difference < m.minor) {
+		return Money{}, fmt.Errorf("%w: %s - %s", ErrOverflow, m, other)
+	}
+	return Money{minor: difference, currency: currency}, nil
+}
+
+// Cmp compares two amounts and returns -1, 0 or +1
+func (m Money) Cmp(other Money) (int, error) {
+	if _, err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.minor < other.minor:
+		return -1, nil
+	case m.minor > other.minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Mul multiplies the amount by an integer quantity
+func (m Money) Mul(quantity int64) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(quantity))
+	if !product.IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s * %d", ErrOverflow, m, quantity)
+	}
+	return Money{minor: product.Int64(), currency: m.currency}, nil
+}
+
+// MulDecimal multiplies the amount by a decimal factor, such as a tax rate, and rounds the result
+func (m Money) MulDecimal(factor Decimal, mode RoundingMode) Money {
+	minor := new(big.Rat).Mul(new(big.Rat).SetInt64(m.minor), factor.Rat())
+	return Money{minor: round(minor, mode), currency: m.currency}
+}
+
+// MulRatio multiplies the amount by num/den and rounds the result, e.g. for proration
+func (m Money) MulRatio(num, den int64, mode RoundingMode) Money {
+	if den == 0 {
+		panic("money: ratio with zero denominator")
+	}
+	minor := new(big.Rat).Mul(new(big.Rat).SetInt64(m.minor), big.NewRat(num, den))
+	return Money{minor: round(minor, mode), currency: m.currency}
+}
+
+// Allocate splits the amount in proportion to the given ratios without losing
+// minor units. Remainders go one unit at a time to the earliest shares.
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	if len(ratios) == 0 {
+		return nil, errors.New("allocate needs at least one ratio")
+	}
+
+	var total int64
+	for _, ratio := range ratios {
+		if ratio < 0 {
+			return nil, errors.New("allocation ratios must not be negative")
+		}
+		total += ratio
+	}
+	if total == 0 {
+		return nil, errors.New("allocation ratios must not all be zero")
+	}
+
+	shares := make([]Money, len(ratios))
+	remainder := m.minor
+	for i, ratio := range ratios {
+		share := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(ratio))
+		share.Quo(share, big.NewInt(total))
+		shares[i] = Money{minor: share.Int64(), currency: m.currency}
+		remainder -= share.Int64()
+	}
+
+	// Hand out what integer division left over, skipping zero-ratio shares
+	step := int64(1)
+	if remainder < 0 {
+		step = -1
+	}
+	for i := 0; remainder != 0; i = (i + 1) % len(shares) {
+		if ratios[i] == 0 {
+			continue
+		}
+		shares[i].minor += step
+		remainder -= step
+	}
+	return shares, nil
+}
+
+// Split divides the amount into n equal shares without losing minor units
+func (m Money) Split(n int) ([]Money, error) {
+	if n <= 0 {
+		return nil, errors.New("split needs a positive number of shares")
+	}
+	ratios := make([]int64, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return m.Allocate(ratios...)
+}
+
+// Sum adds up amounts that all share the given currency
+func Sum(currency string, amounts ...Money) (Money, error) {
+	total := Zero(currency)
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// String formats the amount as "49.99 USD"
+func (m Money) String() string {
+	if m.currency == "" {
+		return m.Amount()
+	}
+	return m.Amount() + " " + m.currency
+}
+
+// Amount formats the amount in major units with exactly the currency's number of digits
+func (m Money) Amount() string {
+	exponent := Exponent(m.currency)
+	return new(big.Rat).SetFrac64(m.minor, pow10(exponent)).FloatString(exponent)
+}
+
+// jsonMoney is the wire format of Money: a decimal string plus the currency code
+type jsonMoney struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// MarshalJSON encodes the amount as {"amount":"49.99","currency":"USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	amount, _ := json.Marshal(m.Amount())
+	return json.Marshal(jsonMoney{Amount: amount, Currency: m.currency})
+}
+
+// UnmarshalJSON decodes the object form and also accepts bare numbers, which is how
+// amounts were encoded while they were float64. Bare numbers are read as USD. Like Parse, it
+// fails on an amount with more digits than the currency's minor unit, except that a bare number
+// may carry the noise of a float64 holding a whole number of cents.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "null" {
+		*m = Money{}
+		return nil
+	}
+
+	if !strings.HasPrefix(trimmed, "{") {
+		var legacy Decimal
+		if err := legacy.UnmarshalJSON(data); err != nil {
+			return err
+		}
+		amount, err := fromJSON(legacy, "USD", true)
+		if err != nil {
+			return err
+		}
+		*m = amount
+		return nil
+	}
+
+	var wire jsonMoney
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	var amount Decimal
+	if len(wire.Amount) > 0 {
+		if err := amount.UnmarshalJSON(wire.Amount); err != nil {
+			return err
+		}
+	}
+	decoded, err := fromJSON(amount, wire.Currency, false)
+	if err != nil {
+		return err
+	}
+	*m = decoded
+	return nil
+}
+
+// fromJSON converts a decoded amount in major units to minor units. A legacy float64 amount is
+// rounded to the minor unit when that is the same float64, as it is for 19.989999999999998.
+func fromJSON(amount Decimal, currency string, legacy bool) (Money, error) {
+	exponent := Exponent(currency)
+	minor := new(big.Rat).Mul(amount.Rat(), new(big.Rat).SetInt64(pow10(exponent)))
+	if !new(big.Int).Quo(minor.Num(), minor.Denom()).IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s", ErrOverflow, amount)
+	}
+	if !minor.IsInt() {
+		rounded := new(big.Rat).SetInt64(round(minor, RoundHalfUp))
+		exact, _ := amount.Rat().Float64()
+		nearest, _ := new(big.Rat).Quo(rounded, new(big.Rat).SetInt64(pow10(exponent))).Float64()
+		if !legacy || exact != nearest {
+			return Money{}, fmt.Errorf("amount %s has more precision than %s allows", amount, currency)
+		}
+		minor = rounded
+	}
+	if !minor.Num().IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s", ErrOverflow, amount)
+	}
+	return Money{minor: minor.Num().Int64(), currency: currency}, nil
+}
+
+// round converts a rational number of minor units to an integer using the given mode
+func round(r *big.Rat, mode RoundingMode) int64 {
+	num, den := r.Num(), r.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() == 0 {
+		return quo.Int64()
+	}
+
+	// Direction to move away from zero
+	away := int64(1)
+	if num.Sign() < 0 {
+		away = -1
+	}
+
+	// Compare twice the remainder with the denominator to find ties
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+	half := twice.Cmp(den)
+
+	switch mode {
+	case RoundDown:
+		return quo.Int64()
+	case RoundUp:
+		return quo.Int64() + away
+	case RoundHalfEven:
+		if half > 0 || (half == 0 && quo.Bit(0) == 1) {
+			return quo.Int64() + away
+		}
+		return quo.Int64()
+	default:
+		if half >= 0 {
+			return quo.Int64() + away
+		}
+		return quo.Int64()
+	}
+}
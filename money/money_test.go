@@ -0,0 +1,209 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestRoundingModes(t *testing.T) {
+	tests := []struct {
+		amount                     string
+		halfUp, halfEven, down, up int64
+	}{
+		// Ties below an even and an odd cent
+		{amount: "0.125", halfUp: 13, halfEven: 12, down: 12, up: 13},
+		{amount: "0.135", halfUp: 14, halfEven: 14, down: 13, up: 14},
+		{amount: "-0.125", halfUp: -13, halfEven: -12, down: -12, up: -13},
+		{amount: "-0.135", halfUp: -14, halfEven: -14, down: -13, up: -14},
+		// Just either side of a tie
+		{amount: "0.1249", halfUp: 12, halfEven: 12, down: 12, up: 13},
+		{amount: "0.1251", halfUp: 13, halfEven: 13, down: 12, up: 13},
+		{amount: "-0.1251", halfUp: -13, halfEven: -13, down: -12, up: -13},
+		// A tie at zero and amounts that need no rounding
+		{amount: "0.005", halfUp: 1, halfEven: 0, down: 0, up: 1},
+		{amount: "-0.005", halfUp: -1, halfEven: 0, down: 0, up: -1},
+		{amount: "0.12", halfUp: 12, halfEven: 12, down: 12, up: 12},
+		{amount: "0", halfUp: 0, halfEven: 0, down: 0, up: 0},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []struct {
+			name string
+			mode RoundingMode
+			want int64
+		}{
+			{"half up", RoundHalfUp, tt.halfUp},
+			{"half even", RoundHalfEven, tt.halfEven},
+			{"down", RoundDown, tt.down},
+			{"up", RoundUp, tt.up},
+		} {
+			got := FromDecimal(MustDecimal(tt.amount), "USD", mode.mode)
+			if got.MinorUnits() != mode.want {
+				t.Errorf("%s rounded %s = %d cents, want %d", tt.amount, mode.name, got.MinorUnits(), mode.want)
+			}
+		}
+	}
+}
+
+func TestMulRatioRoundsTies(t *testing.T) {
+	// 25 cents over 2 is a tie at 12.5 cents
+	tests := []struct {
+		mode RoundingMode
+		want int64
+	}{
+		{RoundHalfUp, 13},
+		{RoundHalfEven, 12},
+		{RoundDown, 12},
+		{RoundUp, 13},
+	}
+	for _, tt := range tests {
+		if got := New(25, "USD").MulRatio(1, 2, tt.mode); got.MinorUnits() != tt.want {
+			t.Errorf("0.25 USD x 1/2 in mode %d = %s, want %d cents", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		ratios []int64
+		want   []int64
+	}{
+		{name: "even split", amount: 900, ratios: []int64{1, 1, 1}, want: []int64{300, 300, 300}},
+		{name: "remainder goes to the earliest shares", amount: 1000, ratios: []int64{1, 1, 1}, want: []int64{334, 333, 333}},
+		{name: "remainder of two", amount: 1001, ratios: []int64{1, 1, 1}, want: []int64{334, 334, 333}},
+		{name: "weighted", amount: 1000, ratios: []int64{70, 20, 10}, want: []int64{700, 200, 100}},
+		{name: "weighted with a remainder", amount: 5, ratios: []int64{3, 7}, want: []int64{2, 3}},
+		{name: "negative amount", amount: -1000, ratios: []int64{1, 1, 1}, want: []int64{-334, -333, -333}},
+		{name: "negative weighted", amount: -5, ratios: []int64{3, 7}, want: []int64{-2, -3}},
+		{name: "zero ratio gets nothing", amount: 1000, ratios: []int64{0, 1, 1}, want: []int64{0, 500, 500}},
+		{name: "zero ratio skipped for the remainder", amount: 1001, ratios: []int64{0, 1, 1}, want: []int64{0, 501, 500}},
+		{name: "zero ratio skipped for a negative remainder", amount: -3, ratios: []int64{1, 0, 1}, want: []int64{-2, 0, -1}},
+		{name: "zero amount", amount: 0, ratios: []int64{1, 2}, want: []int64{0, 0}},
+		{name: "fewer units than shares", amount: 2, ratios: []int64{1, 1, 1}, want: []int64{1, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares, err := New(tt.amount, "USD").Allocate(tt.ratios...)
+			if err != nil {
+				t.Fatalf("Allocate failed: %v", err)
+			}
+			if len(shares) != len(tt.want) {
+				t.Fatalf("Allocate returned %d shares, want %d", len(shares), len(tt.want))
+			}
+			var total int64
+			for i, share := range shares {
+				if share.MinorUnits() != tt.want[i] || share.Currency() != "USD" {
+					t.Errorf("share %d = %s, want %d cents", i, share, tt.want[i])
+				}
+				total += share.MinorUnits()
+			}
+			if total != tt.amount {
+				t.Errorf("shares add up to %d, want %d", total, tt.amount)
+			}
+		})
+	}
+
+	for _, ratios := range [][]int64{nil, {0, 0}, {1, -1}} {
+		if _, err := New(1000, "USD").Allocate(ratios...); err == nil {
+			t.Errorf("Allocate(%v) succeeded", ratios)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Money
+	}{
+		{name: "object", json: `{"amount":"49.99","currency":"USD"}`, want: New(4999, "USD")},
+		{name: "object in a zero-exponent currency", json: `{"amount":"1000","currency":"JPY"}`, want: New(1000, "JPY")},
+		{name: "object with a numeric amount", json: `{"amount":12.5,"currency":"EUR"}`, want: New(1250, "EUR")},
+		{name: "object without an amount", json: `{"currency":"GBP"}`, want: Zero("GBP")},
+		{name: "null", json: `null`, want: Money{}},
+		// Amounts stored while they were float64 are bare numbers in USD
+		{name: "legacy number", json: `49.99`, want: New(4999, "USD")},
+		{name: "legacy whole number", json: `10`, want: New(1000, "USD")},
+		{name: "legacy negative number", json: `-3.5`, want: New(-350, "USD")},
+		{name: "legacy float noise", json: `19.989999999999998`, want: New(1999, "USD")},
+		{name: "legacy string", json: `"7.25"`, want: New(725, "USD")},
+		{name: "legacy number with spaces", json: " 1.5 ", want: New(150, "USD")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatalf("Unmarshal(%s) failed: %v", tt.json, err)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %s, want %s", tt.json, got, tt.want)
+			}
+		})
+	}
+
+	invalid := []string{
+		`true`, `"abc"`, `1e3`, `{"amount":"x","currency":"USD"}`, `{"amount":`,
+		// Finer than the minor unit, as Parse refuses
+		`{"amount":"49.999","currency":"USD"}`, `{"amount":"1.5","currency":"JPY"}`, `0.125`, `"7.255"`,
+		`{"amount":"92233720368547758.08","currency":"USD"}`,
+	}
+	for _, invalid := range invalid {
+		var got Money
+		if err := json.Unmarshal([]byte(invalid), &got); err == nil {
+			t.Errorf("Unmarshal(%s) = %s, want an error", invalid, got)
+		}
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	for _, amount := range []Money{New(4999, "USD"), New(-1, "EUR"), New(1000, "JPY"), New(1234, "KWD")} {
+		data, err := json.Marshal(amount)
+		if err != nil {
+			t.Fatalf("Marshal(%s) failed: %v", amount, err)
+		}
+		var got Money
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal(%s) failed: %v", data, err)
+		}
+		if got != amount {
+			t.Errorf("%s round-tripped through %s as %s", amount, data, got)
+		}
+	}
+}
+
+func TestArithmeticOverflow(t *testing.T) {
+	largest, smallest := New(math.MaxInt64, "USD"), New(math.MinInt64, "USD")
+	one := New(1, "USD")
+	overflows := map[string]func() (Money, error){
+		"Add":             func() (Money, error) { return largest.Add(one) },
+		"Add negative":    func() (Money, error) { return smallest.Add(one.Neg()) },
+		"Sub":             func() (Money, error) { return smallest.Sub(one) },
+		"Sub negative":    func() (Money, error) { return largest.Sub(one.Neg()) },
+		"Sub the minimum": func() (Money, error) { return Zero("USD").Sub(smallest) },
+		"Mul":             func() (Money, error) { return New(math.MaxInt64/2+1, "USD").Mul(2) },
+		"Mul negative":    func() (Money, error) { return largest.Mul(-2) },
+		"Sum":             func() (Money, error) { return Sum("USD", largest, one) },
+	}
+	for name, overflow := range overflows {
+		if got, err := overflow(); !errors.Is(err, ErrOverflow) {
+			t.Errorf("%s = %s, %v, want ErrOverflow", name, got, err)
+		}
+	}
+
+	// Results at the edges of the range do not overflow
+	if got, err := largest.Sub(largest); err != nil || !got.IsZero() {
+		t.Errorf("MaxInt64 - MaxInt64 = %s, %v, want 0", got, err)
+	}
+	if got, err := smallest.Add(largest); err != nil || got != one.Neg() {
+		t.Errorf("MinInt64 + MaxInt64 = %s, %v, want %s", got, err, one.Neg())
+	}
+	if got, err := New(-4999, "USD").Mul(3); err != nil || got != New(-14997, "USD") {
+		t.Errorf("-49.99 * 3 = %s, %v, want -149.97 USD", got, err)
+	}
+}
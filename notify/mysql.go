@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const createNotificationsSentTable = `
+CREATE TABLE IF NOT EXISTS notifications_sent (
+	message_id VARCHAR(191) NOT NULL PRIMARY KEY,
+	sent_at    DATETIME(6)  NOT NULL
+)`
+
+// MySQLSentLog is a SentLog backed by a MySQL table
+type MySQLSentLog struct {
+	db *sql.DB
+}
+
+// NewMySQLSentLog creates a MySQL-backed sent log and makes sure its table exists
+func NewMySQLSentLog(ctx context.Context, db *sql.DB) (*MySQLSentLog, error) {
+	if _, err := db.ExecContext(ctx, createNotificationsSentTable); err != nil {
+		return nil, fmt.Errorf("creating notifications_sent table: %w", err)
+	}
+	return &MySQLSentLog{db: db}, nil
+}
+
+// Sent reports whether a message has been delivered
+func (l *MySQLSentLog) Sent(ctx context.Context, id string) (bool, error) {
+	var found int
+	err := l.db.QueryRowContext(ctx, `SELECT 1 FROM notifications_sent WHERE message_id = ?`, id).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("loading notification %s: %w", id, err)
+	}
+	return true, nil
+}
+
+// Record records that a message was delivered, keeping the first delivery time
+func (l *MySQLSentLog) Record(ctx context.Context, id string, sentAt time.Time) error {
+	// INSERT IGNORE keeps the first record of a message sent twice
+	_, err := l.db.ExecContext(ctx,
+		`INSERT IGNORE INTO notifications_sent (message_id, sent_at) VALUES (?, ?)`,
+		id, sentAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("recording notification %s: %w", id, err)
+	}
+	return nil
+}
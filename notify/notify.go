@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Kind is the kind of billing notification, which picks its template
+type Kind string
+
+const (
+	InvoiceIssued        Kind = "invoice_issued"
+	PaymentSucceeded     Kind = "payment_succeeded"
+	PaymentFailed        Kind = "payment_failed"
+	TrialEnding          Kind = "trial_ending"
+	SubscriptionCanceled Kind = "subscription_canceled"
+)
+
+// Kinds lists every kind of notification
+var Kinds = []Kind{InvoiceIssued, PaymentSucceeded, PaymentFailed, TrialEnding, SubscriptionCanceled}
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Message is an email to a customer. Its ID identifies the notification, e.g.
+// invoice_issued/inv_123, so that the same notification always has the same ID and is sent once.
+type Message struct {
+	ID          string
+	Kind        Kind
+	To          string
+	Subject     string
+	Body        string
+	Date        time.Time
+	Attachments []Attachment
+}
+
+// Notifier delivers messages
+type Notifier interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// SentLog remembers which messages have been delivered, by message ID
+type SentLog interface {
+	// Sent reports whether a message has been delivered
+	Sent(ctx context.Context, id string) (bool, error)
+	// Record records that a message was delivered
+	Record(ctx context.Context, id string, sentAt time.Time) error
+}
+
+// Deliver sends a message unless the log shows it was already delivered, and records it once it
+// is sent. It reports whether the message was sent now. A worker that crashes between sending and
+// recording will send the message again on retry, with the same Message-ID header, which mail
+// clients use to drop the duplicate.
+func Deliver(ctx context.Context, notifier Notifier, log SentLog, message Message) (bool, error) {
+	sent, err := log.Sent(ctx, message.ID)
+	if err != nil || sent {
+		return false, err
+	}
+	if err := notifier.Send(ctx, message); err != nil {
+		return false, err
+	}
+	return true, log.Record(ctx, message.ID, time.Now())
+}
+
+// MemorySentLog is an in-memory SentLog, useful for local runs and tests
+type MemorySentLog struct {
+	mu   sync.RWMutex
+	sent map[string]time.Time
+}
+
+// NewMemorySentLog creates an empty in-memory sent log
+func NewMemorySentLog() *MemorySentLog {
+	return &MemorySentLog{sent: make(map[string]time.Time)}
+}
+
+// Sent reports whether a message has been delivered
+func (l *MemorySentLog) Sent(ctx context.Context, id string) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.sent[id]
+	return ok, nil
+}
+
+// Record records that a message was delivered, keeping the first delivery time
+func (l *MemorySentLog) Record(ctx context.Context, id string, sentAt time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.sent[id]; !ok {
+		l.sent[id] = sentAt
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// fakeSMTPServer accepts mail on a local port and keeps every message it receives
+type fakeSMTPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTPServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost fake SMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch command {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case "DATA":
+			reply("354 send the message")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func TestSMTPNotifierDeliversOnce(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	notifier := NewSMTPNotifier(server.listener.Addr().String(), "billing@example.com", "", "")
+	log := NewMemorySentLog()
+
+	message, err := Compose(InvoiceIssued, "invoice_issued/inv_1", "cust_1@example.com", Data{
+		CustomerID:     "cust_1",
+		SubscriptionID: "sub_1",
+		InvoiceID:      "inv_1",
+		Amount:         money.MustParse("49.99", "USD"),
+		PaymentStatus:  "succeeded",
+		PaymentID:      "pay_1",
+	})
+	if err != nil {
+		t.Fatalf("Compose failed: %v", err)
+	}
+	message.Attachments = []Attachment{{Filename: "inv_1.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}}
+
+	// A retried delivery of the same message is skipped
+	for i, want := range []bool{true, false} {
+		sent, err := Deliver(context.Background(), notifier, log, message)
+		if err != nil {
+			t.Fatalf("delivery %d failed: %v", i+1, err)
+		}
+		if sent != want {
+			t.Errorf("delivery %d sent = %v, want %v", i+1, sent, want)
+		}
+	}
+
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(received))
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(received[0]))
+	if err != nil {
+		t.Fatalf("received message does not parse: %v", err)
+	}
+	if got := parsed.Header.Get("Subject"); got != "Your invoice inv_1" {
+		t.Errorf("Subject = %q, want %q", got, "Your invoice inv_1")
+	}
+	if got := parsed.Header.Get("Message-ID"); got != "<invoice_issued/inv_1@example.com>" {
+		t.Errorf("Message-ID = %q", got)
+	}
+	if !strings.Contains(received[0], `filename=inv_1.pdf`) {
+		t.Error("message is missing the PDF attachment")
+	}
+}
+
+// failingNotifier fails every send
+type failingNotifier struct{}
+
+func (failingNotifier) Send(ctx context.Context, message Message) error {
+	return errors.New("connection refused")
+}
+
+func TestDeliverDoesNotRecordFailedSends(t *testing.T) {
+	log := NewMemorySentLog()
+	if _, err := Deliver(context.Background(), failingNotifier{}, log, Message{ID: "m"}); err == nil {
+		t.Fatal("Deliver succeeded, want an error")
+	}
+	if sent, _ := log.Sent(context.Background(), "m"); sent {
+		t.Error("failed message was recorded as sent, so a retry would never send it")
+	}
+}
+
+func TestComposeEveryKind(t *testing.T) {
+	data := Data{
+		CustomerID:     "cust_1",
+		SubscriptionID: "sub_1",
+		PlanName:       "Premium Monthly",
+		InvoiceID:      "inv_1",
+		Amount:         money.MustParse("49.99", "USD"),
+		DueDate:        time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC),
+		Severity:       "final",
+		TrialEnd:       time.Date(2025, 6, 16, 0, 0, 0, 0, time.UTC),
+		CanceledAt:     time.Date(2025, 6, 20, 0, 0, 0, 0, time.UTC),
+	}
+	subjects := map[Kind]string{
+		InvoiceIssued:        "Your invoice inv_1 for Premium Monthly",
+		PaymentSucceeded:     "Payment received for invoice inv_1",
+		PaymentFailed:        "Final notice: payment failed for invoice inv_1",
+		TrialEnding:          "Your trial of Premium Monthly ends on 16 Jun 2025",
+		SubscriptionCanceled: "Your subscription sub_1 has been canceled",
+	}
+	for _, kind := range Kinds {
+		message, err := Compose(kind, string(kind)+"/x", "cust_1@example.com", data)
+		if err != nil {
+			t.Errorf("%s: Compose failed: %v", kind, err)
+			continue
+		}
+		if message.Subject != subjects[kind] {
+			t.Errorf("%s: subject = %q, want %q", kind, message.Subject, subjects[kind])
+		}
+		if !strings.Contains(message.Body, "cust_1") {
+			t.Errorf("%s: body does not greet the customer:\n%s", kind, message.Body)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"context"
+	"strings"
+
+	"github.com/tanint/play-temporal/blobs"
+)
+
+// Outbox is a Notifier that writes messages to a blob store as .eml files instead of sending them,
+// for local runs without an SMTP server. A message is always written under the same key, so
+// writing it again replaces it rather than adding a copy.
+type Outbox struct {
+	store blobs.Store
+	from  string
+}
+
+// NewOutbox creates an outbox writing messages from the given address under outbox/ in a store
+func NewOutbox(store blobs.Store, from string) *Outbox {
+	return &Outbox{store: store, from: from}
+}
+
+// Send writes a message to outbox/<message ID>.eml
+func (o *Outbox) Send(ctx context.Context, message Message) error {
+	data, err := message.MIME(o.from)
+	if err != nil {
+		return err
+	}
+	_, err = o.store.Put(ctx, OutboxKey(message.ID), data, "message/rfc822")
+	return err
+}
+
+// OutboxKey is the blob key a message is written to by an Outbox
+func OutboxKey(id string) string {
+	return "outbox/" + strings.ReplaceAll(id, "/", "_") + ".eml"
+}
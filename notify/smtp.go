@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+)
+
+// SMTPNotifier sends messages through an SMTP server
+type SMTPNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPNotifier creates a notifier that sends from the given address through the SMTP server at
+// addr (host:port). The username and password are used for PLAIN authentication when set, which
+// net/smtp only allows over TLS or to localhost.
+func NewSMTPNotifier(addr, from, username, password string) *SMTPNotifier {
+	n := &SMTPNotifier{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n
+}
+
+// Send delivers a message to its recipient. STARTTLS is used when the server offers it.
+func (n *SMTPNotifier) Send(ctx context.Context, message Message) error {
+	data, err := message.MIME(n.from)
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(n.addr, n.auth, n.from, []string{message.To}, data); err != nil {
+		return fmt.Errorf("sending %s to %s: %w", message.ID, message.To, err)
+	}
+	return nil
+}
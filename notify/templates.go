@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+//go:embed templates/*.txt.tmpl
+var templateFiles embed.FS
+
+var templates = template.Must(template.New("").
+	Funcs(template.FuncMap{"date": date}).
+	ParseFS(templateFiles, "templates/*.txt.tmpl"))
+
+// Data is what the templates can show. Each kind of notification uses the fields that apply to it.
+type Data struct {
+	CustomerID     string
+	SubscriptionID string
+	PlanName       string
+	InvoiceID      string
+	InvoiceNumber  string
+	Amount         money.Money
+	DueDate        time.Time
+	// PaymentStatus is the invoice's payment status, for invoice emails
+	PaymentStatus string
+	PaymentID     string
+	DeclineCode   string
+	// Attached is true when the rendered invoice is attached to the email
+	Attached bool
+	// Severity is the payment reminder's severity: notice, warning or final
+	Severity    string
+	NextRetryAt time.Time
+	TrialEnd    time.Time
+	CanceledAt  time.Time
+	Reason      string
+}
+
+// Compose renders the template for a kind of notification into a message. A template's first line
+// is the subject, as "Subject: ...", and the rest is the body.
+func Compose(kind Kind, id, to string, data Data) (Message, error) {
+	var out bytes.Buffer
+	if err := templates.ExecuteTemplate(&out, string(kind)+".txt.tmpl", data); err != nil {
+		return Message{}, fmt.Errorf("composing %s email: %w", kind, err)
+	}
+
+	subject, body, _ := strings.Cut(out.String(), "\n")
+	subject, ok := strings.CutPrefix(subject, "Subject: ")
+	if !ok {
+		return Message{}, fmt.Errorf("composing %s email: template does not start with a subject", kind)
+	}
+	return Message{
+		ID:      id,
+		Kind:    kind,
+		To:      to,
+		Subject: strings.TrimSpace(subject),
+		Body:    strings.TrimSpace(body) + "\n",
+	}, nil
+}
+
+// date formats a date for an email, leaving unknown dates blank
+func date(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2 Jan 2006")
+}
@@ -0,0 +1,321 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// CardBehavior configures how the fake gateway treats a payment method
+type CardBehavior struct {
+	// DeclineCode declines every charge and authorization on the card with this code
+	DeclineCode string
+	// Timeouts is how many charges are processed but never answered, blocking until the context
+	// is done as if the response was lost. Later requests are answered normally.
+	Timeouts int
+	// Expired reports the card as expired when it is looked up
+	Expired bool
+}
+
+// Test cards known to every fake gateway. Any other payment method ID starting with "pm_" succeeds.
+var defaultCards = map[string]CardBehavior{
+	"pm_card_declined":           {DeclineCode: DeclineGeneric},
+	"pm_card_insufficient_funds": {DeclineCode: DeclineInsufficientFunds},
+	"pm_card_expired":            {DeclineCode: DeclineExpiredCard, Expired: true},
+	"pm_card_stolen":             {DeclineCode: DeclineStolenCard},
+	"pm_card_timeout":            {Timeouts: 1},
+}
+
+// FakeGateway is a deterministic in-process Gateway. Cards behave as configured with SetCard,
+// IDs are numbered in request order and every request is idempotent on its key. Declines are not
+// remembered, so repeating a declined request asks the card again.
+type FakeGateway struct {
+	mu             sync.Mutex
+	now            func() time.Time
+	cards          map[string]CardBehavior
+	seq            int
+	charges        map[string]Charge        // keyed by charge ID
+	authorizations map[string]Authorization // keyed by authorization ID
+	byKey          map[string]interface{}   // results keyed by idempotency key
+}
+
+// NewFakeGateway creates a fake gateway that knows the default test cards
+func NewFakeGateway() *FakeGateway {
+	g := &FakeGateway{
+		now:            time.Now,
+		cards:          make(map[string]CardBehavior),
+		charges:        make(map[string]Charge),
+		authorizations: make(map[string]Authorization),
+		byKey:          make(map[string]interface{}),
+	}
+	for id, behavior := range defaultCards {
+		g.cards[id] = behavior
+	}
+	return g
+}
+
+// SetCard configures how a payment method behaves
+func (g *FakeGateway) SetCard(paymentMethodID string, behavior CardBehavior) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cards[paymentMethodID] = behavior
+}
+
+// SetClock replaces the clock used to timestamp charges, refunds and authorizations
+func (g *FakeGateway) SetClock(now func() time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.now = now
+}
+
+// Charges returns every charge taken so far, in no particular order
+func (g *FakeGateway) Charges() []Charge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	charges := make([]Charge, 0, len(g.charges))
+	for _, charge := range g.charges {
+		charges = append(charges, charge)
+	}
+	return charges
+}
+
+// Charge takes money from a payment method
+func (g *FakeGateway) Charge(ctx context.Context, request ChargeRequest) (Charge, error) {
+	charge, lost, err := g.charge(request)
+	if err != nil {
+		return Charge{}, err
+	}
+	if lost {
+		<-ctx.Done()
+		return Charge{}, fmt.Errorf("%w: %v", ErrGatewayUnavailable, ctx.Err())
+	}
+	return charge, nil
+}
+
+// charge takes money under the lock and reports whether the response should be lost
+func (g *FakeGateway) charge(request ChargeRequest) (Charge, bool, error) {
+	if err := validateAmount(request.Amount); err != nil {
+		return Charge{}, false, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if request.IdempotencyKey != "" {
+		if previous, ok := g.byKey[request.IdempotencyKey].(Charge); ok {
+			return previous, false, nil
+		}
+	}
+	card, err := g.card(request.PaymentMethodID)
+	if err != nil {
+		return Charge{}, false, err
+	}
+	if card.DeclineCode != "" {
+		return Charge{}, false, &DeclineError{Code: card.DeclineCode}
+	}
+
+	charge := Charge{
+		ID:              g.nextID("ch"),
+		PaymentMethodID: request.PaymentMethodID,
+		Amount:          request.Amount,
+		Refunded:        money.Zero(request.Amount.Currency()),
+		CreatedAt:       g.now(),
+	}
+	g.charges[charge.ID] = charge
+	if request.IdempotencyKey != "" {
+		g.byKey[request.IdempotencyKey] = charge
+	}
+
+	lost := card.Timeouts > 0
+	if lost {
+		card.Timeouts--
+		g.cards[request.PaymentMethodID] = card
+	}
+	return charge, lost, nil
+}
+
+// Refund returns money from an earlier charge. A zero amount refunds what is left of the charge.
+func (g *FakeGateway) Refund(ctx context.Context, request RefundRequest) (Refund, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if request.IdempotencyKey != "" {
+		if previous, ok := g.byKey[request.IdempotencyKey].(Refund); ok {
+			return previous, nil
+		}
+	}
+	charge, ok := g.charges[request.ChargeID]
+	if !ok {
+		return Refund{}, fmt.Errorf("%w: %s", ErrChargeNotFound, request.ChargeID)
+	}
+
+	remaining, err := charge.Amount.Sub(charge.Refunded)
+	if err != nil {
+		return Refund{}, err
+	}
+	amount := request.Amount
+	if amount.IsZero() {
+		amount = remaining
+	}
+	if err := validateAmount(amount); err != nil {
+		return Refund{}, err
+	}
+	if cmp, err := amount.Cmp(remaining); err != nil {
+		return Refund{}, err
+	} else if cmp > 0 {
+		return Refund{}, fmt.Errorf("%w: refund of %s, %s left on charge %s", ErrRefundTooLarge, amount, remaining, charge.ID)
+	}
+
+	if charge.Refunded, err = charge.Refunded.Add(amount); err != nil {
+		return Refund{}, err
+	}
+	g.charges[charge.ID] = charge
+
+	refund := Refund{
+		ID:        g.nextID("re"),
+		ChargeID:  charge.ID,
+		Amount:    amount,
+		CreatedAt: g.now(),
+	}
+	if request.IdempotencyKey != "" {
+		g.byKey[request.IdempotencyKey] = refund
+	}
+	return refund, nil
+}
+
+// Authorize holds money on a payment method without taking it
+func (g *FakeGateway) Authorize(ctx context.Context, request ChargeRequest) (Authorization, error) {
+	if err := validateAmount(request.Amount); err != nil {
+		return Authorization{}, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if request.IdempotencyKey != "" {
+		if previous, ok := g.byKey[request.IdempotencyKey].(Authorization); ok {
+			return previous, nil
+		}
+	}
+	card, err := g.card(request.PaymentMethodID)
+	if err != nil {
+		return Authorization{}, err
+	}
+	if card.DeclineCode != "" {
+		return Authorization{}, &DeclineError{Code: card.DeclineCode}
+	}
+
+	authorization := Authorization{
+		ID:              g.nextID("auth"),
+		PaymentMethodID: request.PaymentMethodID,
+		Amount:          request.Amount,
+		CreatedAt:       g.now(),
+	}
+	g.authorizations[authorization.ID] = authorization
+	if request.IdempotencyKey != "" {
+		g.byKey[request.IdempotencyKey] = authorization
+	}
+	return authorization, nil
+}
+
+// Capture takes money held by an authorization. A zero amount captures all of it.
+func (g *FakeGateway) Capture(ctx context.Context, request CaptureRequest) (Charge, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if request.IdempotencyKey != "" {
+		if previous, ok := g.byKey[request.IdempotencyKey].(Charge); ok {
+			return previous, nil
+		}
+	}
+	authorization, ok := g.authorizations[request.AuthorizationID]
+	if !ok {
+		return Charge{}, fmt.Errorf("%w: %s", ErrAuthorizationNotFound, request.AuthorizationID)
+	}
+	if authorization.Captured {
+		return Charge{}, fmt.Errorf("authorization %s was already captured", authorization.ID)
+	}
+
+	amount := request.Amount
+	if amount.IsZero() {
+		amount = authorization.Amount
+	}
+	if err := validateAmount(amount); err != nil {
+		return Charge{}, err
+	}
+	if cmp, err := amount.Cmp(authorization.Amount); err != nil {
+		return Charge{}, err
+	} else if cmp > 0 {
+		return Charge{}, fmt.Errorf("capture of %s exceeds the %s authorized by %s", amount, authorization.Amount, authorization.ID)
+	}
+
+	authorization.Captured = true
+	g.authorizations[authorization.ID] = authorization
+
+	charge := Charge{
+		ID:              g.nextID("ch"),
+		PaymentMethodID: authorization.PaymentMethodID,
+		Amount:          amount,
+		Refunded:        money.Zero(amount.Currency()),
+		CreatedAt:       g.now(),
+	}
+	g.charges[charge.ID] = charge
+	if request.IdempotencyKey != "" {
+		g.byKey[request.IdempotencyKey] = charge
+	}
+	return charge, nil
+}
+
+// GetPaymentMethod looks up a payment method
+func (g *FakeGateway) GetPaymentMethod(ctx context.Context, paymentMethodID string) (PaymentMethod, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	card, err := g.card(paymentMethodID)
+	if err != nil {
+		return PaymentMethod{}, err
+	}
+
+	method := PaymentMethod{
+		ID:       paymentMethodID,
+		Brand:    "visa",
+		Last4:    "4242",
+		ExpMonth: 12,
+		ExpYear:  g.now().Year() + 3,
+	}
+	if card.Expired {
+		method.ExpYear = g.now().Year() - 1
+	}
+	return method, nil
+}
+
+// card returns the behavior of a payment method. Only IDs starting with "pm_" exist.
+func (g *FakeGateway) card(paymentMethodID string) (CardBehavior, error) {
+	if !strings.HasPrefix(paymentMethodID, "pm_") {
+		return CardBehavior{}, fmt.Errorf("%w: %q", ErrPaymentMethodNotFound, paymentMethodID)
+	}
+	return g.cards[paymentMethodID], nil
+}
+
+// nextID numbers objects in the order they are created
+func (g *FakeGateway) nextID(prefix string) string {
+	g.seq++
+	return fmt.Sprintf("%s_fake_%06d", prefix, g.seq)
+}
+
+// validateAmount checks that an amount can be charged
+func validateAmount(amount money.Money) error {
+	if amount.Currency() == "" {
+		return errors.New("amount needs a currency")
+	}
+	if amount.Sign() <= 0 {
+		return fmt.Errorf("amount %s must be positive", amount)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// Errors returned by gateways for objects they do not know
+var (
+	ErrPaymentMethodNotFound = errors.New("payment method not found")
+	ErrChargeNotFound        = errors.New("charge not found")
+	ErrAuthorizationNotFound = errors.New("authorization not found")
+)
+
+// ErrRefundTooLarge is returned when a refund is larger than what is left of its charge
+var ErrRefundTooLarge = errors.New("refund exceeds the amount left on the charge")
+
+// ErrGatewayUnavailable is wrapped by gateway failures that are worth retrying,
+// such as a 5xx response or a dropped connection
+var ErrGatewayUnavailable = errors.New("payment gateway unavailable")
+
+// Decline codes returned by gateways
+const (
+	DeclineGeneric           = "card_declined"
+	DeclineInsufficientFunds = "insufficient_funds"
+	DeclineProcessingError   = "processing_error"
+	DeclineTryAgainLater     = "try_again_later"
+	DeclineExpiredCard       = "expired_card"
+	DeclineIncorrectCVC      = "incorrect_cvc"
+	DeclineLostCard          = "lost_card"
+	DeclineStolenCard        = "stolen_card"
+	DeclineFraudulent        = "fraudulent"
+)
+
+// softDeclines are the decline codes that may succeed when the same card is tried again.
+// Every other code, including ones not listed here, is a hard decline.
+var softDeclines = map[string]bool{
+	DeclineGeneric:           true,
+	DeclineInsufficientFunds: true,
+	DeclineProcessingError:   true,
+	DeclineTryAgainLater:     true,
+}
+
+// DeclineError is returned when the gateway refuses to charge a payment method
+type DeclineError struct {
+	Code    string
+	Message string
+}
+
+func (e *DeclineError) Error() string {
+	if e.Message == "" {
+		return "payment declined: " + e.Code
+	}
+	return fmt.Sprintf("payment declined: %s (%s)", e.Message, e.Code)
+}
+
+// Soft reports whether trying the same card again may succeed
+func (e *DeclineError) Soft() bool {
+	return softDeclines[e.Code]
+}
+
+// ChargeRequest asks the gateway to move money from a payment method. Requests are idempotent on
+// IdempotencyKey: repeating a request that succeeded returns the original result.
+type ChargeRequest struct {
+	IdempotencyKey  string      `json:"-"`
+	PaymentMethodID string      `json:"payment_method_id"`
+	CustomerID      string      `json:"customer_id"`
+	Amount          money.Money `json:"amount"`
+	Description     string      `json:"description"`
+}
+
+// Charge is money taken from a payment method
+type Charge struct {
+	ID              string      `json:"id"`
+	PaymentMethodID string      `json:"payment_method_id"`
+	Amount          money.Money `json:"amount"`
+	Refunded        money.Money `json:"refunded"`
+	CreatedAt       time.Time   `json:"created_at"`
+}
+
+// RefundRequest asks the gateway to return part or all of a charge
+type RefundRequest struct {
+	IdempotencyKey string      `json:"-"`
+	ChargeID       string      `json:"charge_id"`
+	Amount         money.Money `json:"amount"`
+	Reason         string      `json:"reason"`
+}
+
+// Refund is money returned to the payment method of a charge
+type Refund struct {
+	ID        string      `json:"id"`
+	ChargeID  string      `json:"charge_id"`
+	Amount    money.Money `json:"amount"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Authorization is money held on a payment method until it is captured
+type Authorization struct {
+	ID              string      `json:"id"`
+	PaymentMethodID string      `json:"payment_method_id"`
+	Amount          money.Money `json:"amount"`
+	Captured        bool        `json:"captured"`
+	CreatedAt       time.Time   `json:"created_at"`
+}
+
+// CaptureRequest turns an authorization into a charge for up to the authorized amount
+type CaptureRequest struct {
+	IdempotencyKey  string      `json:"-"`
+	AuthorizationID string      `json:"authorization_id"`
+	Amount          money.Money `json:"amount"`
+}
+
+// PaymentMethod describes a card stored with the gateway
+type PaymentMethod struct {
+	ID       string `json:"id"`
+	Brand    string `json:"brand"`
+	Last4    string `json:"last4"`
+	ExpMonth int    `json:"exp_month"`
+	ExpYear  int    `json:"exp_year"`
+}
+
+// Gateway is a payment processor. Charges that are refused fail with a *DeclineError and
+// transient failures wrap ErrGatewayUnavailable.
+type Gateway interface {
+	// Charge takes money from a payment method
+	Charge(ctx context.Context, request ChargeRequest) (Charge, error)
+	// Refund returns money from an earlier charge
+	Refund(ctx context.Context, request RefundRequest) (Refund, error)
+	// Authorize holds money on a payment method without taking it
+	Authorize(ctx context.Context, request ChargeRequest) (Authorization, error)
+	// Capture takes money held by an authorization
+	Capture(ctx context.Context, request CaptureRequest) (Charge, error)
+	// GetPaymentMethod looks up a payment method
+	GetPaymentMethod(ctx context.Context, paymentMethodID string) (PaymentMethod, error)
+}
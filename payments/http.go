@@ -0,0 +1,146 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPGateway is a Gateway that talks to a payment processor's JSON API:
+//
+//	POST /v1/charges                        ChargeRequest -> Charge
+//	POST /v1/refunds                        RefundRequest -> Refund
+//	POST /v1/authorizations                 ChargeRequest -> Authorization
+//	POST /v1/authorizations/{id}/capture    CaptureRequest -> Charge
+//	GET  /v1/payment_methods/{id}           PaymentMethod
+//
+// Idempotency keys are sent in the Idempotency-Key header. Declines are answered with
+// 402 Payment Required and an ErrorResponse body, and refunds that are too large with
+// 400 Bad Request and the error code "amount_too_large".
+type HTTPGateway struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// AmountTooLargeCode is the error code of a refund larger than what is left of its charge
+const AmountTooLargeCode = "amount_too_large"
+
+// ErrorResponse is the body of a failed gateway request
+type ErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewHTTPGateway creates a gateway for the API at baseURL. A nil client uses http.DefaultClient.
+func NewHTTPGateway(baseURL, apiKey string, client *http.Client) *HTTPGateway {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPGateway{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  client,
+	}
+}
+
+// Charge takes money from a payment method
+func (g *HTTPGateway) Charge(ctx context.Context, request ChargeRequest) (Charge, error) {
+	var charge Charge
+	err := g.do(ctx, http.MethodPost, "/v1/charges", request.IdempotencyKey, request, &charge, ErrPaymentMethodNotFound)
+	return charge, err
+}
+
+// Refund returns money from an earlier charge
+func (g *HTTPGateway) Refund(ctx context.Context, request RefundRequest) (Refund, error) {
+	var refund Refund
+	err := g.do(ctx, http.MethodPost, "/v1/refunds", request.IdempotencyKey, request, &refund, ErrChargeNotFound)
+	return refund, err
+}
+
+// Authorize holds money on a payment method without taking it
+func (g *HTTPGateway) Authorize(ctx context.Context, request ChargeRequest) (Authorization, error) {
+	var authorization Authorization
+	err := g.do(ctx, http.MethodPost, "/v1/authorizations", request.IdempotencyKey, request, &authorization, ErrPaymentMethodNotFound)
+	return authorization, err
+}
+
+// Capture takes money held by an authorization
+func (g *HTTPGateway) Capture(ctx context.Context, request CaptureRequest) (Charge, error) {
+	var charge Charge
+	path := "/v1/authorizations/" + url.PathEscape(request.AuthorizationID) + "/capture"
+	err := g.do(ctx, http.MethodPost, path, request.IdempotencyKey, request, &charge, ErrAuthorizationNotFound)
+	return charge, err
+}
+
+// GetPaymentMethod looks up a payment method
+func (g *HTTPGateway) GetPaymentMethod(ctx context.Context, paymentMethodID string) (PaymentMethod, error) {
+	var method PaymentMethod
+	path := "/v1/payment_methods/" + url.PathEscape(paymentMethodID)
+	err := g.do(ctx, http.MethodGet, path, "", nil, &method, ErrPaymentMethodNotFound)
+	return method, err
+}
+
+// do sends a request and decodes the response into out. A 404 is reported as notFound.
+func (g *HTTPGateway) do(ctx context.Context, method, path, idempotencyKey string, body, out interface{}, notFound error) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	// A request that never got an answer may or may not have been processed,
+	// so it is retried with the same idempotency key
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s %s: %v", ErrGatewayUnavailable, method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("%w: decoding %s %s response: %v", ErrGatewayUnavailable, method, path, err)
+		}
+		return nil
+	}
+
+	var failure ErrorResponse
+	_ = json.NewDecoder(resp.Body).Decode(&failure)
+	switch {
+	case resp.StatusCode == http.StatusPaymentRequired:
+		return &DeclineError{Code: failure.Error.Code, Message: failure.Error.Message}
+	case resp.StatusCode == http.StatusBadRequest && failure.Error.Code == AmountTooLargeCode:
+		return fmt.Errorf("%w: %s", ErrRefundTooLarge, failure.Error.Message)
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", notFound, failure.Error.Message)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%w: %s %s returned %s", ErrGatewayUnavailable, method, path, resp.Status)
+	default:
+		return fmt.Errorf("payment gateway rejected %s %s with %s: %s", method, path, resp.Status, failure.Error.Message)
+	}
+}
@@ -0,0 +1,235 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// newTestServer serves the HTTP gateway API from a fake gateway
+func newTestServer(t *testing.T, fake *FakeGateway) *HTTPGateway {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/charges", func(w http.ResponseWriter, r *http.Request) {
+		var request ChargeRequest
+		decode(w, r, &request)
+		request.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		charge, err := fake.Charge(r.Context(), request)
+		respond(w, charge, err)
+	})
+	mux.HandleFunc("POST /v1/refunds", func(w http.ResponseWriter, r *http.Request) {
+		var request RefundRequest
+		decode(w, r, &request)
+		request.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		refund, err := fake.Refund(r.Context(), request)
+		respond(w, refund, err)
+	})
+	mux.HandleFunc("POST /v1/authorizations", func(w http.ResponseWriter, r *http.Request) {
+		var request ChargeRequest
+		decode(w, r, &request)
+		request.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		authorization, err := fake.Authorize(r.Context(), request)
+		respond(w, authorization, err)
+	})
+	mux.HandleFunc("POST /v1/authorizations/{id}/capture", func(w http.ResponseWriter, r *http.Request) {
+		var request CaptureRequest
+		decode(w, r, &request)
+		request.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		request.AuthorizationID = r.PathValue("id")
+		charge, err := fake.Capture(r.Context(), request)
+		respond(w, charge, err)
+	})
+	mux.HandleFunc("GET /v1/payment_methods/{id}", func(w http.ResponseWriter, r *http.Request) {
+		method, err := fake.GetPaymentMethod(r.Context(), r.PathValue("id"))
+		respond(w, method, err)
+	})
+	mux.HandleFunc("POST /v1/outage", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return NewHTTPGateway(server.URL, "sk_test", server.Client())
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func respond(w http.ResponseWriter, v interface{}, err error) {
+	var failure ErrorResponse
+	status := http.StatusOK
+	var decline *DeclineError
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+		return
+	case errors.As(err, &decline):
+		status = http.StatusPaymentRequired
+		failure.Error.Code = decline.Code
+	case errors.Is(err, ErrRefundTooLarge):
+		status = http.StatusBadRequest
+		failure.Error.Code = AmountTooLargeCode
+	case errors.Is(err, ErrPaymentMethodNotFound), errors.Is(err, ErrChargeNotFound), errors.Is(err, ErrAuthorizationNotFound):
+		status = http.StatusNotFound
+	default:
+		status = http.StatusBadRequest
+	}
+	failure.Error.Message = err.Error()
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(failure)
+}
+
+func chargeRequest(key, paymentMethodID string) ChargeRequest {
+	return ChargeRequest{
+		IdempotencyKey:  key,
+		PaymentMethodID: paymentMethodID,
+		CustomerID:      "cust_1",
+		Amount:          money.MustParse("49.99", "USD"),
+	}
+}
+
+func TestHTTPGatewayChargeIsIdempotent(t *testing.T) {
+	fake := NewFakeGateway()
+	gateway := newTestServer(t, fake)
+	ctx := context.Background()
+
+	first, err := gateway.Charge(ctx, chargeRequest("pay_inv_1", "pm_card_visa"))
+	if err != nil {
+		t.Fatalf("Charge failed: %v", err)
+	}
+	retried, err := gateway.Charge(ctx, chargeRequest("pay_inv_1", "pm_card_visa"))
+	if err != nil {
+		t.Fatalf("retried Charge failed: %v", err)
+	}
+
+	if retried.ID != first.ID {
+		t.Errorf("retry returned charge %s, want %s", retried.ID, first.ID)
+	}
+	if first.Amount != money.MustParse("49.99", "USD") {
+		t.Errorf("charged %s, want USD 49.99", first.Amount)
+	}
+	if n := len(fake.Charges()); n != 1 {
+		t.Errorf("gateway took %d charges, want 1", n)
+	}
+}
+
+func TestHTTPGatewayDeclines(t *testing.T) {
+	gateway := newTestServer(t, NewFakeGateway())
+
+	tests := []struct {
+		paymentMethodID string
+		code            string
+		soft            bool
+	}{
+		{"pm_card_declined", DeclineGeneric, true},
+		{"pm_card_insufficient_funds", DeclineInsufficientFunds, true},
+		{"pm_card_expired", DeclineExpiredCard, false},
+		{"pm_card_stolen", DeclineStolenCard, false},
+	}
+
+	for _, tt := range tests {
+		_, err := gateway.Charge(context.Background(), chargeRequest("pay_"+tt.paymentMethodID, tt.paymentMethodID))
+		var decline *DeclineError
+		if !errors.As(err, &decline) {
+			t.Errorf("charging %s = %v, want a *DeclineError", tt.paymentMethodID, err)
+			continue
+		}
+		if decline.Code != tt.code || decline.Soft() != tt.soft {
+			t.Errorf("charging %s declined with %s (soft %v), want %s (soft %v)",
+				tt.paymentMethodID, decline.Code, decline.Soft(), tt.code, tt.soft)
+		}
+	}
+}
+
+func TestHTTPGatewayAuthorizeCaptureRefund(t *testing.T) {
+	gateway := newTestServer(t, NewFakeGateway())
+	ctx := context.Background()
+
+	authorization, err := gateway.Authorize(ctx, chargeRequest("auth_1", "pm_card_visa"))
+	if err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+	charge, err := gateway.Capture(ctx, CaptureRequest{
+		IdempotencyKey:  "capture_1",
+		AuthorizationID: authorization.ID,
+		Amount:          money.MustParse("40.00", "USD"),
+	})
+	if err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+	if charge.Amount != money.MustParse("40.00", "USD") {
+		t.Errorf("captured %s, want USD 40.00", charge.Amount)
+	}
+
+	refund, err := gateway.Refund(ctx, RefundRequest{IdempotencyKey: "refund_1", ChargeID: charge.ID})
+	if err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if refund.Amount != charge.Amount {
+		t.Errorf("refunded %s, want the whole charge of %s", refund.Amount, charge.Amount)
+	}
+	_, err = gateway.Refund(ctx, RefundRequest{IdempotencyKey: "refund_2", ChargeID: charge.ID, Amount: money.MustParse("1.00", "USD")})
+	if !errors.Is(err, ErrRefundTooLarge) {
+		t.Errorf("refunding a fully refunded charge = %v, want an error", err)
+	}
+}
+
+func TestHTTPGatewayErrors(t *testing.T) {
+	gateway := newTestServer(t, NewFakeGateway())
+	ctx := context.Background()
+
+	if _, err := gateway.GetPaymentMethod(ctx, "card_unknown"); !errors.Is(err, ErrPaymentMethodNotFound) {
+		t.Errorf("looking up an unknown card = %v, want ErrPaymentMethodNotFound", err)
+	}
+	if _, err := gateway.Refund(ctx, RefundRequest{ChargeID: "ch_missing"}); !errors.Is(err, ErrChargeNotFound) {
+		t.Errorf("refunding an unknown charge = %v, want ErrChargeNotFound", err)
+	}
+
+	var outage struct{}
+	err := gateway.do(ctx, http.MethodPost, "/v1/outage", "", outage, &outage, ErrChargeNotFound)
+	if !errors.Is(err, ErrGatewayUnavailable) {
+		t.Errorf("a 503 response = %v, want ErrGatewayUnavailable", err)
+	}
+
+	unauthorized := NewHTTPGateway(gateway.baseURL, "sk_wrong", gateway.client)
+	if _, err := unauthorized.GetPaymentMethod(ctx, "pm_card_visa"); err == nil || errors.Is(err, ErrGatewayUnavailable) {
+		t.Errorf("a 401 response = %v, want a permanent error", err)
+	}
+}
+
+func TestFakeGatewayTimeoutProcessesCharge(t *testing.T) {
+	fake := NewFakeGateway()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := fake.Charge(ctx, chargeRequest("pay_inv_1", "pm_card_timeout"))
+	if !errors.Is(err, ErrGatewayUnavailable) {
+		t.Fatalf("charging a timing out card = %v, want ErrGatewayUnavailable", err)
+	}
+
+	// The lost response is recovered by retrying with the same key
+	charge, err := fake.Charge(context.Background(), chargeRequest("pay_inv_1", "pm_card_timeout"))
+	if err != nil {
+		t.Fatalf("retried Charge failed: %v", err)
+	}
+	charges := fake.Charges()
+	if len(charges) != 1 || charges[0].ID != charge.ID {
+		t.Errorf("gateway took charges %v, want only %s", charges, charge.ID)
+	}
+}
@@ -0,0 +1,146 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+const createPaymentsTable = `
+CREATE TABLE IF NOT EXISTS payments (
+	idempotency_key   VARCHAR(191) NOT NULL PRIMARY KEY,
+	id                VARCHAR(64)  NOT NULL,
+	invoice_id        VARCHAR(64)  NOT NULL,
+	subscription_id   VARCHAR(64)  NOT NULL,
+	amount_minor      BIGINT       NOT NULL,
+	currency          CHAR(3)      NOT NULL,
+	status            VARCHAR(32)  NOT NULL,
+	payment_method_id VARCHAR(64)  NOT NULL,
+	processed_at      DATETIME(6)  NOT NULL,
+	seq               BIGINT       NOT NULL AUTO_INCREMENT UNIQUE,
+	INDEX idx_payments_invoice (invoice_id, seq)
+)`
+
+// MySQLLedger is a Ledger backed by a MySQL table
+type MySQLLedger struct {
+	db *sql.DB
+}
+
+// NewMySQLLedger creates a MySQL-backed payment ledger and makes sure its table exists
+func NewMySQLLedger(ctx context.Context, db *sql.DB) (*MySQLLedger, error) {
+	if _, err := db.ExecContext(ctx, createPaymentsTable); err != nil {
+		return nil, fmt.Errorf("creating payments table: %w", err)
+	}
+	return &MySQLLedger{db: db}, nil
+}
+
+// Get loads the payment recorded under a key. It reports false if there is none.
+func (l *MySQLLedger) Get(ctx context.Context, idempotencyKey string) (Payment, bool, error) {
+	row := l.db.QueryRowContext(ctx,
+		`SELECT idempotency_key, id, invoice_id, subscription_id, amount_minor, currency, status, payment_method_id, processed_at
+		FROM payments WHERE idempotency_key = ?`,
+		idempotencyKey,
+	)
+	payment, err := scanPayment(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Payment{}, false, nil
+	}
+	if err != nil {
+		return Payment{}, false, fmt.Errorf("loading payment %s: %w", idempotencyKey, err)
+	}
+	return payment, true, nil
+}
+
+// Record stores a payment unless its key is already taken
+func (l *MySQLLedger) Record(ctx context.Context, payment Payment) (Payment, bool, error) {
+	if err := payment.Validate(); err != nil {
+		return Payment{}, false, err
+	}
+
+	// INSERT IGNORE skips rows that hit the idempotency key
+	result, err := l.db.ExecContext(ctx,
+		`INSERT IGNORE INTO payments
+			(idempotency_key, id, invoice_id, subscription_id, amount_minor, currency, status, payment_method_id, processed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		payment.IdempotencyKey,
+		payment.ID,
+		payment.InvoiceID,
+		payment.SubscriptionID,
+		payment.Amount.MinorUnits(),
+		payment.Amount.Currency(),
+		payment.Status,
+		payment.PaymentMethodID,
+		payment.ProcessedAt.UTC(),
+	)
+	if err != nil {
+		return Payment{}, false, fmt.Errorf("recording payment %s: %w", payment.IdempotencyKey, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Payment{}, false, fmt.Errorf("recording payment %s: %w", payment.IdempotencyKey, err)
+	}
+	if affected == 1 {
+		return payment, true, nil
+	}
+
+	// The key was taken by an earlier attempt, which is the payment that counts
+	existing, found, err := l.Get(ctx, payment.IdempotencyKey)
+	if err != nil {
+		return Payment{}, false, err
+	}
+	if !found {
+		return Payment{}, false, fmt.Errorf("payment %s was neither recorded nor found", payment.IdempotencyKey)
+	}
+	return existing, false, nil
+}
+
+// ListByInvoice returns the payments recorded for an invoice, oldest first
+func (l *MySQLLedger) ListByInvoice(ctx context.Context, invoiceID string) ([]Payment, error) {
+	rows, err := l.db.QueryContext(ctx,
+		`SELECT idempotency_key, id, invoice_id, subscription_id, amount_minor, currency, status, payment_method_id, processed_at
+		FROM payments WHERE invoice_id = ? ORDER BY seq`,
+		invoiceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing payments for invoice %s: %w", invoiceID, err)
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("listing payments for invoice %s: %w", invoiceID, err)
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// scanPayment reads a payment from a row selected with the columns used above
+func scanPayment(row interface {
+	Scan(dest ...interface{}) error
+}) (Payment, error) {
+	var payment Payment
+	var amountMinor int64
+	var currency string
+	err := row.Scan(
+		&payment.IdempotencyKey,
+		&payment.ID,
+		&payment.InvoiceID,
+		&payment.SubscriptionID,
+		&amountMinor,
+		&currency,
+		&payment.Status,
+		&payment.PaymentMethodID,
+		&payment.ProcessedAt,
+	)
+	if err != nil {
+		return Payment{}, err
+	}
+	payment.Amount = money.New(amountMinor, currency)
+	return payment, nil
+}
@@ -0,0 +1,115 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// Payment statuses
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Payment is a charge attempt recorded in the ledger. Payments are idempotent on
+// IdempotencyKey: recording a second payment under the same key returns the first.
+type Payment struct {
+	IdempotencyKey  string
+	ID              string
+	InvoiceID       string
+	SubscriptionID  string
+	Amount          money.Money
+	Status          string
+	PaymentMethodID string
+	ProcessedAt     time.Time
+}
+
+// Validate checks that a payment has everything needed to be recorded
+func (p Payment) Validate() error {
+	if p.IdempotencyKey == "" || p.ID == "" || p.InvoiceID == "" {
+		return errors.New("payment needs an idempotency key, payment ID and invoice ID")
+	}
+	if p.Status != StatusSucceeded && p.Status != StatusFailed {
+		return fmt.Errorf("unknown payment status %q", p.Status)
+	}
+	return nil
+}
+
+// IdempotencyKey derives the key for a charge attempt on an invoice. Attempt 0 is the first charge;
+// each later attempt, such as a dunning retry, is a new charge with its own key.
+func IdempotencyKey(invoiceID string, attempt int) string {
+	if attempt <= 0 {
+		return "pay_" + invoiceID
+	}
+	return fmt.Sprintf("pay_%s_retry_%d", invoiceID, attempt)
+}
+
+// Ledger records payments by idempotency key
+type Ledger interface {
+	// Get loads the payment recorded under a key. It reports false if there is none.
+	Get(ctx context.Context, idempotencyKey string) (Payment, bool, error)
+	// Record stores a payment unless its key is already taken. It returns the payment stored
+	// under the key and reports false if that is an earlier payment rather than this one.
+	Record(ctx context.Context, payment Payment) (Payment, bool, error)
+	// ListByInvoice returns the payments recorded for an invoice, oldest first
+	ListByInvoice(ctx context.Context, invoiceID string) ([]Payment, error)
+}
+
+// MemoryLedger is an in-memory Ledger, useful for local runs and tests
+type MemoryLedger struct {
+	mu        sync.RWMutex
+	byKey     map[string]Payment
+	byInvoice map[string][]string // idempotency keys keyed by invoice ID, in recording order
+}
+
+// NewMemoryLedger creates an empty in-memory payment ledger
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{
+		byKey:     make(map[string]Payment),
+		byInvoice: make(map[string][]string),
+	}
+}
+
+// Get loads the payment recorded under a key. It reports false if there is none.
+func (l *MemoryLedger) Get(ctx context.Context, idempotencyKey string) (Payment, bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	payment, ok := l.byKey[idempotencyKey]
+	return payment, ok, nil
+}
+
+// Record stores a payment unless its key is already taken
+func (l *MemoryLedger) Record(ctx context.Context, payment Payment) (Payment, bool, error) {
+	if err := payment.Validate(); err != nil {
+		return Payment{}, false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, ok := l.byKey[payment.IdempotencyKey]; ok {
+		return existing, false, nil
+	}
+	l.byKey[payment.IdempotencyKey] = payment
+	l.byInvoice[payment.InvoiceID] = append(l.byInvoice[payment.InvoiceID], payment.IdempotencyKey)
+	return payment, true, nil
+}
+
+// ListByInvoice returns the payments recorded for an invoice, oldest first
+func (l *MemoryLedger) ListByInvoice(ctx context.Context, invoiceID string) ([]Payment, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	keys := l.byInvoice[invoiceID]
+	payments := make([]Payment, len(keys))
+	for i, key := range keys {
+		payments[i] = l.byKey[key]
+	}
+	return payments, nil
+}
@@ -0,0 +1,184 @@
+package periods
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/catalog"
+)
+
+// ErrUnknownTimeZone is returned for a time zone name that is not in the time zone database
+var ErrUnknownTimeZone = errors.New("unknown time zone")
+
+// ErrInvalidSchedule is returned when building a schedule whose anchor does not fall on its anchor day
+var ErrInvalidSchedule = errors.New("invalid billing schedule")
+
+// Period is the time range [Start, End) covered by a billing cycle
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains reports whether t falls within the period
+func (p Period) Contains(t time.Time) bool {
+	return !t.Before(p.Start) && t.Before(p.End)
+}
+
+// Schedule lays out a subscription's billing periods back to back from an anchor. Periods are
+// counted in calendar days and months in the customer's time zone, so they start at the anchor's
+// local time of day whatever the daylight saving time. Monthly, quarterly and annual periods start
+// on the anchor day, or on the last day of months shorter than it, and are each computed from the
+// anchor so that a short month does not move the periods after it. Weekly periods are 7 days long.
+//
+// A Schedule only holds names and times, so it can be kept in workflow state.
+type Schedule struct {
+	Interval catalog.Interval
+	// Anchor is when the first period, number 0, starts
+	Anchor time.Time
+	// AnchorDay is the day of the month, 1 to 31, that monthly, quarterly and annual periods start on
+	AnchorDay int
+	// TimeZone is the IANA name of the customer's time zone, such as America/New_York. Empty means UTC.
+	TimeZone string
+}
+
+// NewSchedule builds the schedule of periods of an interval starting at an anchor. An anchor day
+// of zero means the anchor's own day in the time zone; otherwise the anchor must fall on it, or on
+// the last day of its month when the month is shorter.
+func NewSchedule(interval catalog.Interval, anchor time.Time, anchorDay int, timeZone string) (Schedule, error) {
+	switch interval {
+	case catalog.IntervalWeek, catalog.IntervalMonth, catalog.IntervalQuarter, catalog.IntervalYear:
+	default:
+		return Schedule{}, fmt.Errorf("%w: unknown interval %q", ErrInvalidSchedule, interval)
+	}
+	if anchor.IsZero() {
+		return Schedule{}, fmt.Errorf("%w: no anchor", ErrInvalidSchedule)
+	}
+	loc, err := LoadLocation(timeZone)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	local := anchor.In(loc)
+	switch {
+	case anchorDay == 0:
+		anchorDay = local.Day()
+	case anchorDay < 1 || anchorDay > 31:
+		return Schedule{}, fmt.Errorf("%w: anchor day %d is not a day of the month", ErrInvalidSchedule, anchorDay)
+	case local.Day() != clampDay(local.Year(), local.Month(), anchorDay):
+		return Schedule{}, fmt.Errorf("%w: anchor %s is not on day %d of the month in %s",
+			ErrInvalidSchedule, local.Format(time.RFC3339), anchorDay, loc)
+	}
+	return Schedule{Interval: interval, Anchor: anchor, AnchorDay: anchorDay, TimeZone: timeZone}, nil
+}
+
+// LoadLocation loads a time zone by its IANA name from the time zone database embedded in the
+// binary, so that every worker computes the same periods. Empty means UTC.
+func LoadLocation(timeZone string) (*time.Location, error) {
+	if timeZone == "" {
+		return time.UTC, nil
+	}
+	loc, ok := loadEmbeddedLocation(timeZone)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTimeZone, timeZone)
+	}
+	return loc, nil
+}
+
+// AnchorDay is the day of the month of t in a time zone, the anchor day of a schedule anchored at t
+func AnchorDay(t time.Time, timeZone string) (int, error) {
+	loc, err := LoadLocation(timeZone)
+	if err != nil {
+		return 0, err
+	}
+	return t.In(loc).Day(), nil
+}
+
+// Start is when period n starts. Period 0 starts at the anchor and negative periods come before it.
+func (s Schedule) Start(n int) time.Time {
+	local := s.Anchor.In(s.location())
+	hour, minute, second := local.Clock()
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, local.Nanosecond(), local.Location())
+	}
+
+	if s.Interval == catalog.IntervalWeek {
+		return date(local.Year(), local.Month(), local.Day()+7*n)
+	}
+	months := int(local.Month()-1) + n*s.months()
+	year := local.Year() + floorDiv(months, 12)
+	month := time.Month(months-floorDiv(months, 12)*12) + 1
+	return date(year, month, clampDay(year, month, s.AnchorDay))
+}
+
+// Period is billing period n
+func (s Schedule) Period(n int) Period {
+	return Period{Start: s.Start(n), End: s.Start(n + 1)}
+}
+
+// Containing finds the billing period that t falls in, and its number
+func (s Schedule) Containing(t time.Time) (int, Period) {
+	// Estimate from the average length of a period, then step to the right one
+	average := 7 * 24 * time.Hour
+	if s.Interval != catalog.IntervalWeek {
+		average = time.Duration(s.months()) * 30 * 24 * time.Hour
+	}
+	n := int(t.Sub(s.Anchor) / average)
+	for t.Before(s.Start(n)) {
+		n--
+	}
+	for !t.Before(s.Start(n + 1)) {
+		n++
+	}
+	return n, s.Period(n)
+}
+
+// Reanchor starts the schedule over at a period boundary with another interval, keeping its anchor
+// day and time zone so that switching back lines up with the old periods. Anchoring on a day that
+// is not the anchor day, such as at the end of a weekly period, makes that day the anchor day.
+func (s Schedule) Reanchor(at time.Time, interval catalog.Interval) Schedule {
+	local := at.In(s.location())
+	anchorDay := s.AnchorDay
+	if local.Day() != clampDay(local.Year(), local.Month(), anchorDay) {
+		anchorDay = local.Day()
+	}
+	return Schedule{Interval: interval, Anchor: at, AnchorDay: anchorDay, TimeZone: s.TimeZone}
+}
+
+// location is the schedule's time zone. NewSchedule has checked that it loads; a schedule built by
+// hand with one that does not falls back to UTC.
+func (s Schedule) location() *time.Location {
+	loc, err := LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// months is the number of months in a period of the schedule's interval
+func (s Schedule) months() int {
+	switch s.Interval {
+	case catalog.IntervalQuarter:
+		return 3
+	case catalog.IntervalYear:
+		return 12
+	default:
+		return 1
+	}
+}
+
+// clampDay is the anchor day, or the month's last day when the month is shorter
+func clampDay(year int, month time.Month, anchorDay int) int {
+	// Day 0 of the next month is the last day of this one
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return min(anchorDay, last)
+}
+
+// floorDiv divides rounding toward negative infinity, so months before the anchor's year divide correctly
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,264 @@
+package periods
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/tanint/play-temporal/catalog"
+)
+
+// testTimeZones have daylight saving time on both hemispheres, a half-hour shift and none at all
+var testTimeZones = []string{"", "America/New_York", "Europe/London", "Australia/Lord_Howe", "Asia/Kolkata", "America/Sao_Paulo"}
+
+var testIntervals = []catalog.Interval{catalog.IntervalWeek, catalog.IntervalMonth, catalog.IntervalQuarter, catalog.IntervalYear}
+
+// randomSchedule is a schedule with a random interval, time zone and anchor, and a period number
+type randomSchedule struct {
+	Schedule Schedule
+	N        int
+}
+
+// Generate makes randomSchedule a quick.Generator. Anchors are often at the end of a month, and
+// their time of day is after the early hours when daylight saving time changes the clocks. Anchors
+// are from 2005 on and periods within 15 years of them, so no period starts before 1990: time zones
+// changed their offsets before then.
+func (randomSchedule) Generate(r *rand.Rand, size int) reflect.Value {
+	timeZone := testTimeZones[r.Intn(len(testTimeZones))]
+	loc, err := LoadLocation(timeZone)
+	if err != nil {
+		panic(err)
+	}
+	day := 1 + r.Intn(31)
+	if r.Intn(2) == 0 {
+		day = 28 + r.Intn(4)
+	}
+	year, month := 2005+r.Intn(40), time.Month(1+r.Intn(12))
+	anchor := time.Date(year, month, clampDay(year, month, day), 4+r.Intn(20), r.Intn(60), r.Intn(60), 0, loc)
+
+	schedule, err := NewSchedule(testIntervals[r.Intn(len(testIntervals))], anchor, day, timeZone)
+	if err != nil {
+		panic(err)
+	}
+	periodsIn15Years := 15 * map[catalog.Interval]int{
+		catalog.IntervalWeek:    52,
+		catalog.IntervalMonth:   12,
+		catalog.IntervalQuarter: 4,
+		catalog.IntervalYear:    1,
+	}[schedule.Interval]
+	return reflect.ValueOf(randomSchedule{Schedule: schedule, N: r.Intn(2*periodsIn15Years) - periodsIn15Years})
+}
+
+func checkProperty(t *testing.T, property interface{}) {
+	t.Helper()
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPeriodsAreContiguous(t *testing.T) {
+	checkProperty(t, func(rs randomSchedule) bool {
+		period, next := rs.Schedule.Period(rs.N), rs.Schedule.Period(rs.N+1)
+		return period.End.Equal(next.Start) && period.Start.Before(period.End)
+	})
+}
+
+func TestPeriodsStartOnTheAnchorDayAndTime(t *testing.T) {
+	checkProperty(t, func(rs randomSchedule) bool {
+		s := rs.Schedule
+		loc, _ := LoadLocation(s.TimeZone)
+		anchor, start := s.Anchor.In(loc), s.Start(rs.N).In(loc)
+		if start.Hour() != anchor.Hour() || start.Minute() != anchor.Minute() || start.Second() != anchor.Second() {
+			return false
+		}
+		if s.Interval == catalog.IntervalWeek {
+			return start.Weekday() == anchor.Weekday()
+		}
+		months := (start.Year()-anchor.Year())*12 + int(start.Month()-anchor.Month())
+		return start.Day() == clampDay(start.Year(), start.Month(), s.AnchorDay) && months == rs.N*s.months()
+	})
+}
+
+func TestPeriodLengths(t *testing.T) {
+	// The shortest and longest periods of each interval, in days, give or take a daylight saving hour
+	bounds := map[catalog.Interval][2]int{
+		catalog.IntervalWeek:    {7, 7},
+		catalog.IntervalMonth:   {28, 31},
+		catalog.IntervalQuarter: {89, 92},
+		catalog.IntervalYear:    {365, 366},
+	}
+	checkProperty(t, func(rs randomSchedule) bool {
+		length := rs.Schedule.Period(rs.N).End.Sub(rs.Schedule.Period(rs.N).Start)
+		bound := bounds[rs.Schedule.Interval]
+		return length >= time.Duration(bound[0])*24*time.Hour-time.Hour &&
+			length <= time.Duration(bound[1])*24*time.Hour+time.Hour
+	})
+}
+
+func TestContainingFindsThePeriod(t *testing.T) {
+	checkProperty(t, func(rs randomSchedule, offset uint32) bool {
+		period := rs.Schedule.Period(rs.N)
+		at := period.Start.Add(time.Duration(offset) * time.Second % period.End.Sub(period.Start))
+		n, found := rs.Schedule.Containing(at)
+		return n == rs.N && found.Start.Equal(period.Start) && found.End.Equal(period.End) && found.Contains(at)
+	})
+}
+
+func TestReanchoringAtABoundaryKeepsLaterPeriods(t *testing.T) {
+	checkProperty(t, func(rs randomSchedule, k uint8) bool {
+		s := rs.Schedule
+		reanchored := s.Reanchor(s.Start(rs.N), s.Interval)
+		later := int(k % 50)
+		return reanchored.Start(later).Equal(s.Start(rs.N + later))
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	utc := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+	}
+	newYork, _ := LoadLocation("America/New_York")
+
+	tests := []struct {
+		name     string
+		interval catalog.Interval
+		anchor   time.Time
+		day      int
+		timeZone string
+		starts   []time.Time
+	}{
+		{
+			name:     "monthly on the 31st",
+			interval: catalog.IntervalMonth,
+			anchor:   utc(2024, time.January, 31),
+			starts:   []time.Time{utc(2024, time.January, 31), utc(2024, time.February, 29), utc(2024, time.March, 31), utc(2024, time.April, 30)},
+		},
+		{
+			name:     "monthly on the 30th started in February",
+			interval: catalog.IntervalMonth,
+			anchor:   utc(2025, time.February, 28),
+			day:      30,
+			starts:   []time.Time{utc(2025, time.February, 28), utc(2025, time.March, 30), utc(2025, time.April, 30)},
+		},
+		{
+			name:     "quarterly on the 31st",
+			interval: catalog.IntervalQuarter,
+			anchor:   utc(2025, time.August, 31),
+			starts:   []time.Time{utc(2025, time.August, 31), utc(2025, time.November, 30), utc(2026, time.February, 28), utc(2026, time.May, 31)},
+		},
+		{
+			name:     "annual on a leap day",
+			interval: catalog.IntervalYear,
+			anchor:   utc(2024, time.February, 29),
+			starts:   []time.Time{utc(2024, time.February, 29), utc(2025, time.February, 28), utc(2026, time.February, 28), utc(2027, time.February, 28), utc(2028, time.February, 29)},
+		},
+		{
+			name:     "weekly across the end of a month",
+			interval: catalog.IntervalWeek,
+			anchor:   utc(2025, time.January, 29),
+			starts:   []time.Time{utc(2025, time.January, 29), utc(2025, time.February, 5), utc(2025, time.February, 12)},
+		},
+		{
+			// 00:30 UTC on 1 March is still 28 February in New York, and midnight there stays
+			// midnight when the clocks change
+			name:     "monthly in the customer's time zone",
+			interval: catalog.IntervalMonth,
+			anchor:   time.Date(2025, time.February, 28, 19, 30, 0, 0, newYork),
+			timeZone: "America/New_York",
+			starts: []time.Time{
+				time.Date(2025, time.March, 1, 0, 30, 0, 0, time.UTC),
+				time.Date(2025, time.March, 28, 23, 30, 0, 0, time.UTC),
+				time.Date(2025, time.April, 28, 23, 30, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := NewSchedule(tt.interval, tt.anchor, tt.day, tt.timeZone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for n, want := range tt.starts {
+				if got := schedule.Start(n); !got.Equal(want) {
+					t.Errorf("period %d starts %s, want %s", n, got, want.In(got.Location()))
+				}
+			}
+		})
+	}
+}
+
+func TestNewScheduleRejects(t *testing.T) {
+	anchor := time.Date(2025, time.March, 15, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		interval catalog.Interval
+		day      int
+		timeZone string
+		err      error
+	}{
+		{"unknown interval", "fortnight", 0, "", ErrInvalidSchedule},
+		{"anchor off the anchor day", catalog.IntervalMonth, 31, "", ErrInvalidSchedule},
+		{"anchor day out of range", catalog.IntervalMonth, 32, "", ErrInvalidSchedule},
+		{"unknown time zone", catalog.IntervalMonth, 0, "Mars/Olympus_Mons", ErrUnknownTimeZone},
+	}
+	for _, tt := range tests {
+		if _, err := NewSchedule(tt.interval, anchor, tt.day, tt.timeZone); !errors.Is(err, tt.err) {
+			t.Errorf("%s: NewSchedule = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestLoadLocationIgnoresTheSystemDatabase(t *testing.T) {
+	// A ZONEINFO database in which New York keeps UTC, as a worker with other rules might have
+	archive, err := zip.NewReader(bytes.NewReader(zoneinfoZip), int64(len(zoneinfoZip)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc, err := archive.Open("UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer utc.Close()
+	var other bytes.Buffer
+	w := zip.NewWriter(&other)
+	entry, err := w.CreateHeader(&zip.FileHeader{Name: "America/New_York", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(entry, utc); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "zoneinfo.zip")
+	if err := os.WriteFile(path, other.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZONEINFO", path)
+
+	winter := time.Date(2025, time.January, 15, 12, 0, 0, 0, time.UTC)
+	system, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, offset := winter.In(system).Zone(); offset != 0 {
+		t.Fatalf("time.LoadLocation ignored ZONEINFO: offset %d", offset)
+	}
+	loc, err := LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, offset := winter.In(loc).Zone(); offset != -5*60*60 {
+		t.Errorf("LoadLocation offset in winter = %d, want %d", offset, -5*60*60)
+	}
+}
@@ -0,0 +1,71 @@
+package periods
+
+import (
+	"archive/zip"
+	"bytes"
+	_ "embed"
+	"io"
+	"sync"
+	"time"
+)
+
+// zoneinfoZip is the IANA time zone database, copied from $GOROOT/lib/time/zoneinfo.zip. Billing
+// periods are computed in workflow code, which must give the same result on every worker and on
+// replay, so time zones are only ever loaded from this copy and never from the worker's system
+// database or ZONEINFO. Updating it changes the periods of time zones whose rules changed, so do it
+// when no subscription in those time zones is mid-period, or version the workflows that use it.
+//
+//go:embed zoneinfo.zip
+var zoneinfoZip []byte
+
+var (
+	zoneinfoOnce  sync.Once
+	zoneinfoFiles map[string]*zip.File
+	zoneinfoErr   error
+
+	// locations caches the time zones loaded, by name
+	locations sync.Map
+)
+
+// loadEmbeddedLocation loads a time zone from the embedded database, reporting false for a name
+// that is not in it
+func loadEmbeddedLocation(timeZone string) (*time.Location, bool) {
+	if loc, ok := locations.Load(timeZone); ok {
+		return loc.(*time.Location), true
+	}
+
+	zoneinfoOnce.Do(func() {
+		var archive *zip.Reader
+		archive, zoneinfoErr = zip.NewReader(bytes.NewReader(zoneinfoZip), int64(len(zoneinfoZip)))
+		if zoneinfoErr != nil {
+			return
+		}
+		zoneinfoFiles = make(map[string]*zip.File, len(archive.File))
+		for _, file := range archive.File {
+			zoneinfoFiles[file.Name] = file
+		}
+	})
+	if zoneinfoErr != nil {
+		panic("periods: embedded time zone database is corrupt: " + zoneinfoErr.Error())
+	}
+
+	file, ok := zoneinfoFiles[timeZone]
+	if !ok {
+		return nil, false
+	}
+	r, err := file.Open()
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+	loc, err := time.LoadLocationFromTZData(timeZone, data)
+	if err != nil {
+		return nil, false
+	}
+	actual, _ := locations.LoadOrStore(timeZone, loc)
+	return actual.(*time.Location), true
+}
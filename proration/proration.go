@@ -0,0 +1,66 @@
+package proration
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// Change describes switching from one price to another part-way through a billing period.
+// OldAmount and NewAmount are the prices for a full period.
+type Change struct {
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	ChangeAt    time.Time
+	OldAmount   money.Money
+	NewAmount   money.Money
+}
+
+// Result is the outcome of prorating a change
+type Result struct {
+	// Credit is the unused part of the old price, as a positive amount owed to the customer
+	Credit money.Money
+	// Charge is the part of the new price for the rest of the period
+	Charge money.Money
+	// Net is Charge minus Credit: positive for upgrades, negative for downgrades
+	Net money.Money
+	// Remaining is the time left in the period when the change takes effect
+	Remaining time.Duration
+}
+
+// Calculate prorates a change by the time left in the period, measured in whole seconds.
+// Amounts are rounded half-up to the currency's minor unit.
+func Calculate(change Change) (Result, error) {
+	if !change.PeriodEnd.After(change.PeriodStart) {
+		return Result{}, errors.New("billing period must end after it starts")
+	}
+	if change.ChangeAt.Before(change.PeriodStart) || change.ChangeAt.After(change.PeriodEnd) {
+		return Result{}, fmt.Errorf("change at %s is outside the billing period", change.ChangeAt.Format(time.RFC3339))
+	}
+	if change.OldAmount.Currency() != change.NewAmount.Currency() {
+		return Result{}, fmt.Errorf("%w: %s and %s", money.ErrCurrencyMismatch,
+			change.OldAmount.Currency(), change.NewAmount.Currency())
+	}
+
+	total := int64(change.PeriodEnd.Sub(change.PeriodStart) / time.Second)
+	remaining := int64(change.PeriodEnd.Sub(change.ChangeAt) / time.Second)
+	if total == 0 {
+		return Result{}, errors.New("billing period is shorter than a second")
+	}
+
+	credit := change.OldAmount.MulRatio(remaining, total, money.RoundHalfUp)
+	charge := change.NewAmount.MulRatio(remaining, total, money.RoundHalfUp)
+	net, err := charge.Sub(credit)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Credit:    credit,
+		Charge:    charge,
+		Net:       net,
+		Remaining: time.Duration(remaining) * time.Second,
+	}, nil
+}
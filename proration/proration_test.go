@@ -0,0 +1,168 @@
+package proration
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+func TestCalculate(t *testing.T) {
+	start := time.Date(2025, time.April, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2025, time.May, 1, 0, 0, 0, 0, time.UTC) // 30 days
+
+	tests := []struct {
+		name      string
+		change    Change
+		credit    string
+		charge    string
+		net       string
+		remaining time.Duration
+	}{
+		{
+			name: "upgrade halfway through",
+			change: Change{
+				PeriodStart: start, PeriodEnd: end,
+				ChangeAt:  start.AddDate(0, 0, 15),
+				OldAmount: money.MustParse("10.00", "USD"),
+				NewAmount: money.MustParse("30.00", "USD"),
+			},
+			credit: "5.00", charge: "15.00", net: "10.00",
+			remaining: 15 * 24 * time.Hour,
+		},
+		{
+			name: "downgrade with a third of the period left",
+			change: Change{
+				PeriodStart: start, PeriodEnd: end,
+				ChangeAt:  start.AddDate(0, 0, 20),
+				OldAmount: money.MustParse("49.99", "USD"),
+				NewAmount: money.MustParse("9.99", "USD"),
+			},
+			credit: "16.66", charge: "3.33", net: "-13.33",
+			remaining: 10 * 24 * time.Hour,
+		},
+		{
+			name: "change at period start prorates the full amounts",
+			change: Change{
+				PeriodStart: start, PeriodEnd: end,
+				ChangeAt:  start,
+				OldAmount: money.MustParse("10.00", "USD"),
+				NewAmount: money.MustParse("25.00", "USD"),
+			},
+			credit: "10.00", charge: "25.00", net: "15.00",
+			remaining: 30 * 24 * time.Hour,
+		},
+		{
+			name: "change at period end prorates nothing",
+			change: Change{
+				PeriodStart: start, PeriodEnd: end,
+				ChangeAt:  end,
+				OldAmount: money.MustParse("10.00", "USD"),
+				NewAmount: money.MustParse("25.00", "USD"),
+			},
+			credit: "0.00", charge: "0.00", net: "0.00",
+			remaining: 0,
+		},
+		{
+			name: "rounds half-up to the minor unit",
+			change: Change{
+				PeriodStart: start, PeriodEnd: end,
+				ChangeAt:  start.AddDate(0, 0, 29),
+				OldAmount: money.MustParse("0.45", "USD"),
+				NewAmount: money.MustParse("0.75", "USD"),
+			},
+			// 0.45/30 = 0.015 -> 0.02, 0.75/30 = 0.025 -> 0.03
+			credit: "0.02", charge: "0.03", net: "0.01",
+			remaining: 24 * time.Hour,
+		},
+		{
+			name: "zero-decimal currency",
+			change: Change{
+				PeriodStart: start, PeriodEnd: end,
+				ChangeAt:  start.AddDate(0, 0, 10),
+				OldAmount: money.MustParse("1000", "JPY"),
+				NewAmount: money.MustParse("3000", "JPY"),
+			},
+			credit: "667", charge: "2000", net: "1333",
+			remaining: 20 * 24 * time.Hour,
+		},
+		{
+			name: "ignores sub-second remainders",
+			change: Change{
+				PeriodStart: start, PeriodEnd: start.Add(4 * time.Second),
+				ChangeAt:  start.Add(time.Second + 500*time.Millisecond),
+				OldAmount: money.MustParse("4.00", "USD"),
+				NewAmount: money.MustParse("8.00", "USD"),
+			},
+			credit: "2.00", charge: "4.00", net: "2.00",
+			remaining: 2 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Calculate(tt.change)
+			if err != nil {
+				t.Fatalf("Calculate() error = %v", err)
+			}
+			if got := result.Credit.Amount(); got != tt.credit {
+				t.Errorf("Credit = %s, want %s", got, tt.credit)
+			}
+			if got := result.Charge.Amount(); got != tt.charge {
+				t.Errorf("Charge = %s, want %s", got, tt.charge)
+			}
+			if got := result.Net.Amount(); got != tt.net {
+				t.Errorf("Net = %s, want %s", got, tt.net)
+			}
+			if result.Remaining != tt.remaining {
+				t.Errorf("Remaining = %s, want %s", result.Remaining, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestCalculateErrors(t *testing.T) {
+	start := time.Date(2025, time.April, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	usd := money.MustParse("10.00", "USD")
+
+	tests := []struct {
+		name     string
+		change   Change
+		mismatch bool
+	}{
+		{
+			name:   "period ends before it starts",
+			change: Change{PeriodStart: end, PeriodEnd: start, ChangeAt: start, OldAmount: usd, NewAmount: usd},
+		},
+		{
+			name:   "change before the period",
+			change: Change{PeriodStart: start, PeriodEnd: end, ChangeAt: start.Add(-time.Hour), OldAmount: usd, NewAmount: usd},
+		},
+		{
+			name:   "change after the period",
+			change: Change{PeriodStart: start, PeriodEnd: end, ChangeAt: end.Add(time.Hour), OldAmount: usd, NewAmount: usd},
+		},
+		{
+			name: "different currencies",
+			change: Change{
+				PeriodStart: start, PeriodEnd: end, ChangeAt: start,
+				OldAmount: usd, NewAmount: money.MustParse("10.00", "EUR"),
+			},
+			mismatch: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Calculate(tt.change)
+			if err == nil {
+				t.Fatal("Calculate() error = nil, want an error")
+			}
+			if tt.mismatch && !errors.Is(err, money.ErrCurrencyMismatch) {
+				t.Errorf("Calculate() error = %v, want ErrCurrencyMismatch", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package render
+
+import (
+	"embed"
+	"html/template"
+	"io"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+var templates = template.Must(template.New("").
+	Funcs(template.FuncMap{"date": date}).
+	ParseFS(templateFiles, "templates/*.tmpl"))
+
+// HTML writes the invoice as an HTML page
+func HTML(w io.Writer, doc Document) error {
+	return templates.ExecuteTemplate(w, "invoice.html.tmpl", doc)
+}
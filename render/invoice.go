@@ -0,0 +1,128 @@
+package render
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/exchange"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/payments"
+	"github.com/tanint/play-temporal/tax"
+)
+
+// Document is an invoice as it is rendered: its line items, tax, discounts and whether it has been
+// paid. It is built from the billing activities' invoice and payment details.
+type Document struct {
+	InvoiceID      string
+	Number         string
+	SubscriptionID string
+	CustomerID     string
+	PlanName       string
+	IssuedAt       time.Time
+	DueDate        time.Time
+	// Lines are the invoice's items, with discounts and credit as negative amounts
+	Lines    []Line
+	CouponID string
+	Tax      tax.Summary
+	// ExchangeRate is the rate the plan's price was converted at, zero when it was not converted
+	ExchangeRate exchange.Rate
+	// AmountDue is what is left to pay after the customer's credit balance
+	AmountDue money.Money
+	// PaymentStatus is the status of the invoice's payment, empty when none was attempted
+	PaymentStatus string
+	PaymentID     string
+	DeclineCode   string
+}
+
+// Line is a line item on a rendered invoice
+type Line struct {
+	Description string
+	Quantity    int64
+	Amount      money.Money
+}
+
+// Total is a row of the totals under the line items
+type Total struct {
+	Label  string
+	Amount money.Money
+	// Emphasized marks the amount due, which is printed in bold
+	Emphasized bool
+}
+
+// Reference is how the invoice is referred to: its number, or its ID while it is a draft and has none
+func (d Document) Reference() string {
+	if d.Number != "" {
+		return d.Number
+	}
+	return d.InvoiceID
+}
+
+// Totals lists the subtotal, each tax rate, the total, credit applied and the amount due
+func (d Document) Totals() []Total {
+	var totals []Total
+	if d.Tax.Total.Currency() != "" {
+		totals = append(totals, Total{Label: "Subtotal", Amount: d.Tax.Subtotal})
+		for _, line := range d.Tax.Lines {
+			label := fmt.Sprintf("%s (%s%%)", line.Name, line.Percent)
+			if d.Tax.Inclusive {
+				label = "Includes " + label
+			}
+			totals = append(totals, Total{Label: label, Amount: line.Amount})
+		}
+		totals = append(totals, Total{Label: "Total", Amount: d.Tax.Total})
+
+		if credit, err := d.AmountDue.Sub(d.Tax.Total); err == nil && !credit.IsZero() {
+			totals = append(totals, Total{Label: "Credit applied", Amount: credit})
+		}
+	}
+	return append(totals, Total{Label: "Amount due", Amount: d.AmountDue, Emphasized: true})
+}
+
+// Notes are the remarks printed under the totals: the coupon, tax exemption and currency conversion
+func (d Document) Notes() []string {
+	var notes []string
+	if d.CouponID != "" {
+		notes = append(notes, fmt.Sprintf("Coupon %s applied.", d.CouponID))
+	}
+	if d.Tax.Exempt {
+		note := "Exempt from tax"
+		if d.Tax.ExemptionReason != "" {
+			note += ": " + d.Tax.ExemptionReason
+		}
+		notes = append(notes, note+".")
+	}
+	if !d.ExchangeRate.IsZero() {
+		notes = append(notes, fmt.Sprintf("Prices converted at 1 %s = %s %s (%s, as of %s).",
+			d.ExchangeRate.From, d.ExchangeRate.Rate, d.ExchangeRate.To,
+			d.ExchangeRate.Source, d.ExchangeRate.AsOf.Format("2006-01-02")))
+	}
+	return notes
+}
+
+// PaymentLabel describes whether the invoice has been paid
+func (d Document) PaymentLabel() string {
+	switch d.PaymentStatus {
+	case payments.StatusSucceeded:
+		return fmt.Sprintf("Paid (%s)", d.PaymentID)
+	case payments.StatusFailed:
+		if d.DeclineCode != "" {
+			return fmt.Sprintf("Payment failed (%s)", d.DeclineCode)
+		}
+		return "Payment failed"
+	case "":
+		if d.AmountDue.IsZero() {
+			return "Nothing to pay"
+		}
+		return "Awaiting payment"
+	default:
+		return "Payment " + d.PaymentStatus
+	}
+}
+
+// date formats a date for an invoice, leaving unknown dates blank
+func date(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2 Jan 2006")
+}
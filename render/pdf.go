@@ -0,0 +1,255 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The PDF is laid out on A4 pages in the standard Type 1 fonts every PDF reader has, so no font
+// needs to be embedded. The line items and totals are set in Courier to line up their columns.
+const (
+	pageWidth    = 595
+	pageHeight   = 842
+	margin       = 56
+	footerHeight = 24
+
+	// Column widths, in Courier characters, of the line items and totals
+	descriptionWidth = 48
+	quantityWidth    = 8
+	amountWidth      = 20
+	labelWidth       = descriptionWidth + quantityWidth
+	tableWidth       = labelWidth + amountWidth
+	noteWidth        = 100
+)
+
+// pdfFont is one of the standard fonts, by its resource name on every page
+type pdfFont string
+
+const (
+	fontHeading   pdfFont = "F1"
+	fontBody      pdfFont = "F2"
+	fontTable     pdfFont = "F3"
+	fontTableBold pdfFont = "F4"
+)
+
+var pdfFonts = []struct {
+	name     pdfFont
+	baseFont string
+}{
+	{fontHeading, "Helvetica-Bold"},
+	{fontBody, "Helvetica"},
+	{fontTable, "Courier"},
+	{fontTableBold, "Courier-Bold"},
+}
+
+// pdfLine is a line of text, flowed down the page beneath the previous one
+type pdfLine struct {
+	font pdfFont
+	size float64
+	text string
+}
+
+// PDF writes the invoice as a PDF document. The output depends only on the document, so rendering
+// the same invoice again produces the same bytes.
+func PDF(w io.Writer, doc Document) error {
+	pages := paginate(pdfLines(doc))
+
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 and 2 are the catalog and the page tree, followed by the fonts and then a content
+	// stream and page object for every page
+	firstPage := 3 + len(pdfFonts)
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i+1)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+
+	var fonts []string
+	for i, font := range pdfFonts {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.baseFont))
+		fonts = append(fonts, fmt.Sprintf("/%s %d 0 R", font.name, 3+i))
+	}
+
+	for i, page := range pages {
+		content := pageContent(page, i+1, len(pages))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, strings.Join(fonts, " "), firstPage+2*i))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfLines lays the invoice out as lines of text, in the same order as the HTML page
+func pdfLines(doc Document) []pdfLine {
+	lines := []pdfLine{
+		{fontHeading, 20, "Invoice"},
+		{fontBody, 11, doc.PaymentLabel()},
+		{},
+	}
+
+	detail := func(label, value string) {
+		if value != "" {
+			lines = append(lines, pdfLine{fontTable, 10, padRight(label, 14) + value})
+		}
+	}
+	detail("Invoice", doc.Reference())
+	detail("Subscription", doc.SubscriptionID)
+	detail("Customer", doc.CustomerID)
+	detail("Plan", doc.PlanName)
+	detail("Issued", date(doc.IssuedAt))
+	detail("Due", date(doc.DueDate))
+	lines = append(lines, pdfLine{})
+
+	lines = append(lines,
+		pdfLine{fontTableBold, 10, padRight("Description", descriptionWidth) + padLeft("Quantity", quantityWidth) + padLeft("Amount", amountWidth)},
+		pdfLine{fontTable, 10, strings.Repeat("-", tableWidth)},
+	)
+	for _, item := range doc.Lines {
+		description := wrap(item.Description, descriptionWidth-2)
+		lines = append(lines, pdfLine{fontTable, 10,
+			padRight(description[0], descriptionWidth) + padLeft(strconv.FormatInt(item.Quantity, 10), quantityWidth) + padLeft(item.Amount.String(), amountWidth)})
+		for _, rest := range description[1:] {
+			lines = append(lines, pdfLine{fontTable, 10, rest})
+		}
+	}
+	lines = append(lines, pdfLine{fontTable, 10, strings.Repeat("-", tableWidth)})
+
+	for _, total := range doc.Totals() {
+		font := fontTable
+		if total.Emphasized {
+			font = fontTableBold
+		}
+		lines = append(lines, pdfLine{font, 10, padRight(total.Label, labelWidth) + padLeft(total.Amount.String(), amountWidth)})
+	}
+
+	if notes := doc.Notes(); len(notes) > 0 {
+		lines = append(lines, pdfLine{})
+		for _, note := range notes {
+			for _, text := range wrap(note, noteWidth) {
+				lines = append(lines, pdfLine{fontBody, 9, text})
+			}
+		}
+	}
+	return lines
+}
+
+// paginate splits lines into pages, starting a new page when the next line would run into the footer
+func paginate(lines []pdfLine) [][]pdfLine {
+	var pages [][]pdfLine
+	var page []pdfLine
+	y := float64(pageHeight - margin)
+	for _, line := range lines {
+		height := leading(line)
+		if y-height < margin+footerHeight && len(page) > 0 {
+			pages = append(pages, page)
+			page, y = nil, pageHeight-margin
+		}
+		page = append(page, line)
+		y -= height
+	}
+	return append(pages, page)
+}
+
+// pageContent is the content stream drawing a page's lines and its page number
+func pageContent(lines []pdfLine, number, count int) string {
+	var content strings.Builder
+	y := float64(pageHeight - margin)
+	for _, line := range lines {
+		y -= leading(line)
+		if line.text != "" {
+			fmt.Fprintf(&content, "BT /%s %g Tf %d %g Td (%s) Tj ET\n", line.font, line.size, margin, y, pdfString(line.text))
+		}
+	}
+	fmt.Fprintf(&content, "BT /%s 8 Tf %d %d Td (%s) Tj ET", fontBody, margin, margin, pdfString(fmt.Sprintf("Page %d of %d", number, count)))
+	return content.String()
+}
+
+// leading is the height a line takes up, with blank lines as a half-height gap
+func leading(line pdfLine) float64 {
+	if line.text == "" && line.size == 0 {
+		return 7
+	}
+	return line.size * 1.4
+}
+
+// pdfString escapes text for a PDF string literal in WinAnsiEncoding. Characters the encoding
+// shares with Latin-1 are kept, any others are replaced with a question mark.
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// wrap breaks text into lines of at most width characters, at spaces where it can
+func wrap(text string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		for utf8.RuneCountInString(word) > width {
+			if line != "" {
+				lines, line = append(lines, line), ""
+			}
+			runes := []rune(word)
+			lines, word = append(lines, string(runes[:width])), string(runes[width:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines, line = append(lines, line), word
+		}
+	}
+	return append(lines, line)
+}
+
+func padRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+func padLeft(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return strings.Repeat(" ", width-n) + s
+	}
+	return s
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/tax"
+)
+
+func testDocument() Document {
+	return Document{
+		InvoiceID:      "inv_1",
+		SubscriptionID: "sub_1",
+		CustomerID:     "cust_au",
+		PlanName:       "Premium <Monthly>",
+		IssuedAt:       time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC),
+		DueDate:        time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC),
+		Lines: []Line{
+			{Description: "Premium Monthly (Monthly subscription)", Quantity: 1, Amount: money.MustParse("49.99", "USD")},
+			{Description: "Coupon WELCOME20 (20% off)", Quantity: 1, Amount: money.MustParse("-10.00", "USD")},
+			{Description: "GST (10%)", Quantity: 1, Amount: money.MustParse("4.00", "USD")},
+			{Description: "Applied customer credit", Quantity: 1, Amount: money.MustParse("-5.00", "USD")},
+		},
+		CouponID: "WELCOME20",
+		Tax: tax.Summary{
+			Jurisdiction: "AU",
+			Lines:        []tax.Line{{Name: "GST", Percent: money.MustDecimal("10"), Amount: money.MustParse("4.00", "USD")}},
+			Subtotal:     money.MustParse("39.99", "USD"),
+			Tax:          money.MustParse("4.00", "USD"),
+			Total:        money.MustParse("43.99", "USD"),
+		},
+		AmountDue:     money.MustParse("38.99", "USD"),
+		PaymentStatus: "succeeded",
+		PaymentID:     "pay_1",
+	}
+}
+
+func TestHTML(t *testing.T) {
+	var out bytes.Buffer
+	if err := HTML(&out, testDocument()); err != nil {
+		t.Fatalf("HTML failed: %v", err)
+	}
+	page := out.String()
+
+	for _, want := range []string{
+		"Invoice inv_1",
+		"Paid (pay_1)",
+		"Premium &lt;Monthly&gt;",
+		"-10.00 USD",
+		"GST (10%)",
+		"Credit applied",
+		"38.99 USD",
+		"Coupon WELCOME20 applied.",
+		"9 Jun 2025",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML is missing %q", want)
+		}
+	}
+}
+
+func TestPDF(t *testing.T) {
+	var first, second bytes.Buffer
+	if err := PDF(&first, testDocument()); err != nil {
+		t.Fatalf("PDF failed: %v", err)
+	}
+	if err := PDF(&second, testDocument()); err != nil {
+		t.Fatalf("PDF failed: %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("rendering the same invoice twice produced different PDFs")
+	}
+
+	pdf := first.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatalf("PDF is missing its header or trailer")
+	}
+	checkXref(t, pdf)
+	for _, want := range []string{"(Paid \\(pay_1\\)) Tj", "38.99 USD", "Page 1 of 1"} {
+		if !strings.Contains(pdf, want) {
+			t.Errorf("PDF is missing %q", want)
+		}
+	}
+}
+
+func TestPDFPaginatesLongInvoices(t *testing.T) {
+	doc := testDocument()
+	for i := 0; i < 120; i++ {
+		doc.Lines = append(doc.Lines, Line{Description: fmt.Sprintf("Usage on day %d", i), Quantity: 1, Amount: money.MustParse("0.10", "USD")})
+	}
+
+	var out bytes.Buffer
+	if err := PDF(&out, doc); err != nil {
+		t.Fatalf("PDF failed: %v", err)
+	}
+	pdf := out.String()
+	checkXref(t, pdf)
+	if !strings.Contains(pdf, "/Count 3") || !strings.Contains(pdf, "Page 3 of 3") {
+		t.Errorf("long invoice was not split into 3 pages")
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := map[string]string{
+		"a (b) c\\d": "a \\(b\\) c\\\\d",
+		"café":       "caf\\351",
+		"5 €":        "5 ?",
+	}
+	for in, want := range tests {
+		if got := pdfString(in); got != want {
+			t.Errorf("pdfString(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// checkXref checks that every object offset in the cross-reference table points at its object
+func checkXref(t *testing.T, pdf string) {
+	t.Helper()
+	start, err := strconv.Atoi(regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(pdf)[1])
+	if err != nil || !strings.HasPrefix(pdf[start:], "xref\n") {
+		t.Fatalf("startxref does not point at the xref table")
+	}
+	for i, entry := range regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(pdf[start:], -1) {
+		offset, _ := strconv.Atoi(entry[1])
+		if want := fmt.Sprintf("%d 0 obj", i+1); !strings.HasPrefix(pdf[offset:], want) {
+			t.Errorf("xref entry %d points at %q, want %q", i+1, pdf[offset:offset+10], want)
+		}
+	}
+}
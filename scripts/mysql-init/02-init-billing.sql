@@ -0,0 +1,8 @@
+-- Create billing database used by the subscription activities
+CREATE DATABASE IF NOT EXISTS billing;
+
+-- Grant all privileges to temporal user for the billing database
+GRANT ALL PRIVILEGES ON billing.* TO 'temporal'@'%';
+
+-- Apply changes
+FLUSH PRIVILEGES;
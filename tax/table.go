@@ -0,0 +1,182 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanint/play-temporal/money"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownJurisdiction is returned when a customer's jurisdiction is not in the rule table
+var ErrUnknownJurisdiction = errors.New("unknown tax jurisdiction")
+
+// Rate is a percentage tax levied in a jurisdiction
+type Rate struct {
+	Name    string        `yaml:"name" json:"name"`
+	Percent money.Decimal `yaml:"percent" json:"percent"`
+}
+
+// Jurisdiction is the set of rates a customer located there pays. Inclusive jurisdictions
+// price plans with tax included, exclusive ones add the tax on top of the price.
+type Jurisdiction struct {
+	Code      string `yaml:"code" json:"code"`
+	Name      string `yaml:"name" json:"name"`
+	Inclusive bool   `yaml:"inclusive,omitempty" json:"inclusive,omitempty"`
+	Rates     []Rate `yaml:"rates,omitempty" json:"rates,omitempty"`
+}
+
+// Customer places a customer in a jurisdiction and may exempt them from tax
+type Customer struct {
+	ID              string `yaml:"id" json:"id"`
+	Jurisdiction    string `yaml:"jurisdiction,omitempty" json:"jurisdiction,omitempty"`
+	Exempt          bool   `yaml:"exempt,omitempty" json:"exempt,omitempty"`
+	ExemptionReason string `yaml:"exemption_reason,omitempty" json:"exemption_reason,omitempty"`
+}
+
+// Table is the built-in Calculator. It taxes every customer at the rates of their jurisdiction,
+// or of DefaultJurisdiction for customers it does not list.
+type Table struct {
+	DefaultJurisdiction string         `yaml:"default_jurisdiction" json:"default_jurisdiction"`
+	Jurisdictions       []Jurisdiction `yaml:"jurisdictions" json:"jurisdictions"`
+	Customers           []Customer     `yaml:"customers,omitempty" json:"customers,omitempty"`
+
+	byCode     map[string]Jurisdiction
+	byCustomer map[string]Customer
+}
+
+// Load reads a rule table from a YAML or JSON file, chosen by the file extension, and validates it
+func Load(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading tax rules: %w", err)
+	}
+
+	var t Table
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &t)
+	case ".json":
+		err = json.Unmarshal(data, &t)
+	default:
+		return nil, fmt.Errorf("unsupported tax rules format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing tax rules %s: %w", path, err)
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid tax rules %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Validate checks every jurisdiction and customer and indexes them
+func (t *Table) Validate() error {
+	byCode := make(map[string]Jurisdiction, len(t.Jurisdictions))
+	for _, jurisdiction := range t.Jurisdictions {
+		if jurisdiction.Code == "" {
+			return errors.New("jurisdiction is missing a code")
+		}
+		if _, exists := byCode[jurisdiction.Code]; exists {
+			return fmt.Errorf("duplicate jurisdiction %q", jurisdiction.Code)
+		}
+		for _, rate := range jurisdiction.Rates {
+			if rate.Name == "" {
+				return fmt.Errorf("jurisdiction %q: rate is missing a name", jurisdiction.Code)
+			}
+			if rate.Percent.Sign() < 0 {
+				return fmt.Errorf("jurisdiction %q: %s rate must not be negative", jurisdiction.Code, rate.Name)
+			}
+		}
+		byCode[jurisdiction.Code] = jurisdiction
+	}
+	if _, ok := byCode[t.DefaultJurisdiction]; !ok {
+		return fmt.Errorf("default jurisdiction %q is not in the table", t.DefaultJurisdiction)
+	}
+
+	byCustomer := make(map[string]Customer, len(t.Customers))
+	for _, customer := range t.Customers {
+		if customer.ID == "" {
+			return errors.New("customer is missing an ID")
+		}
+		if _, exists := byCustomer[customer.ID]; exists {
+			return fmt.Errorf("duplicate customer %q", customer.ID)
+		}
+		if _, ok := byCode[customer.Jurisdiction]; customer.Jurisdiction != "" && !ok {
+			return fmt.Errorf("customer %q: %w %q", customer.ID, ErrUnknownJurisdiction, customer.Jurisdiction)
+		}
+		byCustomer[customer.ID] = customer
+	}
+
+	t.byCode = byCode
+	t.byCustomer = byCustomer
+	return nil
+}
+
+// Calculate taxes the request at the rates of the customer's jurisdiction. Each rate's tax is
+// rounded half-up to the currency's minor unit.
+func (t *Table) Calculate(ctx context.Context, request Request) (Summary, error) {
+	customer, ok := t.byCustomer[request.CustomerID]
+	if !ok || customer.Jurisdiction == "" {
+		customer.Jurisdiction = t.DefaultJurisdiction
+	}
+	jurisdiction, ok := t.byCode[customer.Jurisdiction]
+	if !ok {
+		return Summary{}, fmt.Errorf("%w: %s", ErrUnknownJurisdiction, customer.Jurisdiction)
+	}
+
+	summary := Summary{
+		Jurisdiction: jurisdiction.Code,
+		Inclusive:    jurisdiction.Inclusive,
+		Subtotal:     request.Amount,
+		Tax:          money.Zero(request.Amount.Currency()),
+		Total:        request.Amount,
+	}
+	if customer.Exempt {
+		summary.Exempt = true
+		summary.ExemptionReason = customer.ExemptionReason
+		return summary, nil
+	}
+
+	// An inclusive amount is 100% plus every rate, so each rate's share of it is percent / (100 + total)
+	base := big.NewRat(100, 1)
+	if jurisdiction.Inclusive {
+		for _, rate := range jurisdiction.Rates {
+			base.Add(base, rate.Percent.Rat())
+		}
+	}
+
+	var err error
+	for _, rate := range jurisdiction.Rates {
+		factor := new(big.Rat).Quo(rate.Percent.Rat(), base)
+		line := Line{
+			Name:    rate.Name,
+			Percent: rate.Percent,
+			Amount:  request.Amount.MulDecimal(money.DecimalFromRat(factor), money.RoundHalfUp),
+		}
+		if summary.Tax, err = summary.Tax.Add(line.Amount); err != nil {
+			return Summary{}, err
+		}
+		summary.Lines = append(summary.Lines, line)
+	}
+
+	if jurisdiction.Inclusive {
+		summary.Subtotal, err = request.Amount.Sub(summary.Tax)
+	} else {
+		summary.Total, err = request.Amount.Add(summary.Tax)
+	}
+	if err != nil {
+		return Summary{}, err
+	}
+	for i := range summary.Lines {
+		summary.Lines[i].Taxable = summary.Subtotal
+	}
+	return summary, nil
+}
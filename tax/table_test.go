@@ -0,0 +1,117 @@
+package tax
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+func TestTableCalculate(t *testing.T) {
+	table, err := Load("../config/tax.yaml")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		customerID string
+		amount     string
+		subtotal   string
+		tax        string
+		total      string
+		lines      int
+	}{
+		{
+			name:       "unlisted customer uses the default jurisdiction",
+			customerID: "cust123", amount: "49.99",
+			subtotal: "49.99", tax: "0.00", total: "49.99",
+		},
+		{
+			name:       "exclusive GST is added on top",
+			customerID: "cust_au", amount: "49.99",
+			subtotal: "49.99", tax: "5.00", total: "54.99", lines: 1,
+		},
+		{
+			name:       "inclusive VAT is worked out of the price",
+			customerID: "cust_uk", amount: "49.99",
+			subtotal: "41.66", tax: "8.33", total: "49.99", lines: 1,
+		},
+		{
+			name:       "exempt customer pays no tax",
+			customerID: "cust_charity", amount: "49.99",
+			subtotal: "49.99", tax: "0.00", total: "49.99",
+		},
+	}
+
+	for _, tt := range tests {
+		summary, err := table.Calculate(context.Background(), Request{
+			CustomerID: tt.customerID,
+			Amount:     money.MustParse(tt.amount, "USD"),
+		})
+		if err != nil {
+			t.Fatalf("%s: Calculate failed: %v", tt.name, err)
+		}
+		if summary.Subtotal != money.MustParse(tt.subtotal, "USD") ||
+			summary.Tax != money.MustParse(tt.tax, "USD") ||
+			summary.Total != money.MustParse(tt.total, "USD") {
+			t.Errorf("%s: subtotal %s + tax %s = %s, want %s + %s = %s", tt.name,
+				summary.Subtotal, summary.Tax, summary.Total, tt.subtotal, tt.tax, tt.total)
+		}
+		if len(summary.Lines) != tt.lines {
+			t.Errorf("%s: %d tax lines, want %d", tt.name, len(summary.Lines), tt.lines)
+		}
+	}
+}
+
+func TestTableCalculateInclusiveWithSeveralRates(t *testing.T) {
+	table := &Table{
+		DefaultJurisdiction: "X",
+		Jurisdictions: []Jurisdiction{{
+			Code:      "X",
+			Inclusive: true,
+			Rates: []Rate{
+				{Name: "GST", Percent: money.MustDecimal("5")},
+				{Name: "PST", Percent: money.MustDecimal("7")},
+			},
+		}},
+	}
+	if err := table.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 112.00 is 100.00 plus 5% and 7% of it
+	summary, err := table.Calculate(context.Background(), Request{CustomerID: "c", Amount: money.MustParse("112.00", "CAD")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Lines[0].Amount != money.MustParse("5.00", "CAD") || summary.Lines[1].Amount != money.MustParse("7.00", "CAD") {
+		t.Errorf("tax lines = %+v, want CAD 5.00 and CAD 7.00", summary.Lines)
+	}
+	if summary.Subtotal != money.MustParse("100.00", "CAD") {
+		t.Errorf("subtotal = %s, want CAD 100.00", summary.Subtotal)
+	}
+}
+
+func TestTableValidate(t *testing.T) {
+	tables := map[string]Table{
+		"missing default jurisdiction": {
+			DefaultJurisdiction: "GB",
+			Jurisdictions:       []Jurisdiction{{Code: "US"}},
+		},
+		"customer in unknown jurisdiction": {
+			DefaultJurisdiction: "US",
+			Jurisdictions:       []Jurisdiction{{Code: "US"}},
+			Customers:           []Customer{{ID: "c", Jurisdiction: "FR"}},
+		},
+		"negative rate": {
+			DefaultJurisdiction: "US",
+			Jurisdictions:       []Jurisdiction{{Code: "US", Rates: []Rate{{Name: "Sales tax", Percent: money.MustDecimal("-1")}}}},
+		},
+	}
+	for name, table := range tables {
+		if err := table.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded, want an error", name)
+		}
+	}
+}
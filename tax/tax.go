@@ -0,0 +1,42 @@
+package tax
+
+import (
+	"context"
+
+	"github.com/tanint/play-temporal/money"
+)
+
+// Request is an amount to be taxed for a customer. The amount is the invoice's charges after
+// discounts, including tax already when the customer's jurisdiction prices inclusive of tax.
+type Request struct {
+	CustomerID string
+	Amount     money.Money
+}
+
+// Line is the tax charged at one rate
+type Line struct {
+	Name    string        // e.g. VAT or GST
+	Percent money.Decimal // e.g. 20 for 20%
+	Taxable money.Money
+	Amount  money.Money
+}
+
+// Summary is the tax on a request. Subtotal + Tax = Total, where Total is the request's amount
+// for inclusive pricing and Subtotal is for exclusive pricing.
+type Summary struct {
+	Jurisdiction string
+	// Inclusive is true when the request's amount already included the tax
+	Inclusive bool
+	// Exempt is true when the customer does not pay tax, with the reason in ExemptionReason
+	Exempt          bool
+	ExemptionReason string
+	Lines           []Line
+	Subtotal        money.Money
+	Tax             money.Money
+	Total           money.Money
+}
+
+// Calculator works out the tax on an invoice
+type Calculator interface {
+	Calculate(ctx context.Context, request Request) (Summary, error)
+}
@@ -0,0 +1,85 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const createUsageEventsTable = `
+CREATE TABLE IF NOT EXISTS usage_events (
+	seq             BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	subscription_id VARCHAR(64)  NOT NULL,
+	meter           VARCHAR(64)  NOT NULL,
+	event_id        VARCHAR(128) NOT NULL,
+	quantity        BIGINT       NOT NULL,
+	occurred_at     DATETIME(6)  NOT NULL,
+	recorded_at     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE KEY uq_usage_events_event (subscription_id, meter, event_id),
+	INDEX idx_usage_events_period (subscription_id, meter, occurred_at)
+)`
+
+// MySQLStore is a Store backed by a MySQL table
+type MySQLStore struct {
+	db *sql.DB
+}
+
+// NewMySQLStore creates a MySQL-backed usage store and makes sure its table exists
+func NewMySQLStore(ctx context.Context, db *sql.DB) (*MySQLStore, error) {
+	if _, err := db.ExecContext(ctx, createUsageEventsTable); err != nil {
+		return nil, fmt.Errorf("creating usage_events table: %w", err)
+	}
+	return &MySQLStore{db: db}, nil
+}
+
+// Record stores an event. It reports false if the event was already recorded.
+func (s *MySQLStore) Record(ctx context.Context, event Event) (bool, error) {
+	if err := event.Validate(); err != nil {
+		return false, err
+	}
+
+	// A row that hits the unique event key is left as it is and counts as no rows affected.
+	// Unlike INSERT IGNORE, this still reports every other error, such as an out-of-range value.
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO usage_events (subscription_id, meter, event_id, quantity, occurred_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE seq = seq`,
+		event.SubscriptionID, event.Meter, event.EventID, event.Quantity, event.Timestamp.UTC(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("recording usage event %s: %w", event.EventID, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("recording usage event %s: %w", event.EventID, err)
+	}
+	return affected == 1, nil
+}
+
+// Aggregate combines the events of a meter with timestamps in [start, end)
+func (s *MySQLStore) Aggregate(ctx context.Context, subscriptionID, meter string, aggregation Aggregation, start, end time.Time) (int64, error) {
+	var query string
+	switch aggregation {
+	case AggregationSum:
+		query = `SELECT COALESCE(SUM(quantity), 0) FROM usage_events
+			WHERE subscription_id = ? AND meter = ? AND occurred_at >= ? AND occurred_at < ?`
+	case AggregationMax:
+		query = `SELECT COALESCE(MAX(quantity), 0) FROM usage_events
+			WHERE subscription_id = ? AND meter = ? AND occurred_at >= ? AND occurred_at < ?`
+	case AggregationLast:
+		query = `SELECT COALESCE((SELECT quantity FROM usage_events
+			WHERE subscription_id = ? AND meter = ? AND occurred_at >= ? AND occurred_at < ?
+			ORDER BY occurred_at DESC, seq DESC LIMIT 1), 0)`
+	default:
+		return 0, aggregation.Validate()
+	}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, query, subscriptionID, meter, start.UTC(), end.UTC()).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("aggregating %s usage for subscription %s: %w", meter, subscriptionID, err)
+	}
+	return total, nil
+}
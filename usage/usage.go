@@ -0,0 +1,146 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Aggregation determines how the events of a meter are combined over a billing period
+type Aggregation string
+
+const (
+	// AggregationSum adds up every event, e.g. API calls
+	AggregationSum Aggregation = "sum"
+	// AggregationMax takes the highest reported value, e.g. peak storage
+	AggregationMax Aggregation = "max"
+	// AggregationLast takes the most recently reported value, e.g. current storage
+	AggregationLast Aggregation = "last"
+)
+
+// Validate checks that the aggregation is one of the supported kinds
+func (a Aggregation) Validate() error {
+	switch a {
+	case AggregationSum, AggregationMax, AggregationLast:
+		return nil
+	}
+	return fmt.Errorf("unknown usage aggregation %q", a)
+}
+
+// Event is a single usage report. Events are idempotent on
+// (SubscriptionID, Meter, EventID): reporting the same event twice records it once.
+type Event struct {
+	SubscriptionID string
+	Meter          string
+	EventID        string
+	Quantity       int64
+	Timestamp      time.Time
+}
+
+// Validate checks that an event has everything needed to be recorded
+func (e Event) Validate() error {
+	if e.SubscriptionID == "" || e.Meter == "" || e.EventID == "" {
+		return errors.New("usage event needs a subscription ID, meter and event ID")
+	}
+	if e.Quantity < 0 {
+		return errors.New("usage quantity must not be negative")
+	}
+	if e.Timestamp.IsZero() {
+		return errors.New("usage event needs a timestamp")
+	}
+	return nil
+}
+
+// Store records usage events and aggregates them per billing period
+type Store interface {
+	// Record stores an event. It reports false if the event was already recorded.
+	Record(ctx context.Context, event Event) (bool, error)
+	// Aggregate combines the events of a meter with timestamps in [start, end)
+	Aggregate(ctx context.Context, subscriptionID, meter string, aggregation Aggregation, start, end time.Time) (int64, error)
+}
+
+// Aggregate combines events in the order they were recorded
+func Aggregate(events []Event, aggregation Aggregation) int64 {
+	var total int64
+	var latest time.Time
+	for _, event := range events {
+		switch aggregation {
+		case AggregationSum:
+			total += event.Quantity
+		case AggregationMax:
+			if event.Quantity > total {
+				total = event.Quantity
+			}
+		case AggregationLast:
+			if !event.Timestamp.Before(latest) {
+				total = event.Quantity
+				latest = event.Timestamp
+			}
+		}
+	}
+	return total
+}
+
+type eventKey struct {
+	subscriptionID string
+	meter          string
+	eventID        string
+}
+
+// MemoryStore is an in-memory Store, useful for local runs and tests
+type MemoryStore struct {
+	mu     sync.RWMutex
+	seen   map[eventKey]bool
+	events map[string][]Event // keyed by subscription ID
+}
+
+// NewMemoryStore creates an empty in-memory usage store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		seen:   make(map[eventKey]bool),
+		events: make(map[string][]Event),
+	}
+}
+
+// Record stores an event. It reports false if the event was already recorded.
+func (s *MemoryStore) Record(ctx context.Context, event Event) (bool, error) {
+	if err := event.Validate(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := eventKey{event.SubscriptionID, event.Meter, event.EventID}
+	if s.seen[key] {
+		return false, nil
+	}
+	s.seen[key] = true
+	s.events[event.SubscriptionID] = append(s.events[event.SubscriptionID], event)
+	return true, nil
+}
+
+// Aggregate combines the events of a meter with timestamps in [start, end)
+func (s *MemoryStore) Aggregate(ctx context.Context, subscriptionID, meter string, aggregation Aggregation, start, end time.Time) (int64, error) {
+	if err := aggregation.Validate(); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	var matching []Event
+	for _, event := range s.events[subscriptionID] {
+		if event.Meter == meter && !event.Timestamp.Before(start) && event.Timestamp.Before(end) {
+			matching = append(matching, event)
+		}
+	}
+	s.mu.RUnlock()
+
+	// Keep recording order for events reported with the same timestamp
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].Timestamp.Before(matching[j].Timestamp)
+	})
+	return Aggregate(matching, aggregation), nil
+}
@@ -0,0 +1,120 @@
+package usage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreAggregate(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	at := func(days int) time.Time { return start.AddDate(0, 0, days) }
+
+	store := NewMemoryStore()
+	for _, event := range []Event{
+		{Meter: "storage_gb", EventID: "e1", Quantity: 40, Timestamp: at(3)},
+		// Reported late, after an event that happened later
+		{Meter: "storage_gb", EventID: "e2", Quantity: 70, Timestamp: at(20)},
+		{Meter: "storage_gb", EventID: "e3", Quantity: 90, Timestamp: at(10)},
+		// Two reports for the same moment, the later recorded one wins for last
+		{Meter: "storage_gb", EventID: "e4", Quantity: 55, Timestamp: at(25)},
+		{Meter: "storage_gb", EventID: "e5", Quantity: 50, Timestamp: at(25)},
+		// Outside the period: the end is exclusive and the start inclusive
+		{Meter: "storage_gb", EventID: "e6", Quantity: 500, Timestamp: end},
+		{Meter: "storage_gb", EventID: "e7", Quantity: 500, Timestamp: start.Add(-time.Second)},
+		{Meter: "storage_gb", EventID: "e8", Quantity: 5, Timestamp: start},
+		// Another meter and another subscription
+		{Meter: "api_calls", EventID: "e1", Quantity: 1000, Timestamp: at(3)},
+		{SubscriptionID: "sub_other", Meter: "storage_gb", EventID: "e1", Quantity: 1000, Timestamp: at(3)},
+	} {
+		if event.SubscriptionID == "" {
+			event.SubscriptionID = "sub_usage"
+		}
+		if _, err := store.Record(context.Background(), event); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		meter       string
+		aggregation Aggregation
+		want        int64
+	}{
+		{meter: "storage_gb", aggregation: AggregationSum, want: 40 + 70 + 90 + 55 + 50 + 5},
+		{meter: "storage_gb", aggregation: AggregationMax, want: 90},
+		{meter: "storage_gb", aggregation: AggregationLast, want: 50},
+		{meter: "api_calls", aggregation: AggregationSum, want: 1000},
+		{meter: "unused", aggregation: AggregationSum},
+		{meter: "unused", aggregation: AggregationMax},
+		{meter: "unused", aggregation: AggregationLast},
+	}
+	for _, tt := range tests {
+		got, err := store.Aggregate(context.Background(), "sub_usage", tt.meter, tt.aggregation, start, end)
+		if err != nil {
+			t.Fatalf("Aggregate(%s, %s) failed: %v", tt.meter, tt.aggregation, err)
+		}
+		if got != tt.want {
+			t.Errorf("Aggregate(%s, %s) = %d, want %d", tt.meter, tt.aggregation, got, tt.want)
+		}
+	}
+
+	if _, err := store.Aggregate(context.Background(), "sub_usage", "storage_gb", "avg", start, end); err == nil {
+		t.Error("Aggregate with an unknown aggregation succeeded")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	t0 := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Quantity: 3, Timestamp: t0},
+		{Quantity: 0, Timestamp: t0.Add(2 * time.Hour)},
+		{Quantity: 8, Timestamp: t0.Add(time.Hour)},
+	}
+
+	tests := []struct {
+		aggregation Aggregation
+		events      []Event
+		want        int64
+	}{
+		{aggregation: AggregationSum, events: events, want: 11},
+		{aggregation: AggregationMax, events: events, want: 8},
+		// The latest event reported zero
+		{aggregation: AggregationLast, events: events, want: 0},
+		{aggregation: AggregationSum},
+		{aggregation: AggregationMax},
+		{aggregation: AggregationLast},
+	}
+	for _, tt := range tests {
+		if got := Aggregate(tt.events, tt.aggregation); got != tt.want {
+			t.Errorf("Aggregate(%d events, %s) = %d, want %d", len(tt.events), tt.aggregation, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreRecordIsIdempotent(t *testing.T) {
+	store := NewMemoryStore()
+	event := Event{SubscriptionID: "sub_usage", Meter: "api_calls", EventID: "e1", Quantity: 10, Timestamp: time.Now()}
+
+	for i, want := range []bool{true, false} {
+		recorded, err := store.Record(context.Background(), event)
+		if err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+		if recorded != want {
+			t.Errorf("Record attempt %d reported %t, want %t", i+1, recorded, want)
+		}
+	}
+
+	total, err := store.Aggregate(context.Background(), "sub_usage", "api_calls", AggregationSum, time.Time{}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if total != 10 {
+		t.Errorf("total after recording the event twice = %d, want 10", total)
+	}
+
+	if _, err := store.Record(context.Background(), Event{SubscriptionID: "sub_usage", Meter: "api_calls", EventID: "e2", Quantity: -1, Timestamp: time.Now()}); err == nil {
+		t.Error("Record with a negative quantity succeeded")
+	}
+}
@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Attempt is the outcome of one delivery of an event to an endpoint
+type Attempt struct {
+	Number     int           `json:"number"`
+	At         time.Time     `json:"at"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"status_code,omitempty"` // zero when no response was received
+	Error      string        `json:"error,omitempty"`       // empty when the endpoint accepted the event
+}
+
+// Succeeded reports whether the endpoint accepted the event
+func (a Attempt) Succeeded() bool {
+	return a.Error == ""
+}
+
+// Sender POSTs signed events to endpoints
+type Sender struct {
+	client *http.Client
+}
+
+// NewSender creates a sender. A nil client uses one with a 10 second timeout.
+func NewSender(client *http.Client) *Sender {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Sender{client: client}
+}
+
+// Send POSTs an event to an endpoint as JSON, signed with the endpoint's secret. Any 2xx response
+// means the endpoint accepted it; anything else, or no response, is returned as a failed attempt.
+func (s *Sender) Send(ctx context.Context, endpoint Endpoint, event Event) Attempt {
+	attempt := Attempt{At: time.Now()}
+	fail := func(err error) Attempt {
+		attempt.Duration = time.Since(attempt.At)
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fail(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "play-temporal-webhooks/1.0")
+	req.Header.Set(IDHeader, event.ID)
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, attempt.At, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fail(fmt.Errorf("endpoint responded %s", resp.Status))
+	}
+	attempt.Duration = time.Since(attempt.At)
+	return attempt
+}
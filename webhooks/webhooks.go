@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrEndpointNotFound is returned when no endpoint is configured with an ID
+var ErrEndpointNotFound = errors.New("webhook endpoint not found")
+
+// Event types sent to endpoints
+const (
+	SubscriptionCreated       = "subscription.created"
+	SubscriptionStatusChanged = "subscription.status_changed"
+	InvoiceCreated            = "invoice.created"
+	PaymentSucceeded          = "payment.succeeded"
+	PaymentFailed             = "payment.failed"
+	BillingCycleCompleted     = "billing_cycle.completed"
+	BillingCycleSkipped       = "billing_cycle.skipped"
+)
+
+// EventTypes lists every event type
+var EventTypes = []string{
+	SubscriptionCreated,
+	SubscriptionStatusChanged,
+	InvoiceCreated,
+	PaymentSucceeded,
+	PaymentFailed,
+	BillingCycleCompleted,
+	BillingCycleSkipped,
+}
+
+// Event is a billing event, sent as the JSON body of a webhook
+type Event struct {
+	ID             string          `json:"id"`
+	Type           string          `json:"type"`
+	CreatedAt      time.Time       `json:"created_at"`
+	SubscriptionID string          `json:"subscription_id,omitempty"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// NewEvent creates an event with data encoded as JSON
+func NewEvent(id, eventType string, createdAt time.Time, subscriptionID string, data any) (Event, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("encoding %s event: %w", eventType, err)
+	}
+	return Event{
+		ID:             id,
+		Type:           eventType,
+		CreatedAt:      createdAt.UTC(),
+		SubscriptionID: subscriptionID,
+		Data:           encoded,
+	}, nil
+}
+
+// Endpoint is a URL that receives events, signed with its secret
+type Endpoint struct {
+	ID     string `yaml:"id" json:"id"`
+	URL    string `yaml:"url" json:"url"`
+	Secret string `yaml:"secret" json:"secret"`
+	// Events are the event types sent to the endpoint. Empty sends every event.
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"`
+}
+
+// Receives reports whether the endpoint is sent events of a type
+func (e Endpoint) Receives(eventType string) bool {
+	return len(e.Events) == 0 || slices.Contains(e.Events, eventType)
+}
+
+// Target is an endpoint an event is delivered to, without its secret, which workflows never see
+type Target struct {
+	EndpointID string
+	URL        string
+}
+
+// Config is the list of endpoints, stored in a local file
+type Config struct {
+	Endpoints []Endpoint `yaml:"endpoints" json:"endpoints"`
+
+	byID map[string]Endpoint
+}
+
+// Load reads the endpoints from a YAML or JSON file, chosen by the file extension, and validates them
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading webhook endpoints: %w", err)
+	}
+
+	var c Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &c)
+	case ".json":
+		err = json.Unmarshal(data, &c)
+	default:
+		return nil, fmt.Errorf("unsupported webhook endpoints format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing webhook endpoints %s: %w", path, err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid webhook endpoints %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Validate checks every endpoint and indexes them by ID
+func (c *Config) Validate() error {
+	byID := make(map[string]Endpoint, len(c.Endpoints))
+	for _, endpoint := range c.Endpoints {
+		if endpoint.ID == "" {
+			return errors.New("endpoint is missing an ID")
+		}
+		if _, exists := byID[endpoint.ID]; exists {
+			return fmt.Errorf("duplicate endpoint %q", endpoint.ID)
+		}
+		if u, err := url.Parse(endpoint.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint %q: invalid URL %q", endpoint.ID, endpoint.URL)
+		}
+		if endpoint.Secret == "" {
+			return fmt.Errorf("endpoint %q is missing a signing secret", endpoint.ID)
+		}
+		for _, eventType := range endpoint.Events {
+			if !slices.Contains(EventTypes, eventType) {
+				return fmt.Errorf("endpoint %q: unknown event type %q", endpoint.ID, eventType)
+			}
+		}
+		byID[endpoint.ID] = endpoint
+	}
+	c.byID = byID
+	return nil
+}
+
+// Endpoint returns the endpoint with an ID
+func (c *Config) Endpoint(id string) (Endpoint, error) {
+	endpoint, ok := c.byID[id]
+	if !ok {
+		return Endpoint{}, fmt.Errorf("%w: %s", ErrEndpointNotFound, id)
+	}
+	return endpoint, nil
+}
+
+// Targets lists the endpoints sent events of a type
+func (c *Config) Targets(eventType string) []Target {
+	var targets []Target
+	for _, endpoint := range c.Endpoints {
+		if endpoint.Receives(eventType) {
+			targets = append(targets, Target{EndpointID: endpoint.ID, URL: endpoint.URL})
+		}
+	}
+	return targets
+}
@@ -0,0 +1,99 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	signedAt := time.Unix(1700000000, 0)
+	header := Sign("whsec_test", signedAt, body)
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   string
+		now    time.Time
+		valid  bool
+	}{
+		{name: "valid", secret: "whsec_test", header: header, body: string(body), now: signedAt.Add(time.Minute), valid: true},
+		{name: "wrong secret", secret: "whsec_other", header: header, body: string(body), now: signedAt},
+		{name: "tampered body", secret: "whsec_test", header: header, body: `{"id":"evt_2"}`, now: signedAt},
+		{name: "replayed later", secret: "whsec_test", header: header, body: string(body), now: signedAt.Add(time.Hour)},
+		{name: "malformed header", secret: "whsec_test", header: "v1=abc", body: string(body), now: signedAt},
+	}
+	for _, tt := range tests {
+		err := Verify(tt.secret, tt.header, []byte(tt.body), 5*time.Minute, tt.now)
+		if tt.valid && err != nil {
+			t.Errorf("%s: Verify failed: %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: Verify = %v, want ErrInvalidSignature", tt.name, err)
+		}
+	}
+}
+
+func TestSenderSignsEvents(t *testing.T) {
+	endpoint := Endpoint{ID: "test", Secret: "whsec_test"}
+	var verifyErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = Verify(endpoint.Secret, r.Header.Get(SignatureHeader), body, time.Minute, time.Now())
+		if r.Header.Get(IDHeader) == "evt_fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	endpoint.URL = server.URL
+
+	sender := NewSender(server.Client())
+	event, err := NewEvent("evt_1", InvoiceCreated, time.Now(), "sub_1", map[string]string{"id": "inv_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempt := sender.Send(context.Background(), endpoint, event)
+	if !attempt.Succeeded() || attempt.StatusCode != http.StatusOK {
+		t.Errorf("attempt = %+v, want success", attempt)
+	}
+	if verifyErr != nil {
+		t.Errorf("receiver could not verify the signature: %v", verifyErr)
+	}
+
+	event.ID = "evt_fail"
+	if attempt := sender.Send(context.Background(), endpoint, event); attempt.Succeeded() || attempt.StatusCode != http.StatusInternalServerError {
+		t.Errorf("attempt = %+v, want a failed attempt with status 500", attempt)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	configs := map[string]Config{
+		"missing secret":     {Endpoints: []Endpoint{{ID: "a", URL: "https://example.com/hook"}}},
+		"invalid URL":        {Endpoints: []Endpoint{{ID: "a", URL: "example.com/hook", Secret: "s"}}},
+		"unknown event type": {Endpoints: []Endpoint{{ID: "a", URL: "https://example.com/hook", Secret: "s", Events: []string{"invoice.deleted"}}}},
+		"duplicate endpoint": {Endpoints: []Endpoint{
+			{ID: "a", URL: "https://example.com/a", Secret: "s"},
+			{ID: "a", URL: "https://example.com/b", Secret: "s"},
+		}},
+	}
+	for name, config := range configs {
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded, want an error", name)
+		}
+	}
+
+	config, err := Load("../config/webhooks.yaml")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if targets := config.Targets(PaymentFailed); len(targets) != 1 || targets[0].EndpointID != "local" {
+		t.Errorf("Targets(%s) = %v, want the local endpoint", PaymentFailed, targets)
+	}
+}
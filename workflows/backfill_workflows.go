@@ -0,0 +1,194 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/webhooks"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// BackfillStatusQuery is the query that reports the progress of a BackfillBillingWorkflow
+const BackfillStatusQuery = "get_backfill_status"
+
+// MaxBackfillPeriods is the most billing periods one BackfillBillingWorkflow bills, which keeps
+// its history well within Temporal's limits. Longer ranges are backfilled a piece at a time.
+const MaxBackfillPeriods = 100
+
+// BackfillBillingParams contains parameters for the billing backfill workflow
+type BackfillBillingParams struct {
+	SubscriptionID string
+	// From and To bound the billing dates to backfill: the periods that ended after From and by
+	// To, or by now if To is later or zero. A zero From starts at the subscription's first period.
+	From time.Time
+	To   time.Time
+	// DryRun lists the periods that would be billed without billing them
+	DryRun bool
+}
+
+// BackfilledCycle is a billing period billed by a BackfillBillingWorkflow
+type BackfilledCycle struct {
+	Period        activities.BillingPeriod
+	InvoiceID     string
+	PaymentStatus string
+}
+
+// BackfillBillingState is the progress of a BackfillBillingWorkflow, reported by BackfillStatusQuery
+type BackfillBillingState struct {
+	SubscriptionID string
+	DryRun         bool
+	Unbilled       []activities.BillingPeriod // the periods in the range that had no invoice, oldest first
+	Billed         []BackfilledCycle
+	Stopped        string // why some unbilled periods were not billed, empty when all were
+	Error          string
+}
+
+// BackfillBillingWorkflow bills the billing periods of a subscription that were missed, such as
+// while no worker was running on a billing date. It lays out the subscription's periods on its
+// plan's interval, finds those in the date range that no invoice charges for, and runs the billing
+// cycle for each in order, as of the period's own dates rather than now. A failed payment stops the
+// backfill, since dunning takes the subscription over. Nothing is billed while the subscription's
+// entity workflow is running, since it bills the subscription itself. Run it with the ID
+// backfill-billing-<subscription ID> so that only one backfills a subscription at a time.
+func BackfillBillingWorkflow(ctx workflow.Context, params BackfillBillingParams) (BackfillBillingState, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("BackfillBillingWorkflow started",
+		"subscriptionID", params.SubscriptionID,
+		"from", params.From,
+		"to", params.To,
+		"dryRun", params.DryRun)
+
+	// Configure activity options as for recurring billing
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout:    30 * time.Second,
+		ScheduleToStartTimeout: time.Minute,
+		ScheduleToCloseTimeout: 2 * time.Minute,
+		HeartbeatTimeout:       10 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+			// Declines are handled by dunning, not by retrying the charge
+			NonRetryableErrorTypes: activities.PaymentNonRetryableErrorTypes,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	state := BackfillBillingState{SubscriptionID: params.SubscriptionID, DryRun: params.DryRun}
+
+	// Set up a query handler to report backfill progress
+	err := workflow.SetQueryHandler(ctx, BackfillStatusQuery, func() (BackfillBillingState, error) {
+		return state, nil
+	})
+	if err != nil {
+		logger.Error("Failed to register query handler", "error", err)
+		return state, err
+	}
+
+	// fail records why the backfill stopped
+	fail := func(err error) (BackfillBillingState, error) {
+		state.Error = err.Error()
+		logger.Error("BackfillBillingWorkflow failed", "subscriptionID", params.SubscriptionID, "error", err)
+		return state, err
+	}
+
+	// Periods that have not ended yet are billed when they do
+	to := params.To
+	if now := workflow.Now(ctx); to.IsZero() || to.After(now) {
+		to = now
+	}
+	if !params.From.Before(to) {
+		return fail(temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("backfill range from %s to %s is empty", params.From.Format(time.RFC3339), to.Format(time.RFC3339)),
+			"InvalidBackfillRange", nil))
+	}
+
+	// Step 1: Load the subscription and its plan, and lay out its billing periods
+	var subscription activities.SubscriptionDetails
+	err = workflow.ExecuteActivity(ctx, activities.LoadSubscriptionActivity, params.SubscriptionID).Get(ctx, &subscription)
+	if err != nil {
+		return fail(err)
+	}
+	var plan catalog.Plan
+	err = workflow.ExecuteActivity(ctx, activities.LoadPlanActivity, subscription.PlanID).Get(ctx, &plan)
+	if err != nil {
+		return fail(err)
+	}
+	schedule, err := subscriptionSchedule(subscription, plan.Interval)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Step 2: Find the periods that ended in the range, from the subscription's first, and that
+	// no invoice charges for
+	n, _ := schedule.Containing(params.From)
+	n = max(n, 0)
+	var periods []activities.BillingPeriod
+	for period := schedule.Period(n); !period.End.After(to); period = schedule.Period(n) {
+		if len(periods) == MaxBackfillPeriods {
+			return fail(temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("backfill range has more than %d billing periods; backfill it a piece at a time", MaxBackfillPeriods),
+				"InvalidBackfillRange", nil))
+		}
+		periods = append(periods, period)
+		n++
+	}
+	err = workflow.ExecuteActivity(ctx, activities.FindUnbilledPeriodsActivity, subscription.ID, periods).Get(ctx, &state.Unbilled)
+	if err != nil {
+		return fail(err)
+	}
+	logger.Info("Found unbilled periods", "subscriptionID", subscription.ID, "periods", len(periods), "unbilled", len(state.Unbilled))
+
+	// A dry run stops here. Trialing, paused and ended subscriptions are not billed.
+	switch {
+	case params.DryRun || len(state.Unbilled) == 0:
+		return state, nil
+	case subscription.Status == lifecycle.StatusTrialing,
+		subscription.Status == lifecycle.StatusPaused,
+		subscription.Status.Terminal():
+		state.Stopped = "subscription is " + string(subscription.Status)
+		logger.Info("Skipping backfill", "subscriptionID", subscription.ID, "status", subscription.Status)
+		return state, nil
+	}
+
+	// A subscription with a running entity workflow is billed by it, and billing its periods here
+	// as well could invoice one twice
+	if subscriptionEntityRunning(ctx, subscription.ID) {
+		state.Stopped = "subscription is billed by its entity workflow"
+		logger.Warn("Refusing to backfill a subscription billed by its entity workflow", "subscriptionID", subscription.ID)
+		return state, nil
+	}
+
+	// Step 3: Bill each unbilled period in order, finalizing its invoice right away
+	for _, period := range state.Unbilled {
+		logger.Info("Backfilling billing period", "subscriptionID", subscription.ID, "periodStart", period.Start, "periodEnd", period.End)
+
+		invoice, payment, _, err := runBillingCycle(ctx, subscription, period, nil, activities.CouponRedemption{}, nil)
+		if err != nil && !paymentFailed(err) {
+			return fail(err)
+		}
+		state.Billed = append(state.Billed, BackfilledCycle{Period: period, InvoiceID: invoice.ID, PaymentStatus: payment.Status})
+		_, next := schedule.Containing(period.End)
+		emitEvent(ctx, webhooks.BillingCycleCompleted, subscription.ID, BillingCycle{
+			SubscriptionID:  subscription.ID,
+			Period:          period,
+			InvoiceID:       invoice.ID,
+			PaymentStatus:   payment.Status,
+			NextBillingDate: next.End,
+		})
+		if err != nil {
+			state.Stopped = "payment failed for invoice " + invoice.ID
+			logger.Info("Stopping backfill for dunning", "subscriptionID", subscription.ID, "invoiceID", invoice.ID)
+			return state, nil
+		}
+		subscription.Status = lifecycle.StatusActive
+	}
+
+	logger.Info("BackfillBillingWorkflow completed", "subscriptionID", subscription.ID, "billed", len(state.Billed))
+	return state, nil
+}
@@ -0,0 +1,128 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/lifecycle"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestBackfillBillingFindsUnbilledPeriods(t *testing.T) {
+	month := func(m time.Month) activities.BillingPeriod {
+		return activities.BillingPeriod{
+			Start: time.Date(2025, m, 15, 9, 0, 0, 0, time.UTC),
+			End:   time.Date(2025, m+1, 15, 9, 0, 0, 0, time.UTC),
+		}
+	}
+
+	// February is billed, March's invoice was voided, and April only has a proration invoice
+	// for part of it
+	store := activities.NewMemoryInvoiceStore()
+	activities.SetInvoiceStore(store)
+	t.Cleanup(func() { activities.SetInvoiceStore(activities.NewMemoryInvoiceStore()) })
+	prorated := month(time.April)
+	prorated.Start = prorated.Start.AddDate(0, 0, 5)
+	for _, invoice := range []activities.InvoiceDetails{
+		{ID: "inv_feb", Status: invoices.StatusOpen, Period: month(time.February), Items: []activities.InvoiceItem{{Period: month(time.February)}}},
+		{ID: "inv_mar", Status: invoices.StatusVoid, Period: month(time.March), Items: []activities.InvoiceItem{{Period: month(time.March)}}},
+		{ID: "inv_apr", Status: invoices.StatusPaid, Period: month(time.April), Items: []activities.InvoiceItem{{Period: prorated}}},
+		{ID: "inv_other", SubscriptionID: "sub_other", Status: invoices.StatusPaid, Period: month(time.May)},
+	} {
+		if invoice.SubscriptionID == "" {
+			invoice.SubscriptionID = "sub_backfill"
+		}
+		if err := store.SaveInvoice(context.Background(), invoice); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		dryRun        bool
+		status        lifecycle.Status
+		entityRunning bool
+		stopped       string
+	}{
+		{name: "dry run", dryRun: true, status: lifecycle.StatusActive},
+		{name: "paused subscription", status: lifecycle.StatusPaused, stopped: "subscription is paused"},
+		{name: "billed by the entity workflow", status: lifecycle.StatusActive, entityRunning: true, stopped: "subscription is billed by its entity workflow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestWorkflowEnvironment()
+			env.RegisterWorkflow(BackfillBillingWorkflow)
+			env.RegisterActivity(activities.FindUnbilledPeriodsActivity)
+			env.RegisterActivityWithOptions(func(ctx context.Context, subscriptionID string) (activities.SubscriptionDetails, error) {
+				return activities.SubscriptionDetails{
+					ID:         subscriptionID,
+					PlanID:     "premium-monthly",
+					StartDate:  month(time.January).Start,
+					BillingDay: 15,
+					Status:     tt.status,
+				}, nil
+			}, activity.RegisterOptions{Name: "LoadSubscriptionActivity"})
+			env.RegisterActivityWithOptions(func(ctx context.Context, planID string) (catalog.Plan, error) {
+				return catalog.Plan{ID: planID, Interval: catalog.IntervalMonth}, nil
+			}, activity.RegisterOptions{Name: "LoadPlanActivity"})
+
+			if tt.entityRunning {
+				env.OnSignalExternalWorkflow("default-test-namespace", "subscription-sub_backfill", "", SubscriptionChangedSignalName, nil).Return(nil).Once()
+			}
+
+			// June has not ended yet
+			env.SetStartTime(time.Date(2025, time.June, 20, 0, 0, 0, 0, time.UTC))
+			env.ExecuteWorkflow(BackfillBillingWorkflow, BackfillBillingParams{
+				SubscriptionID: "sub_backfill",
+				From:           time.Date(2024, time.December, 1, 0, 0, 0, 0, time.UTC),
+				DryRun:         tt.dryRun,
+			})
+
+			var state BackfillBillingState
+			if err := env.GetWorkflowResult(&state); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			want := []activities.BillingPeriod{month(time.January), month(time.March), month(time.April), month(time.May)}
+			if len(state.Unbilled) != len(want) {
+				t.Fatalf("unbilled periods = %v, want %v", state.Unbilled, want)
+			}
+			for i := range want {
+				if !state.Unbilled[i].Start.Equal(want[i].Start) || !state.Unbilled[i].End.Equal(want[i].End) {
+					t.Errorf("unbilled period %d = %v, want %v", i, state.Unbilled[i], want[i])
+				}
+			}
+			if len(state.Billed) != 0 || state.Stopped != tt.stopped {
+				t.Errorf("billed %v and stopped %q, want nothing billed and stopped %q", state.Billed, state.Stopped, tt.stopped)
+			}
+		})
+	}
+}
+
+func TestBackfillBillingRejectsLongRanges(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(BackfillBillingWorkflow)
+	env.RegisterActivityWithOptions(func(ctx context.Context, subscriptionID string) (activities.SubscriptionDetails, error) {
+		start := time.Date(2020, time.January, 6, 0, 0, 0, 0, time.UTC)
+		return activities.SubscriptionDetails{ID: subscriptionID, PlanID: "weekly", StartDate: start, Status: lifecycle.StatusActive}, nil
+	}, activity.RegisterOptions{Name: "LoadSubscriptionActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, planID string) (catalog.Plan, error) {
+		return catalog.Plan{ID: planID, Interval: catalog.IntervalWeek}, nil
+	}, activity.RegisterOptions{Name: "LoadPlanActivity"})
+
+	env.SetStartTime(time.Date(2025, time.June, 20, 0, 0, 0, 0, time.UTC))
+	env.ExecuteWorkflow(BackfillBillingWorkflow, BackfillBillingParams{SubscriptionID: "sub_weekly", DryRun: true})
+
+	var appErr *temporal.ApplicationError
+	if err := env.GetWorkflowError(); !errors.As(err, &appErr) || appErr.Type() != "InvalidBackfillRange" {
+		t.Errorf("backfilling 5 years of weekly periods = %v, want an InvalidBackfillRange error", err)
+	}
+}
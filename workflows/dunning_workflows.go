@@ -0,0 +1,331 @@
+package workflows
+
+import (
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/lifecycle"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// DefaultDunningRetryDays is the retry schedule used when none is configured:
+// days after the failed payment on which the charge is retried
+var DefaultDunningRetryDays = []int{1, 3, 7, 14}
+
+// PaymentUpdatedSignalName is the signal sent when the customer updates their payment method
+const PaymentUpdatedSignalName = "payment_updated"
+
+// Dunning outcomes
+const (
+	DunningRecovered = "recovered"
+	// DunningPaymentUpdated means the charge succeeded on a payment method the customer updated
+	DunningPaymentUpdated = "payment_updated"
+	DunningCanceled       = "canceled"
+	// DunningStopped means the subscription ended outside dunning, which stopped without charging
+	DunningStopped = "stopped"
+)
+
+// DunningParams contains parameters for the dunning workflow
+type DunningParams struct {
+	Subscription activities.SubscriptionDetails
+	Invoice      activities.InvoiceDetails
+	// RetryDays are the days after the failed payment on which to retry the charge
+	RetryDays []int
+	// UnpaidAfterRetries is how many retries may fail before the subscription is marked unpaid.
+	// Zero means half of the retries.
+	UnpaidAfterRetries int
+}
+
+// PaymentUpdatedSignal carries the payment method the customer switched to
+type PaymentUpdatedSignal struct {
+	PaymentMethodID string
+}
+
+// DunningState is the progress of a dunning run, exposed through the get_dunning_state query
+type DunningState struct {
+	Status      lifecycle.Status
+	Attempts    int
+	NextRetryAt time.Time
+	Outcome     string
+	Payment     activities.PaymentDetails
+}
+
+// startDunning starts a DunningWorkflow as an abandoned child so it keeps retrying
+// after the billing workflow that found the failed payment has completed
+func startDunning(ctx workflow.Context, subscription activities.SubscriptionDetails, invoice activities.InvoiceDetails) error {
+	childOptions := workflow.ChildWorkflowOptions{
+		WorkflowID:        "dunning-" + invoice.ID,
+		ParentClosePolicy: enums.PARENT_CLOSE_POLICY_ABANDON,
+	}
+	childCtx := workflow.WithChildOptions(ctx, childOptions)
+
+	params := DunningParams{
+		Subscription: subscription,
+		Invoice:      invoice,
+		RetryDays:    DefaultDunningRetryDays,
+	}
+
+	// Only wait for the child to start; it may run for weeks
+	child := workflow.ExecuteChildWorkflow(childCtx, DunningWorkflow, params)
+	return child.GetChildWorkflowExecution().Get(ctx, nil)
+}
+
+// cancelDunning requests cancellation of the dunning workflow of an invoice. An invoice may have
+// no dunning workflow running, so a failed request is only logged.
+func cancelDunning(ctx workflow.Context, invoiceID string) {
+	err := workflow.RequestCancelExternalWorkflow(ctx, "dunning-"+invoiceID, "").Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Info("No dunning workflow to cancel", "invoiceID", invoiceID, "error", err)
+	}
+}
+
+// DunningWorkflow retries a failed payment on a schedule, sending escalating reminders and
+// moving the subscription from past_due to unpaid to canceled. A payment_updated signal stores the
+// new payment method and charges it right away; if that charge fails too, the remaining retries
+// go on against the new method, and if the method cannot be stored they go on against the old one. It stops as soon as a charge succeeds, and cancels right away when
+// a charge is refused as suspected fraud. The invoice is marked paid when a retry succeeds and uncollectible when the
+// subscription is canceled. The subscription is reloaded before each charge, and dunning stops
+// without charging once it has ended some other way; it also stops when the workflow is canceled,
+// as the entity workflow does when the subscription is canceled.
+func DunningWorkflow(ctx workflow.Context, params DunningParams) (DunningState, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("DunningWorkflow started",
+		"subscriptionID", params.Subscription.ID,
+		"invoiceID", params.Invoice.ID)
+
+	// Configure activity options
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+			// A declined retry waits for the next date on the schedule
+			NonRetryableErrorTypes: activities.PaymentNonRetryableErrorTypes,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	retryDays := params.RetryDays
+	if len(retryDays) == 0 {
+		retryDays = DefaultDunningRetryDays
+	}
+	unpaidAfter := params.UnpaidAfterRetries
+	if unpaidAfter <= 0 {
+		unpaidAfter = len(retryDays) / 2
+	}
+
+	subscription := params.Subscription
+	state := DunningState{Status: lifecycle.StatusPastDue}
+
+	// Set up a query handler to report dunning progress
+	err := workflow.SetQueryHandler(ctx, "get_dunning_state", func() (DunningState, error) {
+		return state, nil
+	})
+	if err != nil {
+		logger.Error("Failed to register query handler", "error", err)
+		return state, err
+	}
+
+	// setStatus moves the subscription to a new dunning status
+	setStatus := func(status lifecycle.Status) error {
+		err := workflow.ExecuteActivity(ctx, activities.UpdateSubscriptionStatusActivity, subscription.ID, status).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+		emitStatusChange(ctx, subscription.ID, state.Status, status, "dunning for invoice "+params.Invoice.ID)
+		notifySubscriptionEntity(ctx, subscription.ID)
+		state.Status = status
+		return nil
+	}
+
+	// remind sends a reminder of the given severity for the upcoming retry
+	remind := func(severity string, nextRetryAt time.Time) {
+		reminder := activities.PaymentReminder{
+			InvoiceID:      params.Invoice.ID,
+			SubscriptionID: subscription.ID,
+			CustomerID:     subscription.CustomerID,
+			Amount:         params.Invoice.Amount,
+			Attempt:        state.Attempts,
+			Severity:       severity,
+			DeclineCode:    state.Payment.DeclineCode,
+			NextRetryAt:    nextRetryAt,
+		}
+		err := workflow.ExecuteActivity(ctx, activities.SendPaymentReminderEmailActivity, reminder).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Failed to send payment reminder", "error", err)
+			// Continue despite email failure
+		}
+	}
+
+	// charge retries the payment and reports whether it succeeded, sending a receipt if it did.
+	// Each retry is a new charge attempt with its own idempotency key. A card suspected of fraud
+	// is not retried again. A subscription that has ended, such as by being canceled, is not charged.
+	fraudSuspected, ended := false, false
+	charge := func() (bool, error) {
+		var current activities.SubscriptionDetails
+		err := workflow.ExecuteActivity(ctx, activities.LoadSubscriptionActivity, subscription.ID).Get(ctx, &current)
+		if err != nil {
+			return false, err
+		}
+		if current.Status.Terminal() {
+			logger.Info("Subscription ended outside dunning, not charging", "status", current.Status)
+			state.Status = current.Status
+			ended = true
+			return false, nil
+		}
+
+		state.Attempts++
+		payment, err := processPayment(ctx, params.Invoice, subscription, state.Attempts)
+		state.Payment = payment
+		switch paymentErrorType(err) {
+		case "":
+			if err != nil {
+				return false, err
+			}
+			if err := markInvoice(ctx, &params.Invoice, invoices.StatusPaid); err != nil {
+				return false, err
+			}
+			sendReceipt(ctx, params.Invoice, subscription, payment)
+			return true, nil
+		case activities.FraudSuspectedErrorType:
+			fraudSuspected = true
+		}
+		return false, nil
+	}
+
+	// Step 1: Mark the subscription past due and send the first notice
+	if err := setStatus(lifecycle.StatusPastDue); err != nil {
+		logger.Error("Failed to update subscription status", "error", err)
+		return state, err
+	}
+	failedAt := workflow.Now(ctx)
+	state.NextRetryAt = failedAt.Add(time.Duration(retryDays[0]) * 24 * time.Hour)
+	remind(activities.ReminderNotice, state.NextRetryAt)
+
+	// Step 2: Retry on schedule until a charge succeeds or the schedule runs out. A payment
+	// method update is charged as soon as it arrives, and the schedule goes on if that fails.
+	paymentUpdated := workflow.GetSignalChannel(ctx, PaymentUpdatedSignalName)
+	for i := range retryDays {
+		state.NextRetryAt = failedAt.Add(time.Duration(retryDays[i]) * 24 * time.Hour)
+
+		var succeeded, updated bool
+		for {
+			update := waitForRetry(ctx, paymentUpdated, state.NextRetryAt)
+			if ctx.Err() != nil {
+				logger.Info("DunningWorkflow canceled", "invoiceID", params.Invoice.ID, "attempts", state.Attempts)
+				state.Outcome = DunningStopped
+				state.NextRetryAt = time.Time{}
+				return state, temporal.NewCanceledError()
+			}
+			if update == nil {
+				succeeded, err = charge()
+				break
+			}
+
+			logger.Info("Payment method updated, charging it", "paymentMethodID", update.PaymentMethodID)
+			err = workflow.ExecuteActivity(ctx, activities.UpdatePaymentMethodActivity, subscription.ID, update.PaymentMethodID).Get(ctx, nil)
+			if err != nil {
+				// A payment method that cannot be used is the customer's to fix, so the retries go on
+				// against the one they had
+				logger.Warn("Failed to update payment method, resuming dunning",
+					"paymentMethodID", update.PaymentMethodID, "nextRetryAt", state.NextRetryAt, "error", err)
+				err = nil
+				remind(activities.ReminderNotice, state.NextRetryAt)
+				continue
+			}
+			subscription.PaymentMethodID = update.PaymentMethodID
+			updated = true
+			if succeeded, err = charge(); err != nil || succeeded || fraudSuspected || ended {
+				break
+			}
+			logger.Info("Charge on the updated payment method failed, resuming dunning", "nextRetryAt", state.NextRetryAt)
+			remind(activities.ReminderNotice, state.NextRetryAt)
+		}
+		if err != nil {
+			logger.Error("Failed to retry payment", "error", err)
+			return state, err
+		}
+		if ended {
+			state.Outcome = DunningStopped
+			state.NextRetryAt = time.Time{}
+			logger.Info("DunningWorkflow stopped", "subscriptionID", subscription.ID, "status", state.Status, "attempts", state.Attempts)
+			return state, nil
+		}
+		if succeeded {
+			state.Outcome = DunningRecovered
+			if updated {
+				state.Outcome = DunningPaymentUpdated
+			}
+			state.NextRetryAt = time.Time{}
+			if err := setStatus(lifecycle.StatusActive); err != nil {
+				return state, err
+			}
+			logger.Info("DunningWorkflow recovered payment", "attempts", state.Attempts, "outcome", state.Outcome)
+			return state, nil
+		}
+
+		// Escalate: unpaid once enough retries have failed, with a sterner reminder.
+		// There is no point retrying a card suspected of fraud.
+		last := i == len(retryDays)-1
+		if last || fraudSuspected {
+			break
+		}
+		nextRetryAt := failedAt.Add(time.Duration(retryDays[i+1]) * 24 * time.Hour)
+		if state.Attempts >= unpaidAfter {
+			if state.Status != lifecycle.StatusUnpaid {
+				if err := setStatus(lifecycle.StatusUnpaid); err != nil {
+					return state, err
+				}
+			}
+			severity := activities.ReminderWarning
+			if i+1 == len(retryDays)-1 {
+				severity = activities.ReminderFinal
+			}
+			remind(severity, nextRetryAt)
+		} else {
+			remind(activities.ReminderNotice, nextRetryAt)
+		}
+	}
+
+	// Step 3: Every retry failed, cancel the subscription and mark the invoice uncollectible
+	state.Outcome = DunningCanceled
+	state.NextRetryAt = time.Time{}
+	if err := setStatus(lifecycle.StatusCanceled); err != nil {
+		return state, err
+	}
+	if err := markInvoice(ctx, &params.Invoice, invoices.StatusUncollectible); err != nil {
+		logger.Error("Failed to mark invoice uncollectible", "error", err)
+		return state, err
+	}
+	notifyCanceled(ctx, subscription, "because payment for invoice "+params.Invoice.ID+" could not be collected")
+	logger.Info("DunningWorkflow canceled subscription", "subscriptionID", subscription.ID, "attempts", state.Attempts)
+	return state, nil
+}
+
+// waitForRetry waits until a retry is due and returns nil, or returns the payment method update
+// the customer sent before then
+func waitForRetry(ctx workflow.Context, paymentUpdated workflow.ReceiveChannel, retryAt time.Time) *PaymentUpdatedSignal {
+	wait := retryAt.Sub(workflow.Now(ctx))
+	if wait < 0 {
+		wait = 0
+	}
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+	timer := workflow.NewTimer(timerCtx, wait)
+
+	var update *PaymentUpdatedSignal
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(paymentUpdated, func(c workflow.ReceiveChannel, more bool) {
+		var signal PaymentUpdatedSignal
+		c.Receive(ctx, &signal)
+		update = &signal
+	})
+	selector.AddFuture(timer, func(f workflow.Future) {})
+	selector.Select(ctx)
+	return update
+}
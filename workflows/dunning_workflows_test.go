@@ -0,0 +1,243 @@
+package workflows
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// stubWebhookDelivery replaces webhook delivery with a workflow that delivers nothing
+func stubWebhookDelivery(env *testsuite.TestWorkflowEnvironment) {
+	env.RegisterWorkflowWithOptions(func(ctx workflow.Context, params WebhookDeliveryParams) (WebhookDeliveryState, error) {
+		return WebhookDeliveryState{}, nil
+	}, workflow.RegisterOptions{Name: "WebhookDeliveryWorkflow"})
+}
+
+// stubEmails replaces every customer email with one that sends nothing
+func stubEmails(env *testsuite.TestWorkflowEnvironment) {
+	env.RegisterActivityWithOptions(func(ctx context.Context, reminder activities.PaymentReminder) error {
+		return nil
+	}, activity.RegisterOptions{Name: "SendPaymentReminderEmailActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, invoice activities.InvoiceDetails, subscription activities.SubscriptionDetails, payment activities.PaymentDetails) error {
+		return nil
+	}, activity.RegisterOptions{Name: "SendPaymentReceiptEmailActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, subscription activities.SubscriptionDetails, canceledAt time.Time, reason string) error {
+		return nil
+	}, activity.RegisterOptions{Name: "SendSubscriptionCanceledEmailActivity"})
+}
+
+// dunningBackend stands in for the subscription and invoice stores and payment gateway behind the
+// dunning workflow's activities
+type dunningBackend struct {
+	// status is the stored subscription's status
+	status lifecycle.Status
+	// succeedOnAttempt is the first charge attempt that succeeds, zero for none
+	succeedOnAttempt int
+	// missingMethod is a payment method the customer may switch to that does not exist
+	missingMethod string
+
+	statuses      []lifecycle.Status
+	methods       []string
+	invoiceStatus invoices.Status
+}
+
+// newDunningTestEnv returns a test environment for DunningWorkflow whose activities work on backend
+// and which signals the entity workflow of sub_dunning on every status change
+func newDunningTestEnv(backend *dunningBackend) *testsuite.TestWorkflowEnvironment {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(DunningWorkflow)
+	stubWebhookDelivery(env)
+	stubEmails(env)
+
+	backend.status = lifecycle.StatusActive
+	backend.invoiceStatus = invoices.StatusOpen
+	env.RegisterActivityWithOptions(func(ctx context.Context, subscriptionID string) (activities.SubscriptionDetails, error) {
+		return activities.SubscriptionDetails{ID: subscriptionID, CustomerID: "cust_1", Status: backend.status}, nil
+	}, activity.RegisterOptions{Name: "LoadSubscriptionActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, subscriptionID string, status lifecycle.Status) error {
+		if err := lifecycle.Transition(backend.status, status); err != nil {
+			return temporal.NewNonRetryableApplicationError(err.Error(), lifecycle.InvalidTransitionErrorType, err)
+		}
+		backend.status = status
+		backend.statuses = append(backend.statuses, status)
+		return nil
+	}, activity.RegisterOptions{Name: "UpdateSubscriptionStatusActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, subscriptionID string, paymentMethodID string) error {
+		if paymentMethodID == backend.missingMethod {
+			return temporal.NewNonRetryableApplicationError("payment method not found", "PaymentMethodNotFound", nil)
+		}
+		return nil
+	}, activity.RegisterOptions{Name: "UpdatePaymentMethodActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, invoiceID string, status invoices.Status) (activities.InvoiceDetails, error) {
+		backend.invoiceStatus = status
+		return activities.InvoiceDetails{ID: invoiceID, Status: status}, nil
+	}, activity.RegisterOptions{Name: "UpdateInvoiceStatusActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, invoice activities.InvoiceDetails, subscription activities.SubscriptionDetails, attempt int) (activities.PaymentDetails, error) {
+		backend.methods = append(backend.methods, subscription.PaymentMethodID)
+		payment := activities.PaymentDetails{InvoiceID: invoice.ID, Amount: invoice.Amount, Status: "succeeded"}
+		if backend.succeedOnAttempt == 0 || attempt < backend.succeedOnAttempt {
+			payment.Status = "failed"
+			return activities.PaymentDetails{}, temporal.NewNonRetryableApplicationError(
+				"card declined", activities.CardDeclinedErrorType, nil, payment)
+		}
+		return payment, nil
+	}, activity.RegisterOptions{Name: "ProcessPaymentActivity"})
+
+	// Every status change is signaled to the subscription's entity workflow
+	env.OnSignalExternalWorkflow("default-test-namespace", "subscription-sub_dunning", "", SubscriptionChangedSignalName, nil).Return(nil).Maybe()
+	return env
+}
+
+// executeDunning runs DunningWorkflow for an invoice of sub_dunning that failed to be paid with pm_old
+func executeDunning(env *testsuite.TestWorkflowEnvironment) {
+	env.ExecuteWorkflow(DunningWorkflow, DunningParams{
+		Subscription: activities.SubscriptionDetails{ID: "sub_dunning", CustomerID: "cust_1", PaymentMethodID: "pm_old"},
+		Invoice:      activities.InvoiceDetails{ID: "inv_dunning", Status: invoices.StatusOpen, Amount: money.MustParse("49.99", "USD")},
+	})
+}
+
+func TestDunningAfterPaymentMethodUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+		// update is when the customer updates their payment method, zero for never
+		update time.Duration
+		// missing makes the payment method the customer updates to one that does not exist
+		missing bool
+		// succeedOnAttempt is the first charge attempt that succeeds, zero for none
+		succeedOnAttempt int
+		outcome          string
+		statuses         []lifecycle.Status
+		invoiceStatus    invoices.Status
+		methods          []string
+	}{
+		{
+			name:             "update charged right away",
+			update:           12 * time.Hour,
+			succeedOnAttempt: 1,
+			outcome:          DunningPaymentUpdated,
+			statuses:         []lifecycle.Status{lifecycle.StatusPastDue, lifecycle.StatusActive},
+			invoiceStatus:    invoices.StatusPaid,
+			methods:          []string{"pm_new"},
+		},
+		{
+			name:             "retry succeeds after the update's charge fails",
+			update:           12 * time.Hour,
+			succeedOnAttempt: 2,
+			outcome:          DunningPaymentUpdated,
+			statuses:         []lifecycle.Status{lifecycle.StatusPastDue, lifecycle.StatusActive},
+			invoiceStatus:    invoices.StatusPaid,
+			methods:          []string{"pm_new", "pm_new"},
+		},
+		{
+			name:          "retries run out after the update's charge fails",
+			update:        12 * time.Hour,
+			outcome:       DunningCanceled,
+			statuses:      []lifecycle.Status{lifecycle.StatusPastDue, lifecycle.StatusUnpaid, lifecycle.StatusCanceled},
+			invoiceStatus: invoices.StatusUncollectible,
+			methods:       []string{"pm_new", "pm_new", "pm_new", "pm_new", "pm_new"},
+		},
+		{
+			name:             "retry succeeds after updating to a missing payment method",
+			update:           12 * time.Hour,
+			missing:          true,
+			succeedOnAttempt: 1,
+			outcome:          DunningRecovered,
+			statuses:         []lifecycle.Status{lifecycle.StatusPastDue, lifecycle.StatusActive},
+			invoiceStatus:    invoices.StatusPaid,
+			methods:          []string{"pm_old"},
+		},
+		{
+			name:             "retry succeeds without an update",
+			succeedOnAttempt: 3,
+			outcome:          DunningRecovered,
+			statuses:         []lifecycle.Status{lifecycle.StatusPastDue, lifecycle.StatusUnpaid, lifecycle.StatusActive},
+			invoiceStatus:    invoices.StatusPaid,
+			methods:          []string{"pm_old", "pm_old", "pm_old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &dunningBackend{succeedOnAttempt: tt.succeedOnAttempt}
+			if tt.missing {
+				backend.missingMethod = "pm_new"
+			}
+			env := newDunningTestEnv(backend)
+			if tt.update > 0 {
+				env.RegisterDelayedCallback(func() {
+					env.SignalWorkflow(PaymentUpdatedSignalName, PaymentUpdatedSignal{PaymentMethodID: "pm_new"})
+				}, tt.update)
+			}
+			executeDunning(env)
+
+			var state DunningState
+			if err := env.GetWorkflowResult(&state); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			if state.Outcome != tt.outcome || state.Status != tt.statuses[len(tt.statuses)-1] {
+				t.Errorf("dunning ended %q with status %s, want %q with status %s",
+					state.Outcome, state.Status, tt.outcome, tt.statuses[len(tt.statuses)-1])
+			}
+			if !reflect.DeepEqual(backend.statuses, tt.statuses) {
+				t.Errorf("subscription went through %v, want %v", backend.statuses, tt.statuses)
+			}
+			if backend.invoiceStatus != tt.invoiceStatus {
+				t.Errorf("invoice is %s, want %s", backend.invoiceStatus, tt.invoiceStatus)
+			}
+			if !reflect.DeepEqual(backend.methods, tt.methods) {
+				t.Errorf("charged %v, want %v", backend.methods, tt.methods)
+			}
+			env.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDunningStopsWhenSubscriptionEnds(t *testing.T) {
+	day := 24 * time.Hour
+
+	t.Run("canceled outside dunning", func(t *testing.T) {
+		backend := &dunningBackend{succeedOnAttempt: 3}
+		env := newDunningTestEnv(backend)
+		// Canceled between the first and second retries, as the entity workflow's cancel update does
+		env.RegisterDelayedCallback(func() { backend.status = lifecycle.StatusCanceled }, 2*day)
+		executeDunning(env)
+
+		var state DunningState
+		if err := env.GetWorkflowResult(&state); err != nil {
+			t.Fatalf("workflow failed: %v", err)
+		}
+		if state.Outcome != DunningStopped || state.Status != lifecycle.StatusCanceled {
+			t.Errorf("dunning ended %q with status %s, want %q with status canceled", state.Outcome, state.Status, DunningStopped)
+		}
+		if len(backend.methods) != 1 {
+			t.Errorf("charged %v, want only the retry before the subscription was canceled", backend.methods)
+		}
+		if backend.invoiceStatus != invoices.StatusOpen {
+			t.Errorf("invoice is %s, want it left open", backend.invoiceStatus)
+		}
+	})
+
+	t.Run("workflow canceled", func(t *testing.T) {
+		backend := &dunningBackend{succeedOnAttempt: 3}
+		env := newDunningTestEnv(backend)
+		env.RegisterDelayedCallback(env.CancelWorkflow, 2*day)
+		executeDunning(env)
+
+		if err := env.GetWorkflowError(); !temporal.IsCanceledError(err) {
+			t.Fatalf("workflow ended with %v, want it canceled", err)
+		}
+		if len(backend.methods) != 1 {
+			t.Errorf("charged %v, want only the retry before dunning was canceled", backend.methods)
+		}
+	})
+}
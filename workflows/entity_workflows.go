@@ -0,0 +1,1218 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/periods"
+	"github.com/tanint/play-temporal/proration"
+	"github.com/tanint/play-temporal/webhooks"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Updates accepted by the subscription entity workflow
+const (
+	ChangePlanUpdateName  = "change_plan"
+	PauseUpdateName       = "pause"
+	ResumeUpdateName      = "resume"
+	CancelUpdateName      = "cancel"
+	ExtendTrialUpdateName = "extend_trial"
+	ApplyCouponUpdateName = "apply_coupon"
+)
+
+// SubscriptionChangedSignalName tells the subscription entity workflow that its subscription was
+// changed outside it, such as by dunning, so that it reloads the subscription
+const SubscriptionChangedSignalName = "subscription_changed"
+
+// DefaultTrialReminderDays is how many days before a trial ends the reminder is sent
+// when none is configured
+const DefaultTrialReminderDays = 3
+
+// entityCyclesPerRun is how many billing cycles one run bills before continuing as new
+const entityCyclesPerRun = 12
+
+// maxEntityHistory is how many history events are kept, oldest dropped first
+const maxEntityHistory = 100
+
+// SubscriptionEntityParams contains parameters for the subscription entity workflow
+type SubscriptionEntityParams struct {
+	SubscriptionID string
+	// NextBillingDate is when the first cycle is billed. Zero means the end of the current period.
+	NextBillingDate time.Time
+	// TrialReminderDays is how many days before a trial ends the reminder is sent.
+	// Zero means DefaultTrialReminderDays.
+	TrialReminderDays int
+	// InvoiceGracePeriod is how long each renewal invoice stays a draft that can be edited before
+	// it is finalized. Zero means DefaultInvoiceGracePeriod; negative finalizes it right away.
+	InvoiceGracePeriod time.Duration
+	// State is carried over by continue-as-new and is nil on the first run
+	State *SubscriptionEntityState
+}
+
+// SubscriptionEntityState is what the entity workflow remembers about its subscription between runs
+type SubscriptionEntityState struct {
+	Status lifecycle.Status
+	// PeriodStart and NextBillingDate bound the period the next cycle bills
+	PeriodStart     time.Time
+	NextBillingDate time.Time
+	// Schedule lays out the billing periods that the billing dates follow
+	Schedule     periods.Schedule
+	CyclesBilled int
+	// TrialEnd is when a trialing subscription is converted, zero without a trial
+	TrialEnd          time.Time
+	TrialReminderDays int
+	TrialReminderSent bool
+	// ResumeAt is when a paused subscription resumes by itself, zero to stay paused
+	ResumeAt time.Time
+	// CancelAtPeriodEnd cancels the subscription instead of billing the next cycle
+	CancelAtPeriodEnd bool
+	// PaidInvoiceID is the last invoice the subscription paid, which unused time is refunded against
+	PaidInvoiceID string
+	// PendingAdjustments are prorated line items and credits carried to the next invoice
+	PendingAdjustments []activities.InvoiceItem
+	// Coupon is the coupon redeemed on the subscription, the zero value when none is active
+	Coupon             activities.CouponRedemption
+	InvoiceGracePeriod time.Duration
+	History            []SubscriptionEvent
+}
+
+// SubscriptionEvent is an entry in the subscription's history
+type SubscriptionEvent struct {
+	Time   time.Time
+	Type   string
+	Detail string
+}
+
+// SubscriptionStatus is returned by the get_status query
+type SubscriptionStatus struct {
+	SubscriptionID    string
+	PlanID            string
+	Quantity          int64
+	Status            lifecycle.Status
+	NextBillingDate   time.Time
+	TrialEnd          time.Time
+	ResumeAt          time.Time
+	CancelAtPeriodEnd bool
+	CyclesBilled      int
+	Coupon            activities.CouponRedemption
+}
+
+// ChangePlanRequest is the input of the change_plan update
+type ChangePlanRequest struct {
+	PlanID   string
+	Quantity int64
+}
+
+// ExtendTrialRequest is the input of the extend_trial update
+type ExtendTrialRequest struct {
+	Days int
+}
+
+// ApplyCouponRequest is the input of the apply_coupon update
+type ApplyCouponRequest struct {
+	CouponID string
+}
+
+// PauseRequest is the input of the pause update
+type PauseRequest struct {
+	// ResumeAt resumes the subscription automatically. Zero pauses it until the resume update.
+	ResumeAt time.Time
+}
+
+// CancelRequest is the input of the cancel update
+type CancelRequest struct {
+	// AtPeriodEnd keeps the subscription until the end of the current period instead of ending it now
+	AtPeriodEnd bool
+	// Refund refunds the unused time of the current period when canceling immediately
+	Refund bool
+}
+
+// CancelResult describes a cancellation
+type CancelResult struct {
+	Status lifecycle.Status
+	// CancelAt is when the subscription ends, later than now when canceled at period end
+	CancelAt time.Time
+	// Refund is set when unused time was refunded
+	Refund *activities.RefundDetails
+}
+
+// ChangePlanResult describes how a plan change was prorated and billed
+type ChangePlanResult struct {
+	FromPlanID string
+	ToPlanID   string
+	Policy     catalog.ProrationPolicy
+	Proration  proration.Result
+	// InvoiceID and InvoiceNumber are set when the prorated difference was invoiced immediately
+	InvoiceID     string
+	InvoiceNumber string
+	// Carried is true when the adjustment was deferred to the next invoice
+	Carried bool
+}
+
+// startSubscriptionEntity starts the entity workflow for a subscription as an abandoned child,
+// so it keeps billing after the workflow that created the subscription has completed
+func startSubscriptionEntity(ctx workflow.Context, params SubscriptionEntityParams) error {
+	childOptions := workflow.ChildWorkflowOptions{
+		WorkflowID:        "subscription-" + params.SubscriptionID,
+		ParentClosePolicy: enums.PARENT_CLOSE_POLICY_ABANDON,
+	}
+	childCtx := workflow.WithChildOptions(ctx, childOptions)
+
+	// Only wait for the child to start; it runs for the subscription's whole lifetime
+	child := workflow.ExecuteChildWorkflow(childCtx, SubscriptionEntityWorkflow, params)
+	return child.GetChildWorkflowExecution().Get(ctx, nil)
+}
+
+// notifySubscriptionEntity signals the entity workflow of a subscription that the subscription was
+// changed. Subscriptions billed by a schedule have no entity workflow, so a failed signal is only logged.
+func notifySubscriptionEntity(ctx workflow.Context, subscriptionID string) {
+	err := workflow.SignalExternalWorkflow(ctx, "subscription-"+subscriptionID, "", SubscriptionChangedSignalName, nil).Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Info("No subscription entity workflow to notify", "subscriptionID", subscriptionID, "error", err)
+	}
+}
+
+// subscriptionEntityRunning reports whether the entity workflow of a subscription is running. It
+// signals the entity that the subscription may have changed, which only a running workflow accepts
+// and which at worst makes it reload the subscription.
+func subscriptionEntityRunning(ctx workflow.Context, subscriptionID string) bool {
+	err := workflow.SignalExternalWorkflow(ctx, "subscription-"+subscriptionID, "", SubscriptionChangedSignalName, nil).Get(ctx, nil)
+	return err == nil
+}
+
+// SubscriptionEntityWorkflow is a long-lived workflow that owns a single subscription. It sleeps
+// until each billing date and bills the cycle, accepts plan changes, lifecycle changes and coupons
+// as updates, and continues as new periodically to keep its history bounded. A trialing subscription
+// is first run out to the end of its trial and converted by charging its first period.
+func SubscriptionEntityWorkflow(ctx workflow.Context, params SubscriptionEntityParams) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("SubscriptionEntityWorkflow started", "subscriptionID", params.SubscriptionID)
+
+	// Configure activity options
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+			// Declines are handled by dunning, not by retrying the charge
+			NonRetryableErrorTypes: activities.PaymentNonRetryableErrorTypes,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	// Step 1: Load the subscription this workflow owns and its plan
+	var subscription activities.SubscriptionDetails
+	err := workflow.ExecuteActivity(ctx, activities.LoadSubscriptionActivity, params.SubscriptionID).Get(ctx, &subscription)
+	if err != nil {
+		logger.Error("Failed to load subscription", "error", err)
+		return err
+	}
+	var plan catalog.Plan
+	err = workflow.ExecuteActivity(ctx, activities.LoadPlanActivity, subscription.PlanID).Get(ctx, &plan)
+	if err != nil {
+		logger.Error("Failed to load plan", "error", err)
+		return err
+	}
+
+	// Step 2: Restore the state carried over from the previous run, or start fresh
+	var state SubscriptionEntityState
+	if params.State != nil {
+		state = *params.State
+	} else {
+		schedule, err := subscriptionSchedule(subscription, plan.Interval)
+		if err != nil {
+			logger.Error("Failed to lay out billing periods", "error", err)
+			return err
+		}
+		_, period := schedule.Containing(workflow.Now(ctx))
+		state = SubscriptionEntityState{
+			Status:             subscription.Status,
+			PeriodStart:        period.Start,
+			NextBillingDate:    period.End,
+			Schedule:           schedule,
+			InvoiceGracePeriod: params.InvoiceGracePeriod,
+		}
+		if !params.NextBillingDate.IsZero() {
+			state.NextBillingDate = params.NextBillingDate
+		}
+		// A trial's first period starts when the trial ends
+		if subscription.Status == lifecycle.StatusTrialing {
+			period := schedule.Period(0)
+			state.TrialEnd = subscription.TrialEnd
+			state.TrialReminderDays = params.TrialReminderDays
+			state.PeriodStart = period.Start
+			state.NextBillingDate = period.End
+		}
+	}
+
+	// record appends an event to the history, dropping the oldest beyond the limit. Every change
+	// to the state is recorded, so revision also tells sleeping loops when to look again.
+	revision := 0
+	record := func(eventType, detail string) {
+		revision++
+		state.History = append(state.History, SubscriptionEvent{
+			Time:   workflow.Now(ctx),
+			Type:   eventType,
+			Detail: detail,
+		})
+		if len(state.History) > maxEntityHistory {
+			state.History = state.History[len(state.History)-maxEntityHistory:]
+		}
+	}
+	if params.State == nil {
+		record("started", fmt.Sprintf("%s on %s, next billing %s",
+			subscription.Status, subscription.PlanID, state.NextBillingDate.Format(time.RFC3339)))
+	}
+
+	// setStatus moves the subscription to a new status
+	setStatus := func(ctx workflow.Context, status lifecycle.Status, detail string) error {
+		err := workflow.ExecuteActivity(ctx, activities.UpdateSubscriptionStatusActivity, subscription.ID, status).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+		emitStatusChange(ctx, subscription.ID, state.Status, status, detail)
+		state.Status = status
+		subscription.Status = status
+		record(string(status), detail)
+		return nil
+	}
+
+	// Step 3: Expose the subscription through queries
+	err = workflow.SetQueryHandler(ctx, "get_status", func() (SubscriptionStatus, error) {
+		return SubscriptionStatus{
+			SubscriptionID:    subscription.ID,
+			PlanID:            subscription.PlanID,
+			Quantity:          subscription.Quantity,
+			Status:            state.Status,
+			NextBillingDate:   state.NextBillingDate,
+			TrialEnd:          state.TrialEnd,
+			ResumeAt:          state.ResumeAt,
+			CancelAtPeriodEnd: state.CancelAtPeriodEnd,
+			CyclesBilled:      state.CyclesBilled,
+			Coupon:            state.Coupon,
+		}, nil
+	})
+	if err != nil {
+		logger.Error("Failed to register query handler", "error", err)
+		return err
+	}
+	err = workflow.SetQueryHandler(ctx, "get_balance", func() (money.Money, error) {
+		// The balance is what the next invoice adds on top of its charges; negative is a credit
+		amounts := make([]money.Money, len(state.PendingAdjustments))
+		for i, item := range state.PendingAdjustments {
+			amounts[i] = item.Amount
+		}
+		return money.Sum(subscription.PricePerMonth.Currency(), amounts...)
+	})
+	if err != nil {
+		logger.Error("Failed to register query handler", "error", err)
+		return err
+	}
+	err = workflow.SetQueryHandler(ctx, "get_history", func() ([]SubscriptionEvent, error) {
+		return state.History, nil
+	})
+	if err != nil {
+		logger.Error("Failed to register query handler", "error", err)
+		return err
+	}
+
+	// Renewal invoices are held as drafts for their grace period. Edits to a draft do not wait for
+	// the lock, which the billing cycle holding the draft open has. Changing the plan, pausing and
+	// canceling end the grace period instead of waiting it out.
+	drafts, err := registerInvoiceDrafts(ctx, state.InvoiceGracePeriod, ao)
+	if err != nil {
+		logger.Error("Failed to register draft invoice handlers", "error", err)
+		return err
+	}
+
+	// Updates and billing cycles run one at a time so each sees the result of the previous one
+	lock := workflow.NewMutex(ctx)
+
+	// ended reports whether the subscription has been canceled or its trial has expired
+	ended := func() bool { return state.Status.Terminal() }
+
+	// Step 4: Register update handlers
+	err = workflow.SetUpdateHandlerWithOptions(ctx, ChangePlanUpdateName,
+		func(ctx workflow.Context, request ChangePlanRequest) (ChangePlanResult, error) {
+			// Update handlers get the root context, without the activity options
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			drafts.endGrace()
+			if err := lock.Lock(ctx); err != nil {
+				return ChangePlanResult{}, err
+			}
+			defer lock.Unlock()
+
+			// Nothing has been charged during a trial, so there is nothing to prorate
+			if state.Status == lifecycle.StatusTrialing {
+				quantity := request.Quantity
+				if quantity <= 0 {
+					quantity = subscription.Quantity
+				}
+				result := ChangePlanResult{FromPlanID: subscription.PlanID, ToPlanID: request.PlanID}
+				var updated activities.SubscriptionDetails
+				err := workflow.ExecuteActivity(ctx, activities.ChangeSubscriptionPlanActivity,
+					subscription.ID, request.PlanID, quantity).Get(ctx, &updated)
+				if err != nil {
+					logger.Error("Failed to change plan", "error", err)
+					return ChangePlanResult{}, err
+				}
+				subscription = updated
+				record("plan_changed", fmt.Sprintf("%s to %s during trial", result.FromPlanID, result.ToPlanID))
+				return result, nil
+			}
+
+			period := activities.BillingPeriod{Start: state.PeriodStart, End: state.NextBillingDate}
+			result, items, updated, err := changePlan(ctx, subscription, period, request)
+			if err != nil {
+				logger.Error("Failed to change plan", "error", err)
+				return ChangePlanResult{}, err
+			}
+			subscription = updated
+			if result.Carried {
+				state.PendingAdjustments = append(state.PendingAdjustments, items...)
+			}
+			record("plan_changed", fmt.Sprintf("%s to %s, net %s", result.FromPlanID, result.ToPlanID, result.Proration.Net))
+			return result, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request ChangePlanRequest) error {
+				if ended() {
+					return fmt.Errorf("subscription is %s", state.Status)
+				}
+				if request.PlanID == "" {
+					return errors.New("plan ID is required")
+				}
+				if request.PlanID == subscription.PlanID && (request.Quantity == 0 || request.Quantity == subscription.Quantity) {
+					return fmt.Errorf("subscription is already on plan %s", request.PlanID)
+				}
+				return nil
+			},
+		},
+	)
+	if err != nil {
+		logger.Error("Failed to register change_plan update handler", "error", err)
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, PauseUpdateName,
+		func(ctx workflow.Context, request PauseRequest) (lifecycle.Status, error) {
+			// Update handlers get the root context, without the activity options
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			drafts.endGrace()
+			if err := lock.Lock(ctx); err != nil {
+				return "", err
+			}
+			defer lock.Unlock()
+
+			detail := "until resumed"
+			if !request.ResumeAt.IsZero() {
+				detail = "until " + request.ResumeAt.Format(time.RFC3339)
+			}
+			if err := setStatus(ctx, lifecycle.StatusPaused, detail); err != nil {
+				return "", err
+			}
+			state.ResumeAt = request.ResumeAt
+			return state.Status, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request PauseRequest) error {
+				if err := checkTransition(state.Status, lifecycle.StatusPaused); err != nil {
+					return err
+				}
+				if !request.ResumeAt.IsZero() && !request.ResumeAt.After(workflow.Now(ctx)) {
+					return errors.New("resume date must be in the future")
+				}
+				return nil
+			},
+		},
+	)
+	if err != nil {
+		logger.Error("Failed to register pause update handler", "error", err)
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, ResumeUpdateName,
+		func(ctx workflow.Context) (lifecycle.Status, error) {
+			// Update handlers get the root context, without the activity options
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			if err := lock.Lock(ctx); err != nil {
+				return "", err
+			}
+			defer lock.Unlock()
+
+			// Resuming also takes back a cancellation scheduled for the end of the period
+			if state.CancelAtPeriodEnd {
+				state.CancelAtPeriodEnd = false
+				record("cancel_unscheduled", "")
+			}
+			if state.Status == lifecycle.StatusPaused {
+				if err := setStatus(ctx, lifecycle.StatusActive, "resumed"); err != nil {
+					return "", err
+				}
+				state.ResumeAt = time.Time{}
+			}
+			return state.Status, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context) error {
+				if state.CancelAtPeriodEnd && !ended() {
+					return nil
+				}
+				if state.Status != lifecycle.StatusPaused {
+					return transitionError(state.Status, lifecycle.StatusActive)
+				}
+				return nil
+			},
+		},
+	)
+	if err != nil {
+		logger.Error("Failed to register resume update handler", "error", err)
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, CancelUpdateName,
+		func(ctx workflow.Context, request CancelRequest) (CancelResult, error) {
+			// Update handlers get the root context, without the activity options
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			drafts.endGrace()
+			if err := lock.Lock(ctx); err != nil {
+				return CancelResult{}, err
+			}
+			defer lock.Unlock()
+
+			// Cancel at the end of the period: the billing loop cancels instead of billing the next
+			// cycle, and a trial ends without being converted
+			if request.AtPeriodEnd {
+				cancelAt := state.NextBillingDate
+				if state.Status == lifecycle.StatusTrialing {
+					cancelAt = state.TrialEnd
+				}
+				state.CancelAtPeriodEnd = true
+				record("cancel_scheduled", "at "+cancelAt.Format(time.RFC3339))
+				return CancelResult{Status: state.Status, CancelAt: cancelAt}, nil
+			}
+
+			// Only an active subscription has paid for the current period
+			result := CancelResult{CancelAt: workflow.Now(ctx)}
+			if request.Refund && state.Status == lifecycle.StatusActive {
+				period := activities.BillingPeriod{Start: state.PeriodStart, End: state.NextBillingDate}
+				refund, err := refundUnusedTime(ctx, subscription, period, state.PaidInvoiceID)
+				if err != nil {
+					logger.Error("Failed to refund unused time", "error", err)
+					return CancelResult{}, err
+				}
+				if refund.ID != "" {
+					result.Refund = &refund
+					record("refunded", fmt.Sprintf("%s for %s", refund.ID, refund.Amount))
+				}
+			}
+
+			previous := state.Status
+			if err := setStatus(ctx, lifecycle.StatusCanceled, "canceled immediately"); err != nil {
+				return CancelResult{}, err
+			}
+			// A past due or unpaid subscription is in dunning, which must not charge it again: the
+			// dunning of each open invoice is canceled and the invoice written off as uncollectible
+			if previous == lifecycle.StatusPastDue || previous == lifecycle.StatusUnpaid {
+				var open []activities.InvoiceDetails
+				err := workflow.ExecuteActivity(ctx, activities.ListOpenInvoicesActivity, subscription.ID).Get(ctx, &open)
+				if err != nil {
+					return CancelResult{}, err
+				}
+				for _, invoice := range open {
+					cancelDunning(ctx, invoice.ID)
+					if err := markInvoice(ctx, &invoice, invoices.StatusUncollectible); err != nil {
+						return CancelResult{}, err
+					}
+					record("invoice_uncollectible", invoice.ID+" of the canceled subscription")
+				}
+			}
+			notifyCanceled(ctx, subscription, "at your request")
+			state.CancelAtPeriodEnd = false
+			result.Status = state.Status
+			return result, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request CancelRequest) error {
+				if err := checkTransition(state.Status, lifecycle.StatusCanceled); err != nil {
+					return err
+				}
+				if request.AtPeriodEnd && request.Refund {
+					return errors.New("unused time is only refunded when canceling immediately")
+				}
+				if request.AtPeriodEnd && state.CancelAtPeriodEnd {
+					return errors.New("subscription is already canceled at the end of the period")
+				}
+				return nil
+			},
+		},
+	)
+	if err != nil {
+		logger.Error("Failed to register cancel update handler", "error", err)
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, ExtendTrialUpdateName,
+		func(ctx workflow.Context, request ExtendTrialRequest) (time.Time, error) {
+			// Update handlers get the root context, without the activity options
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			if err := lock.Lock(ctx); err != nil {
+				return time.Time{}, err
+			}
+			defer lock.Unlock()
+
+			trialEnd := state.TrialEnd.AddDate(0, 0, request.Days)
+			err := workflow.ExecuteActivity(ctx, activities.ExtendTrialActivity, subscription.ID, trialEnd).Get(ctx, nil)
+			if err != nil {
+				logger.Error("Failed to extend trial", "error", err)
+				return time.Time{}, err
+			}
+			state.TrialEnd = trialEnd
+			subscription.TrialEnd = trialEnd
+			// Remind the customer again before the new end
+			state.TrialReminderSent = false
+			record("trial_extended", fmt.Sprintf("by %d days to %s", request.Days, trialEnd.Format(time.RFC3339)))
+			return trialEnd, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request ExtendTrialRequest) error {
+				if state.Status != lifecycle.StatusTrialing {
+					return fmt.Errorf("cannot extend the trial of a %s subscription", state.Status)
+				}
+				if request.Days <= 0 {
+					return errors.New("days must be positive")
+				}
+				return nil
+			},
+		},
+	)
+	if err != nil {
+		logger.Error("Failed to register extend_trial update handler", "error", err)
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, ApplyCouponUpdateName,
+		func(ctx workflow.Context, request ApplyCouponRequest) (activities.CouponRedemption, error) {
+			// Update handlers get the root context, without the activity options
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			if err := lock.Lock(ctx); err != nil {
+				return activities.CouponRedemption{}, err
+			}
+			defer lock.Unlock()
+
+			var coupon catalog.Coupon
+			err := workflow.ExecuteActivity(ctx, activities.LoadCouponActivity, request.CouponID).Get(ctx, &coupon)
+			if err != nil {
+				logger.Error("Failed to load coupon", "error", err)
+				return activities.CouponRedemption{}, err
+			}
+			if currency := subscription.PricePerMonth.Currency(); coupon.Currency != "" && coupon.Currency != currency {
+				return activities.CouponRedemption{}, fmt.Errorf("coupon %s is in %s but the subscription is billed in %s",
+					coupon.ID, coupon.Currency, currency)
+			}
+
+			// A new coupon replaces the one redeemed before
+			if state.Coupon.Active() {
+				record("coupon_replaced", state.Coupon.Coupon.ID)
+			}
+			state.Coupon = activities.CouponRedemption{Coupon: coupon, RedeemedAt: workflow.Now(ctx)}
+			record("coupon_redeemed", fmt.Sprintf("%s (%s)", coupon.ID, coupon.Name))
+			return state.Coupon, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request ApplyCouponRequest) error {
+				if ended() {
+					return fmt.Errorf("subscription is %s", state.Status)
+				}
+				if request.CouponID == "" {
+					return errors.New("coupon ID is required")
+				}
+				if state.Coupon.Active() && state.Coupon.Coupon.ID == request.CouponID {
+					return fmt.Errorf("coupon %s is already applied", request.CouponID)
+				}
+				return nil
+			},
+		},
+	)
+	if err != nil {
+		logger.Error("Failed to register apply_coupon update handler", "error", err)
+		return err
+	}
+
+	// Reload the subscription whenever another workflow signals that it changed it. Signals that
+	// arrive during a reload are covered by it.
+	subscriptionChanged := workflow.GetSignalChannel(ctx, SubscriptionChangedSignalName)
+	syncing := false
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			subscriptionChanged.Receive(ctx, nil)
+			syncing = true
+			for subscriptionChanged.ReceiveAsync(nil) {
+			}
+			if err := lock.Lock(ctx); err != nil {
+				return
+			}
+			if _, err := syncSubscription(ctx, &state, &subscription, record); err != nil {
+				logger.Error("Failed to reload subscription", "error", err)
+			}
+			lock.Unlock()
+			syncing = false
+		}
+	})
+	// idle reports whether no update or reload is in flight or waiting, so the run can end
+	idle := func() bool {
+		return workflow.AllHandlersFinished(ctx) && !syncing && subscriptionChanged.Len() == 0
+	}
+
+	// Step 5: Run out the trial, if any, and convert or expire the subscription at its end
+	if err := runTrial(ctx, lock, &state, &subscription, record); err != nil {
+		logger.Error("Failed to run trial", "error", err)
+		return err
+	}
+
+	// Step 6: Sleep until each billing date and bill the cycle, until the subscription has
+	// ended or this run has grown long enough to continue as new
+	for cycles := 0; !ended(); {
+		if cycles >= entityCyclesPerRun || workflow.GetInfo(ctx).GetContinueAsNewSuggested() {
+			// Let in-flight updates and reloads finish so none are lost when the run ends
+			if err := workflow.Await(ctx, idle); err != nil {
+				return err
+			}
+			logger.Info("Continuing subscription entity as new", "subscriptionID", subscription.ID)
+			return workflow.NewContinueAsNewError(ctx, SubscriptionEntityWorkflow, SubscriptionEntityParams{
+				SubscriptionID: subscription.ID,
+				State:          &state,
+			})
+		}
+
+		// Wake at the billing date, or earlier when a paused subscription is due to resume, and
+		// look again whenever an update changes the state
+		wake := state.NextBillingDate
+		autoResume := state.Status == lifecycle.StatusPaused && !state.ResumeAt.IsZero() && state.ResumeAt.Before(wake)
+		if autoResume {
+			wake = state.ResumeAt
+		}
+		if wait := wake.Sub(workflow.Now(ctx)); wait > 0 {
+			seen := revision
+			if _, err := workflow.AwaitWithTimeout(ctx, wait, func() bool { return revision != seen }); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if autoResume {
+			if err := resumePaused(ctx, lock, &state, setStatus); err != nil {
+				logger.Error("Failed to resume subscription", "error", err)
+				return err
+			}
+			continue
+		}
+
+		if err := billCycle(ctx, lock, &state, &subscription, drafts, record); err != nil {
+			logger.Error("Failed to bill cycle", "error", err)
+			return err
+		}
+		cycles++
+	}
+
+	// Step 7: Finish once every update has been handled
+	if err := workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) }); err != nil {
+		return err
+	}
+	logger.Info("SubscriptionEntityWorkflow completed", "subscriptionID", subscription.ID, "status", state.Status)
+	return nil
+}
+
+// resumePaused resumes a paused subscription whose resume date has come
+func resumePaused(
+	ctx workflow.Context,
+	lock workflow.Mutex,
+	state *SubscriptionEntityState,
+	setStatus func(ctx workflow.Context, status lifecycle.Status, detail string) error,
+) error {
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// An update may have resumed, paused again or canceled the subscription while this waited for the lock
+	if state.Status != lifecycle.StatusPaused || state.ResumeAt.IsZero() || state.ResumeAt.After(workflow.Now(ctx)) {
+		return nil
+	}
+	if err := setStatus(ctx, lifecycle.StatusActive, "resumed automatically"); err != nil {
+		return err
+	}
+	state.ResumeAt = time.Time{}
+	return nil
+}
+
+// billCycle bills the period ending at the next billing date, or skips it while the subscription
+// is paused, then moves the state on to the following period. A subscription canceled at the end
+// of the period is canceled instead of billed. The invoice is held as a draft by drafts before it
+// is finalized; other updates wait until it has been.
+func billCycle(
+	ctx workflow.Context,
+	lock workflow.Mutex,
+	state *SubscriptionEntityState,
+	subscription *activities.SubscriptionDetails,
+	drafts *invoiceDrafts,
+	record func(eventType, detail string),
+) error {
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// Reload the subscription: dunning and payment method changes happen outside this workflow
+	current, err := syncSubscription(ctx, state, subscription, record)
+	if err != nil {
+		return err
+	}
+	if current.Status.Terminal() {
+		return nil
+	}
+	if state.CancelAtPeriodEnd {
+		err := workflow.ExecuteActivity(ctx, activities.UpdateSubscriptionStatusActivity, current.ID, lifecycle.StatusCanceled).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+		emitStatusChange(ctx, current.ID, state.Status, lifecycle.StatusCanceled, "canceled at the end of the billing period")
+		state.Status = lifecycle.StatusCanceled
+		subscription.Status = state.Status
+		record("canceled", "at the end of the period ending "+state.NextBillingDate.Format(time.RFC3339))
+		notifyCanceled(ctx, *subscription, "at the end of the billing period, as you requested")
+		return nil
+	}
+	var plan catalog.Plan
+	err = workflow.ExecuteActivity(ctx, activities.LoadPlanActivity, current.PlanID).Get(ctx, &plan)
+	if err != nil {
+		return err
+	}
+
+	// The next period follows on the schedule. A plan change to another interval starts the
+	// schedule over at the end of this period.
+	period := activities.BillingPeriod{Start: state.PeriodStart, End: state.NextBillingDate}
+	schedule := state.Schedule
+	if schedule.Interval != plan.Interval {
+		schedule = schedule.Reanchor(period.End, plan.Interval)
+	}
+	_, next := schedule.Containing(period.End)
+	nextBillingDate := next.End
+	// A paused subscription is not billed, nor is an unpaid one while its open invoice goes uncollected
+	if state.Status == lifecycle.StatusPaused || state.Status == lifecycle.StatusUnpaid {
+		record("cycle_skipped", fmt.Sprintf("%s for the period ending %s", state.Status, period.End.Format(time.RFC3339)))
+		emitEvent(ctx, webhooks.BillingCycleSkipped, current.ID, BillingCycle{
+			SubscriptionID:  current.ID,
+			Period:          period,
+			NextBillingDate: nextBillingDate,
+			Reason:          "subscription is " + string(state.Status),
+		})
+	} else {
+		invoice, payment, carried, err := runBillingCycle(ctx, current, period, state.PendingAdjustments, state.Coupon, drafts)
+		if err != nil && !paymentFailed(err) {
+			return err
+		}
+		state.PendingAdjustments = carried
+		state.CyclesBilled++
+		countCouponCycle(state, record)
+		if err == nil {
+			state.Status = lifecycle.StatusActive
+			state.PaidInvoiceID = invoice.ID
+		} else {
+			state.Status = lifecycle.StatusPastDue
+		}
+		subscription.Status = state.Status
+		record("invoiced", fmt.Sprintf("%s (%s) for %s, payment %s", invoice.Number, invoice.ID, invoice.Amount, payment.Status))
+		emitEvent(ctx, webhooks.BillingCycleCompleted, current.ID, BillingCycle{
+			SubscriptionID:  current.ID,
+			Period:          period,
+			InvoiceID:       invoice.ID,
+			PaymentStatus:   payment.Status,
+			NextBillingDate: nextBillingDate,
+		})
+	}
+
+	state.PeriodStart = period.End
+	state.NextBillingDate = nextBillingDate
+	state.Schedule = schedule
+	return nil
+}
+
+// syncSubscription reloads the subscription and takes on its status, which workflows such as
+// dunning change outside the entity workflow. The caller holds the lock.
+func syncSubscription(
+	ctx workflow.Context,
+	state *SubscriptionEntityState,
+	subscription *activities.SubscriptionDetails,
+	record func(eventType, detail string),
+) (activities.SubscriptionDetails, error) {
+	var current activities.SubscriptionDetails
+	err := workflow.ExecuteActivity(ctx, activities.LoadSubscriptionActivity, subscription.ID).Get(ctx, &current)
+	if err != nil {
+		return current, err
+	}
+	*subscription = current
+	if current.Status != state.Status {
+		state.Status = current.Status
+		record(string(current.Status), "outside the subscription workflow")
+	}
+	return current, nil
+}
+
+// runTrial waits out a trialing subscription, sending a reminder shortly before the trial ends,
+// and then charges the first period. The subscription becomes active if the payment succeeds and
+// expires if it does not. Extending the trial moves both the reminder and the conversion.
+func runTrial(
+	ctx workflow.Context,
+	lock workflow.Mutex,
+	state *SubscriptionEntityState,
+	subscription *activities.SubscriptionDetails,
+	record func(eventType, detail string),
+) error {
+	logger := workflow.GetLogger(ctx)
+
+	reminderDays := state.TrialReminderDays
+	if reminderDays <= 0 {
+		reminderDays = DefaultTrialReminderDays
+	}
+
+	for state.Status == lifecycle.StatusTrialing {
+		// Sleep until the next trial milestone, waking early if the trial is extended or canceled
+		trialEnd := state.TrialEnd
+		next := trialEnd
+		if !state.TrialReminderSent {
+			next = trialEnd.AddDate(0, 0, -reminderDays)
+		}
+		if wait := next.Sub(workflow.Now(ctx)); wait > 0 {
+			changed := func() bool { return state.Status != lifecycle.StatusTrialing || !state.TrialEnd.Equal(trialEnd) }
+			if _, err := workflow.AwaitWithTimeout(ctx, wait, changed); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !state.TrialReminderSent {
+			reminder := activities.TrialReminder{
+				SubscriptionID: subscription.ID,
+				CustomerID:     subscription.CustomerID,
+				PlanID:         subscription.PlanID,
+				Price:          subscription.PricePerMonth,
+				TrialEnd:       trialEnd,
+			}
+			err := workflow.ExecuteActivity(ctx, activities.SendTrialEndingEmailActivity, reminder).Get(ctx, nil)
+			if err != nil {
+				logger.Error("Failed to send trial ending email", "error", err)
+				// Continue despite email failure
+			}
+			state.TrialReminderSent = true
+			record("trial_reminder_sent", "trial ends "+trialEnd.Format(time.RFC3339))
+			continue
+		}
+
+		if err := convertTrial(ctx, lock, state, subscription, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertTrial charges the first period after a trial and activates or expires the subscription
+func convertTrial(
+	ctx workflow.Context,
+	lock workflow.Mutex,
+	state *SubscriptionEntityState,
+	subscription *activities.SubscriptionDetails,
+	record func(eventType, detail string),
+) error {
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// An update may have ended or extended the trial while this waited for the lock
+	if state.Status != lifecycle.StatusTrialing || state.TrialEnd.After(workflow.Now(ctx)) {
+		return nil
+	}
+
+	// A trial canceled at period end ends without being charged
+	if state.CancelAtPeriodEnd {
+		err := workflow.ExecuteActivity(ctx, activities.UpdateSubscriptionStatusActivity, subscription.ID, lifecycle.StatusCanceled).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+		emitStatusChange(ctx, subscription.ID, state.Status, lifecycle.StatusCanceled, "canceled at the end of the trial")
+		state.Status = lifecycle.StatusCanceled
+		subscription.Status = state.Status
+		record("canceled", "at the end of the trial")
+		notifyCanceled(ctx, *subscription, "at the end of your trial, as you requested")
+		return nil
+	}
+
+	// Reload the subscription: the plan or payment method may have changed during the trial
+	var current activities.SubscriptionDetails
+	err := workflow.ExecuteActivity(ctx, activities.LoadSubscriptionActivity, subscription.ID).Get(ctx, &current)
+	if err != nil {
+		return err
+	}
+	*subscription = current
+	var plan catalog.Plan
+	err = workflow.ExecuteActivity(ctx, activities.LoadPlanActivity, current.PlanID).Get(ctx, &plan)
+	if err != nil {
+		return err
+	}
+
+	// Billing periods start when the trial ends, which may have been extended since it began
+	schedule, err := subscriptionSchedule(current, plan.Interval)
+	if err != nil {
+		return err
+	}
+	period := schedule.Period(0)
+	// The invoice that converts the trial is finalized right away, like a subscription's first
+	invoice, payment, carried, err := chargeCycle(ctx, current, period, state.PendingAdjustments, state.Coupon, nil)
+	if err != nil && !paymentFailed(err) {
+		return err
+	}
+
+	// A trial that cannot be paid for expires instead of going to dunning
+	status := lifecycle.StatusActive
+	if err != nil {
+		status = lifecycle.StatusExpired
+	}
+	err = workflow.ExecuteActivity(ctx, activities.UpdateSubscriptionStatusActivity, current.ID, status).Get(ctx, nil)
+	if err != nil {
+		return err
+	}
+	emitStatusChange(ctx, current.ID, state.Status, status, "trial ended")
+
+	state.Status = status
+	subscription.Status = status
+	state.PendingAdjustments = carried
+	state.PeriodStart = period.Start
+	state.NextBillingDate = period.End
+	state.Schedule = schedule
+	detail := fmt.Sprintf("%s (%s) for %s, payment %s", invoice.Number, invoice.ID, invoice.Amount, payment.Status)
+	if status == lifecycle.StatusActive {
+		state.PaidInvoiceID = invoice.ID
+		state.CyclesBilled++
+		countCouponCycle(state, record)
+		record("trial_converted", detail)
+	} else {
+		record("trial_expired", detail)
+	}
+	return nil
+}
+
+// countCouponCycle counts a billed cycle against the redeemed coupon and drops the coupon once it
+// has discounted all the cycles it is good for
+func countCouponCycle(state *SubscriptionEntityState, record func(eventType, detail string)) {
+	if !state.Coupon.Active() {
+		return
+	}
+	state.Coupon.CyclesApplied++
+	if !state.Coupon.Active() {
+		record("coupon_ended", fmt.Sprintf("%s after %d cycles", state.Coupon.Coupon.ID, state.Coupon.CyclesApplied))
+		state.Coupon = activities.CouponRedemption{}
+	}
+}
+
+// changePlan prorates a plan change over the current period from workflow time and either invoices
+// it right away or returns the prorated items to be carried to the next invoice, depending on the
+// new plan's policy
+func changePlan(
+	ctx workflow.Context,
+	subscription activities.SubscriptionDetails,
+	period activities.BillingPeriod,
+	request ChangePlanRequest,
+) (ChangePlanResult, []activities.InvoiceItem, activities.SubscriptionDetails, error) {
+	quantity := request.Quantity
+	if quantity <= 0 {
+		quantity = subscription.Quantity
+	}
+
+	// Load both plans to name the change and price the new one
+	var oldPlan, newPlan catalog.Plan
+	if err := workflow.ExecuteActivity(ctx, activities.LoadPlanActivity, subscription.PlanID).Get(ctx, &oldPlan); err != nil {
+		return ChangePlanResult{}, nil, subscription, err
+	}
+	if err := workflow.ExecuteActivity(ctx, activities.LoadPlanActivity, request.PlanID).Get(ctx, &newPlan); err != nil {
+		return ChangePlanResult{}, nil, subscription, err
+	}
+
+	// The new plan is priced in the subscription's currency, which may need converting
+	var newAmount money.Money
+	err := workflow.ExecuteActivity(ctx, activities.PricePlanActivity,
+		newPlan.ID, quantity, subscription.PricePerMonth.Currency()).Get(ctx, &newAmount)
+	if err != nil {
+		return ChangePlanResult{}, nil, subscription, err
+	}
+
+	now := workflow.Now(ctx)
+	prorated, err := proration.Calculate(proration.Change{
+		PeriodStart: period.Start,
+		PeriodEnd:   period.End,
+		ChangeAt:    now,
+		OldAmount:   subscription.PricePerMonth,
+		NewAmount:   newAmount,
+	})
+	if err != nil {
+		return ChangePlanResult{}, nil, subscription, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidPlanChange", err)
+	}
+
+	result := ChangePlanResult{
+		FromPlanID: oldPlan.ID,
+		ToPlanID:   newPlan.ID,
+		Policy:     newPlan.Proration,
+		Proration:  prorated,
+	}
+	remaining := activities.BillingPeriod{Start: now, End: period.End}
+	items := []activities.InvoiceItem{
+		{
+			Description: fmt.Sprintf("Unused time on %s", oldPlan.ID),
+			Amount:      prorated.Credit.Neg(),
+			Quantity:    subscription.Quantity,
+			Period:      remaining,
+		},
+		{
+			Description: fmt.Sprintf("Remaining time on %s", newPlan.ID),
+			Amount:      prorated.Charge,
+			Quantity:    quantity,
+			Period:      remaining,
+		},
+	}
+
+	// Move the subscription to the new plan before billing the difference
+	var updated activities.SubscriptionDetails
+	err = workflow.ExecuteActivity(ctx, activities.ChangeSubscriptionPlanActivity, subscription.ID, newPlan.ID, quantity).Get(ctx, &updated)
+	if err != nil {
+		return ChangePlanResult{}, nil, subscription, err
+	}
+
+	// Credits cannot be charged, so a net credit is always carried to the next invoice
+	if newPlan.Proration == catalog.ProrationNextCycle || prorated.Net.Sign() <= 0 {
+		result.Carried = true
+		return result, items, updated, nil
+	}
+
+	// Coupons discount billing cycles, so the prorated difference is invoiced without one. The
+	// invoice is finalized right away, since the customer asked for the change.
+	charges := activities.Charges{Period: period, Items: items, Total: prorated.Net}
+	invoice, err := generateInvoice(ctx, updated, charges, activities.CouponRedemption{}, nil)
+	if err != nil {
+		return ChangePlanResult{}, nil, updated, err
+	}
+	result.InvoiceID = invoice.ID
+	result.InvoiceNumber = invoice.Number
+
+	_, err = processPayment(ctx, invoice, updated, 0)
+	if err != nil && !paymentFailed(err) {
+		return ChangePlanResult{}, nil, updated, err
+	}
+	if err != nil {
+		if err := startDunning(ctx, updated, invoice); err != nil {
+			return ChangePlanResult{}, nil, updated, err
+		}
+		return result, nil, updated, nil
+	}
+	if err := markInvoice(ctx, &invoice, invoices.StatusPaid); err != nil {
+		return ChangePlanResult{}, nil, updated, err
+	}
+	return result, nil, updated, nil
+}
+
+// refundUnusedTime refunds the part of the subscription's price for the rest of the period. It runs a
+// RefundWorkflow against the last invoice the subscription paid, so the refund goes through the
+// payment gateway with a credit note and is capped at what is left to refund on that invoice.
+// Nothing is refunded, and the zero RefundDetails returned, when no time is left, no invoice was
+// paid or the invoice has already been refunded in full.
+func refundUnusedTime(
+	ctx workflow.Context,
+	subscription activities.SubscriptionDetails,
+	period activities.BillingPeriod,
+	invoiceID string,
+) (activities.RefundDetails, error) {
+	prorated, err := proration.Calculate(proration.Change{
+		PeriodStart: period.Start,
+		PeriodEnd:   period.End,
+		ChangeAt:    workflow.Now(ctx),
+		OldAmount:   subscription.PricePerMonth,
+		NewAmount:   money.Zero(subscription.PricePerMonth.Currency()),
+	})
+	if err != nil {
+		return activities.RefundDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidRefund", err)
+	}
+	if prorated.Credit.Sign() <= 0 || invoiceID == "" {
+		return activities.RefundDetails{}, nil
+	}
+
+	var invoice activities.RefundableInvoice
+	err = workflow.ExecuteActivity(ctx, activities.LoadRefundableInvoiceActivity, invoiceID).Get(ctx, &invoice)
+	if err != nil {
+		return activities.RefundDetails{}, err
+	}
+	remaining, err := invoice.Remaining()
+	if err != nil {
+		return activities.RefundDetails{}, err
+	}
+	amount := prorated.Credit
+	cmp, err := amount.Cmp(remaining)
+	if err != nil {
+		return activities.RefundDetails{}, temporal.NewNonRetryableApplicationError(err.Error(), "CurrencyMismatch", err)
+	}
+	if cmp > 0 {
+		amount = remaining
+	}
+	if amount.Sign() <= 0 {
+		return activities.RefundDetails{}, nil
+	}
+
+	// The refund workflow's ID is the one refunds of the invoice are started under, so it never
+	// runs alongside another refund of the same invoice
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{WorkflowID: "refund-" + invoiceID})
+	params := RefundParams{
+		InvoiceID: invoiceID,
+		Amount:    amount,
+		Reason:    fmt.Sprintf("Unused time on %s", subscription.PlanID),
+	}
+	var refund RefundState
+	if err := workflow.ExecuteChildWorkflow(childCtx, RefundWorkflow, params).Get(ctx, &refund); err != nil {
+		return activities.RefundDetails{}, err
+	}
+	refund.Refund.SubscriptionID = subscription.ID
+	return refund.Refund, nil
+}
+
+// transitionError rejects a status change with a typed application error, so callers can tell
+// an illegal transition apart from other failures
+func transitionError(from, to lifecycle.Status) error {
+	err := &lifecycle.TransitionError{From: from, To: to}
+	return temporal.NewNonRetryableApplicationError(err.Error(), lifecycle.InvalidTransitionErrorType, err)
+}
+
+// checkTransition rejects moving to the current status or to one the lifecycle does not allow
+func checkTransition(from, to lifecycle.Status) error {
+	if from == to || !lifecycle.CanTransition(from, to) {
+		return transitionError(from, to)
+	}
+	return nil
+}
+
+// subscriptionSchedule lays out a subscription's billing periods of an interval. They start on
+// its billing day in the customer's time zone, from when it started or, after a trial, from when
+// the trial ended.
+func subscriptionSchedule(subscription activities.SubscriptionDetails, interval catalog.Interval) (periods.Schedule, error) {
+	anchor := subscription.StartDate
+	if !subscription.TrialEnd.IsZero() {
+		anchor = subscription.TrialEnd
+	}
+	return periods.NewSchedule(interval, anchor, subscription.BillingDay, subscription.TimeZone)
+}
@@ -0,0 +1,697 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/proration"
+	"github.com/tanint/play-temporal/tax"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// entityBackend stands in for the stores and payment gateway behind the entity workflow's activities
+type entityBackend struct {
+	subscription activities.SubscriptionDetails
+	// declined makes every charge fail as declined, as does a subscription without a payment method
+	declined bool
+	// credited is how much of each paid invoice earlier credit notes have already refunded
+	credited money.Money
+
+	invoices       []activities.InvoiceDetails
+	statuses       []lifecycle.Status
+	trialReminders []time.Time
+	dunning        []string
+	// dunningCanceled are the dunning workflows the entity requested cancellation of
+	dunningCanceled []string
+	refunds         []RefundParams
+
+	// failStatusUpdates fails every status update, and cancelStatusUpdates cancels the workflow
+	// during one instead, as a step after the payment going wrong in SubscriptionWorkflow
+	failStatusUpdates   bool
+	cancelStatusUpdates bool
+	// compensations are the compensating activities SubscriptionWorkflow ran, in order
+	compensations []string
+}
+
+// billedPeriods returns the periods the finalized invoices charge for, in order
+func (b *entityBackend) billedPeriods() []activities.BillingPeriod {
+	billed := make([]activities.BillingPeriod, len(b.invoices))
+	for i, invoice := range b.invoices {
+		billed[i] = invoice.Period
+	}
+	return billed
+}
+
+// newEntityTestEnv returns a test environment for SubscriptionEntityWorkflow, RecurringBillingWorkflow
+// and SubscriptionWorkflow, whose activities work on backend. Webhooks, dunning and refunds are stubbed out; dunning only records the invoice it was
+// started for, or the workflow ID it was canceled by, and refunds only record what they were asked to refund.
+func newEntityTestEnv(backend *entityBackend) *testsuite.TestWorkflowEnvironment {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(SubscriptionEntityWorkflow)
+	env.RegisterWorkflow(RecurringBillingWorkflow)
+	env.RegisterWorkflow(SubscriptionWorkflow)
+	stubWebhookDelivery(env)
+	stubEmails(env)
+	env.RegisterWorkflowWithOptions(func(ctx workflow.Context, params DunningParams) (DunningState, error) {
+		backend.dunning = append(backend.dunning, params.Invoice.ID)
+		return DunningState{}, nil
+	}, workflow.RegisterOptions{Name: "DunningWorkflow"})
+	env.RegisterWorkflowWithOptions(func(ctx workflow.Context, params RefundParams) (RefundState, error) {
+		backend.refunds = append(backend.refunds, params)
+		refund := activities.RefundDetails{ID: "re_" + params.InvoiceID, Amount: params.Amount, Reason: params.Reason, Status: "succeeded"}
+		return RefundState{InvoiceID: params.InvoiceID, Status: RefundCompleted, Amount: params.Amount, Refund: refund}, nil
+	}, workflow.RegisterOptions{Name: "RefundWorkflow"})
+
+	register := func(name string, fn any) {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+	register("LoadSubscriptionActivity", func(ctx context.Context, subscriptionID string) (activities.SubscriptionDetails, error) {
+		return backend.subscription, nil
+	})
+	register("LoadPlanActivity", func(ctx context.Context, planID string) (catalog.Plan, error) {
+		return catalog.Plan{ID: planID, Currency: "USD", Interval: catalog.IntervalMonth}, nil
+	})
+	register("UpdateSubscriptionStatusActivity", func(ctx context.Context, subscriptionID string, status lifecycle.Status) error {
+		switch {
+		case backend.failStatusUpdates:
+			return temporal.NewNonRetryableApplicationError("subscription store unavailable", "StoreUnavailable", nil)
+		case backend.cancelStatusUpdates:
+			env.CancelWorkflow()
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		if err := lifecycle.Transition(backend.subscription.Status, status); err != nil {
+			return temporal.NewNonRetryableApplicationError(err.Error(), lifecycle.InvalidTransitionErrorType, err)
+		}
+		backend.subscription.Status = status
+		backend.statuses = append(backend.statuses, status)
+		return nil
+	})
+	register("ExtendTrialActivity", func(ctx context.Context, subscriptionID string, trialEnd time.Time) error {
+		backend.subscription.TrialEnd = trialEnd
+		backend.subscription.BillingDay = trialEnd.Day()
+		return nil
+	})
+	register("SendTrialEndingEmailActivity", func(ctx context.Context, reminder activities.TrialReminder) error {
+		backend.trialReminders = append(backend.trialReminders, reminder.TrialEnd)
+		return nil
+	})
+
+	// Billing a cycle
+	register("CalculateChargesActivity", func(ctx context.Context, subscription activities.SubscriptionDetails, period activities.BillingPeriod) (activities.Charges, error) {
+		item := activities.InvoiceItem{Description: subscription.PlanID, Amount: subscription.PricePerMonth, Quantity: 1, Period: period}
+		return activities.Charges{Period: period, Items: []activities.InvoiceItem{item}, Total: subscription.PricePerMonth}, nil
+	})
+	register("CreateDraftInvoiceActivity", func(ctx context.Context, subscription activities.SubscriptionDetails, charges activities.Charges, invoiceID string) (activities.InvoiceDetails, error) {
+		return activities.InvoiceDetails{
+			ID:             invoiceID,
+			SubscriptionID: subscription.ID,
+			Amount:         charges.Total,
+			Currency:       charges.Total.Currency(),
+			Status:         invoices.StatusDraft,
+			Period:         charges.Period,
+			Items:          charges.Items,
+		}, nil
+	})
+	register("CalculateTaxActivity", func(ctx context.Context, subscription activities.SubscriptionDetails, charges activities.Charges) (tax.Summary, error) {
+		return tax.Summary{Subtotal: charges.Total, Total: charges.Total}, nil
+	})
+	register("GenerateInvoiceActivity", func(ctx context.Context, draft activities.InvoiceDetails, charges activities.Charges, taxes tax.Summary) (activities.InvoiceDetails, error) {
+		draft.Amount = taxes.Total
+		return draft, nil
+	})
+	register("ApplyCustomerBalanceActivity", func(ctx context.Context, invoice activities.InvoiceDetails, customerID string) (activities.InvoiceDetails, error) {
+		return invoice, nil
+	})
+	register("FinalizeInvoiceActivity", func(ctx context.Context, invoice activities.InvoiceDetails) (activities.InvoiceDetails, error) {
+		invoice.Status = invoices.StatusOpen
+		invoice.Number = fmt.Sprintf("INV-%06d", len(backend.invoices)+1)
+		backend.invoices = append(backend.invoices, invoice)
+		return invoice, nil
+	})
+	register("PostInvoiceActivity", func(ctx context.Context, invoice activities.InvoiceDetails, subscription activities.SubscriptionDetails) error {
+		return nil
+	})
+	register("ProcessPaymentActivity", func(ctx context.Context, invoice activities.InvoiceDetails, subscription activities.SubscriptionDetails, attempt int) (activities.PaymentDetails, error) {
+		payment := activities.PaymentDetails{ID: "py_" + invoice.ID, InvoiceID: invoice.ID, Amount: invoice.Amount, Status: "succeeded"}
+		if backend.declined || subscription.PaymentMethodID == "" {
+			payment.Status = "failed"
+			return activities.PaymentDetails{}, temporal.NewNonRetryableApplicationError(
+				"card declined", activities.CardDeclinedErrorType, nil, payment)
+		}
+		return payment, nil
+	})
+	register("UpdateInvoiceStatusActivity", func(ctx context.Context, invoiceID string, status invoices.Status) (activities.InvoiceDetails, error) {
+		for i := range backend.invoices {
+			if backend.invoices[i].ID == invoiceID {
+				backend.invoices[i].Status = status
+				return backend.invoices[i], nil
+			}
+		}
+		return activities.InvoiceDetails{}, activities.ErrInvoiceNotFound
+	})
+	register("RenderInvoiceActivity", func(ctx context.Context, invoice activities.InvoiceDetails, subscription activities.SubscriptionDetails, payment activities.PaymentDetails) (activities.InvoiceDocuments, error) {
+		return activities.InvoiceDocuments{}, nil
+	})
+	register("SendInvoiceEmailActivity", func(ctx context.Context, invoice activities.InvoiceDetails, subscription activities.SubscriptionDetails, payment activities.PaymentDetails, documents activities.InvoiceDocuments) error {
+		return nil
+	})
+	register("ListOpenInvoicesActivity", func(ctx context.Context, subscriptionID string) ([]activities.InvoiceDetails, error) {
+		var open []activities.InvoiceDetails
+		for _, invoice := range backend.invoices {
+			if invoice.Status == invoices.StatusOpen {
+				open = append(open, invoice)
+			}
+		}
+		return open, nil
+	})
+	register("FindUnbilledPeriodsActivity", func(ctx context.Context, subscriptionID string, periods []activities.BillingPeriod) ([]activities.BillingPeriod, error) {
+		var unbilled []activities.BillingPeriod
+		for _, period := range periods {
+			billed := false
+			for _, invoice := range backend.invoices {
+				if invoice.Status != invoices.StatusVoid && invoice.Period.Start.Equal(period.Start) && invoice.Period.End.Equal(period.End) {
+					billed = true
+				}
+			}
+			if !billed {
+				unbilled = append(unbilled, period)
+			}
+		}
+		return unbilled, nil
+	})
+	register("LoadRefundableInvoiceActivity", func(ctx context.Context, invoiceID string) (activities.RefundableInvoice, error) {
+		for _, invoice := range backend.invoices {
+			if invoice.ID == invoiceID && invoice.Status == invoices.StatusPaid {
+				credited := backend.credited
+				if credited.IsZero() {
+					credited = money.Zero(invoice.Amount.Currency())
+				}
+				return activities.RefundableInvoice{
+					InvoiceID:      invoiceID,
+					SubscriptionID: invoice.SubscriptionID,
+					CustomerID:     backend.subscription.CustomerID,
+					Captured:       invoice.Amount,
+					Credited:       credited,
+				}, nil
+			}
+		}
+		return activities.RefundableInvoice{}, temporal.NewNonRetryableApplicationError("invoice not paid", "InvoiceNotPaid", nil)
+	})
+	register("CreateSubscriptionActivity", func(ctx context.Context, request activities.CreateSubscriptionRequest) (activities.SubscriptionDetails, error) {
+		backend.subscription.ID = request.SubscriptionID
+		return backend.subscription, nil
+	})
+	register("DeleteSubscriptionActivity", func(ctx context.Context, subscriptionID string) error {
+		backend.compensations = append(backend.compensations, "DeleteSubscriptionActivity")
+		return nil
+	})
+	register("VoidInvoiceActivity", func(ctx context.Context, invoice activities.InvoiceDetails, customerID string) (activities.InvoiceDetails, error) {
+		backend.compensations = append(backend.compensations, "VoidInvoiceActivity")
+		invoice.Status = invoices.StatusVoid
+		return invoice, nil
+	})
+	register("ReversePaymentActivity", func(ctx context.Context, payment activities.PaymentDetails, customerID string, reason string) (activities.RefundDetails, error) {
+		backend.compensations = append(backend.compensations, "ReversePaymentActivity")
+		return activities.RefundDetails{ID: "re_" + payment.ID, Amount: payment.Amount, Reason: reason, Status: "succeeded"}, nil
+	})
+	register("ReconcileLedgerActivity", func(ctx context.Context, invoices []activities.InvoiceDetails) (activities.Reconciliation, error) {
+		return activities.Reconciliation{Invoices: len(invoices)}, nil
+	})
+	env.OnRequestCancelExternalWorkflow("default-test-namespace", mock.Anything, "").Return(nil).Run(func(args mock.Arguments) {
+		backend.dunningCanceled = append(backend.dunningCanceled, args.String(1))
+	}).Maybe()
+	return env
+}
+
+// sendEntityUpdate sends an update to the entity workflow after a delay and reports through the
+// returned function whether it was rejected, with the error, once the workflow has run
+func sendEntityUpdate(env *testsuite.TestWorkflowEnvironment, after time.Duration, name string, args ...any) func() error {
+	var rejected error
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(name, fmt.Sprintf("%s-%s", name, after), &testsuite.TestUpdateCallback{
+			OnReject:   func(err error) { rejected = err },
+			OnAccept:   func() {},
+			OnComplete: func(any, error) {},
+		}, args...)
+	}, after)
+	return func() error { return rejected }
+}
+
+// entityStatus queries the entity workflow's status
+func entityStatus(t *testing.T, env *testsuite.TestWorkflowEnvironment) SubscriptionStatus {
+	t.Helper()
+	result, err := env.QueryWorkflow("get_status")
+	if err != nil {
+		t.Fatalf("get_status query failed: %v", err)
+	}
+	var status SubscriptionStatus
+	if err := result.Get(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// monthlyPeriods returns n monthly billing periods starting on the 15th of start's month
+func monthlyPeriods(start time.Time, n int) []activities.BillingPeriod {
+	periods := make([]activities.BillingPeriod, n)
+	for i := range periods {
+		periods[i] = activities.BillingPeriod{Start: start.AddDate(0, i, 0), End: start.AddDate(0, i+1, 0)}
+	}
+	return periods
+}
+
+// checkPeriods fails the test unless got and want are the same periods
+func checkPeriods(t *testing.T, got, want []activities.BillingPeriod) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("billed %d periods %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if !got[i].Start.Equal(want[i].Start) || !got[i].End.Equal(want[i].End) {
+			t.Errorf("period %d = %s to %s, want %s to %s", i, got[i].Start, got[i].End, want[i].Start, want[i].End)
+		}
+	}
+}
+
+// activeSubscription is a monthly subscription billed on the 15th since January 2025
+func activeSubscription() activities.SubscriptionDetails {
+	return activities.SubscriptionDetails{
+		ID:              "sub_entity",
+		CustomerID:      "cust_1",
+		PlanID:          "premium-monthly",
+		Quantity:        1,
+		PricePerMonth:   money.MustParse("49.99", "USD"),
+		StartDate:       time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC),
+		BillingDay:      15,
+		Status:          lifecycle.StatusActive,
+		PaymentMethodID: "pm_card",
+	}
+}
+
+func TestSubscriptionEntityBillsEachCycle(t *testing.T) {
+	backend := &entityBackend{subscription: activeSubscription()}
+	env := newEntityTestEnv(backend)
+	env.SetStartTime(time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC))
+
+	// Bill on February 15th and March 15th, then cancel on April 15th instead of billing
+	scheduled := sendEntityUpdate(env, 60*24*time.Hour, CancelUpdateName, CancelRequest{AtPeriodEnd: true})
+	env.ExecuteWorkflow(SubscriptionEntityWorkflow, SubscriptionEntityParams{SubscriptionID: "sub_entity"})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	if err := scheduled(); err != nil {
+		t.Fatalf("cancel at period end was rejected: %v", err)
+	}
+	checkPeriods(t, backend.billedPeriods(), monthlyPeriods(time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC), 2))
+	for _, invoice := range backend.invoices {
+		if invoice.Status != invoices.StatusPaid {
+			t.Errorf("invoice %s is %s, want paid", invoice.ID, invoice.Status)
+		}
+	}
+	status := entityStatus(t, env)
+	if status.Status != lifecycle.StatusCanceled || status.CyclesBilled != 2 {
+		t.Errorf("subscription is %s after %d cycles, want canceled after 2", status.Status, status.CyclesBilled)
+	}
+	if backend.subscription.Status != lifecycle.StatusCanceled {
+		t.Errorf("stored subscription is %s, want canceled", backend.subscription.Status)
+	}
+}
+
+func TestSubscriptionEntityUpdates(t *testing.T) {
+	day := 24 * time.Hour
+	backend := &entityBackend{subscription: activeSubscription()}
+	env := newEntityTestEnv(backend)
+	env.SetStartTime(time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC))
+
+	// Pause over the February 15th billing date until March 1st, then cancel on April 1st
+	resumeAt := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	updates := []struct {
+		name     string
+		rejected bool
+		result   func() error
+	}{
+		{name: "resume an active subscription", rejected: true, result: sendEntityUpdate(env, day, ResumeUpdateName)},
+		{name: "extend the trial of an active subscription", rejected: true, result: sendEntityUpdate(env, day, ExtendTrialUpdateName, ExtendTrialRequest{Days: 7})},
+		{name: "change to the current plan", rejected: true, result: sendEntityUpdate(env, day, ChangePlanUpdateName, ChangePlanRequest{PlanID: "premium-monthly"})},
+		{name: "pause with a resume date in the past", rejected: true, result: sendEntityUpdate(env, day, PauseUpdateName, PauseRequest{ResumeAt: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)})},
+		{name: "refund when canceling at period end", rejected: true, result: sendEntityUpdate(env, day, CancelUpdateName, CancelRequest{AtPeriodEnd: true, Refund: true})},
+		{name: "pause", result: sendEntityUpdate(env, 2*day, PauseUpdateName, PauseRequest{ResumeAt: resumeAt})},
+		{name: "pause a paused subscription", rejected: true, result: sendEntityUpdate(env, 3*day, PauseUpdateName, PauseRequest{})},
+		{name: "cancel", result: sendEntityUpdate(env, 71*day, CancelUpdateName, CancelRequest{})},
+	}
+	env.ExecuteWorkflow(SubscriptionEntityWorkflow, SubscriptionEntityParams{SubscriptionID: "sub_entity"})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	for _, update := range updates {
+		if err := update.result(); (err != nil) != update.rejected {
+			t.Errorf("%s: rejected with %v, want rejected %t", update.name, err, update.rejected)
+		}
+	}
+
+	// February's cycle was skipped while paused; March's was billed after resuming by itself
+	checkPeriods(t, backend.billedPeriods(), monthlyPeriods(time.Date(2025, time.February, 15, 0, 0, 0, 0, time.UTC), 1))
+	want := []lifecycle.Status{lifecycle.StatusPaused, lifecycle.StatusActive, lifecycle.StatusActive, lifecycle.StatusCanceled}
+	if fmt.Sprint(backend.statuses) != fmt.Sprint(want) {
+		t.Errorf("subscription went through %v, want %v", backend.statuses, want)
+	}
+}
+
+func TestSubscriptionEntitySyncsStatusChangedOutsideIt(t *testing.T) {
+	day := 24 * time.Hour
+	backend := &entityBackend{subscription: activeSubscription(), declined: true}
+	env := newEntityTestEnv(backend)
+	env.SetStartTime(time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC))
+
+	// changeOutside changes the stored status after a delay, as dunning does, and signals the entity
+	changeOutside := func(after time.Duration, status lifecycle.Status) {
+		env.RegisterDelayedCallback(func() {
+			backend.subscription.Status = status
+			env.SignalWorkflow(SubscriptionChangedSignalName, nil)
+		}, after)
+	}
+
+	// The February 15th payment fails. Dunning recovers it on the 20th, which allows a pause, and
+	// the subscription is resumed. Then March 15th fails and dunning gives up on the 25th: the
+	// April 15th cycle is skipped while unpaid, and a pause is not allowed.
+	env.RegisterDelayedCallback(func() { backend.declined = false }, 28*day)
+	changeOutside(31*day, lifecycle.StatusActive)
+	pausedAfterRecovery := sendEntityUpdate(env, 32*day, PauseUpdateName, PauseRequest{})
+	sendEntityUpdate(env, 33*day, ResumeUpdateName)
+	env.RegisterDelayedCallback(func() { backend.declined = true }, 40*day)
+	changeOutside(64*day, lifecycle.StatusUnpaid)
+	pausedWhileUnpaid := sendEntityUpdate(env, 65*day, PauseUpdateName, PauseRequest{})
+	sendEntityUpdate(env, 100*day, CancelUpdateName, CancelRequest{})
+	env.ExecuteWorkflow(SubscriptionEntityWorkflow, SubscriptionEntityParams{SubscriptionID: "sub_entity"})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	if err := pausedAfterRecovery(); err != nil {
+		t.Errorf("pause after dunning recovered the payment was rejected: %v", err)
+	}
+	if err := pausedWhileUnpaid(); err == nil {
+		t.Error("pause of an unpaid subscription was accepted")
+	}
+	checkPeriods(t, backend.billedPeriods(), monthlyPeriods(time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC), 2))
+	if len(backend.dunning) != 2 {
+		t.Errorf("dunning started for %v, want both invoices", backend.dunning)
+	}
+	if status := entityStatus(t, env); status.Status != lifecycle.StatusCanceled || status.CyclesBilled != 2 {
+		t.Errorf("subscription is %s after %d cycles, want canceled after 2", status.Status, status.CyclesBilled)
+	}
+}
+
+func TestSubscriptionEntityContinuesAsNew(t *testing.T) {
+	backend := &entityBackend{subscription: activeSubscription()}
+	env := newEntityTestEnv(backend)
+	start := time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+	env.ExecuteWorkflow(SubscriptionEntityWorkflow, SubscriptionEntityParams{SubscriptionID: "sub_entity"})
+
+	// The run ends after its cycles, carrying its state into the next run
+	var continued *workflow.ContinueAsNewError
+	if err := env.GetWorkflowError(); !errors.As(err, &continued) {
+		t.Fatalf("workflow ended with %v, want to continue as new", err)
+	}
+	var params SubscriptionEntityParams
+	if err := converter.GetDefaultDataConverter().FromPayloads(continued.Input, &params); err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC)
+	checkPeriods(t, backend.billedPeriods(), monthlyPeriods(first, entityCyclesPerRun))
+	if params.State == nil || params.State.CyclesBilled != entityCyclesPerRun ||
+		!params.State.NextBillingDate.Equal(first.AddDate(0, entityCyclesPerRun+1, 0)) {
+		t.Fatalf("continued with state %+v, want %d cycles billed", params.State, entityCyclesPerRun)
+	}
+
+	// The next run picks up at the following billing date
+	backend.invoices = nil
+	next := newEntityTestEnv(backend)
+	next.SetStartTime(first.AddDate(0, entityCyclesPerRun, 1))
+	sendEntityUpdate(next, 40*24*time.Hour, CancelUpdateName, CancelRequest{})
+	next.ExecuteWorkflow(SubscriptionEntityWorkflow, params)
+
+	if err := next.GetWorkflowError(); err != nil {
+		t.Fatalf("continued workflow failed: %v", err)
+	}
+	checkPeriods(t, backend.billedPeriods(), monthlyPeriods(first.AddDate(0, entityCyclesPerRun, 0), 1))
+	if status := entityStatus(t, next); status.CyclesBilled != entityCyclesPerRun+1 {
+		t.Errorf("continued run has %d cycles billed, want %d", status.CyclesBilled, entityCyclesPerRun+1)
+	}
+}
+
+func TestSubscriptionEntityTrial(t *testing.T) {
+	day := 24 * time.Hour
+	trialEnd := time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// extendAfter sends an extend_trial update by a week after this long, zero for never
+		extendAfter   time.Duration
+		noPayment     bool
+		reminders     []time.Time
+		billed        []activities.BillingPeriod
+		paid          bool
+		statuses      []lifecycle.Status
+		extendRejects bool
+	}{
+		{
+			name:      "converted to paid",
+			reminders: []time.Time{trialEnd},
+			billed:    monthlyPeriods(trialEnd, 1),
+			paid:      true,
+			statuses:  []lifecycle.Status{lifecycle.StatusActive, lifecycle.StatusCanceled},
+		},
+		{
+			name:      "expires without a payment method",
+			noPayment: true,
+			reminders: []time.Time{trialEnd},
+			billed:    monthlyPeriods(trialEnd, 1),
+			statuses:  []lifecycle.Status{lifecycle.StatusExpired},
+		},
+		{
+			name:        "extended after the reminder",
+			extendAfter: 13 * day,
+			reminders:   []time.Time{trialEnd, trialEnd.AddDate(0, 0, 7)},
+			billed:      monthlyPeriods(trialEnd.AddDate(0, 0, 7), 1),
+			paid:        true,
+			statuses:    []lifecycle.Status{lifecycle.StatusActive, lifecycle.StatusCanceled},
+		},
+		{
+			name:        "extended before the reminder",
+			extendAfter: 5 * day,
+			reminders:   []time.Time{trialEnd.AddDate(0, 0, 7)},
+			billed:      monthlyPeriods(trialEnd.AddDate(0, 0, 7), 1),
+			paid:        true,
+			statuses:    []lifecycle.Status{lifecycle.StatusActive, lifecycle.StatusCanceled},
+		},
+		{
+			name:          "extended once converted",
+			extendAfter:   20 * day,
+			reminders:     []time.Time{trialEnd},
+			billed:        monthlyPeriods(trialEnd, 1),
+			paid:          true,
+			statuses:      []lifecycle.Status{lifecycle.StatusActive, lifecycle.StatusCanceled},
+			extendRejects: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscription := activeSubscription()
+			subscription.StartDate = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+			subscription.TrialEnd = trialEnd
+			subscription.Status = lifecycle.StatusTrialing
+			if tt.noPayment {
+				subscription.PaymentMethodID = ""
+			}
+			backend := &entityBackend{subscription: subscription}
+			env := newEntityTestEnv(backend)
+			env.SetStartTime(subscription.StartDate)
+
+			var reminded []time.Time
+			env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+				if info.ActivityType.Name == "SendTrialEndingEmailActivity" {
+					reminded = append(reminded, env.Now())
+				}
+			})
+			extended := func() error { return nil }
+			if tt.extendAfter > 0 {
+				extended = sendEntityUpdate(env, tt.extendAfter, ExtendTrialUpdateName, ExtendTrialRequest{Days: 7})
+			}
+			// A converted subscription is canceled once its trial, however extended, is over
+			sendEntityUpdate(env, 25*day, CancelUpdateName, CancelRequest{})
+			env.ExecuteWorkflow(SubscriptionEntityWorkflow, SubscriptionEntityParams{SubscriptionID: subscription.ID})
+
+			if err := env.GetWorkflowError(); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			if err := extended(); (err != nil) != tt.extendRejects {
+				t.Errorf("extend_trial rejected with %v, want rejected %t", err, tt.extendRejects)
+			}
+
+			// Each reminder is sent three days before the trial it announces ends
+			if len(backend.trialReminders) != len(tt.reminders) || len(reminded) != len(tt.reminders) {
+				t.Fatalf("sent reminders for trials ending %v, want %v", backend.trialReminders, tt.reminders)
+			}
+			for i, end := range tt.reminders {
+				if !backend.trialReminders[i].Equal(end) || !reminded[i].Equal(end.AddDate(0, 0, -DefaultTrialReminderDays)) {
+					t.Errorf("reminder %d sent %s for a trial ending %s, want %s for %s", i,
+						reminded[i], backend.trialReminders[i], end.AddDate(0, 0, -DefaultTrialReminderDays), end)
+				}
+			}
+
+			checkPeriods(t, backend.billedPeriods(), tt.billed)
+			if paid := backend.invoices[0].Status == invoices.StatusPaid; paid != tt.paid {
+				t.Errorf("trial's invoice is %s, want paid %t", backend.invoices[0].Status, tt.paid)
+			}
+			if fmt.Sprint(backend.statuses) != fmt.Sprint(tt.statuses) {
+				t.Errorf("subscription went through %v, want %v", backend.statuses, tt.statuses)
+			}
+			if len(backend.dunning) != 0 {
+				t.Errorf("dunning started for %v, want a failed trial to expire instead", backend.dunning)
+			}
+		})
+	}
+}
+
+func TestSubscriptionEntityRefundsUnusedTime(t *testing.T) {
+	day := 24 * time.Hour
+	// Canceled on March 1st, halfway through the period from February 15th to March 15th
+	halfway, err := proration.Calculate(proration.Change{
+		PeriodStart: time.Date(2025, time.February, 15, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2025, time.March, 15, 0, 0, 0, 0, time.UTC),
+		ChangeAt:    time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC),
+		OldAmount:   money.MustParse("49.99", "USD"),
+		NewAmount:   money.Zero("USD"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cancelAt time.Duration
+		credited money.Money
+		// want is the amount refunded against the invoice for January 15th to February 15th, zero for no refund
+		want money.Money
+	}{
+		{name: "unused time", cancelAt: 40 * day, want: halfway.Credit},
+		{name: "capped at what is left of the invoice", cancelAt: 40 * day, credited: money.MustParse("40.00", "USD"), want: money.MustParse("9.99", "USD")},
+		{name: "invoice refunded in full", cancelAt: 40 * day, credited: money.MustParse("49.99", "USD")},
+		{name: "no invoice paid yet", cancelAt: day},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &entityBackend{subscription: activeSubscription(), credited: tt.credited}
+			env := newEntityTestEnv(backend)
+			env.SetStartTime(time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC))
+
+			canceled := sendEntityUpdate(env, tt.cancelAt, CancelUpdateName, CancelRequest{Refund: true})
+			env.ExecuteWorkflow(SubscriptionEntityWorkflow, SubscriptionEntityParams{SubscriptionID: "sub_entity"})
+
+			if err := env.GetWorkflowError(); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			if err := canceled(); err != nil {
+				t.Fatalf("cancel was rejected: %v", err)
+			}
+			if backend.subscription.Status != lifecycle.StatusCanceled {
+				t.Errorf("stored subscription is %s, want canceled", backend.subscription.Status)
+			}
+			if tt.want.IsZero() {
+				if len(backend.refunds) != 0 {
+					t.Errorf("refunded %v, want nothing", backend.refunds)
+				}
+				return
+			}
+			if len(backend.refunds) != 1 {
+				t.Fatalf("refunded %v, want one refund", backend.refunds)
+			}
+			paid := backend.invoices[0].ID
+			if refund := backend.refunds[0]; refund.InvoiceID != paid || refund.Amount != tt.want {
+				t.Errorf("refunded %s against %s, want %s against %s", refund.Amount, refund.InvoiceID, tt.want, paid)
+			}
+		})
+	}
+}
+
+func TestSubscriptionEntityCancelStopsDunning(t *testing.T) {
+	day := 24 * time.Hour
+	backend := &entityBackend{subscription: activeSubscription(), declined: true}
+	env := newEntityTestEnv(backend)
+	env.SetStartTime(time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC))
+
+	// The charge on February 15th is declined and goes to dunning, then the customer cancels
+	canceled := sendEntityUpdate(env, 30*day, CancelUpdateName, CancelRequest{})
+	env.ExecuteWorkflow(SubscriptionEntityWorkflow, SubscriptionEntityParams{SubscriptionID: "sub_entity"})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	if err := canceled(); err != nil {
+		t.Fatalf("cancel was rejected: %v", err)
+	}
+	if len(backend.dunning) != 1 || backend.dunning[0] != backend.invoices[0].ID {
+		t.Errorf("dunning started for %v, want %s", backend.dunning, backend.invoices[0].ID)
+	}
+	if backend.subscription.Status != lifecycle.StatusCanceled {
+		t.Errorf("stored subscription is %s, want canceled", backend.subscription.Status)
+	}
+	if backend.invoices[0].Status != invoices.StatusUncollectible {
+		t.Errorf("open invoice is %s, want uncollectible", backend.invoices[0].Status)
+	}
+	if want := []string{"dunning-" + backend.invoices[0].ID}; fmt.Sprint(backend.dunningCanceled) != fmt.Sprint(want) {
+		t.Errorf("canceled dunning workflows %v, want %v", backend.dunningCanceled, want)
+	}
+}
+
+func TestSubscriptionEntityPauseEndsInvoiceGracePeriod(t *testing.T) {
+	backend := &entityBackend{subscription: activeSubscription()}
+	env := newEntityTestEnv(backend)
+	start := time.Date(2025, time.February, 14, 23, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	// February 15th's invoice is held as a draft for an hour; the pause comes ten minutes into it
+	var pausedAt time.Time
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(PauseUpdateName, "pause", &testsuite.TestUpdateCallback{
+			OnReject:   func(err error) { t.Errorf("pause was rejected: %v", err) },
+			OnAccept:   func() {},
+			OnComplete: func(any, error) { pausedAt = env.Now() },
+		}, PauseRequest{})
+	}, 70*time.Minute)
+	canceled := sendEntityUpdate(env, 24*time.Hour, CancelUpdateName, CancelRequest{})
+	env.ExecuteWorkflow(SubscriptionEntityWorkflow, SubscriptionEntityParams{SubscriptionID: "sub_entity"})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	if err := canceled(); err != nil {
+		t.Fatalf("cancel was rejected: %v", err)
+	}
+	if want := start.Add(70 * time.Minute); !pausedAt.Equal(want) {
+		t.Errorf("pause completed at %s, want %s without waiting out the grace period", pausedAt, want)
+	}
+	checkPeriods(t, backend.billedPeriods(), monthlyPeriods(time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC), 1))
+	if backend.invoices[0].Status != invoices.StatusPaid {
+		t.Errorf("invoice is %s, want paid", backend.invoices[0].Status)
+	}
+}
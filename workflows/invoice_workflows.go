@@ -0,0 +1,252 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Updates accepted, and the query answered, by billing workflows while they hold a draft invoice open
+const (
+	AddInvoiceItemUpdateName    = "add_invoice_item"
+	RemoveInvoiceItemUpdateName = "remove_invoice_item"
+	FinalizeInvoiceUpdateName   = "finalize_invoice"
+	DraftInvoiceQuery           = "get_draft_invoice"
+)
+
+// DefaultInvoiceGracePeriod is how long a renewal invoice stays a draft, open to edits, before it
+// is finalized when no grace period is configured
+const DefaultInvoiceGracePeriod = time.Hour
+
+// AddInvoiceItemRequest is the input of the add_invoice_item update
+type AddInvoiceItemRequest struct {
+	Description string
+	// Amount is the line's total, negative for a credit, in the invoice's currency
+	Amount money.Money
+	// Quantity is shown on the line. Zero means 1.
+	Quantity int64
+}
+
+// RemoveInvoiceItemRequest is the input of the remove_invoice_item update
+type RemoveInvoiceItemRequest struct {
+	// Index is the position of the item among the draft's items, from 0
+	Index int
+}
+
+// DraftInvoice is returned by the get_draft_invoice query
+type DraftInvoice struct {
+	Invoice activities.InvoiceDetails
+	// FinalizesAt is when the grace period ends and the invoice is finalized
+	FinalizesAt time.Time
+}
+
+// invoiceDrafts holds a billing workflow's draft invoice open for the grace period, during which
+// updates can add and remove its items or finalize it early. A nil *invoiceDrafts finalizes
+// invoices right away.
+type invoiceDrafts struct {
+	grace       time.Duration
+	draft       *activities.InvoiceDetails // the draft being held open, nil when there is none
+	finalizesAt time.Time
+	finalizeNow bool
+	closing     bool // the grace period is over and edits are refused
+	editing     int  // edits in flight
+}
+
+// registerInvoiceDrafts registers the draft invoice updates and query on a workflow. A grace
+// period of zero means DefaultInvoiceGracePeriod and a negative one finalizes invoices right away.
+// Edits run their activities with the given options.
+func registerInvoiceDrafts(ctx workflow.Context, grace time.Duration, ao workflow.ActivityOptions) (*invoiceDrafts, error) {
+	if grace == 0 {
+		grace = DefaultInvoiceGracePeriod
+	}
+	d := &invoiceDrafts{grace: grace}
+
+	// Edits are applied one at a time so none overwrites another
+	lock := workflow.NewMutex(ctx)
+	edit := func(ctx workflow.Context, change func(items []activities.InvoiceItem) []activities.InvoiceItem) (activities.InvoiceDetails, error) {
+		d.editing++
+		defer func() { d.editing-- }()
+		ctx = workflow.WithActivityOptions(ctx, ao)
+		if err := lock.Lock(ctx); err != nil {
+			return activities.InvoiceDetails{}, err
+		}
+		defer lock.Unlock()
+		if d.draft == nil {
+			return activities.InvoiceDetails{}, errors.New("no draft invoice is open")
+		}
+
+		edited := *d.draft
+		edited.Items = change(append([]activities.InvoiceItem(nil), d.draft.Items...))
+		err := workflow.ExecuteActivity(ctx, activities.UpdateDraftInvoiceActivity, edited).Get(ctx, &edited)
+		if err != nil {
+			return activities.InvoiceDetails{}, err
+		}
+		*d.draft = edited
+		return edited, nil
+	}
+
+	err := workflow.SetQueryHandler(ctx, DraftInvoiceQuery, func() (DraftInvoice, error) {
+		if d.draft == nil {
+			return DraftInvoice{}, errors.New("no draft invoice is open")
+		}
+		return DraftInvoice{Invoice: *d.draft, FinalizesAt: d.finalizesAt}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, AddInvoiceItemUpdateName,
+		func(ctx workflow.Context, request AddInvoiceItemRequest) (activities.InvoiceDetails, error) {
+			item := activities.InvoiceItem{Description: request.Description, Amount: request.Amount, Quantity: request.Quantity}
+			if item.Quantity == 0 {
+				item.Quantity = 1
+			}
+			return edit(ctx, func(items []activities.InvoiceItem) []activities.InvoiceItem {
+				return append(items, item)
+			})
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request AddInvoiceItemRequest) error {
+				if err := d.checkEditable(); err != nil {
+					return err
+				}
+				if request.Description == "" {
+					return errors.New("description is required")
+				}
+				if request.Amount.IsZero() {
+					return errors.New("amount is required")
+				}
+				if currency := request.Amount.Currency(); currency != d.draft.Currency {
+					return fmt.Errorf("amount is in %s but the invoice is in %s", currency, d.draft.Currency)
+				}
+				if request.Quantity < 0 {
+					return errors.New("quantity cannot be negative")
+				}
+				items := append(append([]activities.InvoiceItem(nil), d.draft.Items...), activities.InvoiceItem{Amount: request.Amount})
+				return d.checkTotal(items)
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, RemoveInvoiceItemUpdateName,
+		func(ctx workflow.Context, request RemoveInvoiceItemRequest) (activities.InvoiceDetails, error) {
+			return edit(ctx, func(items []activities.InvoiceItem) []activities.InvoiceItem {
+				// An earlier edit may have shortened the list while this one waited its turn
+				if request.Index >= len(items) {
+					return items
+				}
+				return append(items[:request.Index], items[request.Index+1:]...)
+			})
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request RemoveInvoiceItemRequest) error {
+				if err := d.checkEditable(); err != nil {
+					return err
+				}
+				if request.Index < 0 || request.Index >= len(d.draft.Items) {
+					return fmt.Errorf("invoice %s has no item %d", d.draft.ID, request.Index)
+				}
+				items := append(append([]activities.InvoiceItem(nil), d.draft.Items[:request.Index]...), d.draft.Items[request.Index+1:]...)
+				return d.checkTotal(items)
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, FinalizeInvoiceUpdateName,
+		func(ctx workflow.Context) (activities.InvoiceDetails, error) {
+			d.finalizeNow = true
+			return *d.draft, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context) error {
+				return d.checkEditable()
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// checkEditable rejects edits when no draft is being held open
+func (d *invoiceDrafts) checkEditable() error {
+	if d.draft == nil {
+		return errors.New("no draft invoice is open")
+	}
+	if d.closing {
+		return fmt.Errorf("invoice %s is being finalized", d.draft.ID)
+	}
+	return nil
+}
+
+// checkTotal rejects an edit that would leave the draft's items totaling less than zero, which
+// would invoice the customer for a negative amount
+func (d *invoiceDrafts) checkTotal(items []activities.InvoiceItem) error {
+	amounts := make([]money.Money, 0, len(items))
+	for _, item := range items {
+		amounts = append(amounts, item.Amount)
+	}
+	total, err := money.Sum(d.draft.Currency, amounts...)
+	if err != nil {
+		return err
+	}
+	if total.Sign() < 0 {
+		return fmt.Errorf("invoice %s would total %s, less than zero", d.draft.ID, total)
+	}
+	return nil
+}
+
+// endGrace ends the grace period of the draft being held open, if there is one, so that an update
+// waiting for the billing cycle to finish does not wait out the grace period
+func (d *invoiceDrafts) endGrace() {
+	if d != nil && d.draft != nil {
+		d.finalizeNow = true
+	}
+}
+
+// hold keeps a draft invoice open for edits until the grace period ends or the finalize_invoice
+// update ends it early, then waits for edits in flight and returns the draft as edited
+func (d *invoiceDrafts) hold(ctx workflow.Context, draft activities.InvoiceDetails) (activities.InvoiceDetails, error) {
+	if d == nil || d.grace < 0 {
+		return draft, nil
+	}
+
+	d.draft = &draft
+	d.finalizesAt = workflow.Now(ctx).Add(d.grace)
+	d.finalizeNow = false
+	d.closing = false
+	defer func() { d.draft = nil }()
+	workflow.GetLogger(ctx).Info("Holding draft invoice open for edits", "invoiceID", draft.ID, "finalizesAt", d.finalizesAt)
+
+	if _, err := workflow.AwaitWithTimeout(ctx, d.grace, func() bool { return d.finalizeNow }); err != nil {
+		return draft, err
+	}
+	d.closing = true
+	if err := workflow.Await(ctx, func() bool { return d.editing == 0 }); err != nil {
+		return draft, err
+	}
+	return draft, nil
+}
+
+// markInvoice moves an invoice to a new status in the invoice store
+func markInvoice(ctx workflow.Context, invoice *activities.InvoiceDetails, status invoices.Status) error {
+	var updated activities.InvoiceDetails
+	err := workflow.ExecuteActivity(ctx, activities.UpdateInvoiceStatusActivity, invoice.ID, status).Get(ctx, &updated)
+	if err != nil {
+		return err
+	}
+	invoice.Status = updated.Status
+	return nil
+}
@@ -0,0 +1,131 @@
+package workflows
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// draftTestResult is what draftTestWorkflow saw
+type draftTestResult struct {
+	Invoice activities.InvoiceDetails
+	HeldFor time.Duration
+}
+
+// draftTestWorkflow holds a draft invoice open for the grace period and returns it as edited
+func draftTestWorkflow(ctx workflow.Context, invoiceID string, grace time.Duration) (draftTestResult, error) {
+	ao := workflow.ActivityOptions{StartToCloseTimeout: time.Minute}
+	drafts, err := registerInvoiceDrafts(ctx, grace, ao)
+	if err != nil {
+		return draftTestResult{}, err
+	}
+
+	draft := activities.InvoiceDetails{
+		ID:       invoiceID,
+		Currency: "USD",
+		Status:   invoices.StatusDraft,
+		Amount:   money.MustParse("49.99", "USD"),
+		Items:    []activities.InvoiceItem{{Description: "Premium", Quantity: 1, Amount: money.MustParse("49.99", "USD")}},
+	}
+	start := workflow.Now(ctx)
+	invoice, err := drafts.hold(ctx, draft)
+	return draftTestResult{Invoice: invoice, HeldFor: workflow.Now(ctx).Sub(start)}, err
+}
+
+func TestInvoiceDraftGracePeriod(t *testing.T) {
+	tests := []struct {
+		name     string
+		grace    time.Duration
+		finalize bool
+		heldFor  time.Duration
+		amount   string
+	}{
+		{name: "finalized early", grace: time.Hour, finalize: true, heldFor: 4 * time.Minute, amount: "25.00"},
+		{name: "grace period ends", grace: 10 * time.Minute, heldFor: 10 * time.Minute, amount: "25.00"},
+		{name: "no grace period", grace: -1, heldFor: 0, amount: "49.99"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestWorkflowEnvironment()
+			env.RegisterWorkflow(draftTestWorkflow)
+			env.RegisterActivity(activities.UpdateDraftInvoiceActivity)
+
+			var rejected []string
+			send := func(after time.Duration, name string, args ...interface{}) {
+				env.RegisterDelayedCallback(func() {
+					env.UpdateWorkflow(name, name+after.String(), &testsuite.TestUpdateCallback{
+						OnReject:   func(err error) { rejected = append(rejected, name) },
+						OnAccept:   func() {},
+						OnComplete: func(interface{}, error) {},
+					}, args...)
+				}, after)
+			}
+			// Add a setup fee, drop the plan's line, then try to drop a line that is not there
+			send(time.Minute, AddInvoiceItemUpdateName, AddInvoiceItemRequest{Description: "Setup", Amount: money.MustParse("25.00", "USD")})
+			send(2*time.Minute, RemoveInvoiceItemUpdateName, RemoveInvoiceItemRequest{Index: 0})
+			send(3*time.Minute, RemoveInvoiceItemUpdateName, RemoveInvoiceItemRequest{Index: 5})
+			if tt.finalize {
+				send(4*time.Minute, FinalizeInvoiceUpdateName)
+			}
+			env.ExecuteWorkflow(draftTestWorkflow, "inv_draft_"+tt.name, tt.grace)
+
+			if !env.IsWorkflowCompleted() {
+				t.Fatal("workflow did not complete")
+			}
+			var result draftTestResult
+			if err := env.GetWorkflowResult(&result); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			if result.HeldFor != tt.heldFor {
+				t.Errorf("draft held for %s, want %s", result.HeldFor, tt.heldFor)
+			}
+			if want := money.MustParse(tt.amount, "USD"); result.Invoice.Amount != want {
+				t.Errorf("finalized amount = %s, want %s", result.Invoice.Amount, want)
+			}
+			if tt.grace > 0 && (len(rejected) != 1 || rejected[0] != RemoveInvoiceItemUpdateName) {
+				t.Errorf("rejected %v, want only the removal of a missing line", rejected)
+			}
+		})
+	}
+}
+
+func TestInvoiceDraftRejectsNegativeTotal(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(draftTestWorkflow)
+	env.RegisterActivity(activities.UpdateDraftInvoiceActivity)
+
+	var rejected []string
+	send := func(after time.Duration, name string, args ...interface{}) {
+		env.RegisterDelayedCallback(func() {
+			env.UpdateWorkflow(name, name+after.String(), &testsuite.TestUpdateCallback{
+				OnReject:   func(err error) { rejected = append(rejected, name) },
+				OnAccept:   func() {},
+				OnComplete: func(interface{}, error) {},
+			}, args...)
+		}, after)
+	}
+	// A credit larger than the invoice, one smaller, then dropping the plan's line it credits against
+	send(time.Minute, AddInvoiceItemUpdateName, AddInvoiceItemRequest{Description: "Goodwill", Amount: money.MustParse("-60.00", "USD")})
+	send(2*time.Minute, AddInvoiceItemUpdateName, AddInvoiceItemRequest{Description: "Goodwill", Amount: money.MustParse("-20.00", "USD")})
+	send(3*time.Minute, RemoveInvoiceItemUpdateName, RemoveInvoiceItemRequest{Index: 0})
+	env.ExecuteWorkflow(draftTestWorkflow, "inv_draft_credit", 10*time.Minute)
+
+	var result draftTestResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	if want := money.MustParse("29.99", "USD"); result.Invoice.Amount != want {
+		t.Errorf("finalized amount = %s, want %s", result.Invoice.Amount, want)
+	}
+	if want := []string{AddInvoiceItemUpdateName, RemoveInvoiceItemUpdateName}; fmt.Sprint(rejected) != fmt.Sprint(want) {
+		t.Errorf("rejected %v, want %v", rejected, want)
+	}
+}
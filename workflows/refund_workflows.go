@@ -0,0 +1,181 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// RefundStatusQuery is the query that reports the progress of a RefundWorkflow
+const RefundStatusQuery = "get_refund_status"
+
+// Refund statuses
+const (
+	RefundPending   = "pending"
+	RefundRefunded  = "refunded"
+	RefundCompleted = "completed"
+	RefundRejected  = "rejected"
+	RefundFailed    = "failed"
+)
+
+// RefundParams contains parameters for the refund workflow
+type RefundParams struct {
+	InvoiceID string
+	// Amount is how much to refund. Zero refunds everything that has not been refunded yet.
+	Amount money.Money
+	Reason string
+	// ToBalance credits the customer balance instead of refunding the payment method
+	ToBalance bool
+}
+
+// RefundState is the progress of a RefundWorkflow, reported by RefundStatusQuery
+type RefundState struct {
+	InvoiceID  string
+	Status     string
+	Amount     money.Money
+	Remaining  money.Money // what is left to refund on the invoice once this refund is done
+	Refund     activities.RefundDetails
+	CreditNote credits.CreditNote
+	Balance    money.Money // the customer's credit balance, when the refund went to it
+	Error      string
+}
+
+// RefundWorkflow reverses all or part of a paid invoice. It refunds the payment through the
+// payment gateway, or credits the customer balance, and issues a credit note linked to the invoice.
+// The credit notes of an invoice never add up to more than the amount captured for it.
+func RefundWorkflow(ctx workflow.Context, params RefundParams) (RefundState, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("RefundWorkflow started", "invoiceID", params.InvoiceID, "amount", params.Amount)
+
+	// Configure activity options
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	state := RefundState{InvoiceID: params.InvoiceID, Status: RefundPending, Amount: params.Amount}
+
+	// Set up a query handler to report refund progress
+	err := workflow.SetQueryHandler(ctx, RefundStatusQuery, func() (RefundState, error) {
+		return state, nil
+	})
+	if err != nil {
+		logger.Error("Failed to register query handler", "error", err)
+		return state, err
+	}
+
+	// fail records why the refund did not go through
+	fail := func(status string, err error) (RefundState, error) {
+		state.Status = status
+		state.Error = err.Error()
+		logger.Error("RefundWorkflow failed", "invoiceID", params.InvoiceID, "error", err)
+		return state, err
+	}
+
+	// Step 1: Load the invoice's payment and the credit notes already issued against it
+	var invoice activities.RefundableInvoice
+	err = workflow.ExecuteActivity(ctx, activities.LoadRefundableInvoiceActivity, params.InvoiceID).Get(ctx, &invoice)
+	if err != nil {
+		return fail(RefundFailed, err)
+	}
+
+	// Step 2: Check the amount against what is left of the captured amount
+	remaining, err := invoice.Remaining()
+	if err != nil {
+		return fail(RefundFailed, err)
+	}
+	amount := params.Amount
+	if amount.IsZero() {
+		amount = remaining
+	}
+	if err := checkRefundAmount(amount, remaining); err != nil {
+		return fail(RefundRejected, err)
+	}
+	state.Amount = amount
+	if state.Remaining, err = remaining.Sub(amount); err != nil {
+		return fail(RefundFailed, err)
+	}
+
+	// Credit notes are numbered per invoice. Only one RefundWorkflow runs per invoice at a time,
+	// so the number is not taken by anyone else.
+	note := credits.CreditNote{
+		ID:             fmt.Sprintf("cn_%s_%d", invoice.InvoiceID, invoice.CreditNotes+1),
+		InvoiceID:      invoice.InvoiceID,
+		SubscriptionID: invoice.SubscriptionID,
+		CustomerID:     invoice.CustomerID,
+		Amount:         amount,
+		Reason:         params.Reason,
+		Destination:    credits.DestinationPaymentMethod,
+		CreatedAt:      workflow.Now(ctx),
+	}
+	if params.ToBalance {
+		note.Destination = credits.DestinationBalance
+	}
+
+	// Step 3: Refund the payment method through the gateway
+	if !params.ToBalance {
+		err = workflow.ExecuteActivity(ctx, activities.RefundPaymentActivity,
+			invoice.Payment, amount, params.Reason, "refund_"+note.ID).Get(ctx, &state.Refund)
+		if err != nil {
+			return fail(RefundFailed, err)
+		}
+		note.RefundID = state.Refund.ID
+		state.Status = RefundRefunded
+	}
+
+	// Step 4: Issue the credit note for the invoice
+	err = workflow.ExecuteActivity(ctx, activities.IssueCreditNoteActivity, note).Get(ctx, &state.CreditNote)
+	if err != nil {
+		return fail(RefundFailed, err)
+	}
+
+	// Step 5: Credit the customer balance
+	if params.ToBalance {
+		transaction := credits.BalanceTransaction{
+			ID:          "cbt_" + note.ID,
+			CustomerID:  invoice.CustomerID,
+			Amount:      amount,
+			Description: fmt.Sprintf("Credit note %s for invoice %s", note.ID, invoice.InvoiceID),
+			CreatedAt:   workflow.Now(ctx),
+		}
+		err = workflow.ExecuteActivity(ctx, activities.CreditCustomerBalanceActivity, transaction).Get(ctx, &state.Balance)
+		if err != nil {
+			return fail(RefundFailed, err)
+		}
+	}
+
+	state.Status = RefundCompleted
+	logger.Info("RefundWorkflow completed", "invoiceID", params.InvoiceID,
+		"creditNoteID", state.CreditNote.ID, "amount", amount, "destination", note.Destination)
+	return state, nil
+}
+
+// checkRefundAmount rejects refunds that are not positive or exceed what is left to refund
+func checkRefundAmount(amount, remaining money.Money) error {
+	if amount.Sign() <= 0 {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("nothing to refund: %s left of the captured amount", remaining),
+			activities.RefundExceedsCapturedErrorType, nil)
+	}
+	cmp, err := amount.Cmp(remaining)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "CurrencyMismatch", err)
+	}
+	if cmp > 0 {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("refund of %s exceeds the %s left of the captured amount", amount, remaining),
+			activities.RefundExceedsCapturedErrorType, nil)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/credits"
+	"github.com/tanint/play-temporal/money"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// refundBackend is a paid invoice and the credit notes and gateway refunds issued against it
+type refundBackend struct {
+	captured money.Money
+	notes    []credits.CreditNote
+	refunds  []activities.RefundDetails
+}
+
+// executeRefund runs RefundWorkflow with activities that work on backend, and returns its result
+// and what the refund status query reports once it has run
+func executeRefund(t *testing.T, backend *refundBackend, params RefundParams) (RefundState, RefundState, error) {
+	t.Helper()
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(RefundWorkflow)
+
+	register := func(name string, fn any) {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+	register("LoadRefundableInvoiceActivity", func(ctx context.Context, invoiceID string) (activities.RefundableInvoice, error) {
+		credited := money.Zero(backend.captured.Currency())
+		for _, note := range backend.notes {
+			var err error
+			if credited, err = credited.Add(note.Amount); err != nil {
+				return activities.RefundableInvoice{}, err
+			}
+		}
+		return activities.RefundableInvoice{
+			InvoiceID:   invoiceID,
+			CustomerID:  "cust_1",
+			Payment:     activities.PaymentDetails{ID: "ch_1", Amount: backend.captured},
+			Captured:    backend.captured,
+			Credited:    credited,
+			CreditNotes: len(backend.notes),
+		}, nil
+	})
+	register("RefundPaymentActivity", func(ctx context.Context, payment activities.PaymentDetails, amount money.Money, reason string, key string) (activities.RefundDetails, error) {
+		refund := activities.RefundDetails{ID: "re_" + key, ChargeID: payment.ID, Amount: amount, Reason: reason, Status: "succeeded"}
+		backend.refunds = append(backend.refunds, refund)
+		return refund, nil
+	})
+	register("IssueCreditNoteActivity", func(ctx context.Context, note credits.CreditNote) (credits.CreditNote, error) {
+		backend.notes = append(backend.notes, note)
+		return note, nil
+	})
+
+	env.ExecuteWorkflow(RefundWorkflow, params)
+
+	var result RefundState
+	err := env.GetWorkflowError()
+	if err == nil {
+		if err := env.GetWorkflowResult(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query, queryErr := env.QueryWorkflow(RefundStatusQuery)
+	if queryErr != nil {
+		t.Fatalf("%s query failed: %v", RefundStatusQuery, queryErr)
+	}
+	var reported RefundState
+	if err := query.Get(&reported); err != nil {
+		t.Fatal(err)
+	}
+	return result, reported, err
+}
+
+// refundErrorType is the application error type a refund failed with, empty if it did not fail
+func refundErrorType(err error) string {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		return appErr.Type()
+	}
+	return ""
+}
+
+func TestRefundWorkflowCapsPartialRefunds(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    string // empty refunds everything left
+		status    string
+		refunded  string
+		remaining string
+	}{
+		{name: "part of what is left", amount: "10.00", status: RefundCompleted, refunded: "10.00", remaining: "19.99"},
+		{name: "everything left", status: RefundCompleted, refunded: "29.99", remaining: "0.00"},
+		{name: "more than is left", amount: "30.00", status: RefundRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 20.00 of the 49.99 captured was refunded already
+			backend := &refundBackend{
+				captured: money.MustParse("49.99", "USD"),
+				notes:    []credits.CreditNote{{ID: "cn_inv_1_1", InvoiceID: "inv_1", Amount: money.MustParse("20.00", "USD")}},
+			}
+			params := RefundParams{InvoiceID: "inv_1", Reason: "requested_by_customer"}
+			if tt.amount != "" {
+				params.Amount = money.MustParse(tt.amount, "USD")
+			}
+			result, reported, err := executeRefund(t, backend, params)
+
+			if reported.Status != tt.status {
+				t.Errorf("refund status query reported %s, want %s", reported.Status, tt.status)
+			}
+			if tt.status == RefundRejected {
+				if got := refundErrorType(err); got != activities.RefundExceedsCapturedErrorType {
+					t.Errorf("refund failed with %v, want %s", err, activities.RefundExceedsCapturedErrorType)
+				}
+				if reported.Error == "" {
+					t.Error("refund status query reported no error for a rejected refund")
+				}
+				if len(backend.refunds) != 0 || len(backend.notes) != 1 {
+					t.Errorf("rejected refund made %d refunds and left %d credit notes, want 0 and 1", len(backend.refunds), len(backend.notes))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			refunded, remaining := money.MustParse(tt.refunded, "USD"), money.MustParse(tt.remaining, "USD")
+			if result.Amount != refunded || result.Remaining != remaining {
+				t.Errorf("refunded %s with %s remaining, want %s with %s remaining", result.Amount, result.Remaining, refunded, remaining)
+			}
+			if reported.Amount != result.Amount || reported.CreditNote.ID != result.CreditNote.ID {
+				t.Errorf("refund status query reported %+v, want the workflow's result %+v", reported, result)
+			}
+			if result.CreditNote.ID != "cn_inv_1_2" || result.CreditNote.RefundID != result.Refund.ID {
+				t.Errorf("credit note %s refers to refund %s, want cn_inv_1_2 for refund %s", result.CreditNote.ID, result.CreditNote.RefundID, result.Refund.ID)
+			}
+		})
+	}
+}
+
+func TestRefundWorkflowRejectsRepeatedRefund(t *testing.T) {
+	backend := &refundBackend{captured: money.MustParse("49.99", "USD")}
+	params := RefundParams{InvoiceID: "inv_1", Reason: "duplicate"}
+
+	if _, _, err := executeRefund(t, backend, params); err != nil {
+		t.Fatalf("first refund failed: %v", err)
+	}
+	_, reported, err := executeRefund(t, backend, params)
+
+	if got := refundErrorType(err); got != activities.RefundExceedsCapturedErrorType {
+		t.Errorf("repeated refund failed with %v, want %s", err, activities.RefundExceedsCapturedErrorType)
+	}
+	if reported.Status != RefundRejected {
+		t.Errorf("refund status query reported %s, want %s", reported.Status, RefundRejected)
+	}
+	if len(backend.refunds) != 1 || len(backend.notes) != 1 {
+		t.Errorf("made %d gateway refunds and %d credit notes, want 1 of each", len(backend.refunds), len(backend.notes))
+	}
+}
@@ -0,0 +1,54 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// Saga keeps how to undo each step a workflow has completed, so that a later step failing, or the
+// workflow being canceled, does not leave the earlier steps' effects behind. A zero Saga is ready
+// to use.
+type Saga struct {
+	compensations []compensation
+}
+
+// compensation undoes one completed step
+type compensation struct {
+	step string
+	undo func(ctx workflow.Context) error
+}
+
+// AddCompensation registers how to undo a step, once the step has completed
+func (s *Saga) AddCompensation(step string, undo func(ctx workflow.Context) error) {
+	s.compensations = append(s.compensations, compensation{step: step, undo: undo})
+}
+
+// AddActivityCompensation registers an activity, and its arguments, that undoes a step
+func (s *Saga) AddActivityCompensation(step string, activity any, args ...any) {
+	s.AddCompensation(step, func(ctx workflow.Context) error {
+		return workflow.ExecuteActivity(ctx, activity, args...).Get(ctx, nil)
+	})
+}
+
+// Compensate undoes the completed steps, latest first. It runs on a context disconnected from
+// ctx, so that compensations still run after the workflow is canceled; the activity options of ctx
+// apply to them. Every compensation is attempted even if an earlier one fails, and the failures are
+// returned together. The saga is empty afterwards.
+func (s *Saga) Compensate(ctx workflow.Context) error {
+	logger := workflow.GetLogger(ctx)
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+
+	var errs []error
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		c := s.compensations[i]
+		logger.Info("Compensating", "step", c.step)
+		if err := c.undo(ctx); err != nil {
+			logger.Error("Compensation failed", "step", c.step, "error", err)
+			errs = append(errs, fmt.Errorf("compensating %s: %w", c.step, err))
+		}
+	}
+	s.compensations = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package workflows
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// sagaTestResult is what sagaTestWorkflow saw
+type sagaTestResult struct {
+	Undone        []string
+	StepError     string
+	Compensate    string
+	CompensateTwo string
+}
+
+// sagaTestWorkflow completes three steps, registering a compensation for each, then fails a fourth
+// step or waits to be canceled, and compensates
+func sagaTestWorkflow(ctx workflow.Context, waitForCancel bool) (sagaTestResult, error) {
+	var saga Saga
+	var result sagaTestResult
+	for _, step := range []string{"first", "second", "third"} {
+		saga.AddCompensation(step, func(ctx workflow.Context) error {
+			// Compensations must still be able to wait once the workflow is canceled
+			if err := workflow.Sleep(ctx, time.Second); err != nil {
+				return err
+			}
+			result.Undone = append(result.Undone, step)
+			if step == "second" {
+				return errors.New("second cannot be undone")
+			}
+			return nil
+		})
+	}
+
+	var err error
+	if waitForCancel {
+		err = workflow.Sleep(ctx, time.Hour)
+	} else {
+		err = temporal.NewApplicationError("fourth step failed", "StepFailed")
+	}
+	result.StepError = err.Error()
+	if err := saga.Compensate(ctx); err != nil {
+		result.Compensate = err.Error()
+	}
+	if err := saga.Compensate(ctx); err != nil {
+		result.CompensateTwo = err.Error()
+	}
+	return result, nil
+}
+
+func TestSagaCompensatesInReverse(t *testing.T) {
+	tests := []struct {
+		name          string
+		waitForCancel bool
+		stepError     string
+	}{
+		{name: "step failed", stepError: "fourth step failed"},
+		{name: "workflow canceled", waitForCancel: true, stepError: "canceled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestWorkflowEnvironment()
+			env.RegisterWorkflow(sagaTestWorkflow)
+			if tt.waitForCancel {
+				env.RegisterDelayedCallback(env.CancelWorkflow, time.Minute)
+			}
+			env.ExecuteWorkflow(sagaTestWorkflow, tt.waitForCancel)
+
+			if !env.IsWorkflowCompleted() {
+				t.Fatal("workflow did not complete")
+			}
+			var result sagaTestResult
+			if err := env.GetWorkflowResult(&result); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			if !strings.Contains(result.StepError, tt.stepError) {
+				t.Errorf("step error = %q, want %q", result.StepError, tt.stepError)
+			}
+			if want := []string{"third", "second", "first"}; !reflect.DeepEqual(result.Undone, want) {
+				t.Errorf("compensated %v, want %v", result.Undone, want)
+			}
+			if !strings.Contains(result.Compensate, "compensating second: second cannot be undone") {
+				t.Errorf("Compensate error = %q, want the failed compensation", result.Compensate)
+			}
+			if result.CompensateTwo != "" {
+				t.Errorf("second Compensate = %q, want nothing left to compensate", result.CompensateTwo)
+			}
+		})
+	}
+}
@@ -1,20 +1,44 @@
 package workflows
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
 	"time"
 
 	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/catalog"
+	"github.com/tanint/play-temporal/invoices"
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/money"
+	"github.com/tanint/play-temporal/payments"
+	"github.com/tanint/play-temporal/tax"
+	"github.com/tanint/play-temporal/webhooks"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
 // SubscriptionParams contains parameters for starting a subscription
 type SubscriptionParams struct {
-	CustomerID string
-	PlanID     string
+	CustomerID      string
+	PlanID          string
+	Quantity        int64
+	PaymentMethodID string
+	// TrialDays starts the subscription with a free trial. Zero charges right away.
+	TrialDays int
+	// TrialReminderDays is how many days before the trial ends the reminder is sent.
+	// Zero means DefaultTrialReminderDays.
+	TrialReminderDays int
+	// Currency is the currency the customer is billed in. Empty means the plan's currency.
+	Currency string
+	// TimeZone is the IANA name of the customer's time zone, whose calendar billing periods
+	// follow. Empty means UTC.
+	TimeZone string
 }
 
-// SubscriptionWorkflow handles the initial subscription creation and setup
+// SubscriptionWorkflow handles the initial subscription creation and setup. Each completed step
+// registers its compensation, so that if a later step fails, or the workflow is canceled, the
+// captured payment is refunded, the invoice voided and the subscription deleted.
 func SubscriptionWorkflow(ctx workflow.Context, params SubscriptionParams) (string, error) {
 	logger := workflow.GetLogger(ctx)
 	logger.Info("SubscriptionWorkflow started", "customerID", params.CustomerID, "planID", params.PlanID)
@@ -27,66 +51,123 @@ func SubscriptionWorkflow(ctx workflow.Context, params SubscriptionParams) (stri
 			BackoffCoefficient: 2.0,
 			MaximumInterval:    time.Minute,
 			MaximumAttempts:    3,
+			// Declines are handled by dunning, not by retrying the charge
+			NonRetryableErrorTypes: activities.PaymentNonRetryableErrorTypes,
 		},
 	}
 	ctx = workflow.WithActivityOptions(ctx, ao)
 
-	// Step 1: Create the subscription
-	var subscription activities.SubscriptionDetails
-	err := workflow.ExecuteActivity(ctx, activities.CreateSubscriptionActivity, params.CustomerID, params.PlanID).Get(ctx, &subscription)
-	if err != nil {
-		logger.Error("Failed to create subscription", "error", err)
+	// Compensations are retried for longer, since giving up leaves the customer charged
+	var saga Saga
+	compensationCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    5 * time.Minute,
+			MaximumAttempts:    10,
+		},
+	})
+	fail := func(err error) (string, error) {
+		if compensateErr := saga.Compensate(compensationCtx); compensateErr != nil {
+			return "", errors.Join(err, compensateErr)
+		}
 		return "", err
 	}
 
-	// Step 2: Calculate initial charges
-	var amount float64
-	err = workflow.ExecuteActivity(ctx, activities.CalculateChargesActivity, subscription).Get(ctx, &amount)
+	// Step 1: Create the subscription, under an ID recorded in the history so that retries and
+	// replays create the same one
+	subscriptionID, err := newID(ctx, "sub")
 	if err != nil {
-		logger.Error("Failed to calculate charges", "error", err)
 		return "", err
 	}
-
-	// Step 3: Generate the first invoice
-	var invoice activities.InvoiceDetails
-	err = workflow.ExecuteActivity(ctx, activities.GenerateInvoiceActivity, subscription, amount).Get(ctx, &invoice)
-	if err != nil {
-		logger.Error("Failed to generate invoice", "error", err)
-		return "", err
+	request := activities.CreateSubscriptionRequest{
+		SubscriptionID:  subscriptionID,
+		CustomerID:      params.CustomerID,
+		PlanID:          params.PlanID,
+		Quantity:        params.Quantity,
+		PaymentMethodID: params.PaymentMethodID,
+		TrialDays:       params.TrialDays,
+		Currency:        params.Currency,
+		TimeZone:        params.TimeZone,
 	}
-
-	// Step 4: Process payment
-	var payment activities.PaymentDetails
-	err = workflow.ExecuteActivity(ctx, activities.ProcessPaymentActivity, invoice, subscription).Get(ctx, &payment)
+	var subscription activities.SubscriptionDetails
+	err = workflow.ExecuteActivity(ctx, activities.CreateSubscriptionActivity, request).Get(ctx, &subscription)
 	if err != nil {
-		logger.Error("Failed to process payment", "error", err)
+		logger.Error("Failed to create subscription", "error", err)
 		return "", err
 	}
+	saga.AddActivityCompensation("create subscription", activities.DeleteSubscriptionActivity, subscription.ID)
+	emitEvent(ctx, webhooks.SubscriptionCreated, subscription.ID, subscription)
 
-	// Step 5: Send invoice email
-	err = workflow.ExecuteActivity(ctx, activities.SendInvoiceEmailActivity, invoice, subscription.CustomerID).Get(ctx, nil)
-	if err != nil {
-		logger.Error("Failed to send invoice email", "error", err)
-		// Continue despite email failure
+	// A trial is run out and converted by the entity workflow, so nothing is charged yet
+	if subscription.Status == lifecycle.StatusTrialing {
+		entity := SubscriptionEntityParams{
+			SubscriptionID:    subscription.ID,
+			TrialReminderDays: params.TrialReminderDays,
+		}
+		if err := startSubscriptionEntity(ctx, entity); err != nil {
+			logger.Error("Failed to start subscription entity workflow", "error", err)
+			return fail(err)
+		}
+		logger.Info("SubscriptionWorkflow completed", "subscriptionID", subscription.ID,
+			"status", subscription.Status, "trialEnd", subscription.TrialEnd)
+		return subscription.ID, nil
 	}
 
-	// Step 6: Update subscription status based on payment
-	var status string
-	if payment.Status == "succeeded" {
-		status = "active"
-	} else {
-		status = "payment_failed"
+	// Steps 2-6: Bill the first period of the plan's interval. The first invoice is finalized right
+	// away rather than held as a draft, since the subscription is not active until it is paid.
+	var plan catalog.Plan
+	err = workflow.ExecuteActivity(ctx, activities.LoadPlanActivity, subscription.PlanID).Get(ctx, &plan)
+	if err != nil {
+		return fail(err)
 	}
-
-	err = workflow.ExecuteActivity(ctx, activities.UpdateSubscriptionStatusActivity, subscription.ID, status).Get(ctx, nil)
+	schedule, err := subscriptionSchedule(subscription, plan.Interval)
 	if err != nil {
-		logger.Error("Failed to update subscription status", "error", err)
-		return "", err
+		return fail(err)
+	}
+	period := schedule.Period(0)
+	invoice, payment, _, err := runBillingCycle(ctx, subscription, period, nil, activities.CouponRedemption{}, nil)
+	if invoice.ID != "" {
+		saga.AddCompensation("generate invoice", func(ctx workflow.Context) error {
+			return workflow.ExecuteActivity(ctx, activities.VoidInvoiceActivity, invoice, subscription.CustomerID).Get(ctx, nil)
+		})
+	}
+	if payment.Status == payments.StatusSucceeded {
+		saga.AddCompensation("process payment", func(ctx workflow.Context) error {
+			return workflow.ExecuteActivity(ctx, activities.ReversePaymentActivity,
+				payment, subscription.CustomerID, "subscription setup failed").Get(ctx, nil)
+		})
 	}
+	status := lifecycle.StatusActive
+	if paymentFailed(err) {
+		// Dunning was started for the failed payment, and has nothing to collect once the
+		// invoice is voided
+		status = lifecycle.StatusPastDue
+		saga.AddCompensation("start dunning", func(ctx workflow.Context) error {
+			return workflow.RequestCancelExternalWorkflow(ctx, "dunning-"+invoice.ID, "").Get(ctx, nil)
+		})
+	} else if err != nil {
+		return fail(err)
+	}
+	emitEvent(ctx, webhooks.BillingCycleCompleted, subscription.ID, BillingCycle{
+		SubscriptionID:  subscription.ID,
+		Period:          period,
+		InvoiceID:       invoice.ID,
+		PaymentStatus:   payment.Status,
+		NextBillingDate: period.End,
+	})
 
-	// Note: We're not starting the recurring billing workflow here
-	// Instead, it should be started separately using the RecurringBillingStarter
-	// This avoids any issues with parent-child workflow relationships
+	// Step 7: Hand the subscription over to its long-lived entity workflow,
+	// which bills every following cycle
+	entity := SubscriptionEntityParams{
+		SubscriptionID:  subscription.ID,
+		NextBillingDate: period.End,
+	}
+	if err := startSubscriptionEntity(ctx, entity); err != nil {
+		logger.Error("Failed to start subscription entity workflow", "error", err)
+		return fail(err)
+	}
 
 	logger.Info("SubscriptionWorkflow completed", "subscriptionID", subscription.ID, "status", status)
 	return subscription.ID, nil
@@ -97,22 +178,39 @@ type RecurringBillingParams struct {
 	SubscriptionID  string
 	CustomerID      string
 	NextBillingDate time.Time
+	// InvoiceGracePeriod is how long the invoice stays a draft that can be edited before it is
+	// finalized. Zero means DefaultInvoiceGracePeriod; negative finalizes it right away.
+	InvoiceGracePeriod time.Duration
+	// Lookback is how long before its billing date a run bills the periods that ended, which
+	// should be the longest time between runs of the schedule so that each run bills every period
+	// that ended since the last. Zero bills the last period that ended only.
+	Lookback time.Duration
 }
 
-// RecurringBillingWorkflow handles the recurring billing for a subscription
-// This workflow is designed to be used with a cron schedule
+// scheduledStartTimeKey is the search attribute Temporal Schedules set on the workflows they start,
+// holding the time the run was scheduled for
+var scheduledStartTimeKey = temporal.NewSearchAttributeKeyTime("TemporalScheduledStartTime")
+
+// RecurringBillingWorkflow bills the billing periods of a subscription that ended since its last run,
+// those that ended within the lookback, unless an invoice already charges for them. A failed payment
+// stops it, since dunning takes the subscription over. This workflow is designed to be started by a
+// Temporal Schedule, and bills as of the time the run was scheduled for rather than when it started,
+// so that runs caught up after an outage or backfilled bill the periods they were meant to. Started
+// by hand, it bills as of now. Each invoice is held as a draft for the grace period, during which the
+// add_invoice_item, remove_invoice_item and finalize_invoice updates can change it.
 func RecurringBillingWorkflow(ctx workflow.Context, params RecurringBillingParams) error {
 	logger := workflow.GetLogger(ctx)
 
 	// Get information about the current workflow execution
 	info := workflow.GetInfo(ctx)
 
-	// Log workflow execution details including cron schedule
+	// Log workflow execution details including the time the run was scheduled for
+	billingDate := billingTime(ctx)
 	logger.Info("RecurringBillingWorkflow started",
 		"subscriptionID", params.SubscriptionID,
 		"workflowID", info.WorkflowExecution.ID,
 		"runID", info.WorkflowExecution.RunID,
-		"cronSchedule", info.CronSchedule)
+		"billingDate", billingDate)
 
 	// Configure activity options with longer timeouts for reliability
 	ao := workflow.ActivityOptions{
@@ -125,88 +223,442 @@ func RecurringBillingWorkflow(ctx workflow.Context, params RecurringBillingParam
 			BackoffCoefficient: 2.0,
 			MaximumInterval:    time.Minute,
 			MaximumAttempts:    5,
+			// Declines are handled by dunning, not by retrying the charge
+			NonRetryableErrorTypes: activities.PaymentNonRetryableErrorTypes,
 		},
 	}
 	ctx = workflow.WithActivityOptions(ctx, ao)
 
 	// Set up a query handler to check the next billing date
+	nextBillingDate := params.NextBillingDate
 	err := workflow.SetQueryHandler(ctx, "get_next_billing_date", func() (time.Time, error) {
-		return params.NextBillingDate, nil
+		return nextBillingDate, nil
 	})
 	if err != nil {
 		logger.Error("Failed to register query handler", "error", err)
 		return err
 	}
+	drafts, err := registerInvoiceDrafts(ctx, params.InvoiceGracePeriod, ao)
+	if err != nil {
+		logger.Error("Failed to register draft invoice handlers", "error", err)
+		return err
+	}
 
-	// For cron workflows, we don't need to wait for the next billing date
-	// The cron schedule will automatically trigger the workflow at the right time
+	// The schedule starts the workflow at the right time, so there is no billing date to wait for
 	logger.Info("Processing billing cycle for subscription",
 		"subscriptionID", params.SubscriptionID,
-		"billingDate", workflow.Now(ctx).Format(time.RFC3339))
+		"billingDate", billingDate.Format(time.RFC3339))
 
-	// Mock getting the subscription details
-	// In a real implementation, we would fetch the current subscription state
-	subscription := activities.SubscriptionDetails{
-		ID:              params.SubscriptionID,
-		CustomerID:      params.CustomerID,
-		PlanID:          "mock-plan",
-		PricePerMonth:   49.99,
-		Status:          "active",
-		PaymentMethodID: "mock-payment-method",
+	// Step 1: Load the current subscription state
+	var subscription activities.SubscriptionDetails
+	err = workflow.ExecuteActivity(ctx, activities.LoadSubscriptionActivity, params.SubscriptionID).Get(ctx, &subscription)
+	if err != nil {
+		logger.Error("Failed to load subscription", "error", err)
+		return err
 	}
 
-	// Step 1: Calculate charges for this billing period
-	var amount float64
-	err = workflow.ExecuteActivity(ctx, activities.CalculateChargesActivity, subscription).Get(ctx, &amount)
+	// Bill the last period that has ended on the subscription's schedule
+	var plan catalog.Plan
+	err = workflow.ExecuteActivity(ctx, activities.LoadPlanActivity, subscription.PlanID).Get(ctx, &plan)
 	if err != nil {
-		logger.Error("Failed to calculate charges", "error", err)
+		logger.Error("Failed to load plan", "error", err)
 		return err
 	}
-
-	// Step 2: Generate invoice
-	var invoice activities.InvoiceDetails
-	err = workflow.ExecuteActivity(ctx, activities.GenerateInvoiceActivity, subscription, amount).Get(ctx, &invoice)
+	schedule, err := subscriptionSchedule(subscription, plan.Interval)
 	if err != nil {
-		logger.Error("Failed to generate invoice", "error", err)
+		logger.Error("Failed to lay out billing periods", "error", err)
 		return err
 	}
+	n, current := schedule.Containing(billingDate)
+	nextBillingDate = current.End
 
-	// Step 3: Process payment
-	var payment activities.PaymentDetails
-	err = workflow.ExecuteActivity(ctx, activities.ProcessPaymentActivity, invoice, subscription).Get(ctx, &payment)
+	// Nothing is billed before the first period has ended
+	if n < 1 {
+		logger.Info("Skipping billing cycle until the first period has ended",
+			"subscriptionID", params.SubscriptionID,
+			"periodEnd", current.End)
+		return nil
+	}
+
+	// The periods that ended since the last run, oldest first. Those are the periods ending within
+	// the lookback, and always the last one ended, as a schedule's runs need not line up with the
+	// plan's interval: a monthly run of a weekly plan has four or five periods to bill.
+	period := schedule.Period(n - 1)
+	since := billingDate.Add(-params.Lookback)
+	periods := []activities.BillingPeriod{period}
+	for k := n - 2; k >= 0 && schedule.Period(k).End.After(since) && len(periods) < MaxBackfillPeriods; k-- {
+		periods = append([]activities.BillingPeriod{schedule.Period(k)}, periods...)
+	}
+
+	// Trialing, paused and ended subscriptions are not billed
+	switch {
+	case subscription.Status == lifecycle.StatusTrialing,
+		subscription.Status == lifecycle.StatusPaused,
+		subscription.Status.Terminal():
+		logger.Info("Skipping billing cycle",
+			"subscriptionID", params.SubscriptionID,
+			"status", subscription.Status)
+		emitEvent(ctx, webhooks.BillingCycleSkipped, subscription.ID, BillingCycle{
+			SubscriptionID:  subscription.ID,
+			Period:          period,
+			NextBillingDate: nextBillingDate,
+			Reason:          "subscription is " + string(subscription.Status),
+		})
+		return nil
+	}
+
+	// A triggered, caught up or backfilled run may find periods invoiced already, by an earlier run
+	// or by the subscription's entity workflow
+	var unbilled []activities.BillingPeriod
+	err = workflow.ExecuteActivity(ctx, activities.FindUnbilledPeriodsActivity, subscription.ID, periods).Get(ctx, &unbilled)
 	if err != nil {
-		logger.Error("Failed to process payment", "error", err)
+		logger.Error("Failed to look for invoices of the period", "error", err)
 		return err
 	}
+	if len(unbilled) == 0 {
+		logger.Info("Skipping billing cycle of a period already invoiced",
+			"subscriptionID", params.SubscriptionID,
+			"periodStart", period.Start,
+			"periodEnd", period.End)
+		emitEvent(ctx, webhooks.BillingCycleSkipped, subscription.ID, BillingCycle{
+			SubscriptionID:  subscription.ID,
+			Period:          period,
+			NextBillingDate: nextBillingDate,
+			Reason:          "period is already invoiced",
+		})
+		return nil
+	}
+
+	// Steps 2-6: Bill each unbilled period in order
+	for _, period := range unbilled {
+		invoice, payment, _, err := runBillingCycle(ctx, subscription, period, nil, activities.CouponRedemption{}, drafts)
+		if err != nil && !paymentFailed(err) {
+			return err
+		}
+
+		emitEvent(ctx, webhooks.BillingCycleCompleted, subscription.ID, BillingCycle{
+			SubscriptionID:  subscription.ID,
+			Period:          period,
+			InvoiceID:       invoice.ID,
+			PaymentStatus:   payment.Status,
+			NextBillingDate: nextBillingDate,
+		})
+
+		logger.Info("Completed billing cycle",
+			"subscriptionID", params.SubscriptionID,
+			"periodEnd", period.End,
+			"nextBillingDate", nextBillingDate,
+			"paymentStatus", payment.Status)
+
+		// Dunning takes the subscription over, and the later periods are billed once it recovers
+		if err != nil {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// newID generates a random ID with a prefix, such as sub_5f1e0c9a3b7d2e41. It is recorded once in
+// the history, so that replays reuse it and activities retried with it act on the same record.
+func newID(ctx workflow.Context, prefix string) (string, error) {
+	var id string
+	err := workflow.SideEffect(ctx, func(ctx workflow.Context) any {
+		return fmt.Sprintf("%s_%016x", prefix, rand.Uint64())
+	}).Get(&id)
+	return id, err
+}
+
+// billingTime is the time a billing run is for: the time a schedule run was scheduled for, or now
+// for a run started by hand
+func billingTime(ctx workflow.Context) time.Time {
+	if at, ok := workflow.GetTypedSearchAttributes(ctx).GetTime(scheduledStartTimeKey); ok && !at.IsZero() {
+		return at
+	}
+	return workflow.Now(ctx)
+}
+
+// runBillingCycle charges a subscription for a billing period and updates its status. A failed
+// payment hands the subscription over to dunning, which owns its status from there, and is
+// returned so that the caller can tell it apart with paymentFailed.
+func runBillingCycle(
+	ctx workflow.Context,
+	subscription activities.SubscriptionDetails,
+	period activities.BillingPeriod,
+	adjustments []activities.InvoiceItem,
+	coupon activities.CouponRedemption,
+	drafts *invoiceDrafts,
+) (activities.InvoiceDetails, activities.PaymentDetails, []activities.InvoiceItem, error) {
+	logger := workflow.GetLogger(ctx)
+
+	invoice, payment, carried, err := chargeCycle(ctx, subscription, period, adjustments, coupon, drafts)
+
+	// Step 6: Update subscription status based on the payment outcome
+	switch {
+	case paymentFailed(err):
+		if dunningErr := startDunning(ctx, subscription, invoice); dunningErr != nil {
+			logger.Error("Failed to start dunning", "error", dunningErr)
+			return invoice, payment, carried, dunningErr
+		}
+		emitStatusChange(ctx, subscription.ID, subscription.Status, lifecycle.StatusPastDue,
+			"payment failed for invoice "+invoice.ID)
+		return invoice, payment, carried, err
+	case err != nil:
+		return invoice, payment, carried, err
+	}
 
-	// Step 4: Send invoice email
-	err = workflow.ExecuteActivity(ctx, activities.SendInvoiceEmailActivity, invoice, subscription.CustomerID).Get(ctx, nil)
+	err = workflow.ExecuteActivity(ctx, activities.UpdateSubscriptionStatusActivity, subscription.ID, lifecycle.StatusActive).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to update subscription status", "error", err)
+		return invoice, payment, carried, err
+	}
+	emitStatusChange(ctx, subscription.ID, subscription.Status, lifecycle.StatusActive,
+		"invoice "+invoice.ID+" was paid")
+
+	return invoice, payment, carried, nil
+}
+
+// chargeCycle calculates the charges for a billing period, adds any carried adjustments, generates
+// the invoice with the coupon's discount, tax and the customer's credit balance, takes payment,
+// marks the invoice paid, emails the rendered invoice and reconciles the ledger. The invoice is held
+// as a draft by drafts, if given, before it is finalized. Credits that exceed the charges are
+// returned to be carried to the next cycle. A failed payment is returned as its payment error, along
+// with the invoice and the failed payment. Other failures return whatever invoice and payment were
+// made before them.
+func chargeCycle(
+	ctx workflow.Context,
+	subscription activities.SubscriptionDetails,
+	period activities.BillingPeriod,
+	adjustments []activities.InvoiceItem,
+	coupon activities.CouponRedemption,
+	drafts *invoiceDrafts,
+) (activities.InvoiceDetails, activities.PaymentDetails, []activities.InvoiceItem, error) {
+	logger := workflow.GetLogger(ctx)
+
+	// Step 2: Calculate charges for the period
+	var charges activities.Charges
+	err := workflow.ExecuteActivity(ctx, activities.CalculateChargesActivity, subscription, period).Get(ctx, &charges)
+	if err != nil {
+		logger.Error("Failed to calculate charges", "error", err)
+		return activities.InvoiceDetails{}, activities.PaymentDetails{}, adjustments, err
+	}
+	carried, err := applyAdjustments(&charges, adjustments)
+	if err != nil {
+		logger.Error("Failed to apply adjustments", "error", err)
+		return activities.InvoiceDetails{}, activities.PaymentDetails{}, adjustments, err
+	}
+
+	// Step 3: Generate invoice
+	invoice, err := generateInvoice(ctx, subscription, charges, coupon, drafts)
+	if err != nil {
+		logger.Error("Failed to generate invoice", "error", err)
+		return invoice, activities.PaymentDetails{}, adjustments, err
+	}
+	emitEvent(ctx, webhooks.InvoiceCreated, subscription.ID, invoice)
+
+	// Step 4: Process payment. This is the invoice's first charge attempt, so retries of the
+	// activity reuse its idempotency key and cannot charge twice.
+	payment, paymentErr := processPayment(ctx, invoice, subscription, 0)
+	if paymentErr != nil {
+		logger.Error("Failed to process payment", "error", paymentErr)
+		if !paymentFailed(paymentErr) {
+			return invoice, activities.PaymentDetails{}, carried, paymentErr
+		}
+		emitEvent(ctx, webhooks.PaymentFailed, subscription.ID, payment)
+	} else {
+		emitEvent(ctx, webhooks.PaymentSucceeded, subscription.ID, payment)
+		if err := markInvoice(ctx, &invoice, invoices.StatusPaid); err != nil {
+			logger.Error("Failed to mark invoice paid", "error", err)
+			return invoice, payment, carried, err
+		}
+	}
+
+	// Step 5: Render the invoice and email it, with a receipt if it was paid. An invoice that fails
+	// to render is still emailed, without the documents attached.
+	var documents activities.InvoiceDocuments
+	err = workflow.ExecuteActivity(ctx, activities.RenderInvoiceActivity, invoice, subscription, payment).Get(ctx, &documents)
+	if err != nil {
+		logger.Error("Failed to render invoice", "error", err)
+	}
+	err = workflow.ExecuteActivity(ctx, activities.SendInvoiceEmailActivity, invoice, subscription, payment, documents).Get(ctx, nil)
 	if err != nil {
 		logger.Error("Failed to send invoice email", "error", err)
 		// Continue despite email failure
 	}
+	if paymentErr == nil && payment.Status == payments.StatusSucceeded {
+		sendReceipt(ctx, invoice, subscription, payment)
+	}
 
-	// Step 5: Update subscription status based on payment
-	var status string
-	if payment.Status == "succeeded" {
-		status = "active"
-	} else {
-		status = "payment_failed"
+	// Check that the ledger agrees with the invoice and its payment. Discrepancies are
+	// logged for someone to look into; they do not undo the billing.
+	var reconciliation activities.Reconciliation
+	err = workflow.ExecuteActivity(ctx, activities.ReconcileLedgerActivity, []activities.InvoiceDetails{invoice}).Get(ctx, &reconciliation)
+	if err != nil {
+		logger.Error("Failed to reconcile ledger", "error", err)
+	} else if !reconciliation.Balanced() {
+		logger.Warn("Ledger disagrees with invoice", "invoiceID", invoice.ID, "discrepancies", reconciliation.Discrepancies)
 	}
 
-	err = workflow.ExecuteActivity(ctx, activities.UpdateSubscriptionStatusActivity, subscription.ID, status).Get(ctx, nil)
+	return invoice, payment, carried, paymentErr
+}
+
+// generateInvoice generates an invoice for the charges. It starts as a draft of the charges, which
+// drafts holds open for edits during its grace period. The draft's items, as edited, are then
+// discounted by the coupon, if it is active, and taxed, and what it can is paid from the customer's
+// credit balance. The balance is applied by its own activity once the invoice has its ID, so that
+// retries cannot use the credit twice. Finally the invoice is finalized with its number and posted
+// to the ledger. If a step fails once the draft has been created, the invoice is returned along
+// with the error so that it can be voided.
+func generateInvoice(
+	ctx workflow.Context,
+	subscription activities.SubscriptionDetails,
+	charges activities.Charges,
+	coupon activities.CouponRedemption,
+	drafts *invoiceDrafts,
+) (activities.InvoiceDetails, error) {
+	// The ID is recorded before the draft is created, so a retried activity finds the draft it created
+	invoiceID, err := newID(ctx, "inv")
 	if err != nil {
-		logger.Error("Failed to update subscription status", "error", err)
-		// Continue despite status update failure
+		return activities.InvoiceDetails{}, err
+	}
+	var invoice activities.InvoiceDetails
+	err = workflow.ExecuteActivity(ctx, activities.CreateDraftInvoiceActivity, subscription, charges, invoiceID).Get(ctx, &invoice)
+	if err != nil {
+		return activities.InvoiceDetails{}, err
 	}
+	if invoice, err = drafts.hold(ctx, invoice); err != nil {
+		return invoice, err
+	}
+	charges.Items = invoice.Items
+	charges.Total = invoice.Amount
 
-	// Calculate the next billing date (1 month from now)
-	nextBillingDate := workflow.Now(ctx).AddDate(0, 1, 0)
+	if err := charges.ApplyCoupon(coupon); err != nil {
+		return invoice, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidCoupon", err)
+	}
 
-	logger.Info("Completed billing cycle",
-		"subscriptionID", params.SubscriptionID,
-		"nextBillingDate", nextBillingDate,
-		"paymentStatus", payment.Status)
+	var taxes tax.Summary
+	err = workflow.ExecuteActivity(ctx, activities.CalculateTaxActivity, subscription, charges).Get(ctx, &taxes)
+	if err != nil {
+		return invoice, err
+	}
 
-	return nil
+	err = workflow.ExecuteActivity(ctx, activities.GenerateInvoiceActivity, invoice, charges, taxes).Get(ctx, &invoice)
+	if err != nil {
+		return invoice, err
+	}
+	err = workflow.ExecuteActivity(ctx, activities.ApplyCustomerBalanceActivity, invoice, subscription.CustomerID).Get(ctx, &invoice)
+	if err != nil {
+		return invoice, err
+	}
+	err = workflow.ExecuteActivity(ctx, activities.FinalizeInvoiceActivity, invoice).Get(ctx, &invoice)
+	if err != nil {
+		return invoice, err
+	}
+	err = workflow.ExecuteActivity(ctx, activities.PostInvoiceActivity, invoice, subscription).Get(ctx, nil)
+	if err != nil {
+		return invoice, err
+	}
+	return invoice, nil
+}
+
+// processPayment charges an invoice. When the charge fails with a payment error, the failed payment
+// it carries is returned along with the error.
+func processPayment(
+	ctx workflow.Context,
+	invoice activities.InvoiceDetails,
+	subscription activities.SubscriptionDetails,
+	attempt int,
+) (activities.PaymentDetails, error) {
+	var payment activities.PaymentDetails
+	err := workflow.ExecuteActivity(ctx, activities.ProcessPaymentActivity, invoice, subscription, attempt).Get(ctx, &payment)
+	if errorType := paymentErrorType(err); errorType != "" {
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && appErr.HasDetails() {
+			_ = appErr.Details(&payment)
+		}
+		workflow.GetLogger(ctx).Warn("Payment failed", "invoiceID", invoice.ID,
+			"errorType", errorType, "declineCode", payment.DeclineCode)
+	}
+	return payment, err
+}
+
+// sendReceipt emails the customer a receipt for an invoice's payment. Like other emails, a receipt
+// that cannot be sent is logged and does not fail the workflow.
+func sendReceipt(
+	ctx workflow.Context,
+	invoice activities.InvoiceDetails,
+	subscription activities.SubscriptionDetails,
+	payment activities.PaymentDetails,
+) {
+	err := workflow.ExecuteActivity(ctx, activities.SendPaymentReceiptEmailActivity, invoice, subscription, payment).Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Failed to send payment receipt", "error", err)
+	}
+}
+
+// notifyCanceled emails the customer that their subscription was canceled, and why. An email that
+// cannot be sent is logged and does not undo the cancellation.
+func notifyCanceled(ctx workflow.Context, subscription activities.SubscriptionDetails, reason string) {
+	err := workflow.ExecuteActivity(ctx, activities.SendSubscriptionCanceledEmailActivity,
+		subscription, workflow.Now(ctx), reason).Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Failed to send cancellation email", "error", err)
+	}
+}
+
+// paymentErrorType returns the type of a payment error returned by ProcessPaymentActivity, or ""
+// when err is nil or any other error
+func paymentErrorType(err error) string {
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) {
+		return ""
+	}
+	switch appErr.Type() {
+	case activities.CardDeclinedErrorType,
+		activities.InsufficientFundsErrorType,
+		activities.GatewayTimeoutErrorType,
+		activities.FraudSuspectedErrorType:
+		return appErr.Type()
+	}
+	return ""
+}
+
+// paymentFailed reports whether err is a failed payment, which workflows handle by starting
+// dunning or expiring a trial rather than by failing
+func paymentFailed(err error) bool {
+	return paymentErrorType(err) != ""
+}
+
+// applyAdjustments adds carried line items to the charges. A net credit larger than the
+// charges is applied up to their total and the rest is returned as a single item to carry again.
+func applyAdjustments(charges *activities.Charges, adjustments []activities.InvoiceItem) ([]activities.InvoiceItem, error) {
+	if len(adjustments) == 0 {
+		return nil, nil
+	}
+
+	total := charges.Total
+	for _, item := range adjustments {
+		var err error
+		if total, err = total.Add(item.Amount); err != nil {
+			return nil, err
+		}
+	}
+	charges.Items = append(charges.Items, adjustments...)
+	if total.Sign() >= 0 {
+		charges.Total = total
+		return nil, nil
+	}
+
+	// Only use as much credit as the charges, carry the rest
+	charges.Items = append(charges.Items, activities.InvoiceItem{
+		Description: "Credit carried forward",
+		Amount:      total.Neg(),
+		Quantity:    1,
+	})
+	charges.Total = money.Zero(total.Currency())
+	return []activities.InvoiceItem{{
+		Description: "Credit brought forward",
+		Amount:      total,
+		Quantity:    1,
+	}}, nil
 }
@@ -0,0 +1,184 @@
+package workflows
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/invoices"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// billingTimeTestWorkflow returns the time it bills as of
+func billingTimeTestWorkflow(ctx workflow.Context) (time.Time, error) {
+	return billingTime(ctx), nil
+}
+
+func TestBillingTime(t *testing.T) {
+	now := time.Date(2025, time.March, 4, 2, 0, 0, 0, time.UTC)
+	scheduled := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		scheduled time.Time
+		want      time.Time
+	}{
+		{name: "started by hand", want: now},
+		{name: "started late by a schedule", scheduled: scheduled, want: scheduled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestWorkflowEnvironment()
+			env.RegisterWorkflow(billingTimeTestWorkflow)
+			env.SetStartTime(now)
+			if !tt.scheduled.IsZero() {
+				err := env.SetTypedSearchAttributesOnStart(temporal.NewSearchAttributes(scheduledStartTimeKey.ValueSet(tt.scheduled)))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			env.ExecuteWorkflow(billingTimeTestWorkflow)
+
+			var got time.Time
+			if err := env.GetWorkflowResult(&got); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("billing time = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurringBillingSkipsInvoicedPeriods(t *testing.T) {
+	january := activities.BillingPeriod{
+		Start: time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2025, time.February, 15, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name string
+		// invoiced is the status of an invoice the entity workflow already issued for January, empty for none
+		invoiced invoices.Status
+		billed   int
+	}{
+		{name: "not invoiced", billed: 1},
+		{name: "invoiced by the entity workflow", invoiced: invoices.StatusPaid},
+		{name: "invoice voided", invoiced: invoices.StatusVoid, billed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &entityBackend{subscription: activeSubscription()}
+			if tt.invoiced != "" {
+				backend.invoices = []activities.InvoiceDetails{{ID: "inv_entity", Status: tt.invoiced, Period: january}}
+			}
+			env := newEntityTestEnv(backend)
+			// The run due on March 1st is caught up three days late, and bills the period that had ended by then
+			env.SetStartTime(time.Date(2025, time.March, 4, 2, 0, 0, 0, time.UTC))
+			scheduled := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+			if err := env.SetTypedSearchAttributesOnStart(temporal.NewSearchAttributes(scheduledStartTimeKey.ValueSet(scheduled))); err != nil {
+				t.Fatal(err)
+			}
+			env.ExecuteWorkflow(RecurringBillingWorkflow, RecurringBillingParams{SubscriptionID: "sub_entity", CustomerID: "cust_1"})
+
+			if err := env.GetWorkflowError(); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			var billed []activities.BillingPeriod
+			for _, invoice := range backend.invoices {
+				if invoice.ID != "inv_entity" {
+					billed = append(billed, invoice.Period)
+				}
+			}
+			want := []activities.BillingPeriod{january}
+			checkPeriods(t, billed, want[:tt.billed])
+		})
+	}
+}
+
+func TestRecurringBillingBillsEveryPeriodSinceTheLastRun(t *testing.T) {
+	january := activities.BillingPeriod{
+		Start: time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2025, time.February, 15, 0, 0, 0, 0, time.UTC),
+	}
+	february := activities.BillingPeriod{
+		Start: time.Date(2025, time.February, 15, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2025, time.March, 15, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name     string
+		lookback time.Duration
+		invoiced bool // whether January is invoiced already
+		want     []activities.BillingPeriod
+	}{
+		{name: "quarterly runs", lookback: 92 * 24 * time.Hour, want: []activities.BillingPeriod{january, february}},
+		{name: "quarterly runs with a period invoiced", lookback: 92 * 24 * time.Hour, invoiced: true, want: []activities.BillingPeriod{february}},
+		{name: "no lookback", want: []activities.BillingPeriod{february}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &entityBackend{subscription: activeSubscription()}
+			if tt.invoiced {
+				backend.invoices = []activities.InvoiceDetails{{ID: "inv_entity", Status: invoices.StatusPaid, Period: january}}
+			}
+			env := newEntityTestEnv(backend)
+			// A monthly plan billed by a schedule running on the first of each quarter
+			env.SetStartTime(time.Date(2025, time.April, 1, 0, 0, 0, 0, time.UTC))
+			env.ExecuteWorkflow(RecurringBillingWorkflow, RecurringBillingParams{
+				SubscriptionID:     "sub_entity",
+				CustomerID:         "cust_1",
+				InvoiceGracePeriod: -1,
+				Lookback:           tt.lookback,
+			})
+
+			if err := env.GetWorkflowError(); err != nil {
+				t.Fatalf("workflow failed: %v", err)
+			}
+			var billed []activities.BillingPeriod
+			for _, invoice := range backend.invoices {
+				if invoice.ID != "inv_entity" {
+					billed = append(billed, invoice.Period)
+				}
+			}
+			checkPeriods(t, billed, tt.want)
+		})
+	}
+}
+
+func TestSubscriptionWorkflowCompensates(t *testing.T) {
+	tests := []struct {
+		name     string
+		canceled bool
+	}{
+		{name: "step after the payment fails"},
+		{name: "workflow canceled after the payment", canceled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &entityBackend{subscription: activeSubscription()}
+			backend.failStatusUpdates = !tt.canceled
+			backend.cancelStatusUpdates = tt.canceled
+			env := newEntityTestEnv(backend)
+			env.SetStartTime(time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC))
+			env.ExecuteWorkflow(SubscriptionWorkflow, SubscriptionParams{CustomerID: "cust_1", PlanID: "premium-monthly", Quantity: 1, PaymentMethodID: "pm_card"})
+
+			err := env.GetWorkflowError()
+			if err == nil {
+				t.Fatal("workflow succeeded, want it to fail")
+			}
+			if tt.canceled && !temporal.IsCanceledError(err) {
+				t.Errorf("workflow failed with %v, want canceled", err)
+			}
+			// The payment is reversed before the invoice it paid is voided, and the subscription goes last
+			want := []string{"ReversePaymentActivity", "VoidInvoiceActivity", "DeleteSubscriptionActivity"}
+			if fmt.Sprint(backend.compensations) != fmt.Sprint(want) {
+				t.Errorf("compensated with %v, want %v", backend.compensations, want)
+			}
+		})
+	}
+}
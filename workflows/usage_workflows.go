@@ -0,0 +1,47 @@
+package workflows
+
+import (
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/usage"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// RecordUsageWorkflow records a metered usage event for a subscription.
+// It returns false if an event with the same ID was already recorded.
+func RecordUsageWorkflow(ctx workflow.Context, event usage.Event) (bool, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("RecordUsageWorkflow started",
+		"subscriptionID", event.SubscriptionID,
+		"meter", event.Meter,
+		"eventID", event.EventID)
+
+	// Configure activity options
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	// Stamp events that were reported without a time with the workflow clock
+	if event.Timestamp.IsZero() {
+		event.Timestamp = workflow.Now(ctx)
+	}
+
+	var recorded bool
+	err := workflow.ExecuteActivity(ctx, activities.RecordUsageActivity, event).Get(ctx, &recorded)
+	if err != nil {
+		logger.Error("Failed to record usage", "error", err)
+		return false, err
+	}
+
+	logger.Info("RecordUsageWorkflow completed", "eventID", event.EventID, "recorded", recorded)
+	return recorded, nil
+}
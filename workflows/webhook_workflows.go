@@ -0,0 +1,245 @@
+package workflows
+
+import (
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/blobs"
+	"github.com/tanint/play-temporal/lifecycle"
+	"github.com/tanint/play-temporal/webhooks"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// WebhookDeliveriesQuery is the query that reports the delivery log of a WebhookDeliveryWorkflow
+const WebhookDeliveriesQuery = "get_webhook_deliveries"
+
+// Webhook delivery statuses
+const (
+	WebhookPending      = "pending"
+	WebhookRetrying     = "retrying"
+	WebhookDelivered    = "delivered"
+	WebhookDeadLettered = "dead_lettered"
+)
+
+// Default webhook retry schedule: 30s, 1m, 2m, ... between attempts, at most an hour apart
+const (
+	DefaultWebhookMaxAttempts    = 8
+	DefaultWebhookInitialBackoff = 30 * time.Second
+	DefaultWebhookMaxBackoff     = time.Hour
+)
+
+// WebhookDeliveryParams contains parameters for the webhook delivery workflow
+type WebhookDeliveryParams struct {
+	Event webhooks.Event
+	// MaxAttempts is how many times delivery to an endpoint is attempted before the event is
+	// dead-lettered for it. Zero means DefaultWebhookMaxAttempts.
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt, doubled after each following
+	// one up to MaxBackoff. Zero means the defaults.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// WebhookDelivery is the delivery log of an event to one endpoint
+type WebhookDelivery struct {
+	EndpointID    string
+	URL           string
+	Status        string
+	Attempts      []webhooks.Attempt
+	NextAttemptAt time.Time // zero unless the delivery is waiting to be retried
+	DeadLetterURL string    // where the dead-lettered event was stored
+}
+
+// WebhookDeliveryState is the progress of a WebhookDeliveryWorkflow, reported by WebhookDeliveriesQuery
+type WebhookDeliveryState struct {
+	EventID    string
+	EventType  string
+	Deliveries []WebhookDelivery
+}
+
+// WebhookDeliveryWorkflow delivers a billing event to every endpoint that receives its type. Each
+// endpoint is delivered to on its own, retried with exponential backoff, and dead-lettered once its
+// attempts run out. Every attempt is kept in the endpoint's delivery log.
+func WebhookDeliveryWorkflow(ctx workflow.Context, params WebhookDeliveryParams) (WebhookDeliveryState, error) {
+	logger := workflow.GetLogger(ctx)
+	event := params.Event
+	logger.Info("WebhookDeliveryWorkflow started", "eventID", event.ID, "eventType", event.Type)
+
+	// Each delivery activity is a single attempt; the workflow does the retrying so that every
+	// attempt is logged
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 1},
+	}
+	attemptCtx := workflow.WithActivityOptions(ctx, ao)
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	maxAttempts := params.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultWebhookMaxAttempts
+	}
+	initialBackoff := params.InitialBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = DefaultWebhookInitialBackoff
+	}
+	maxBackoff := params.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultWebhookMaxBackoff
+	}
+
+	state := WebhookDeliveryState{EventID: event.ID, EventType: event.Type}
+
+	// Set up a query handler to report the delivery log
+	err := workflow.SetQueryHandler(ctx, WebhookDeliveriesQuery, func() (WebhookDeliveryState, error) {
+		return state, nil
+	})
+	if err != nil {
+		logger.Error("Failed to register query handler", "error", err)
+		return state, err
+	}
+
+	// Step 1: Find the endpoints that receive the event
+	var targets []webhooks.Target
+	err = workflow.ExecuteActivity(ctx, activities.ListWebhookTargetsActivity, event.Type).Get(ctx, &targets)
+	if err != nil {
+		logger.Error("Failed to list webhook endpoints", "error", err)
+		return state, err
+	}
+	for _, target := range targets {
+		state.Deliveries = append(state.Deliveries, WebhookDelivery{
+			EndpointID: target.EndpointID,
+			URL:        target.URL,
+			Status:     WebhookPending,
+		})
+	}
+
+	// deliver retries delivery to one endpoint until it succeeds or its attempts run out
+	deliver := func(ctx workflow.Context, delivery *WebhookDelivery) {
+		backoff := initialBackoff
+		for number := 1; number <= maxAttempts; number++ {
+			var attempt webhooks.Attempt
+			err := workflow.ExecuteActivity(attemptCtx, activities.DeliverWebhookActivity, delivery.EndpointID, event, number).Get(ctx, &attempt)
+			if err != nil {
+				// The activity itself failed, e.g. timed out, which counts as a failed attempt
+				attempt = webhooks.Attempt{Number: number, At: workflow.Now(ctx), Error: err.Error()}
+			}
+			delivery.Attempts = append(delivery.Attempts, attempt)
+			if attempt.Succeeded() {
+				delivery.Status = WebhookDelivered
+				delivery.NextAttemptAt = time.Time{}
+				return
+			}
+			if number == maxAttempts {
+				break
+			}
+
+			delivery.Status = WebhookRetrying
+			delivery.NextAttemptAt = workflow.Now(ctx).Add(backoff)
+			if err := workflow.Sleep(ctx, backoff); err != nil {
+				return
+			}
+			backoff = min(2*backoff, maxBackoff)
+		}
+
+		// Every attempt failed: dead-letter the event for this endpoint
+		delivery.Status = WebhookDeadLettered
+		delivery.NextAttemptAt = time.Time{}
+		letter := activities.DeadLetter{
+			EndpointID: delivery.EndpointID,
+			URL:        delivery.URL,
+			Event:      event,
+			Attempts:   delivery.Attempts,
+		}
+		var object blobs.Object
+		if err := workflow.ExecuteActivity(ctx, activities.DeadLetterWebhookActivity, letter).Get(ctx, &object); err != nil {
+			logger.Error("Failed to store dead-lettered webhook", "endpointID", delivery.EndpointID, "error", err)
+			return
+		}
+		delivery.DeadLetterURL = object.URL
+		logger.Warn("Webhook dead-lettered", "endpointID", delivery.EndpointID, "attempts", len(delivery.Attempts))
+	}
+
+	// Step 2: Deliver to every endpoint at once, so that a failing endpoint does not hold up the others
+	wg := workflow.NewWaitGroup(ctx)
+	for i := range state.Deliveries {
+		wg.Add(1)
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			defer wg.Done()
+			deliver(ctx, &state.Deliveries[i])
+		})
+	}
+	wg.Wait(ctx)
+
+	logger.Info("WebhookDeliveryWorkflow completed", "eventID", event.ID, "endpoints", len(state.Deliveries))
+	return state, nil
+}
+
+// SubscriptionStatusChange is the data of a subscription.status_changed event
+type SubscriptionStatusChange struct {
+	SubscriptionID string           `json:"subscription_id"`
+	From           lifecycle.Status `json:"from"`
+	To             lifecycle.Status `json:"to"`
+	Reason         string           `json:"reason,omitempty"`
+}
+
+// BillingCycle is the data of billing_cycle.completed and billing_cycle.skipped events
+type BillingCycle struct {
+	SubscriptionID  string                   `json:"subscription_id"`
+	Period          activities.BillingPeriod `json:"period"`
+	InvoiceID       string                   `json:"invoice_id,omitempty"`
+	PaymentStatus   string                   `json:"payment_status,omitempty"`
+	NextBillingDate time.Time                `json:"next_billing_date"`
+	Reason          string                   `json:"reason,omitempty"`
+}
+
+// emitStatusChange emits a subscription.status_changed event, unless the status did not change
+func emitStatusChange(ctx workflow.Context, subscriptionID string, from, to lifecycle.Status, reason string) {
+	if from == to {
+		return
+	}
+	emitEvent(ctx, webhooks.SubscriptionStatusChanged, subscriptionID, SubscriptionStatusChange{
+		SubscriptionID: subscriptionID,
+		From:           from,
+		To:             to,
+		Reason:         reason,
+	})
+}
+
+// emitEvent starts a WebhookDeliveryWorkflow child (ID webhook-<event ID>) for a billing event.
+// Delivery outlives the caller and can take hours, so this only waits for it to start, and an
+// event that cannot be started is logged rather than failing billing.
+func emitEvent(ctx workflow.Context, eventType string, subscriptionID string, data any) {
+	logger := workflow.GetLogger(ctx)
+
+	// Event IDs are random, recorded once in the history so that replays reuse them
+	id, err := newID(ctx, "evt")
+	if err != nil {
+		logger.Error("Failed to generate webhook event ID", "error", err)
+		return
+	}
+
+	event, err := webhooks.NewEvent(id, eventType, workflow.Now(ctx), subscriptionID, data)
+	if err != nil {
+		logger.Error("Failed to create webhook event", "eventType", eventType, "error", err)
+		return
+	}
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:        "webhook-" + event.ID,
+		ParentClosePolicy: enums.PARENT_CLOSE_POLICY_ABANDON,
+	})
+	child := workflow.ExecuteChildWorkflow(childCtx, WebhookDeliveryWorkflow, WebhookDeliveryParams{Event: event})
+	if err := child.GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		logger.Error("Failed to start webhook delivery", "eventType", eventType, "error", err)
+	}
+}
@@ -0,0 +1,137 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanint/play-temporal/activities"
+	"github.com/tanint/play-temporal/blobs"
+	"github.com/tanint/play-temporal/webhooks"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestWebhookDeliveryRetriesAndDeadLetters(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(WebhookDeliveryWorkflow)
+	start := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	// ep_flaky fails twice and then accepts the event; ep_down never does
+	failures := map[string]int{"ep_flaky": 2, "ep_down": -1}
+	// Attempts at the two endpoints run side by side
+	var mu sync.Mutex
+	attempted := map[string][]time.Time{}
+	var letters []activities.DeadLetter
+	env.RegisterActivityWithOptions(func(ctx context.Context, eventType string) ([]webhooks.Target, error) {
+		return []webhooks.Target{
+			{EndpointID: "ep_down", URL: "https://down.example.com/hooks"},
+			{EndpointID: "ep_flaky", URL: "https://flaky.example.com/hooks"},
+		}, nil
+	}, activity.RegisterOptions{Name: "ListWebhookTargetsActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, endpointID string, event webhooks.Event, number int) (webhooks.Attempt, error) {
+		at := env.Now()
+		mu.Lock()
+		attempted[endpointID] = append(attempted[endpointID], at)
+		mu.Unlock()
+		if failures[endpointID] < 0 || number <= failures[endpointID] {
+			return webhooks.Attempt{Number: number, At: at, StatusCode: 503, Error: "503 Service Unavailable"}, nil
+		}
+		return webhooks.Attempt{Number: number, At: at, StatusCode: 200}, nil
+	}, activity.RegisterOptions{Name: "DeliverWebhookActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, letter activities.DeadLetter) (blobs.Object, error) {
+		mu.Lock()
+		letters = append(letters, letter)
+		mu.Unlock()
+		return blobs.Object{URL: "file:///dead_letter/" + letter.EndpointID + ".json"}, nil
+	}, activity.RegisterOptions{Name: "DeadLetterWebhookActivity"})
+
+	event, err := webhooks.NewEvent("evt_1", webhooks.BillingCycleCompleted, start, "sub_1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.ExecuteWorkflow(WebhookDeliveryWorkflow, WebhookDeliveryParams{
+		Event:          event,
+		MaxAttempts:    4,
+		InitialBackoff: 30 * time.Second,
+		MaxBackoff:     time.Minute,
+	})
+
+	var state WebhookDeliveryState
+	if err := env.GetWorkflowResult(&state); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+
+	// The backoff doubles from 30s and is capped at a minute
+	want := map[string][]time.Duration{
+		"ep_flaky": {0, 30 * time.Second, 90 * time.Second},
+		"ep_down":  {0, 30 * time.Second, 90 * time.Second, 150 * time.Second},
+	}
+	for endpointID, offsets := range want {
+		times := attempted[endpointID]
+		if len(times) != len(offsets) {
+			t.Errorf("%s was attempted %d times, want %d", endpointID, len(times), len(offsets))
+			continue
+		}
+		for i, offset := range offsets {
+			if got := times[i].Sub(start); got != offset {
+				t.Errorf("%s attempt %d came %s after the event, want %s", endpointID, i+1, got, offset)
+			}
+		}
+	}
+
+	statuses := map[string]string{}
+	for _, delivery := range state.Deliveries {
+		statuses[delivery.EndpointID] = delivery.Status
+	}
+	if statuses["ep_flaky"] != WebhookDelivered || statuses["ep_down"] != WebhookDeadLettered {
+		t.Errorf("deliveries ended %v, want ep_flaky delivered and ep_down dead-lettered", statuses)
+	}
+	if len(letters) != 1 || letters[0].EndpointID != "ep_down" || len(letters[0].Attempts) != 4 {
+		t.Errorf("dead-lettered %+v, want ep_down's event with its 4 attempts", letters)
+	}
+
+	// The endpoint that is down did not hold up the one that recovered
+	flaky, down := attempted["ep_flaky"], attempted["ep_down"]
+	if len(flaky) > 0 && len(down) > 0 && !flaky[len(flaky)-1].Before(down[len(down)-1]) {
+		t.Errorf("ep_flaky was delivered to at %s, after ep_down's last attempt at %s", flaky[len(flaky)-1], down[len(down)-1])
+	}
+}
+
+func TestWebhookDeliveryCountsActivityFailuresAsAttempts(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(WebhookDeliveryWorkflow)
+
+	env.RegisterActivityWithOptions(func(ctx context.Context, eventType string) ([]webhooks.Target, error) {
+		return []webhooks.Target{{EndpointID: "ep_1", URL: "https://example.com/hooks"}}, nil
+	}, activity.RegisterOptions{Name: "ListWebhookTargetsActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, endpointID string, event webhooks.Event, number int) (webhooks.Attempt, error) {
+		if number == 1 {
+			return webhooks.Attempt{}, errors.New("connection reset")
+		}
+		return webhooks.Attempt{Number: number, StatusCode: 200}, nil
+	}, activity.RegisterOptions{Name: "DeliverWebhookActivity"})
+
+	event, err := webhooks.NewEvent("evt_2", webhooks.BillingCycleCompleted, time.Now(), "sub_1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.ExecuteWorkflow(WebhookDeliveryWorkflow, WebhookDeliveryParams{Event: event})
+
+	var state WebhookDeliveryState
+	if err := env.GetWorkflowResult(&state); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	if len(state.Deliveries) != 1 {
+		t.Fatalf("delivered to %d endpoints, want 1", len(state.Deliveries))
+	}
+	delivery := state.Deliveries[0]
+	if delivery.Status != WebhookDelivered || len(delivery.Attempts) != 2 || delivery.Attempts[0].Error == "" {
+		t.Errorf("delivery %s after attempts %+v, want delivered on the second after a failed first", delivery.Status, delivery.Attempts)
+	}
+}